@@ -0,0 +1,499 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiKeyPrefix marks a string as one of our API keys, e.g. ck_1a2b3c4d5e6f_<secret>
+const apiKeyPrefix = "ck"
+
+// validScopes are the scopes an API key can be granted. They mirror the broker actions,
+// with "*" granting everything.
+var validScopes = map[string]bool{
+	"*":         true,
+	"auth":      true,
+	"menu":      true,
+	"orders":    true,
+	"inventory": true,
+	"logs":      true,
+	"users":     true,
+}
+
+// APIKey is a credential for clients that can't log in with a password, such as
+// self-order kiosks and internal jobs. Only a hash of the secret is stored.
+type APIKey struct {
+	ID         int        `json:"id"`
+	UserID     *int       `json:"user_id,omitempty"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// requireAdminToken protects key management and other admin endpoints. Callers must
+// send the token set in API_KEY_ADMIN_TOKEN as "Authorization: Bearer <token>".
+// Without a token configured, the endpoints can't be used at all.
+func (app *Config) requireAdminToken() gin.HandlerFunc {
+	token := os.Getenv("API_KEY_ADMIN_TOKEN")
+
+	return func(c *gin.Context) {
+		if token == "" {
+			app.errorJSON(c, errors.New("admin endpoints are disabled"), http.StatusForbidden)
+			c.Abort()
+			return
+		}
+
+		scheme, credentials, _ := strings.Cut(c.GetHeader("Authorization"), " ")
+		if !strings.EqualFold(scheme, "Bearer") ||
+			subtle.ConstantTimeCompare([]byte(strings.TrimSpace(credentials)), []byte(token)) != 1 {
+			c.Header("WWW-Authenticate", "Bearer")
+			app.errorJSON(c, errors.New("admin token required"), http.StatusUnauthorized)
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// generateAPIKey returns a new plain text key along with its lookup prefix
+func generateAPIKey() (string, string, error) {
+	prefixBytes := make([]byte, 6)
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return "", "", err
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+
+	prefix := hex.EncodeToString(prefixBytes)
+	key := fmt.Sprintf("%s_%s_%s", apiKeyPrefix, prefix, secret)
+
+	return key, prefix, nil
+}
+
+// parseAPIKeyPrefix extracts the lookup prefix from a plain text key
+func parseAPIKeyPrefix(key string) (string, error) {
+	parts := strings.SplitN(key, "_", 3)
+	if len(parts) != 3 || parts[0] != apiKeyPrefix || parts[1] == "" || parts[2] == "" {
+		return "", errors.New("malformed api key")
+	}
+
+	return parts[1], nil
+}
+
+// normalizeScopes validates, de-duplicates and sorts a list of scopes
+func normalizeScopes(scopes []string) ([]string, error) {
+	seen := make(map[string]bool)
+	normalized := []string{}
+
+	for _, scope := range scopes {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		if scope == "" || seen[scope] {
+			continue
+		}
+
+		if !validScopes[scope] {
+			return nil, fmt.Errorf("invalid scope: %s", scope)
+		}
+
+		seen[scope] = true
+		normalized = append(normalized, scope)
+	}
+
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+// splitScopes turns the stored comma separated scopes into a slice
+func splitScopes(scopes string) []string {
+	if scopes == "" {
+		return []string{}
+	}
+
+	return strings.Split(scopes, ",")
+}
+
+// scanAPIKey scans a row of api_keys columns into an APIKey
+func scanAPIKey(row interface{ Scan(...any) error }) (APIKey, string, error) {
+	var key APIKey
+	var userID sql.NullInt64
+	var scopes, keyHash string
+	var lastUsedAt, expiresAt, revokedAt sql.NullTime
+
+	err := row.Scan(
+		&key.ID,
+		&userID,
+		&key.Name,
+		&key.Prefix,
+		&keyHash,
+		&scopes,
+		&lastUsedAt,
+		&expiresAt,
+		&revokedAt,
+		&key.CreatedAt,
+	)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+
+	if userID.Valid {
+		id := int(userID.Int64)
+		key.UserID = &id
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	key.Scopes = splitScopes(scopes)
+
+	return key, keyHash, nil
+}
+
+const apiKeyColumns = `id, user_id, name, prefix, key_hash, scopes, last_used_at, expires_at, revoked_at, created_at`
+
+// insertAPIKey stores a new API key and returns it together with the plain text key,
+// which is never retrievable again
+func (app *Config) insertAPIKey(key APIKey) (APIKey, string, error) {
+	plainText, prefix, err := generateAPIKey()
+	if err != nil {
+		return APIKey{}, "", err
+	}
+
+	key.Prefix = prefix
+	key.CreatedAt = time.Now()
+
+	stmt := `insert into api_keys (user_id, name, prefix, key_hash, scopes, expires_at, created_at)
+		values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	err = app.DB.QueryRow(stmt,
+		key.UserID,
+		key.Name,
+		key.Prefix,
+		hashToken(plainText),
+		strings.Join(key.Scopes, ","),
+		key.ExpiresAt,
+		key.CreatedAt,
+	).Scan(&key.ID)
+	if err != nil {
+		return APIKey{}, "", err
+	}
+
+	return key, plainText, nil
+}
+
+// getAPIKeyByID returns an API key by id
+func (app *Config) getAPIKeyByID(id int) (APIKey, error) {
+	query := `select ` + apiKeyColumns + ` from api_keys where id = $1`
+
+	key, _, err := scanAPIKey(app.DB.QueryRow(query, id))
+	return key, err
+}
+
+// getAPIKeys returns all API keys, optionally restricted to a single user
+func (app *Config) getAPIKeys(userID int) ([]APIKey, error) {
+	query := `select ` + apiKeyColumns + ` from api_keys`
+	args := []any{}
+	if userID > 0 {
+		query += ` where user_id = $1`
+		args = append(args, userID)
+	}
+	query += ` order by created_at desc`
+
+	rows, err := app.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, _, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// updateAPIKeyScopes replaces the scopes granted to an API key
+func (app *Config) updateAPIKeyScopes(id int, scopes []string) error {
+	stmt := `update api_keys set scopes = $1 where id = $2 and revoked_at is null`
+
+	result, err := app.DB.Exec(stmt, strings.Join(scopes, ","), id)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errors.New("api key not found")
+	}
+
+	return nil
+}
+
+// revokeAPIKey marks an API key as revoked
+func (app *Config) revokeAPIKey(id int) error {
+	stmt := `update api_keys set revoked_at = $1 where id = $2 and revoked_at is null`
+
+	result, err := app.DB.Exec(stmt, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errors.New("api key not found")
+	}
+
+	return nil
+}
+
+// verifyAPIKey checks a plain text key and returns the matching key if it is usable
+func (app *Config) verifyAPIKey(plainText string) (*APIKey, error) {
+	prefix, err := parseAPIKeyPrefix(plainText)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `select ` + apiKeyColumns + ` from api_keys where prefix = $1`
+
+	key, keyHash, err := scanAPIKey(app.DB.QueryRow(query, prefix))
+	if err != nil {
+		return nil, errors.New("unknown api key")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(keyHash), []byte(hashToken(plainText))) != 1 {
+		return nil, errors.New("unknown api key")
+	}
+
+	if key.RevokedAt != nil {
+		return nil, errors.New("api key revoked")
+	}
+
+	now := time.Now()
+	if key.ExpiresAt != nil && now.After(*key.ExpiresAt) {
+		return nil, errors.New("api key expired")
+	}
+
+	_, err = app.DB.Exec(`update api_keys set last_used_at = $1 where id = $2`, now, key.ID)
+	if err != nil {
+		return nil, err
+	}
+	key.LastUsedAt = &now
+
+	return &key, nil
+}
+
+func (app *Config) CreateAPIKey(c *gin.Context) {
+	var requestPayload struct {
+		Name      string     `json:"name"`
+		UserID    *int       `json:"user_id"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+
+	err := app.readJSON(c, &requestPayload)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	if strings.TrimSpace(requestPayload.Name) == "" {
+		app.errorJSON(c, errors.New("name is required"), http.StatusBadRequest)
+		return
+	}
+
+	if requestPayload.ExpiresAt != nil && requestPayload.ExpiresAt.Before(time.Now()) {
+		app.errorJSON(c, errors.New("expires_at must be in the future"), http.StatusBadRequest)
+		return
+	}
+
+	scopes, err := normalizeScopes(requestPayload.Scopes)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	key, plainText, err := app.insertAPIKey(APIKey{
+		UserID:    requestPayload.UserID,
+		Name:      requestPayload.Name,
+		Scopes:    scopes,
+		ExpiresAt: requestPayload.ExpiresAt,
+	})
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = app.logRequest("api key", fmt.Sprintf("Created api key %s (%s)", key.Prefix, key.Name))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "API key created; store it now, it will not be shown again",
+		Data: struct {
+			APIKey
+			Key string `json:"key"`
+		}{key, plainText},
+	}
+
+	app.writeJSON(c, http.StatusCreated, payload)
+}
+
+func (app *Config) GetAPIKeys(c *gin.Context) {
+	userID, _ := strconv.Atoi(c.Query("user_id"))
+
+	keys, err := app.getAPIKeys(userID)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "API keys retrieved",
+		Data:    keys,
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+func (app *Config) UpdateAPIKeyScopes(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		app.errorJSON(c, errors.New("invalid id parameter"), http.StatusBadRequest)
+		return
+	}
+
+	var requestPayload struct {
+		Scopes []string `json:"scopes"`
+	}
+
+	err = app.readJSON(c, &requestPayload)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	scopes, err := normalizeScopes(requestPayload.Scopes)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.updateAPIKeyScopes(id, scopes)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusNotFound)
+		return
+	}
+
+	key, err := app.getAPIKeyByID(id)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "API key scopes updated",
+		Data:    key,
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+func (app *Config) RevokeAPIKey(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		app.errorJSON(c, errors.New("invalid id parameter"), http.StatusBadRequest)
+		return
+	}
+
+	err = app.revokeAPIKey(id)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusNotFound)
+		return
+	}
+
+	_ = app.logRequest("api key", fmt.Sprintf("Revoked api key %d", id))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "API key revoked",
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+// IntrospectAPIKey reports whether a key is currently usable and what it may do.
+// Like OAuth token introspection it always answers 200, with active set to false
+// for keys that are unknown, revoked or expired.
+func (app *Config) IntrospectAPIKey(c *gin.Context) {
+	var requestPayload struct {
+		Key string `json:"key"`
+	}
+
+	err := app.readJSON(c, &requestPayload)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	type introspection struct {
+		Active    bool       `json:"active"`
+		KeyID     int        `json:"key_id,omitempty"`
+		UserID    *int       `json:"user_id,omitempty"`
+		Name      string     `json:"name,omitempty"`
+		Scopes    []string   `json:"scopes,omitempty"`
+		ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	}
+
+	key, err := app.verifyAPIKey(requestPayload.Key)
+	if err != nil {
+		app.writeJSON(c, http.StatusOK, jsonResponse{
+			Error:   false,
+			Message: err.Error(),
+			Data:    introspection{Active: false},
+		})
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "API key is active",
+		Data: introspection{
+			Active:    true,
+			KeyID:     key.ID,
+			UserID:    key.UserID,
+			Name:      key.Name,
+			Scopes:    key.Scopes,
+			ExpiresAt: key.ExpiresAt,
+		},
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
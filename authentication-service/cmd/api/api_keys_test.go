@@ -0,0 +1,188 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGenerateAPIKey(t *testing.T) {
+	key, prefix, err := generateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := parseAPIKeyPrefix(key)
+	if err != nil || parsed != prefix {
+		t.Errorf("parseAPIKeyPrefix(%q) = %q, %v; want %q", key, parsed, err, prefix)
+	}
+	if !strings.HasPrefix(key, apiKeyPrefix+"_"+prefix+"_") {
+		t.Errorf("key %q doesn't start with its prefix %q", key, prefix)
+	}
+
+	other, otherPrefix, err := generateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == key || otherPrefix == prefix {
+		t.Errorf("generateAPIKey() returned the same key twice")
+	}
+}
+
+func TestParseAPIKeyPrefix(t *testing.T) {
+	tests := []struct {
+		key     string
+		want    string
+		wantErr bool
+	}{
+		{"ck_1a2b3c4d5e6f_secret", "1a2b3c4d5e6f", false},
+		{"ck_1a2b3c4d5e6f_secret_with_underscores", "1a2b3c4d5e6f", false},
+		{"xx_1a2b3c4d5e6f_secret", "", true},
+		{"ck__secret", "", true},
+		{"ck_1a2b3c4d5e6f_", "", true},
+		{"ck_1a2b3c4d5e6f", "", true},
+		{"", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.key, func(t *testing.T) {
+			got, err := parseAPIKeyPrefix(tt.key)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("parseAPIKeyPrefix(%q) = %q, %v; want %q, error %v", tt.key, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNormalizeScopes(t *testing.T) {
+	tests := []struct {
+		name    string
+		scopes  []string
+		want    []string
+		wantErr bool
+	}{
+		{"sorted", []string{"orders", "menu"}, []string{"menu", "orders"}, false},
+		{"lowercased and trimmed", []string{" Menu ", "LOGS"}, []string{"logs", "menu"}, false},
+		{"duplicates dropped", []string{"menu", "menu", "Menu"}, []string{"menu"}, false},
+		{"blanks dropped", []string{"", " ", "users"}, []string{"users"}, false},
+		{"everything", []string{"*"}, []string{"*"}, false},
+		{"none", nil, []string{}, false},
+		{"unknown scope", []string{"menu", "payments"}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeScopes(tt.scopes)
+			if (err != nil) != tt.wantErr || (!tt.wantErr && !reflect.DeepEqual(got, tt.want)) {
+				t.Errorf("normalizeScopes(%q) = %q, %v; want %q, error %v", tt.scopes, got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSplitScopes(t *testing.T) {
+	if got := splitScopes(""); len(got) != 0 || got == nil {
+		t.Errorf(`splitScopes("") = %#v, want an empty slice`, got)
+	}
+	if got := splitScopes("menu,orders"); !reflect.DeepEqual(got, []string{"menu", "orders"}) {
+		t.Errorf(`splitScopes("menu,orders") = %q`, got)
+	}
+}
+
+func TestRequireAdminToken(t *testing.T) {
+	tests := []struct {
+		name          string
+		token         string
+		authorization string
+		wantStatus    int
+	}{
+		{"right token", "s3cret", "Bearer s3cret", http.StatusOK},
+		{"scheme in any case", "s3cret", "bearer  s3cret ", http.StatusOK},
+		{"wrong token", "s3cret", "Bearer guess", http.StatusUnauthorized},
+		{"no token", "s3cret", "", http.StatusUnauthorized},
+		{"other scheme", "s3cret", "Basic s3cret", http.StatusUnauthorized},
+		{"token not configured", "", "Bearer ", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("API_KEY_ADMIN_TOKEN", tt.token)
+			app := &Config{}
+
+			header := http.Header{}
+			if tt.authorization != "" {
+				header.Set("Authorization", tt.authorization)
+			}
+
+			status, _ := performRequest(t, http.MethodGet, "/api-keys", "/api-keys", "", header,
+				app.requireAdminToken(), func(c *gin.Context) { c.JSON(http.StatusOK, jsonResponse{}) })
+			if status != tt.wantStatus {
+				t.Errorf("status = %d, want %d", status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestVerifyAPIKey(t *testing.T) {
+	plainText := "ck_1a2b3c4d5e6f_secret"
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+
+	// row builds an api_keys row for the key above
+	row := func(keyHash string, expiresAt, revokedAt any) []driver.Value {
+		return []driver.Value{int64(7), nil, "Kiosk", "1a2b3c4d5e6f", keyHash, "menu,orders", nil, expiresAt, revokedAt, past}
+	}
+
+	tests := []struct {
+		name    string
+		key     string
+		rows    [][]driver.Value
+		wantErr string
+	}{
+		{"usable", plainText, [][]driver.Value{row(hashToken(plainText), nil, nil)}, ""},
+		{"not expired yet", plainText, [][]driver.Value{row(hashToken(plainText), future, nil)}, ""},
+		{"wrong secret", "ck_1a2b3c4d5e6f_guess", [][]driver.Value{row(hashToken(plainText), nil, nil)}, "unknown api key"},
+		{"unknown prefix", plainText, nil, "unknown api key"},
+		{"revoked", plainText, [][]driver.Value{row(hashToken(plainText), nil, past)}, "api key revoked"},
+		{"expired", plainText, [][]driver.Value{row(hashToken(plainText), past, nil)}, "api key expired"},
+		{"malformed", "secret", nil, "malformed api key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, fake := newFakeDB(t, func(q fakeQuery) fakeResult {
+				if q.has("from api_keys where prefix = $1") {
+					return fakeResult{rows: tt.rows}
+				}
+				return fakeResult{rowsAffected: 1}
+			})
+			app := &Config{DB: db}
+
+			key, err := app.verifyAPIKey(tt.key)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("verifyAPIKey() = %v, want %q", err, tt.wantErr)
+				}
+				if len(fake.ran("update api_keys set last_used_at")) != 0 {
+					t.Error("last use was recorded for a key that can't be used")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("verifyAPIKey() = %v", err)
+			}
+
+			if key.ID != 7 || !reflect.DeepEqual(key.Scopes, []string{"menu", "orders"}) || key.LastUsedAt == nil {
+				t.Errorf("verifyAPIKey() = %+v", key)
+			}
+			if len(fake.ran("update api_keys set last_used_at")) != 1 {
+				t.Error("last use was not recorded")
+			}
+		})
+	}
+}
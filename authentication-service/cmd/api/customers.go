@@ -0,0 +1,508 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// validDietaryPreferences are the dietary preferences a customer can choose from
+var validDietaryPreferences = map[string]bool{
+	"vegetarian":  true,
+	"vegan":       true,
+	"pescatarian": true,
+	"gluten_free": true,
+	"dairy_free":  true,
+	"nut_free":    true,
+	"halal":       true,
+	"kosher":      true,
+}
+
+var phonePattern = regexp.MustCompile(`^\+?[0-9 ()-]{7,20}$`)
+
+// CustomerProfile holds the contact details and preferences of a customer
+type CustomerProfile struct {
+	Phone              string     `json:"phone"`
+	DietaryPreferences []string   `json:"dietary_preferences"`
+	MarketingEmail     bool       `json:"marketing_email"`
+	MarketingSMS       bool       `json:"marketing_sms"`
+	MarketingConsentAt *time.Time `json:"marketing_consent_at,omitempty"`
+	UpdatedAt          *time.Time `json:"updated_at,omitempty"`
+}
+
+// CustomerAddress is a delivery address belonging to a customer
+type CustomerAddress struct {
+	ID           int       `json:"id"`
+	UserID       int       `json:"user_id"`
+	Label        string    `json:"label"`
+	Line1        string    `json:"line1"`
+	Line2        string    `json:"line2,omitempty"`
+	City         string    `json:"city"`
+	PostalCode   string    `json:"postal_code"`
+	Country      string    `json:"country"`
+	Instructions string    `json:"instructions,omitempty"`
+	IsDefault    bool      `json:"is_default"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// Customer is a user together with their profile and addresses
+type Customer struct {
+	ID        int               `json:"id"`
+	Email     string            `json:"email"`
+	FirstName string            `json:"first_name"`
+	LastName  string            `json:"last_name"`
+	Active    int               `json:"active"`
+	Role      string            `json:"role"`
+	Profile   CustomerProfile   `json:"profile"`
+	Addresses []CustomerAddress `json:"addresses"`
+}
+
+// validate checks the profile and normalizes its dietary preferences
+func (p *CustomerProfile) validate() []FieldError {
+	errs := []FieldError{}
+
+	p.Phone = strings.TrimSpace(p.Phone)
+	if p.Phone != "" && !phonePattern.MatchString(p.Phone) {
+		errs = append(errs, FieldError{Field: "phone", Message: "phone is not a valid phone number"})
+	}
+
+	seen := make(map[string]bool)
+	preferences := []string{}
+	for _, preference := range p.DietaryPreferences {
+		preference = strings.ToLower(strings.TrimSpace(preference))
+		if !validDietaryPreferences[preference] {
+			errs = append(errs, FieldError{Field: "dietary_preferences", Message: fmt.Sprintf("unknown dietary preference: %s", preference)})
+			continue
+		}
+		if !seen[preference] {
+			seen[preference] = true
+			preferences = append(preferences, preference)
+		}
+	}
+	sort.Strings(preferences)
+	p.DietaryPreferences = preferences
+
+	return errs
+}
+
+// validate checks that an address has the fields needed for delivery
+func (a *CustomerAddress) validate() []FieldError {
+	errs := []FieldError{}
+
+	required := []struct {
+		name  string
+		value *string
+	}{
+		{"line1", &a.Line1},
+		{"city", &a.City},
+		{"postal_code", &a.PostalCode},
+		{"country", &a.Country},
+	}
+	for _, field := range required {
+		*field.value = strings.TrimSpace(*field.value)
+		if *field.value == "" {
+			errs = append(errs, FieldError{Field: field.name, Message: field.name + " is required"})
+		}
+	}
+
+	if a.Label == "" {
+		a.Label = "Home"
+	}
+
+	return errs
+}
+
+// getCustomerProfile returns a user's profile, or an empty profile if they haven't
+// filled one in
+func (app *Config) getCustomerProfile(userID int) (CustomerProfile, error) {
+	var profile CustomerProfile
+	var preferences string
+	var consentAt, updatedAt sql.NullTime
+
+	query := `select phone, dietary_preferences, marketing_email, marketing_sms, marketing_consent_at, updated_at
+		from customer_profiles where user_id = $1`
+
+	err := app.DB.QueryRow(query, userID).Scan(
+		&profile.Phone,
+		&preferences,
+		&profile.MarketingEmail,
+		&profile.MarketingSMS,
+		&consentAt,
+		&updatedAt,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return CustomerProfile{DietaryPreferences: []string{}}, nil
+	}
+	if err != nil {
+		return CustomerProfile{}, err
+	}
+
+	profile.DietaryPreferences = []string{}
+	if preferences != "" {
+		profile.DietaryPreferences = strings.Split(preferences, ",")
+	}
+	if consentAt.Valid {
+		profile.MarketingConsentAt = &consentAt.Time
+	}
+	if updatedAt.Valid {
+		profile.UpdatedAt = &updatedAt.Time
+	}
+
+	return profile, nil
+}
+
+// upsertCustomerProfile saves a user's profile. The consent time is only moved when
+// the customer's marketing choices change.
+func (app *Config) upsertCustomerProfile(userID int, profile CustomerProfile) error {
+	now := time.Now()
+	stmt := `insert into customer_profiles (user_id, phone, dietary_preferences, marketing_email, marketing_sms, marketing_consent_at, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $6, $6)
+		on conflict (user_id) do update set
+			phone = excluded.phone,
+			dietary_preferences = excluded.dietary_preferences,
+			marketing_email = excluded.marketing_email,
+			marketing_sms = excluded.marketing_sms,
+			marketing_consent_at = case
+				when customer_profiles.marketing_email <> excluded.marketing_email
+					or customer_profiles.marketing_sms <> excluded.marketing_sms
+				then excluded.marketing_consent_at
+				else customer_profiles.marketing_consent_at
+			end,
+			updated_at = excluded.updated_at`
+
+	_, err := app.DB.Exec(stmt,
+		userID,
+		profile.Phone,
+		strings.Join(profile.DietaryPreferences, ","),
+		profile.MarketingEmail,
+		profile.MarketingSMS,
+		now,
+	)
+	return err
+}
+
+func scanCustomerAddress(row interface{ Scan(...any) error }) (CustomerAddress, error) {
+	var address CustomerAddress
+	err := row.Scan(
+		&address.ID,
+		&address.UserID,
+		&address.Label,
+		&address.Line1,
+		&address.Line2,
+		&address.City,
+		&address.PostalCode,
+		&address.Country,
+		&address.Instructions,
+		&address.IsDefault,
+		&address.CreatedAt,
+		&address.UpdatedAt,
+	)
+	return address, err
+}
+
+const customerAddressColumns = `id, user_id, label, line1, line2, city, postal_code, country, instructions, is_default, created_at, updated_at`
+
+// getCustomerAddresses returns a user's addresses, default first
+func (app *Config) getCustomerAddresses(userID int) ([]CustomerAddress, error) {
+	query := `select ` + customerAddressColumns + ` from customer_addresses where user_id = $1 order by is_default desc, id`
+
+	rows, err := app.DB.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	addresses := []CustomerAddress{}
+	for rows.Next() {
+		address, err := scanCustomerAddress(rows)
+		if err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, address)
+	}
+
+	return addresses, rows.Err()
+}
+
+// saveCustomerAddress inserts a new address (ID 0) or updates an existing one. A
+// user's first address becomes their default, and making an address the default
+// clears the flag on the others.
+func (app *Config) saveCustomerAddress(address CustomerAddress) (CustomerAddress, error) {
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return CustomerAddress{}, err
+	}
+	defer tx.Rollback()
+
+	if !address.IsDefault {
+		var count int
+		err = tx.QueryRow(`select count(*) from customer_addresses where user_id = $1 and id <> $2`, address.UserID, address.ID).Scan(&count)
+		if err != nil {
+			return CustomerAddress{}, err
+		}
+		address.IsDefault = count == 0
+	}
+
+	if address.IsDefault {
+		_, err = tx.Exec(`update customer_addresses set is_default = false where user_id = $1 and id <> $2`, address.UserID, address.ID)
+		if err != nil {
+			return CustomerAddress{}, err
+		}
+	}
+
+	now := time.Now()
+	var row *sql.Row
+	if address.ID == 0 {
+		row = tx.QueryRow(`insert into customer_addresses (user_id, label, line1, line2, city, postal_code, country, instructions, is_default, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $10) returning `+customerAddressColumns,
+			address.UserID, address.Label, address.Line1, address.Line2, address.City, address.PostalCode,
+			address.Country, address.Instructions, address.IsDefault, now)
+	} else {
+		row = tx.QueryRow(`update customer_addresses set label = $1, line1 = $2, line2 = $3, city = $4, postal_code = $5,
+			country = $6, instructions = $7, is_default = $8, updated_at = $9
+			where id = $10 and user_id = $11 returning `+customerAddressColumns,
+			address.Label, address.Line1, address.Line2, address.City, address.PostalCode, address.Country,
+			address.Instructions, address.IsDefault, now, address.ID, address.UserID)
+	}
+
+	saved, err := scanCustomerAddress(row)
+	if err != nil {
+		return CustomerAddress{}, err
+	}
+
+	return saved, tx.Commit()
+}
+
+// deleteCustomerAddress removes an address. If it was the default, the oldest
+// remaining address becomes the default.
+func (app *Config) deleteCustomerAddress(userID, addressID int) error {
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var wasDefault bool
+	err = tx.QueryRow(`delete from customer_addresses where id = $1 and user_id = $2 returning is_default`, addressID, userID).Scan(&wasDefault)
+	if err != nil {
+		return err
+	}
+
+	if wasDefault {
+		_, err = tx.Exec(`update customer_addresses set is_default = true
+			where id = (select id from customer_addresses where user_id = $1 order by id limit 1)`, userID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// customerFromParam loads the user named by the :id parameter, writing an error
+// response and returning nil if there isn't one
+func (app *Config) customerFromParam(c *gin.Context) *User {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		app.errorJSON(c, errors.New("invalid id parameter"), http.StatusBadRequest)
+		return nil
+	}
+
+	user, err := app.getByID(id)
+	if err != nil {
+		app.errorJSON(c, errors.New("customer not found"), http.StatusNotFound)
+		return nil
+	}
+
+	return user
+}
+
+// GetCustomer returns a customer with their profile and addresses. Other services use
+// it to check that a customer exists and is active.
+func (app *Config) GetCustomer(c *gin.Context) {
+	user := app.customerFromParam(c)
+	if user == nil {
+		return
+	}
+
+	profile, err := app.getCustomerProfile(user.ID)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	addresses, err := app.getCustomerAddresses(user.ID)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Customer retrieved",
+		Data: Customer{
+			ID:        user.ID,
+			Email:     user.Email,
+			FirstName: user.FirstName,
+			LastName:  user.LastName,
+			Active:    user.Active,
+			Role:      user.Role,
+			Profile:   profile,
+			Addresses: addresses,
+		},
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+func (app *Config) UpdateCustomerProfile(c *gin.Context) {
+	user := app.customerFromParam(c)
+	if user == nil {
+		return
+	}
+
+	var profile CustomerProfile
+	err := app.readJSON(c, &profile)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	if errs := profile.validate(); len(errs) > 0 {
+		app.validationErrorJSON(c, errs)
+		return
+	}
+
+	err = app.upsertCustomerProfile(user.ID, profile)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	saved, err := app.getCustomerProfile(user.ID)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Customer profile updated",
+		Data:    saved,
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+func (app *Config) GetCustomerAddresses(c *gin.Context) {
+	user := app.customerFromParam(c)
+	if user == nil {
+		return
+	}
+
+	addresses, err := app.getCustomerAddresses(user.ID)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Addresses retrieved",
+		Data:    addresses,
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+// SaveCustomerAddress adds an address (POST) or replaces one (PUT with :address_id)
+func (app *Config) SaveCustomerAddress(c *gin.Context) {
+	user := app.customerFromParam(c)
+	if user == nil {
+		return
+	}
+
+	var address CustomerAddress
+	err := app.readJSON(c, &address)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	address.ID = 0
+	address.UserID = user.ID
+	if value := c.Param("address_id"); value != "" {
+		address.ID, err = strconv.Atoi(value)
+		if err != nil {
+			app.errorJSON(c, errors.New("invalid address_id parameter"), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if errs := address.validate(); len(errs) > 0 {
+		app.validationErrorJSON(c, errs)
+		return
+	}
+
+	saved, err := app.saveCustomerAddress(address)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(c, errors.New("address not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	status, message := http.StatusOK, "Address updated"
+	if address.ID == 0 {
+		status, message = http.StatusCreated, "Address added"
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: message,
+		Data:    saved,
+	}
+
+	app.writeJSON(c, status, payload)
+}
+
+func (app *Config) DeleteCustomerAddress(c *gin.Context) {
+	user := app.customerFromParam(c)
+	if user == nil {
+		return
+	}
+
+	addressID, err := strconv.Atoi(c.Param("address_id"))
+	if err != nil {
+		app.errorJSON(c, errors.New("invalid address_id parameter"), http.StatusBadRequest)
+		return
+	}
+
+	err = app.deleteCustomerAddress(user.ID, addressID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(c, errors.New("address not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Address deleted",
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
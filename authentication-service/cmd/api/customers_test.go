@@ -0,0 +1,213 @@
+package main
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCustomerProfileValidate(t *testing.T) {
+	tests := []struct {
+		name            string
+		profile         CustomerProfile
+		wantPhone       string
+		wantPreferences []string
+		wantErrs        []string
+	}{
+		{
+			name:            "normalizes preferences",
+			profile:         CustomerProfile{Phone: " +44 (20) 7946-0958 ", DietaryPreferences: []string{" Vegan", "halal", "vegan", "GLUTEN_FREE"}},
+			wantPhone:       "+44 (20) 7946-0958",
+			wantPreferences: []string{"gluten_free", "halal", "vegan"},
+		},
+		{
+			name:            "nothing filled in",
+			profile:         CustomerProfile{},
+			wantPreferences: []string{},
+		},
+		{
+			name:            "bad phone",
+			profile:         CustomerProfile{Phone: "call me"},
+			wantPhone:       "call me",
+			wantPreferences: []string{},
+			wantErrs:        []string{"phone: phone is not a valid phone number"},
+		},
+		{
+			name:            "phone too short",
+			profile:         CustomerProfile{Phone: "12345"},
+			wantPhone:       "12345",
+			wantPreferences: []string{},
+			wantErrs:        []string{"phone: phone is not a valid phone number"},
+		},
+		{
+			name:            "unknown preferences",
+			profile:         CustomerProfile{DietaryPreferences: []string{"vegan", "carnivore", ""}},
+			wantPreferences: []string{"vegan"},
+			wantErrs:        []string{"dietary_preferences: unknown dietary preference: carnivore", "dietary_preferences: unknown dietary preference: "},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			profile := tt.profile
+
+			var errs []string
+			for _, err := range profile.validate() {
+				errs = append(errs, err.Field+": "+err.Message)
+			}
+			if !reflect.DeepEqual(errs, tt.wantErrs) {
+				t.Errorf("validate() = %q, want %q", errs, tt.wantErrs)
+			}
+			if profile.Phone != tt.wantPhone || !reflect.DeepEqual(profile.DietaryPreferences, tt.wantPreferences) {
+				t.Errorf("validated profile = %q, %q, want %q, %q", profile.Phone, profile.DietaryPreferences, tt.wantPhone, tt.wantPreferences)
+			}
+		})
+	}
+}
+
+func TestCustomerAddressValidate(t *testing.T) {
+	address := CustomerAddress{Line1: " 1 Main Street ", City: "London", PostalCode: "  ", Country: "GB"}
+
+	var errs []string
+	for _, err := range address.validate() {
+		errs = append(errs, err.Field+": "+err.Message)
+	}
+	if want := []string{"postal_code: postal_code is required"}; !reflect.DeepEqual(errs, want) {
+		t.Errorf("validate() = %q, want %q", errs, want)
+	}
+	if address.Line1 != "1 Main Street" || address.Label != "Home" {
+		t.Errorf("validated address = %+v, want line1 trimmed and the label defaulted", address)
+	}
+
+	empty := CustomerAddress{Label: "Work"}
+	if errs := empty.validate(); len(errs) != 4 || empty.Label != "Work" {
+		t.Errorf("validate() of an empty address = %+v, want 4 errors and the label kept", errs)
+	}
+}
+
+func TestSaveCustomerAddress(t *testing.T) {
+	tests := []struct {
+		name        string
+		address     CustomerAddress
+		others      int64
+		found       bool
+		wantDefault bool
+		wantCleared bool
+		wantErr     error
+	}{
+		{"first address becomes the default", CustomerAddress{UserID: 42}, 0, true, true, true, nil},
+		{"another address", CustomerAddress{UserID: 42}, 2, true, false, false, nil},
+		{"new default", CustomerAddress{UserID: 42, IsDefault: true}, 2, true, true, true, nil},
+		{"updated address", CustomerAddress{ID: 5, UserID: 42}, 1, true, false, false, nil},
+		{"someone else's address", CustomerAddress{ID: 5, UserID: 42}, 1, false, false, false, sql.ErrNoRows},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, fake := newFakeDB(t, func(q fakeQuery) fakeResult {
+				switch {
+				case q.has("select count(*) from customer_addresses"):
+					return fakeResult{rows: [][]driver.Value{{tt.others}}}
+				case q.has("insert into customer_addresses"):
+					return fakeResult{rows: [][]driver.Value{{int64(9), q.args[0], "Home", "1 Main Street", "", "London", "N1", "GB", "", q.args[8], time.Now(), time.Now()}}}
+				case q.has("update customer_addresses set label"):
+					if !tt.found {
+						return fakeResult{}
+					}
+					return fakeResult{rows: [][]driver.Value{{q.args[9], q.args[10], "Home", "1 Main Street", "", "London", "N1", "GB", "", q.args[7], time.Now(), time.Now()}}}
+				}
+				return fakeResult{rowsAffected: 1}
+			})
+			app := &Config{DB: db}
+
+			saved, err := app.saveCustomerAddress(tt.address)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("saveCustomerAddress() = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if fake.commits != 0 {
+					t.Error("a failed save was committed")
+				}
+				return
+			}
+
+			if saved.IsDefault != tt.wantDefault || saved.UserID != 42 {
+				t.Errorf("saved address = %+v, want default %v", saved, tt.wantDefault)
+			}
+			if cleared := len(fake.ran("set is_default = false")) > 0; cleared != tt.wantCleared {
+				t.Errorf("cleared the other defaults = %v, want %v", cleared, tt.wantCleared)
+			}
+			if fake.commits != 1 {
+				t.Errorf("commits = %d, want 1", fake.commits)
+			}
+		})
+	}
+}
+
+func TestDeleteCustomerAddress(t *testing.T) {
+	tests := []struct {
+		name         string
+		address      []driver.Value
+		wantPromoted bool
+		wantErr      error
+	}{
+		{"the default", []driver.Value{true}, true, nil},
+		{"another address", []driver.Value{false}, false, nil},
+		{"missing address", nil, false, sql.ErrNoRows},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, fake := newFakeDB(t, func(q fakeQuery) fakeResult {
+				if q.has("delete from customer_addresses") && tt.address != nil {
+					return fakeResult{rows: [][]driver.Value{tt.address}}
+				}
+				return fakeResult{rowsAffected: 1}
+			})
+			app := &Config{DB: db}
+
+			err := app.deleteCustomerAddress(42, 5)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("deleteCustomerAddress() = %v, want %v", err, tt.wantErr)
+			}
+			if promoted := len(fake.ran("set is_default = true")) > 0; promoted != tt.wantPromoted {
+				t.Errorf("promoted another address = %v, want %v", promoted, tt.wantPromoted)
+			}
+		})
+	}
+}
+
+func TestSaveCustomerAddressHandler(t *testing.T) {
+	now := time.Now()
+	db, _ := newFakeDB(t, func(q fakeQuery) fakeResult {
+		if q.has("from users where id = $1") && q.args[0] == int64(42) {
+			return fakeResult{rows: [][]driver.Value{{int64(42), "ada@example.com", "Ada", "Lovelace", "", int64(1), "customer", now, now}}}
+		}
+		return fakeResult{}
+	})
+	app := &Config{DB: db}
+
+	tests := []struct {
+		name       string
+		target     string
+		body       string
+		wantStatus int
+	}{
+		{"unknown customer", "/customers/7/addresses", `{"line1":"1 Main Street","city":"London","postal_code":"N1","country":"GB"}`, http.StatusNotFound},
+		{"bad id", "/customers/ada/addresses", `{}`, http.StatusBadRequest},
+		{"missing fields", "/customers/42/addresses", `{"line1":"1 Main Street"}`, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, response := performRequest(t, http.MethodPost, "/customers/:id/addresses", tt.target, tt.body, nil, app.SaveCustomerAddress)
+			if status != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", status, tt.wantStatus, response.Message)
+			}
+		})
+	}
+}
@@ -2,12 +2,10 @@ package main
 
 import (
 	"database/sql"
-	"errors"
 	"log"
 	"time"
 
 	_ "github.com/lib/pq"
-	"golang.org/x/crypto/bcrypt"
 )
 
 // openDB creates a new database connection
@@ -28,7 +26,7 @@ func openDB(dsn string) (*sql.DB, error) {
 // getByEmail returns a user by email
 func (app *Config) getByEmail(email string) (*User, error) {
 	var user User
-	query := `select id, email, first_name, last_name, password, active, created_at, updated_at from users where email = $1`
+	query := `select id, email, first_name, last_name, password, active, role, created_at, updated_at from users where email = $1`
 
 	row := app.DB.QueryRow(query, email)
 	err := row.Scan(
@@ -38,6 +36,7 @@ func (app *Config) getByEmail(email string) (*User, error) {
 		&user.LastName,
 		&user.Password,
 		&user.Active,
+		&user.Role,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -49,35 +48,80 @@ func (app *Config) getByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
-// passwordMatches checks if provided password matches stored hash
-func (app *Config) passwordMatches(user *User, plainText string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(plainText))
+// getByID returns a user by id
+func (app *Config) getByID(id int) (*User, error) {
+	var user User
+	query := `select id, email, first_name, last_name, password, active, role, created_at, updated_at from users where id = $1`
+
+	row := app.DB.QueryRow(query, id)
+	err := row.Scan(
+		&user.ID,
+		&user.Email,
+		&user.FirstName,
+		&user.LastName,
+		&user.Password,
+		&user.Active,
+		&user.Role,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+
 	if err != nil {
-		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			return false, nil
-		default:
-			return false, err
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// passwordMatches checks if provided password matches stored hash. When it does and
+// the stored hash uses an outdated algorithm or parameters, the password is rehashed.
+func (app *Config) passwordMatches(user *User, plainText string) (bool, error) {
+	matches, needsRehash, err := app.Passwords.verify(plainText, user.Password)
+	if err != nil || !matches {
+		return false, err
+	}
+
+	if needsRehash {
+		err = app.updatePassword(user.ID, plainText)
+		if err != nil {
+			// The login itself succeeded; the upgrade is retried next time
+			log.Printf("Error upgrading password hash for user %d: %v", user.ID, err)
 		}
 	}
 
 	return true, nil
 }
 
+// updatePassword hashes and stores a new password for a user
+func (app *Config) updatePassword(userID int, plainText string) error {
+	hashedPassword, err := app.Passwords.hash(plainText)
+	if err != nil {
+		return err
+	}
+
+	stmt := `update users set password = $1, updated_at = $2 where id = $3`
+
+	_, err = app.DB.Exec(stmt, hashedPassword, time.Now(), userID)
+	return err
+}
+
 // InsertUser adds a new user to the database
 func (app *Config) InsertUser(user User) (int, error) {
 	// Hash the password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), 12)
+	hashedPassword, err := app.Passwords.hash(user.Password)
 	if err != nil {
 		return 0, err
 	}
 
-	user.Password = string(hashedPassword)
+	user.Password = hashedPassword
+	if user.Role == "" {
+		user.Role = roleCustomer
+	}
 	user.CreatedAt = time.Now()
 	user.UpdatedAt = time.Now()
 
-	stmt := `insert into users (email, first_name, last_name, password, active, created_at, updated_at)
-                values ($1, $2, $3, $4, $5, $6, $7) returning id`
+	stmt := `insert into users (email, first_name, last_name, password, active, role, created_at, updated_at)
+                values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
 
 	var newID int
 	err = app.DB.QueryRow(stmt,
@@ -86,6 +130,7 @@ func (app *Config) InsertUser(user User) (int, error) {
 		user.LastName,
 		user.Password,
 		user.Active,
+		user.Role,
 		user.CreatedAt,
 		user.UpdatedAt,
 	).Scan(&newID)
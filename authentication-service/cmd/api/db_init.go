@@ -25,6 +25,193 @@ func initDB(db *sql.DB) error {
 	if err != nil {
 		return err
 	}
+
+	// Add the role column to existing users tables; existing users are customers
+	usersRoleQuery := `
+	ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(50) NOT NULL DEFAULT 'customer';
+
+	CREATE INDEX IF NOT EXISTS users_role_idx ON users (role);
+	`
+
+	_, err = db.Exec(usersRoleQuery)
+	if err != nil {
+		return err
+	}
+
+	// Create api_keys table if it doesn't exist
+	apiKeysQuery := `
+	CREATE TABLE IF NOT EXISTS api_keys (
+		id SERIAL PRIMARY KEY,
+		user_id INT REFERENCES users(id) ON DELETE CASCADE,
+		name VARCHAR(255) NOT NULL,
+		prefix VARCHAR(16) NOT NULL UNIQUE,
+		key_hash VARCHAR(64) NOT NULL,
+		scopes TEXT NOT NULL DEFAULT '',
+		last_used_at TIMESTAMP WITH TIME ZONE,
+		expires_at TIMESTAMP WITH TIME ZONE,
+		revoked_at TIMESTAMP WITH TIME ZONE,
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL
+	);
+	`
+
+	_, err = db.Exec(apiKeysQuery)
+	if err != nil {
+		return err
+	}
+
+	// Create OAuth2/OpenID Connect tables if they don't exist
+	oauthQuery := `
+	CREATE TABLE IF NOT EXISTS oauth_clients (
+		id SERIAL PRIMARY KEY,
+		client_id VARCHAR(64) NOT NULL UNIQUE,
+		client_secret_hash VARCHAR(64),
+		name VARCHAR(255) NOT NULL,
+		redirect_uris TEXT NOT NULL,
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS oauth_authorization_codes (
+		code_hash VARCHAR(64) PRIMARY KEY,
+		client_id VARCHAR(64) NOT NULL REFERENCES oauth_clients(client_id) ON DELETE CASCADE,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		redirect_uri TEXT NOT NULL,
+		scope TEXT NOT NULL,
+		nonce TEXT NOT NULL DEFAULT '',
+		code_challenge VARCHAR(128) NOT NULL,
+		code_challenge_method VARCHAR(10) NOT NULL,
+		auth_time TIMESTAMP WITH TIME ZONE NOT NULL,
+		expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		used_at TIMESTAMP WITH TIME ZONE,
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS refresh_tokens (
+		id SERIAL PRIMARY KEY,
+		token_hash VARCHAR(64) NOT NULL UNIQUE,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		client_id VARCHAR(64) REFERENCES oauth_clients(client_id) ON DELETE CASCADE,
+		scope TEXT NOT NULL,
+		expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		revoked_at TIMESTAMP WITH TIME ZONE,
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL
+	);
+	`
+
+	_, err = db.Exec(oauthQuery)
+	if err != nil {
+		return err
+	}
+
+	// Create signing_keys table if it doesn't exist
+	signingKeysQuery := `
+	CREATE TABLE IF NOT EXISTS signing_keys (
+		id SERIAL PRIMARY KEY,
+		kid VARCHAR(64) NOT NULL UNIQUE,
+		private_key TEXT NOT NULL,
+		status VARCHAR(10) NOT NULL,
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		activated_at TIMESTAMP WITH TIME ZONE,
+		retired_at TIMESTAMP WITH TIME ZONE
+	);
+	`
+
+	_, err = db.Exec(signingKeysQuery)
+	if err != nil {
+		return err
+	}
+
+	// Create password_tokens table if it doesn't exist
+	passwordTokensQuery := `
+	CREATE TABLE IF NOT EXISTS password_tokens (
+		id SERIAL PRIMARY KEY,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		token_hash VARCHAR(64) NOT NULL UNIQUE,
+		purpose VARCHAR(20) NOT NULL,
+		expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		used_at TIMESTAMP WITH TIME ZONE,
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL
+	);
+	`
+
+	_, err = db.Exec(passwordTokensQuery)
+	if err != nil {
+		return err
+	}
+
+	// Create sessions and login_history tables if they don't exist. Refresh tokens
+	// belong to the session they were issued for.
+	sessionsQuery := `
+	CREATE TABLE IF NOT EXISTS sessions (
+		id SERIAL PRIMARY KEY,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		client_id VARCHAR(64) REFERENCES oauth_clients(client_id) ON DELETE CASCADE,
+		device VARCHAR(255) NOT NULL,
+		ip_address VARCHAR(45) NOT NULL,
+		user_agent TEXT NOT NULL,
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		last_seen_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		revoked_at TIMESTAMP WITH TIME ZONE
+	);
+
+	CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id);
+
+	ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS session_id INT REFERENCES sessions(id) ON DELETE CASCADE;
+
+	CREATE TABLE IF NOT EXISTS login_history (
+		id SERIAL PRIMARY KEY,
+		user_id INT REFERENCES users(id) ON DELETE CASCADE,
+		email VARCHAR(255) NOT NULL,
+		success BOOLEAN NOT NULL,
+		reason VARCHAR(50) NOT NULL DEFAULT '',
+		ip_address VARCHAR(45) NOT NULL,
+		user_agent TEXT NOT NULL,
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS login_history_user_id_created_at_idx ON login_history (user_id, created_at);
+	`
+
+	_, err = db.Exec(sessionsQuery)
+	if err != nil {
+		return err
+	}
+
+	// Create customer profile tables if they don't exist
+	customersQuery := `
+	CREATE TABLE IF NOT EXISTS customer_profiles (
+		user_id INT PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE,
+		phone VARCHAR(32) NOT NULL DEFAULT '',
+		dietary_preferences TEXT NOT NULL DEFAULT '',
+		marketing_email BOOLEAN NOT NULL DEFAULT false,
+		marketing_sms BOOLEAN NOT NULL DEFAULT false,
+		marketing_consent_at TIMESTAMP WITH TIME ZONE,
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		updated_at TIMESTAMP WITH TIME ZONE NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS customer_addresses (
+		id SERIAL PRIMARY KEY,
+		user_id INT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		label VARCHAR(100) NOT NULL,
+		line1 VARCHAR(255) NOT NULL,
+		line2 VARCHAR(255) NOT NULL DEFAULT '',
+		city VARCHAR(100) NOT NULL,
+		postal_code VARCHAR(20) NOT NULL,
+		country VARCHAR(100) NOT NULL,
+		instructions TEXT NOT NULL DEFAULT '',
+		is_default BOOLEAN NOT NULL DEFAULT false,
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL,
+		updated_at TIMESTAMP WITH TIME ZONE NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS customer_addresses_user_id_idx ON customer_addresses (user_id);
+	`
+
+	_, err = db.Exec(customersQuery)
+	if err != nil {
+		return err
+	}
 	
 	log.Println("Database tables initialized")
 	return nil
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeQuery is a statement run against a fakeDB
+type fakeQuery struct {
+	query string
+	args  []driver.Value
+}
+
+// has reports whether the statement contains each of parts, ignoring differences in
+// whitespace
+func (q fakeQuery) has(parts ...string) bool {
+	query := strings.Join(strings.Fields(q.query), " ")
+	for _, part := range parts {
+		if !strings.Contains(query, part) {
+			return false
+		}
+	}
+	return true
+}
+
+// fakeResult answers a fakeQuery: the rows for a query, or the rows affected by a
+// statement
+type fakeResult struct {
+	rows         [][]driver.Value
+	rowsAffected int64
+	err          error
+}
+
+// fakeDB is a database/sql driver for tests that hands every statement to a function,
+// so a test can answer the queries it expects. Statements, commits and rollbacks are
+// recorded.
+type fakeDB struct {
+	mu        sync.Mutex
+	answer    func(fakeQuery) fakeResult
+	queries   []fakeQuery
+	commits   int
+	rollbacks int
+}
+
+// newFakeDB opens a database whose statements are answered by answer
+func newFakeDB(t *testing.T, answer func(fakeQuery) fakeResult) (*sql.DB, *fakeDB) {
+	fake := &fakeDB{answer: answer}
+	db := sql.OpenDB(fake)
+	t.Cleanup(func() { db.Close() })
+
+	return db, fake
+}
+
+// ran returns the statements run that contain each of parts
+func (f *fakeDB) ran(parts ...string) []fakeQuery {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var matches []fakeQuery
+	for _, q := range f.queries {
+		if q.has(parts...) {
+			matches = append(matches, q)
+		}
+	}
+	return matches
+}
+
+func (f *fakeDB) run(query string, args []driver.Value) fakeResult {
+	q := fakeQuery{query: query, args: args}
+
+	f.mu.Lock()
+	f.queries = append(f.queries, q)
+	f.mu.Unlock()
+
+	return f.answer(q)
+}
+
+func (f *fakeDB) Connect(context.Context) (driver.Conn, error) { return &fakeConn{db: f}, nil }
+func (f *fakeDB) Driver() driver.Driver                        { return fakeDriver{} }
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return nil, fmt.Errorf("fakeDB is opened with sql.OpenDB")
+}
+
+type fakeConn struct{ db *fakeDB }
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{db: c.db, query: query}, nil
+}
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return &fakeTx{db: c.db}, nil }
+
+type fakeTx struct{ db *fakeDB }
+
+func (tx *fakeTx) Commit() error {
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	tx.db.commits++
+	return nil
+}
+
+func (tx *fakeTx) Rollback() error {
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	tx.db.rollbacks++
+	return nil
+}
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	result := s.db.run(s.query, args)
+	if result.err != nil {
+		return nil, result.err
+	}
+	return driver.RowsAffected(result.rowsAffected), nil
+}
+
+func (s *fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	result := s.db.run(s.query, args)
+	if result.err != nil {
+		return nil, result.err
+	}
+	return &fakeRows{rows: result.rows}, nil
+}
+
+type fakeRows struct {
+	rows [][]driver.Value
+	next int
+}
+
+func (r *fakeRows) Columns() []string {
+	if len(r.rows) == 0 {
+		return nil
+	}
+
+	columns := make([]string, len(r.rows[0]))
+	for i := range columns {
+		columns[i] = fmt.Sprintf("column%d", i+1)
+	}
+	return columns
+}
+
+func (r *fakeRows) Close() error { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next == len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.next])
+	r.next++
+	return nil
+}
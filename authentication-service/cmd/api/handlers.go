@@ -4,16 +4,17 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"golang.org/x/crypto/bcrypt"
 )
 
 func (app *Config) Authenticate(c *gin.Context) {
 	var requestPayload struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		Device   string `json:"device,omitempty"`
 	}
 
 	err := app.readJSON(c, &requestPayload)
@@ -25,6 +26,7 @@ func (app *Config) Authenticate(c *gin.Context) {
 	// Validate the user against the database
 	user, err := app.getByEmail(requestPayload.Email)
 	if err != nil {
+		app.recordLogin(c, requestPayload.Email, nil, false, loginFailureUnknownUser)
 		app.errorJSON(c, errors.New("invalid credentials"), http.StatusUnauthorized)
 		return
 	}
@@ -32,10 +34,33 @@ func (app *Config) Authenticate(c *gin.Context) {
 	// Check password
 	valid, err := app.passwordMatches(user, requestPayload.Password)
 	if err != nil || !valid {
+		app.recordLogin(c, requestPayload.Email, user, false, loginFailureWrongPassword)
 		app.errorJSON(c, errors.New("invalid credentials"), http.StatusUnauthorized)
 		return
 	}
 
+	// Disabled accounts can't log in
+	if user.Active != 1 {
+		app.recordLogin(c, requestPayload.Email, user, false, loginFailureInactive)
+		app.errorJSON(c, errors.New("invalid credentials"), http.StatusUnauthorized)
+		return
+	}
+
+	app.recordLogin(c, requestPayload.Email, user, true, "")
+
+	// Start a session for this device
+	sessionID, err := app.insertSession(c, user.ID, "", requestPayload.Device)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	tokens, err := app.issueTokens(user, "", "offline_access", "", time.Now(), sessionID)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
 	// Log authentication
 	err = app.logRequest("authentication", fmt.Sprintf("User %s logged in", user.Email))
 	if err != nil {
@@ -43,6 +68,8 @@ func (app *Config) Authenticate(c *gin.Context) {
 		return
 	}
 
+	user.Password = "" // Don't return the hashed password
+
 	payload := struct {
 		Error   bool  `json:"error"`
 		Message string `json:"message"`
@@ -50,7 +77,12 @@ func (app *Config) Authenticate(c *gin.Context) {
 	}{
 		Error:   false,
 		Message: fmt.Sprintf("Logged in user %s", user.Email),
-		Data:    user,
+		// The user's fields stay at the top level of data, next to the session's tokens
+		Data: struct {
+			*User
+			tokenResponse
+			SessionID int `json:"session_id"`
+		}{user, tokens, sessionID},
 	}
 
 	app.writeJSON(c, http.StatusOK, payload)
@@ -65,38 +97,34 @@ func (app *Config) CreateUser(c *gin.Context) {
 		return
 	}
 
-	// Hash the password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), 12)
-	if err != nil {
-		app.errorJSON(c, err, http.StatusInternalServerError)
+	// Validate the user, including the password against the password policy
+	errs := []FieldError{}
+	if strings.TrimSpace(user.Email) == "" {
+		errs = append(errs, FieldError{Field: "email", Message: "email is required"})
+	}
+	if user.Role != "" && !validRoles[user.Role] {
+		errs = append(errs, FieldError{Field: "role", Message: "role must be customer, staff or admin"})
+	}
+	errs = append(errs, app.Policy.validate(user.Password, user)...)
+	if len(errs) > 0 {
+		app.validationErrorJSON(c, errs)
 		return
 	}
 
-	user.Password = string(hashedPassword)
-	user.CreatedAt = time.Now()
-	user.UpdatedAt = time.Now()
-
 	// Insert the user
-	stmt := `insert into users (email, first_name, last_name, password, active, created_at, updated_at)
-		values ($1, $2, $3, $4, $5, $6, $7) returning id`
-
-	var newID int
-	err = app.DB.QueryRow(stmt,
-		user.Email,
-		user.FirstName,
-		user.LastName,
-		user.Password,
-		user.Active,
-		user.CreatedAt,
-		user.UpdatedAt,
-	).Scan(&newID)
+	newID, err := app.InsertUser(user)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
 
+	created, err := app.getByID(newID)
 	if err != nil {
 		app.errorJSON(c, err, http.StatusInternalServerError)
 		return
 	}
 
-	user.ID = newID
+	user = *created
 	user.Password = "" // Don't return the hashed password
 
 	payload := struct {
@@ -112,46 +140,32 @@ func (app *Config) CreateUser(c *gin.Context) {
 	app.writeJSON(c, http.StatusCreated, payload)
 }
 
+// GetAllUsers returns a page of users. Supported query parameters are q (matches email
+// or name), email, name, active, role, sort (id, email, first_name, last_name or
+// created_at, prefixed with "-" for descending), order, limit and cursor.
 func (app *Config) GetAllUsers(c *gin.Context) {
-	var users []User
-	
-	query := `select id, email, first_name, last_name, active, created_at, updated_at from users order by last_name`
-	
-	rows, err := app.DB.Query(query)
+	query, err := parseUserListQuery(c.Request.URL.Query())
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	users, total, nextCursor, err := app.listUsers(query)
 	if err != nil {
 		app.errorJSON(c, err, http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-	
-	for rows.Next() {
-		var user User
-		err := rows.Scan(
-			&user.ID,
-			&user.Email,
-			&user.FirstName,
-			&user.LastName,
-			&user.Active,
-			&user.CreatedAt,
-			&user.UpdatedAt,
-		)
-		if err != nil {
-			app.errorJSON(c, err, http.StatusInternalServerError)
-			return
-		}
-		
-		users = append(users, user)
-	}
-	
-	payload := struct {
-		Error   bool  `json:"error"`
-		Message string `json:"message"`
-		Data    any   `json:"data,omitempty"`
-	}{
+
+	payload := jsonResponse{
 		Error:   false,
 		Message: "Users retrieved",
 		Data:    users,
+		Meta: gin.H{
+			"total":       total,
+			"limit":       query.Limit,
+			"next_cursor": nextCursor,
+		},
 	}
-	
+
 	app.writeJSON(c, http.StatusOK, payload)
 }
@@ -1,6 +1,11 @@
 package main
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -10,6 +15,7 @@ type jsonResponse struct {
 	Error   bool   `json:"error"`
 	Message string `json:"message"`
 	Data    any    `json:"data,omitempty"`
+	Meta    any    `json:"meta,omitempty"`
 }
 
 // readJSON tries to read the body of a request and converts it into JSON
@@ -36,6 +42,16 @@ func (app *Config) errorJSON(c *gin.Context, err error, status ...int) {
 	c.JSON(statusCode, payload)
 }
 
+// validationErrorJSON sends a 422 response listing the problems with individual fields
+func (app *Config) validationErrorJSON(c *gin.Context, errs []FieldError) {
+	var payload jsonResponse
+	payload.Error = true
+	payload.Message = "validation failed"
+	payload.Data = gin.H{"errors": errs}
+
+	c.JSON(http.StatusUnprocessableEntity, payload)
+}
+
 // writeJSON takes a response status code and arbitrary data and writes a json response to the client
 func (app *Config) writeJSON(c *gin.Context, status int, data any, headers ...http.Header) error {
 	// Add headers if they exist
@@ -50,3 +66,28 @@ func (app *Config) writeJSON(c *gin.Context, status int, data any, headers ...ht
 	c.JSON(status, data)
 	return nil
 }
+
+// randomToken returns a URL safe random string built from n random bytes
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken hashes a high entropy secret (API key, refresh token, authorization code)
+// for storage. These secrets are random, so a fast hash is enough; a slow password
+// hash would only add latency to every request.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// sendMail sends an email to a user
+func (app *Config) sendMail(to, subject, body string) error {
+	// In a real application, this would hand the message to a mail service
+	log.Printf("Mail to %s: %s\n%s", to, subject, body)
+	return nil
+}
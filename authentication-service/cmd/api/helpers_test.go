@@ -0,0 +1,54 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// performRequest sends a request through a router that serves path with handlers and
+// returns the status and decoded JSON response
+func performRequest(t *testing.T, method, path, target, body string, header http.Header, handlers ...gin.HandlerFunc) (int, jsonResponse) {
+	recorder := serveRequest(method, path, target, body, header, handlers...)
+
+	var response jsonResponse
+	if recorder.Body.Len() > 0 {
+		if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+			t.Fatalf("%s %s returned invalid JSON %q: %v", method, target, recorder.Body.String(), err)
+		}
+	}
+
+	return recorder.Code, response
+}
+
+// serveRequest sends a request through a router that serves path with handlers. A
+// body starting with "{" is sent as JSON and any other body as a form.
+func serveRequest(method, path, target, body string, header http.Header, handlers ...gin.HandlerFunc) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Handle(method, path, handlers...)
+
+	var reader io.Reader
+	if body != "" {
+		reader = strings.NewReader(body)
+	}
+	request := httptest.NewRequest(method, target, reader)
+	if strings.HasPrefix(body, "{") {
+		request.Header.Set("Content-Type", "application/json")
+	} else if body != "" {
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	for key, values := range header {
+		request.Header[key] = values
+	}
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	return recorder
+}
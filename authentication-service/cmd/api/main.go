@@ -7,6 +7,7 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/gin-contrib/cors"
@@ -19,8 +20,14 @@ const webPort = "8001"
 var counts int64
 
 type Config struct {
-	DB     *sql.DB
-	router *gin.Engine
+	DB        *sql.DB
+	router    *gin.Engine
+	Passwords *passwordHashing
+	Policy    *passwordPolicy
+
+	// Issuer is the OpenID Connect issuer identifier, the public base URL of this service
+	Issuer string
+	keys   *keyManager
 }
 
 type User struct {
@@ -30,6 +37,7 @@ type User struct {
 	FirstName string    `json:"first_name,omitempty"`
 	LastName  string    `json:"last_name,omitempty"`
 	Active    int       `json:"active"`
+	Role      string    `json:"role"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 }
@@ -44,9 +52,32 @@ func main() {
 		log.Panic("Can't connect to Postgres!")
 	}
 
+	// Load the keys used to sign tokens and keep rotating them
+	keys, err := newKeyManager(conn)
+	if err != nil {
+		log.Panic(err)
+	}
+	go keys.scheduleRotation(rotationInterval())
+
+	// Configure password hashing
+	passwords, err := newPasswordHashing()
+	if err != nil {
+		log.Panic(err)
+	}
+
+	// Load the password policy and breached password list
+	policy, err := newPasswordPolicy()
+	if err != nil {
+		log.Panic(err)
+	}
+
 	// Set up application config
 	app := Config{
-		DB: conn,
+		DB:        conn,
+		Passwords: passwords,
+		Policy:    policy,
+		Issuer:    issuerURL(),
+		keys:      keys,
 	}
 
 	// Set up Gin router with middleware
@@ -77,6 +108,16 @@ func main() {
 	}
 }
 
+// issuerURL returns the OpenID Connect issuer, configured with OIDC_ISSUER
+func issuerURL() string {
+	issuer := strings.TrimSuffix(os.Getenv("OIDC_ISSUER"), "/")
+	if issuer == "" {
+		return fmt.Sprintf("http://localhost:%s", webPort)
+	}
+
+	return issuer
+}
+
 // Connect to database
 func connectToDB() *sql.DB {
 	// Get the database URL from environment
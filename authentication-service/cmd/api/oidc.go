@@ -0,0 +1,974 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	authorizationCodeTTL = 5 * time.Minute
+	accessTokenTTL       = 15 * time.Minute
+	idTokenTTL           = time.Hour
+	refreshTokenTTL      = 30 * 24 * time.Hour
+)
+
+// supportedScopes are the OpenID Connect scopes this provider understands
+var supportedScopes = map[string]bool{
+	"openid":         true,
+	"profile":        true,
+	"email":          true,
+	"offline_access": true,
+}
+
+// OAuthClient is an application, such as the partner delivery app, that logs users
+// in with their accounts here
+type OAuthClient struct {
+	ID           int       `json:"id"`
+	ClientID     string    `json:"client_id"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	Confidential bool      `json:"confidential"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// allowsRedirect reports whether uri exactly matches one of the registered redirect URIs
+func (client *OAuthClient) allowsRedirect(uri string) bool {
+	for _, registered := range client.RedirectURIs {
+		if registered == uri {
+			return true
+		}
+	}
+
+	return false
+}
+
+// authorizationCode is a pending authorization code grant
+type authorizationCode struct {
+	ClientID            string
+	UserID              int
+	RedirectURI         string
+	Scope               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	AuthTime            time.Time
+	ExpiresAt           time.Time
+}
+
+// refreshToken is a stored refresh token, identified by the hash of its value
+type refreshToken struct {
+	ID        int
+	UserID    int
+	ClientID  string
+	SessionID int
+	Scope     string
+	ExpiresAt time.Time
+	RevokedAt *time.Time
+}
+
+// tokenResponse is the body returned from the token endpoint
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+// hasScope reports whether a space separated scope string contains scope
+func hasScope(scopes, scope string) bool {
+	for _, s := range strings.Fields(scopes) {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// pkceMatches checks a PKCE code verifier against the S256 challenge
+func pkceMatches(challenge, verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+// signToken signs claims with the active signing key
+func (app *Config) signToken(claims map[string]any) (string, error) {
+	key, err := app.keys.active()
+	if err != nil {
+		return "", err
+	}
+
+	return signJWT(key.privateKey, key.Kid, claims)
+}
+
+// publicKeys returns the keys published in the JWKS document: the next key, the
+// active key and retired keys whose tokens may still be valid
+func (app *Config) publicKeys() []JWK {
+	jwks := []JWK{}
+	for _, key := range app.keys.all() {
+		jwks = append(jwks, publicJWK(key.Kid, &key.privateKey.PublicKey))
+	}
+
+	return jwks
+}
+
+// verifyAccessToken checks an access token issued by this service and returns its claims
+func (app *Config) verifyAccessToken(token string) (map[string]any, error) {
+	claims, err := parseJWT(token, app.keys.publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims["iss"] != app.Issuer || claims["token_use"] != "access" {
+		return nil, errors.New("invalid access token")
+	}
+
+	return claims, nil
+}
+
+// bearerToken extracts the token from an "Authorization: Bearer" header
+func bearerToken(c *gin.Context) (string, bool) {
+	scheme, token, found := strings.Cut(c.GetHeader("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+		return "", false
+	}
+
+	return strings.TrimSpace(token), true
+}
+
+// insertOAuthClient registers a client and returns its plain text secret, if it has one
+func (app *Config) insertOAuthClient(client OAuthClient) (OAuthClient, string, error) {
+	clientID, err := randomToken(16)
+	if err != nil {
+		return OAuthClient{}, "", err
+	}
+
+	var secret string
+	var secretHash sql.NullString
+	if client.Confidential {
+		secret, err = randomToken(32)
+		if err != nil {
+			return OAuthClient{}, "", err
+		}
+		secretHash = sql.NullString{String: hashToken(secret), Valid: true}
+	}
+
+	client.ClientID = clientID
+	client.CreatedAt = time.Now()
+
+	stmt := `insert into oauth_clients (client_id, client_secret_hash, name, redirect_uris, created_at)
+		values ($1, $2, $3, $4, $5) returning id`
+
+	err = app.DB.QueryRow(stmt,
+		client.ClientID,
+		secretHash,
+		client.Name,
+		strings.Join(client.RedirectURIs, " "),
+		client.CreatedAt,
+	).Scan(&client.ID)
+	if err != nil {
+		return OAuthClient{}, "", err
+	}
+
+	return client, secret, nil
+}
+
+// scanOAuthClient scans a row of oauth_clients columns
+func scanOAuthClient(row interface{ Scan(...any) error }) (OAuthClient, string, error) {
+	var client OAuthClient
+	var secretHash sql.NullString
+	var redirectURIs string
+
+	err := row.Scan(
+		&client.ID,
+		&client.ClientID,
+		&secretHash,
+		&client.Name,
+		&redirectURIs,
+		&client.CreatedAt,
+	)
+	if err != nil {
+		return OAuthClient{}, "", err
+	}
+
+	client.RedirectURIs = strings.Fields(redirectURIs)
+	client.Confidential = secretHash.Valid
+
+	return client, secretHash.String, nil
+}
+
+// getOAuthClient returns a client and its secret hash by client_id
+func (app *Config) getOAuthClient(clientID string) (OAuthClient, string, error) {
+	query := `select id, client_id, client_secret_hash, name, redirect_uris, created_at
+		from oauth_clients where client_id = $1`
+
+	return scanOAuthClient(app.DB.QueryRow(query, clientID))
+}
+
+// getOAuthClients returns all registered clients
+func (app *Config) getOAuthClients() ([]OAuthClient, error) {
+	query := `select id, client_id, client_secret_hash, name, redirect_uris, created_at
+		from oauth_clients order by name`
+
+	rows, err := app.DB.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []OAuthClient{}
+	for rows.Next() {
+		client, _, err := scanOAuthClient(rows)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+
+	return clients, rows.Err()
+}
+
+// insertAuthorizationCode stores a new authorization code and returns its plain text value
+func (app *Config) insertAuthorizationCode(code authorizationCode) (string, error) {
+	plainText, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	stmt := `insert into oauth_authorization_codes
+		(code_hash, client_id, user_id, redirect_uri, scope, nonce, code_challenge, code_challenge_method, auth_time, expires_at, created_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
+
+	_, err = app.DB.Exec(stmt,
+		hashToken(plainText),
+		code.ClientID,
+		code.UserID,
+		code.RedirectURI,
+		code.Scope,
+		code.Nonce,
+		code.CodeChallenge,
+		code.CodeChallengeMethod,
+		code.AuthTime,
+		code.ExpiresAt,
+		time.Now(),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	return plainText, nil
+}
+
+// consumeAuthorizationCode marks a code as used and returns it. A code can only be
+// exchanged once.
+func (app *Config) consumeAuthorizationCode(plainText string) (*authorizationCode, error) {
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var code authorizationCode
+	var usedAt sql.NullTime
+
+	query := `select client_id, user_id, redirect_uri, scope, nonce, code_challenge, code_challenge_method, auth_time, expires_at, used_at
+		from oauth_authorization_codes where code_hash = $1 for update`
+
+	err = tx.QueryRow(query, hashToken(plainText)).Scan(
+		&code.ClientID,
+		&code.UserID,
+		&code.RedirectURI,
+		&code.Scope,
+		&code.Nonce,
+		&code.CodeChallenge,
+		&code.CodeChallengeMethod,
+		&code.AuthTime,
+		&code.ExpiresAt,
+		&usedAt,
+	)
+	if err != nil {
+		return nil, errors.New("invalid authorization code")
+	}
+
+	if usedAt.Valid {
+		return nil, errors.New("authorization code already used")
+	}
+
+	if time.Now().After(code.ExpiresAt) {
+		return nil, errors.New("authorization code expired")
+	}
+
+	_, err = tx.Exec(`update oauth_authorization_codes set used_at = $1 where code_hash = $2`, time.Now(), hashToken(plainText))
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return &code, nil
+}
+
+// insertRefreshToken stores a new refresh token for a session and returns its plain
+// text value
+func (app *Config) insertRefreshToken(userID int, clientID, scope string, sessionID int) (string, error) {
+	plainText, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	stmt := `insert into refresh_tokens (token_hash, user_id, client_id, session_id, scope, expires_at, created_at)
+		values ($1, $2, $3, $4, $5, $6, $7)`
+
+	_, err = app.DB.Exec(stmt,
+		hashToken(plainText),
+		userID,
+		sql.NullString{String: clientID, Valid: clientID != ""},
+		sql.NullInt64{Int64: int64(sessionID), Valid: sessionID != 0},
+		scope,
+		now.Add(refreshTokenTTL),
+		now,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	return plainText, nil
+}
+
+// getRefreshToken looks up a refresh token by its plain text value
+func (app *Config) getRefreshToken(plainText string) (*refreshToken, error) {
+	var token refreshToken
+	var clientID sql.NullString
+	var sessionID sql.NullInt64
+	var revokedAt sql.NullTime
+
+	query := `select id, user_id, client_id, session_id, scope, expires_at, revoked_at from refresh_tokens where token_hash = $1`
+
+	err := app.DB.QueryRow(query, hashToken(plainText)).Scan(
+		&token.ID,
+		&token.UserID,
+		&clientID,
+		&sessionID,
+		&token.Scope,
+		&token.ExpiresAt,
+		&revokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	token.ClientID = clientID.String
+	token.SessionID = int(sessionID.Int64)
+	if revokedAt.Valid {
+		token.RevokedAt = &revokedAt.Time
+	}
+
+	return &token, nil
+}
+
+// errRefreshTokenReused means a refresh token was already revoked, possibly by a
+// request using the same token at the same time
+var errRefreshTokenReused = errors.New("refresh token has already been used")
+
+// revokeRefreshToken revokes a refresh token by id. It fails with errRefreshTokenReused
+// if the token was revoked concurrently, so only one request can rotate it.
+func (app *Config) revokeRefreshToken(id int) error {
+	result, err := app.DB.Exec(`update refresh_tokens set revoked_at = $1 where id = $2 and revoked_at is null`, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errRefreshTokenReused
+	}
+
+	return nil
+}
+
+// issueTokens creates the access token, id token and (with offline_access) refresh
+// token returned from the token endpoint. Refresh tokens belong to sessionID.
+func (app *Config) issueTokens(user *User, clientID, scope, nonce string, authTime time.Time, sessionID int) (tokenResponse, error) {
+	now := time.Now()
+
+	jti, err := randomToken(16)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	accessClaims := map[string]any{
+		"iss":       app.Issuer,
+		"sub":       strconv.Itoa(user.ID),
+		"aud":       clientID,
+		"client_id": clientID,
+		"scope":     scope,
+		"token_use": "access",
+		"iat":       now.Unix(),
+		"exp":       now.Add(accessTokenTTL).Unix(),
+		"jti":       jti,
+	}
+	if sessionID != 0 {
+		accessClaims["sid"] = sessionID
+	}
+
+	accessToken, err := app.signToken(accessClaims)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+
+	response := tokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(accessTokenTTL.Seconds()),
+		Scope:       scope,
+	}
+
+	if hasScope(scope, "openid") {
+		claims := map[string]any{
+			"iss":       app.Issuer,
+			"sub":       strconv.Itoa(user.ID),
+			"aud":       clientID,
+			"iat":       now.Unix(),
+			"exp":       now.Add(idTokenTTL).Unix(),
+			"auth_time": authTime.Unix(),
+		}
+		if nonce != "" {
+			claims["nonce"] = nonce
+		}
+		for key, value := range userClaims(user, scope) {
+			claims[key] = value
+		}
+
+		response.IDToken, err = app.signToken(claims)
+		if err != nil {
+			return tokenResponse{}, err
+		}
+	}
+
+	if hasScope(scope, "offline_access") {
+		response.RefreshToken, err = app.insertRefreshToken(user.ID, clientID, scope, sessionID)
+		if err != nil {
+			return tokenResponse{}, err
+		}
+	}
+
+	return response, nil
+}
+
+// userClaims returns the standard claims about a user that the granted scope allows
+func userClaims(user *User, scope string) map[string]any {
+	claims := map[string]any{
+		"sub": strconv.Itoa(user.ID),
+	}
+
+	if hasScope(scope, "email") {
+		claims["email"] = user.Email
+		claims["email_verified"] = false
+	}
+
+	if hasScope(scope, "profile") {
+		claims["given_name"] = user.FirstName
+		claims["family_name"] = user.LastName
+		claims["name"] = strings.TrimSpace(user.FirstName + " " + user.LastName)
+		claims["updated_at"] = user.UpdatedAt.Unix()
+	}
+
+	return claims
+}
+
+// oauthError writes an error in the format defined by RFC 6749
+func (app *Config) oauthError(c *gin.Context, status int, code, description string) {
+	c.Header("Cache-Control", "no-store")
+	c.JSON(status, gin.H{
+		"error":             code,
+		"error_description": description,
+	})
+}
+
+// redirectWithParams redirects the user agent back to the client with the given query parameters
+func redirectWithParams(c *gin.Context, redirectURI string, params url.Values) {
+	target, err := url.Parse(redirectURI)
+	if err != nil {
+		c.String(http.StatusBadRequest, "invalid redirect_uri")
+		return
+	}
+
+	query := target.Query()
+	for key, values := range params {
+		for _, value := range values {
+			query.Add(key, value)
+		}
+	}
+	target.RawQuery = query.Encode()
+
+	c.Redirect(http.StatusFound, target.String())
+}
+
+func (app *Config) RegisterOAuthClient(c *gin.Context) {
+	var requestPayload struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Confidential bool     `json:"confidential"`
+	}
+
+	err := app.readJSON(c, &requestPayload)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	if strings.TrimSpace(requestPayload.Name) == "" || len(requestPayload.RedirectURIs) == 0 {
+		app.errorJSON(c, errors.New("name and at least one redirect_uri are required"), http.StatusBadRequest)
+		return
+	}
+
+	for _, uri := range requestPayload.RedirectURIs {
+		parsed, err := url.Parse(uri)
+		if err != nil || !parsed.IsAbs() || parsed.Fragment != "" || strings.ContainsAny(uri, " ") {
+			app.errorJSON(c, fmt.Errorf("invalid redirect_uri: %s", uri), http.StatusBadRequest)
+			return
+		}
+	}
+
+	client, secret, err := app.insertOAuthClient(OAuthClient{
+		Name:         requestPayload.Name,
+		RedirectURIs: requestPayload.RedirectURIs,
+		Confidential: requestPayload.Confidential,
+	})
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = app.logRequest("oauth", fmt.Sprintf("Registered client %s (%s)", client.ClientID, client.Name))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Client registered",
+		Data: struct {
+			OAuthClient
+			ClientSecret string `json:"client_secret,omitempty"`
+		}{client, secret},
+	}
+
+	app.writeJSON(c, http.StatusCreated, payload)
+}
+
+func (app *Config) GetOAuthClients(c *gin.Context) {
+	clients, err := app.getOAuthClients()
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Clients retrieved",
+		Data:    clients,
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+// authorizeRequest holds the parameters of an authorization request
+type authorizeRequest struct {
+	ClientID            string
+	ClientName          string
+	RedirectURI         string
+	ResponseType        string
+	Scope               string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	Error               string
+}
+
+var loginTemplate = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Sign in</title></head>
+<body>
+	<h1>Sign in to {{.ClientName}}</h1>
+	{{if .Error}}<p style="color: red">{{.Error}}</p>{{end}}
+	<form method="post" action="/authorize">
+		<input type="hidden" name="client_id" value="{{.ClientID}}">
+		<input type="hidden" name="redirect_uri" value="{{.RedirectURI}}">
+		<input type="hidden" name="response_type" value="{{.ResponseType}}">
+		<input type="hidden" name="scope" value="{{.Scope}}">
+		<input type="hidden" name="state" value="{{.State}}">
+		<input type="hidden" name="nonce" value="{{.Nonce}}">
+		<input type="hidden" name="code_challenge" value="{{.CodeChallenge}}">
+		<input type="hidden" name="code_challenge_method" value="{{.CodeChallengeMethod}}">
+		<label>Email <input type="email" name="email" required></label>
+		<label>Password <input type="password" name="password" required></label>
+		<button type="submit">Sign in</button>
+	</form>
+</body>
+</html>
+`))
+
+// readAuthorizeRequest reads and validates authorization request parameters from the
+// query string (GET) or form body (POST). If the client or redirect URI can't be
+// trusted, the error is shown to the user instead of being sent to the redirect URI.
+func (app *Config) readAuthorizeRequest(c *gin.Context, get func(string) string) (*authorizeRequest, *OAuthClient, bool) {
+	req := &authorizeRequest{
+		ClientID:            get("client_id"),
+		RedirectURI:         get("redirect_uri"),
+		ResponseType:        get("response_type"),
+		Scope:               get("scope"),
+		State:               get("state"),
+		Nonce:               get("nonce"),
+		CodeChallenge:       get("code_challenge"),
+		CodeChallengeMethod: get("code_challenge_method"),
+	}
+
+	client, _, err := app.getOAuthClient(req.ClientID)
+	if err != nil {
+		c.String(http.StatusBadRequest, "unknown client_id")
+		return nil, nil, false
+	}
+
+	if !client.allowsRedirect(req.RedirectURI) {
+		c.String(http.StatusBadRequest, "redirect_uri is not registered for this client")
+		return nil, nil, false
+	}
+	req.ClientName = client.Name
+
+	fail := func(code, description string) (*authorizeRequest, *OAuthClient, bool) {
+		params := url.Values{
+			"error":             {code},
+			"error_description": {description},
+		}
+		if req.State != "" {
+			params.Set("state", req.State)
+		}
+		redirectWithParams(c, req.RedirectURI, params)
+		return nil, nil, false
+	}
+
+	if req.ResponseType != "code" {
+		return fail("unsupported_response_type", "only the code response type is supported")
+	}
+
+	if !hasScope(req.Scope, "openid") {
+		return fail("invalid_scope", "the openid scope is required")
+	}
+
+	for _, scope := range strings.Fields(req.Scope) {
+		if !supportedScopes[scope] {
+			return fail("invalid_scope", fmt.Sprintf("unsupported scope: %s", scope))
+		}
+	}
+
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != "S256" {
+		return fail("invalid_request", "a PKCE code_challenge using S256 is required")
+	}
+
+	return req, &client, true
+}
+
+// Authorize shows the login form for an authorization request
+func (app *Config) Authorize(c *gin.Context) {
+	req, _, ok := app.readAuthorizeRequest(c, c.Query)
+	if !ok {
+		return
+	}
+
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(http.StatusOK)
+	_ = loginTemplate.Execute(c.Writer, req)
+}
+
+// AuthorizeSubmit checks the credentials posted from the login form and redirects back
+// to the client with an authorization code
+func (app *Config) AuthorizeSubmit(c *gin.Context) {
+	req, client, ok := app.readAuthorizeRequest(c, c.PostForm)
+	if !ok {
+		return
+	}
+
+	showError := func(message string) {
+		req.Error = message
+		c.Header("Content-Type", "text/html; charset=utf-8")
+		c.Status(http.StatusUnauthorized)
+		_ = loginTemplate.Execute(c.Writer, req)
+	}
+
+	email := c.PostForm("email")
+	user, err := app.getByEmail(email)
+	if err != nil {
+		app.recordLogin(c, email, nil, false, loginFailureUnknownUser)
+		showError("Invalid email or password")
+		return
+	}
+
+	valid, err := app.passwordMatches(user, c.PostForm("password"))
+	if err != nil || !valid {
+		app.recordLogin(c, email, user, false, loginFailureWrongPassword)
+		showError("Invalid email or password")
+		return
+	}
+
+	if user.Active != 1 {
+		app.recordLogin(c, email, user, false, loginFailureInactive)
+		showError("Invalid email or password")
+		return
+	}
+
+	app.recordLogin(c, email, user, true, "")
+
+	now := time.Now()
+	code, err := app.insertAuthorizationCode(authorizationCode{
+		ClientID:            client.ClientID,
+		UserID:              user.ID,
+		RedirectURI:         req.RedirectURI,
+		Scope:               req.Scope,
+		Nonce:               req.Nonce,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		AuthTime:            now,
+		ExpiresAt:           now.Add(authorizationCodeTTL),
+	})
+	if err != nil {
+		params := url.Values{"error": {"server_error"}}
+		if req.State != "" {
+			params.Set("state", req.State)
+		}
+		redirectWithParams(c, req.RedirectURI, params)
+		return
+	}
+
+	_ = app.logRequest("oauth", fmt.Sprintf("User %s authorized client %s", user.Email, client.ClientID))
+
+	params := url.Values{"code": {code}}
+	if req.State != "" {
+		params.Set("state", req.State)
+	}
+	redirectWithParams(c, req.RedirectURI, params)
+}
+
+// authenticateClient identifies the client calling the token endpoint, using HTTP
+// basic auth or form parameters. Confidential clients must present their secret.
+func (app *Config) authenticateClient(c *gin.Context) (*OAuthClient, error) {
+	clientID, secret, basic := c.Request.BasicAuth()
+	if basic {
+		// Basic credentials are form encoded per RFC 6749 section 2.3.1
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID = c.PostForm("client_id")
+		secret = c.PostForm("client_secret")
+	}
+
+	client, secretHash, err := app.getOAuthClient(clientID)
+	if err != nil {
+		return nil, errors.New("unknown client")
+	}
+
+	if client.Confidential {
+		if secret == "" || subtle.ConstantTimeCompare([]byte(secretHash), []byte(hashToken(secret))) != 1 {
+			return nil, errors.New("invalid client credentials")
+		}
+	}
+
+	return &client, nil
+}
+
+// Token exchanges an authorization code or refresh token for tokens
+func (app *Config) Token(c *gin.Context) {
+	client, err := app.authenticateClient(c)
+	if err != nil {
+		app.oauthError(c, http.StatusUnauthorized, "invalid_client", err.Error())
+		return
+	}
+
+	var user *User
+	var scope, nonce string
+	var sessionID int
+	authTime := time.Now()
+
+	switch c.PostForm("grant_type") {
+	case "authorization_code":
+		code, err := app.consumeAuthorizationCode(c.PostForm("code"))
+		if err != nil {
+			app.oauthError(c, http.StatusBadRequest, "invalid_grant", err.Error())
+			return
+		}
+
+		if code.ClientID != client.ClientID || code.RedirectURI != c.PostForm("redirect_uri") {
+			app.oauthError(c, http.StatusBadRequest, "invalid_grant", "authorization code was issued to another client or redirect_uri")
+			return
+		}
+
+		if !pkceMatches(code.CodeChallenge, c.PostForm("code_verifier")) {
+			app.oauthError(c, http.StatusBadRequest, "invalid_grant", "code_verifier does not match code_challenge")
+			return
+		}
+
+		user, err = app.getByID(code.UserID)
+		if err != nil {
+			app.oauthError(c, http.StatusBadRequest, "invalid_grant", "user no longer exists")
+			return
+		}
+		scope, nonce, authTime = code.Scope, code.Nonce, code.AuthTime
+
+	case "refresh_token":
+		token, err := app.getRefreshToken(c.PostForm("refresh_token"))
+		if err != nil || time.Now().After(token.ExpiresAt) || token.ClientID != client.ClientID {
+			app.oauthError(c, http.StatusBadRequest, "invalid_grant", "invalid refresh token")
+			return
+		}
+
+		if token.RevokedAt != nil {
+			// A rotated token being used again means it has leaked, so end the session
+			if token.SessionID != 0 {
+				_ = app.revokeSession(token.UserID, token.SessionID)
+			}
+			app.oauthError(c, http.StatusBadRequest, "invalid_grant", "invalid refresh token")
+			return
+		}
+
+		// Refresh tokens are rotated on every use. Losing a race to rotate the token is
+		// treated like reusing it.
+		err = app.revokeRefreshToken(token.ID)
+		if errors.Is(err, errRefreshTokenReused) {
+			if token.SessionID != 0 {
+				_ = app.revokeSession(token.UserID, token.SessionID)
+			}
+			app.oauthError(c, http.StatusBadRequest, "invalid_grant", "invalid refresh token")
+			return
+		}
+		if err != nil {
+			app.oauthError(c, http.StatusInternalServerError, "server_error", err.Error())
+			return
+		}
+
+		if token.SessionID != 0 {
+			err = app.touchSession(c, token.SessionID)
+			if err != nil {
+				app.oauthError(c, http.StatusBadRequest, "invalid_grant", "invalid refresh token")
+				return
+			}
+		}
+
+		user, err = app.getByID(token.UserID)
+		if err != nil {
+			app.oauthError(c, http.StatusBadRequest, "invalid_grant", "user no longer exists")
+			return
+		}
+		scope, sessionID = token.Scope, token.SessionID
+
+	default:
+		app.oauthError(c, http.StatusBadRequest, "unsupported_grant_type", "grant_type must be authorization_code or refresh_token")
+		return
+	}
+
+	if user.Active != 1 {
+		app.oauthError(c, http.StatusBadRequest, "invalid_grant", "user is not active")
+		return
+	}
+
+	// Each login that can be refreshed becomes a session
+	if sessionID == 0 && hasScope(scope, "offline_access") {
+		sessionID, err = app.insertSession(c, user.ID, client.ClientID, "")
+		if err != nil {
+			app.oauthError(c, http.StatusInternalServerError, "server_error", err.Error())
+			return
+		}
+	}
+
+	response, err := app.issueTokens(user, client.ClientID, scope, nonce, authTime, sessionID)
+	if err != nil {
+		app.oauthError(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+	c.JSON(http.StatusOK, response)
+}
+
+// UserInfo returns claims about the user the access token was issued for
+func (app *Config) UserInfo(c *gin.Context) {
+	token, ok := bearerToken(c)
+	if !ok {
+		c.Header("WWW-Authenticate", `Bearer`)
+		app.oauthError(c, http.StatusUnauthorized, "invalid_token", "a bearer token is required")
+		return
+	}
+
+	claims, err := app.verifyAccessToken(token)
+	if err != nil {
+		c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+		app.oauthError(c, http.StatusUnauthorized, "invalid_token", err.Error())
+		return
+	}
+
+	sub, _ := claims["sub"].(string)
+	userID, _ := strconv.Atoi(sub)
+
+	user, err := app.getByID(userID)
+	if err != nil || user.Active != 1 {
+		app.oauthError(c, http.StatusUnauthorized, "invalid_token", "user not found")
+		return
+	}
+
+	scope, _ := claims["scope"].(string)
+	c.JSON(http.StatusOK, userClaims(user, scope))
+}
+
+// OpenIDConfiguration serves the OpenID Connect discovery document
+func (app *Config) OpenIDConfiguration(c *gin.Context) {
+	scopes := []string{}
+	for scope := range supportedScopes {
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
+
+	c.JSON(http.StatusOK, gin.H{
+		"issuer":                                app.Issuer,
+		"authorization_endpoint":                app.Issuer + "/authorize",
+		"token_endpoint":                        app.Issuer + "/token",
+		"userinfo_endpoint":                     app.Issuer + "/userinfo",
+		"jwks_uri":                              app.Issuer + "/.well-known/jwks.json",
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code", "refresh_token"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"scopes_supported":                      scopes,
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"claims_supported":                      []string{"sub", "email", "email_verified", "name", "given_name", "family_name", "updated_at"},
+	})
+}
+
+// JWKS publishes the public keys used to verify tokens
+func (app *Config) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{
+		"keys": app.publicKeys(),
+	})
+}
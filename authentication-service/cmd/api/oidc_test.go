@@ -0,0 +1,300 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"database/sql/driver"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testKeyManager returns a key manager holding one active signing key, without a
+// database behind it
+func testKeyManager(t *testing.T) *keyManager {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &keyManager{keys: []SigningKey{{ID: 1, Kid: "test-key", Status: keyStatusActive, privateKey: key}}}
+}
+
+func TestAllowsRedirect(t *testing.T) {
+	client := &OAuthClient{RedirectURIs: []string{"https://app.example.com/callback", "myapp://callback"}}
+
+	tests := []struct {
+		uri  string
+		want bool
+	}{
+		{"https://app.example.com/callback", true},
+		{"myapp://callback", true},
+		{"https://app.example.com/callback/", false},
+		{"https://app.example.com/callback?next=/admin", false},
+		{"http://app.example.com/callback", false},
+		{"https://evil.example.com/callback", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			if got := client.allowsRedirect(tt.uri); got != tt.want {
+				t.Errorf("allowsRedirect(%q) = %v, want %v", tt.uri, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasScope(t *testing.T) {
+	tests := []struct {
+		scopes, scope string
+		want          bool
+	}{
+		{"openid profile", "openid", true},
+		{"openid  profile ", "profile", true},
+		{"openid profile", "email", false},
+		{"openid_extra", "openid", false},
+		{"", "openid", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.scopes+"/"+tt.scope, func(t *testing.T) {
+			if got := hasScope(tt.scopes, tt.scope); got != tt.want {
+				t.Errorf("hasScope(%q, %q) = %v, want %v", tt.scopes, tt.scope, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPKCEMatches(t *testing.T) {
+	// The example from RFC 7636 appendix B
+	const verifier = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	const challenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	tests := []struct {
+		name      string
+		challenge string
+		verifier  string
+		want      bool
+	}{
+		{"matching verifier", challenge, verifier, true},
+		{"other verifier", challenge, strings.Replace(verifier, "d", "e", 1), false},
+		{"plain challenge", verifier, verifier, false},
+		{"verifier too short", challenge, verifier[:42], false},
+		{"verifier too long", challenge, strings.Repeat("a", 129), false},
+		{"no verifier", challenge, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pkceMatches(tt.challenge, tt.verifier); got != tt.want {
+				t.Errorf("pkceMatches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUserClaims(t *testing.T) {
+	user := &User{ID: 42, Email: "ada@example.com", FirstName: "Ada", LastName: "Lovelace"}
+
+	tests := []struct {
+		scope string
+		want  []string
+	}{
+		{"openid", []string{"sub"}},
+		{"openid email", []string{"sub", "email", "email_verified"}},
+		{"openid profile", []string{"sub", "given_name", "family_name", "name", "updated_at"}},
+		{"openid email profile", []string{"sub", "email", "email_verified", "given_name", "family_name", "name", "updated_at"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.scope, func(t *testing.T) {
+			claims := userClaims(user, tt.scope)
+			if len(claims) != len(tt.want) {
+				t.Errorf("userClaims(%q) = %v, want the claims %v", tt.scope, claims, tt.want)
+			}
+			for _, claim := range tt.want {
+				if _, ok := claims[claim]; !ok {
+					t.Errorf("userClaims(%q) is missing %s", tt.scope, claim)
+				}
+			}
+			if claims["sub"] != "42" {
+				t.Errorf("sub = %v, want 42", claims["sub"])
+			}
+			if name, ok := claims["name"]; ok && name != "Ada Lovelace" {
+				t.Errorf("name = %v, want Ada Lovelace", name)
+			}
+		})
+	}
+}
+
+func TestIssueTokens(t *testing.T) {
+	app := &Config{Issuer: "https://auth.example.com", keys: testKeyManager(t)}
+	user := &User{ID: 42, Email: "ada@example.com", Active: 1}
+
+	response, err := app.issueTokens(user, "delivery-app", "openid email", "n-0S6", time.Now(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.TokenType != "Bearer" || response.IDToken == "" || response.RefreshToken != "" {
+		t.Errorf("issueTokens() = %+v, want an access and id token without a refresh token", response)
+	}
+
+	claims, err := app.verifyAccessToken(response.AccessToken)
+	if err != nil {
+		t.Fatalf("verifyAccessToken() = %v", err)
+	}
+	if claims["sub"] != "42" || claims["client_id"] != "delivery-app" || claims["scope"] != "openid email" {
+		t.Errorf("access token claims = %v", claims)
+	}
+
+	idClaims, err := parseJWT(response.IDToken, app.keys.publicKey)
+	if err != nil {
+		t.Fatalf("parsing the id token: %v", err)
+	}
+	if idClaims["nonce"] != "n-0S6" || idClaims["email"] != "ada@example.com" || idClaims["aud"] != "delivery-app" {
+		t.Errorf("id token claims = %v", idClaims)
+	}
+
+	// The id token isn't an access token
+	if _, err := app.verifyAccessToken(response.IDToken); err == nil {
+		t.Error("verifyAccessToken() accepted an id token")
+	}
+
+	// Nor is a token from another issuer
+	other := &Config{Issuer: "https://other.example.com", keys: app.keys}
+	if _, err := other.verifyAccessToken(response.AccessToken); err == nil {
+		t.Error("verifyAccessToken() accepted a token from another issuer")
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	const challenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+
+	db, _ := newFakeDB(t, func(q fakeQuery) fakeResult {
+		if q.has("from oauth_clients where client_id = $1") && q.args[0] == "delivery-app" {
+			return fakeResult{rows: [][]driver.Value{{int64(1), "delivery-app", nil, "Delivery", "https://app.example.com/callback", time.Now()}}}
+		}
+		return fakeResult{}
+	})
+	app := &Config{DB: db}
+
+	valid := url.Values{
+		"client_id":             {"delivery-app"},
+		"redirect_uri":          {"https://app.example.com/callback"},
+		"response_type":         {"code"},
+		"scope":                 {"openid profile"},
+		"state":                 {"xyz"},
+		"code_challenge":        {challenge},
+		"code_challenge_method": {"S256"},
+	}
+	with := func(key, value string) url.Values {
+		params := url.Values{}
+		for k, v := range valid {
+			params[k] = v
+		}
+		params.Set(key, value)
+		return params
+	}
+
+	tests := []struct {
+		name       string
+		params     url.Values
+		wantStatus int
+		wantError  string
+	}{
+		{"valid request shows the login form", valid, http.StatusOK, ""},
+		{"unknown client", with("client_id", "unknown"), http.StatusBadRequest, ""},
+		{"unregistered redirect", with("redirect_uri", "https://evil.example.com/callback"), http.StatusBadRequest, ""},
+		{"implicit flow", with("response_type", "token"), http.StatusFound, "unsupported_response_type"},
+		{"no openid scope", with("scope", "profile"), http.StatusFound, "invalid_scope"},
+		{"unsupported scope", with("scope", "openid admin"), http.StatusFound, "invalid_scope"},
+		{"no PKCE", with("code_challenge", ""), http.StatusFound, "invalid_request"},
+		{"plain PKCE", with("code_challenge_method", "plain"), http.StatusFound, "invalid_request"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := serveRequest(http.MethodGet, "/authorize", "/authorize?"+tt.params.Encode(), "", nil, app.Authorize)
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+
+			if tt.wantStatus != http.StatusFound {
+				return
+			}
+
+			// Errors about the request go back to the client, along with its state
+			location, err := url.Parse(recorder.Header().Get("Location"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(location.String(), "https://app.example.com/callback?") {
+				t.Errorf("redirected to %s", location)
+			}
+			if got := location.Query().Get("error"); got != tt.wantError {
+				t.Errorf("error = %q, want %q", got, tt.wantError)
+			}
+			if got := location.Query().Get("state"); got != "xyz" {
+				t.Errorf("state = %q, want xyz", got)
+			}
+		})
+	}
+}
+
+func TestAuthenticateClient(t *testing.T) {
+	db, _ := newFakeDB(t, func(q fakeQuery) fakeResult {
+		if !q.has("from oauth_clients where client_id = $1") {
+			return fakeResult{}
+		}
+		switch q.args[0] {
+		case "public-app":
+			return fakeResult{rows: [][]driver.Value{{int64(1), "public-app", nil, "Public", "myapp://callback", time.Now()}}}
+		case "server-app":
+			return fakeResult{rows: [][]driver.Value{{int64(2), "server-app", hashToken("s3cret"), "Server", "https://server.example.com/cb", time.Now()}}}
+		}
+		return fakeResult{}
+	})
+	app := &Config{DB: db}
+
+	basic := func(id, secret string) http.Header {
+		request, _ := http.NewRequest(http.MethodPost, "/", nil)
+		request.SetBasicAuth(id, secret)
+		return request.Header
+	}
+
+	tests := []struct {
+		name    string
+		body    string
+		header  http.Header
+		wantErr string
+	}{
+		{"public client without a secret", "client_id=public-app", nil, ""},
+		{"confidential client with its secret", "client_id=server-app&client_secret=s3cret", nil, ""},
+		{"confidential client with basic auth", "", basic("server-app", "s3cret"), ""},
+		{"wrong secret", "client_id=server-app&client_secret=guess", nil, "invalid client credentials"},
+		{"wrong secret with basic auth", "", basic("server-app", "guess"), "invalid client credentials"},
+		{"confidential client without a secret", "client_id=server-app", nil, "invalid client credentials"},
+		{"unknown client", "client_id=other-app", nil, "unknown client"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var err error
+			serveRequest(http.MethodPost, "/token", "/token", tt.body, tt.header, func(c *gin.Context) {
+				_, err = app.authenticateClient(c)
+			})
+
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("authenticateClient() = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Errorf("authenticateClient() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// FieldError describes a validation problem with a single request field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// passwordPolicy is the set of rules new passwords must satisfy
+type passwordPolicy struct {
+	minLength        int
+	requireUpper     bool
+	requireLower     bool
+	requireDigit     bool
+	requireSymbol    bool
+	disallowUserInfo bool
+	breached         *bloomFilter
+}
+
+// newPasswordPolicy configures the policy from the environment: PASSWORD_MIN_LENGTH
+// (default 8), PASSWORD_REQUIRE_UPPER, PASSWORD_REQUIRE_LOWER, PASSWORD_REQUIRE_DIGIT,
+// PASSWORD_REQUIRE_SYMBOL (default false), PASSWORD_DISALLOW_USER_INFO (default true)
+// and BREACHED_PASSWORDS_FILE, a list of known breached passwords.
+func newPasswordPolicy() (*passwordPolicy, error) {
+	policy := &passwordPolicy{
+		minLength:        envInt("PASSWORD_MIN_LENGTH", 8),
+		requireUpper:     envBool("PASSWORD_REQUIRE_UPPER", false),
+		requireLower:     envBool("PASSWORD_REQUIRE_LOWER", false),
+		requireDigit:     envBool("PASSWORD_REQUIRE_DIGIT", false),
+		requireSymbol:    envBool("PASSWORD_REQUIRE_SYMBOL", false),
+		disallowUserInfo: envBool("PASSWORD_DISALLOW_USER_INFO", true),
+	}
+
+	if path := os.Getenv("BREACHED_PASSWORDS_FILE"); path != "" {
+		filter, err := loadBreachedPasswords(path)
+		if err != nil {
+			return nil, err
+		}
+		policy.breached = filter
+	}
+
+	return policy, nil
+}
+
+// validate checks a candidate password for user and returns every rule it breaks
+func (p *passwordPolicy) validate(password string, user User) []FieldError {
+	var problems []string
+
+	if len([]rune(password)) < p.minLength {
+		problems = append(problems, fmt.Sprintf("must be at least %d characters long", p.minLength))
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+
+	if p.requireUpper && !hasUpper {
+		problems = append(problems, "must contain an uppercase letter")
+	}
+	if p.requireLower && !hasLower {
+		problems = append(problems, "must contain a lowercase letter")
+	}
+	if p.requireDigit && !hasDigit {
+		problems = append(problems, "must contain a digit")
+	}
+	if p.requireSymbol && !hasSymbol {
+		problems = append(problems, "must contain a symbol")
+	}
+
+	if p.disallowUserInfo && password != "" {
+		lower := strings.ToLower(password)
+		for _, info := range userInfoTokens(user) {
+			if strings.Contains(lower, info) {
+				problems = append(problems, "must not contain your email address or name")
+				break
+			}
+		}
+	}
+
+	if p.breached != nil && password != "" && p.breached.has(breachedKey(password)) {
+		problems = append(problems, "appears in a list of breached passwords; choose a different one")
+	}
+
+	errs := []FieldError{}
+	for _, problem := range problems {
+		errs = append(errs, FieldError{Field: "password", Message: "password " + problem})
+	}
+
+	return errs
+}
+
+// userInfoTokens returns the lower cased parts of a user's email and name that a
+// password must not contain. Very short parts are ignored to avoid false positives.
+func userInfoTokens(user User) []string {
+	candidates := []string{user.FirstName, user.LastName}
+
+	local, _, _ := strings.Cut(user.Email, "@")
+	candidates = append(candidates, local)
+	candidates = append(candidates, emailSeparators.Split(local, -1)...)
+
+	tokens := []string{}
+	for _, candidate := range candidates {
+		candidate = strings.ToLower(strings.TrimSpace(candidate))
+		if len(candidate) >= 3 {
+			tokens = append(tokens, candidate)
+		}
+	}
+
+	return tokens
+}
+
+// breachedKey is what goes into the breached password filter: the upper case hex
+// SHA-1 of the password, the same form used by public breach corpora
+func breachedKey(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+var (
+	emailSeparators = regexp.MustCompile(`[._+-]`)
+	sha1Line        = regexp.MustCompile(`^[0-9A-Fa-f]{40}(:\d+)?$`)
+)
+
+// loadBreachedPasswords builds a bloom filter from a file with one entry per line.
+// Entries are either plain text passwords or SHA-1 hashes (optionally followed by
+// ":count"), so downloaded hash lists can be used as is.
+func loadBreachedPasswords(path string) (*bloomFilter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// Count lines first so the filter can be sized for a 0.1% false positive rate
+	count := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if _, err := file.Seek(0, 0); err != nil {
+		return nil, err
+	}
+
+	filter := newBloomFilter(count, 0.001)
+	scanner = bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+
+		if sha1Line.MatchString(line) {
+			filter.add(strings.ToUpper(line[:40]))
+		} else {
+			filter.add(breachedKey(line))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Loaded %d breached passwords", count)
+	return filter, nil
+}
+
+// bloomFilter is a compact probabilistic set. It can report false positives (a safe
+// password rejected as breached) at the configured rate, but never false negatives.
+type bloomFilter struct {
+	bits []uint64
+	m    uint64
+	k    uint64
+}
+
+// newBloomFilter sizes a filter for n entries at false positive rate p
+func newBloomFilter(n int, p float64) *bloomFilter {
+	if n < 1 {
+		n = 1
+	}
+
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	k := uint64(math.Max(1, math.Round(float64(m)/float64(n)*math.Ln2)))
+
+	return &bloomFilter{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    k,
+	}
+}
+
+// positions derives the k bit positions for value using double hashing
+func (bf *bloomFilter) positions(value string) []uint64 {
+	sum := sha256.Sum256([]byte(value))
+	h1 := binary.BigEndian.Uint64(sum[0:8])
+	h2 := binary.BigEndian.Uint64(sum[8:16]) | 1
+
+	positions := make([]uint64, bf.k)
+	for i := uint64(0); i < bf.k; i++ {
+		positions[i] = (h1 + i*h2) % bf.m
+	}
+
+	return positions
+}
+
+func (bf *bloomFilter) add(value string) {
+	for _, pos := range bf.positions(value) {
+		bf.bits[pos/64] |= 1 << (pos % 64)
+	}
+}
+
+func (bf *bloomFilter) has(value string) bool {
+	for _, pos := range bf.positions(value) {
+		if bf.bits[pos/64]&(1<<(pos%64)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// envBool reads a boolean from the environment, falling back when unset or invalid
+func envBool(key string, fallback bool) bool {
+	switch strings.ToLower(os.Getenv(key)) {
+	case "1", "true", "yes":
+		return true
+	case "0", "false", "no":
+		return false
+	default:
+		return fallback
+	}
+}
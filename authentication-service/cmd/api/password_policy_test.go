@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	user := User{Email: "ada.lovelace@example.com", FirstName: "Ada", LastName: "Lovelace"}
+
+	breached := newBloomFilter(1, 0.001)
+	breached.add(breachedKey("Password123!"))
+
+	strict := &passwordPolicy{
+		minLength:        10,
+		requireUpper:     true,
+		requireLower:     true,
+		requireDigit:     true,
+		requireSymbol:    true,
+		disallowUserInfo: true,
+		breached:         breached,
+	}
+
+	tests := []struct {
+		name     string
+		policy   *passwordPolicy
+		password string
+		want     []string
+	}{
+		{"default length", &passwordPolicy{minLength: 8}, "short", []string{"password must be at least 8 characters long"}},
+		{"length counts characters not bytes", &passwordPolicy{minLength: 8}, "ñññññññ", []string{"password must be at least 8 characters long"}},
+		{"long enough", &passwordPolicy{minLength: 8}, "ññññññññ", nil},
+		{"meets every rule", strict, "Tr0ub4dor&3x", nil},
+		{"breaks every rule", strict, "ada", []string{
+			"password must be at least 10 characters long",
+			"password must contain an uppercase letter",
+			"password must contain a digit",
+			"password must contain a symbol",
+			"password must not contain your email address or name",
+		}},
+		{"no lowercase", strict, "TR0UB4DOR&3X", []string{"password must contain a lowercase letter"}},
+		{"a space counts as a symbol", strict, "Tr0ub4dor 3x", nil},
+		{"last name in any case", strict, "LOVELACE-rules-1", []string{"password must not contain your email address or name"}},
+		{"part of the email address", strict, "Me&Lovelace99", []string{"password must not contain your email address or name"}},
+		{"user info allowed", &passwordPolicy{minLength: 8}, "lovelace99", nil},
+		{"breached", strict, "Password123!", []string{"password appears in a list of breached passwords; choose a different one"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, err := range tt.policy.validate(tt.password, user) {
+				if err.Field != "password" {
+					t.Errorf("field = %q, want password", err.Field)
+				}
+				got = append(got, err.Message)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("validate(%q) = %q, want %q", tt.password, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUserInfoTokens(t *testing.T) {
+	tests := []struct {
+		name string
+		user User
+		want []string
+	}{
+		{"name and email", User{Email: "ada.lovelace@example.com", FirstName: "Ada", LastName: "Lovelace"}, []string{"ada", "lovelace", "ada.lovelace", "ada", "lovelace"}},
+		{"short parts are skipped", User{Email: "al@example.com", FirstName: "Al", LastName: " Li "}, []string{}},
+		{"email separators", User{Email: "jo_smith-jones@example.com"}, []string{"jo_smith-jones", "smith", "jones"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := userInfoTokens(tt.user); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("userInfoTokens() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBreachedKey(t *testing.T) {
+	// The SHA-1 of "password", as it appears in public breach corpora
+	if got := breachedKey("password"); got != "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8" {
+		t.Errorf("breachedKey() = %s", got)
+	}
+}
+
+func TestLoadBreachedPasswords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	lines := "letmein\r\n" +
+		"\n" +
+		"5baa61e4c9b93f3f0682250b6cf8331b7ee68fd8:3730471\n" +
+		breachedKey("qwerty") + "\n"
+	if err := os.WriteFile(path, []byte(lines), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	filter, err := loadBreachedPasswords(path)
+	if err != nil {
+		t.Fatalf("loadBreachedPasswords() = %v", err)
+	}
+
+	for _, password := range []string{"letmein", "password", "qwerty"} {
+		if !filter.has(breachedKey(password)) {
+			t.Errorf("%q isn't in the filter", password)
+		}
+	}
+	if filter.has(breachedKey("correct horse battery staple")) {
+		t.Error("a password that isn't in the file is in the filter")
+	}
+
+	if _, err := loadBreachedPasswords(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("loadBreachedPasswords() of a missing file succeeded")
+	}
+}
+
+func TestBloomFilter(t *testing.T) {
+	const n = 1000
+	filter := newBloomFilter(n, 0.01)
+	for i := 0; i < n; i++ {
+		filter.add(fmt.Sprintf("added-%d", i))
+	}
+
+	// No false negatives
+	for i := 0; i < n; i++ {
+		if !filter.has(fmt.Sprintf("added-%d", i)) {
+			t.Fatalf("added-%d isn't in the filter", i)
+		}
+	}
+
+	// False positives stay near the configured rate
+	falsePositives := 0
+	for i := 0; i < 10*n; i++ {
+		if filter.has(fmt.Sprintf("other-%d", i)) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / (10 * n); rate > 0.03 {
+		t.Errorf("false positive rate = %.3f, want about 0.01", rate)
+	}
+
+	// An empty list still makes a usable filter
+	if empty := newBloomFilter(0, 0.001); empty.has("anything") {
+		t.Error("an empty filter has an entry")
+	}
+}
+
+func TestEnvBool(t *testing.T) {
+	tests := []struct {
+		value    string
+		fallback bool
+		want     bool
+	}{
+		{"1", false, true},
+		{"TRUE", false, true},
+		{"yes", false, true},
+		{"0", true, false},
+		{"False", true, false},
+		{"no", true, false},
+		{"", true, true},
+		{"maybe", false, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("TEST_ENV_BOOL", tt.value)
+			if got := envBool("TEST_ENV_BOOL", tt.fallback); got != tt.want {
+				t.Errorf("envBool(%q, %v) = %v, want %v", tt.value, tt.fallback, got, tt.want)
+			}
+		})
+	}
+}
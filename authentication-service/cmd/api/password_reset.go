@@ -0,0 +1,246 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Purposes of a password token
+const (
+	passwordTokenReset = "reset"
+)
+
+const passwordResetTTL = time.Hour
+
+// passwordToken is a single use token that lets its holder set a user's password
+type passwordToken struct {
+	ID        int
+	UserID    int
+	Purpose   string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+// insertPasswordToken creates a password token for a user and returns its plain text value
+func (app *Config) insertPasswordToken(userID int, purpose string, ttl time.Duration) (string, error) {
+	plainText, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	stmt := `insert into password_tokens (user_id, token_hash, purpose, expires_at, created_at)
+		values ($1, $2, $3, $4, $5)`
+
+	_, err = app.DB.Exec(stmt, userID, hashToken(plainText), purpose, now.Add(ttl), now)
+	if err != nil {
+		return "", err
+	}
+
+	return plainText, nil
+}
+
+// getPasswordToken looks up a password token that is still usable
+func (app *Config) getPasswordToken(plainText string) (*passwordToken, error) {
+	var token passwordToken
+	var usedAt sql.NullTime
+
+	query := `select id, user_id, purpose, expires_at, used_at from password_tokens where token_hash = $1`
+
+	err := app.DB.QueryRow(query, hashToken(plainText)).Scan(
+		&token.ID,
+		&token.UserID,
+		&token.Purpose,
+		&token.ExpiresAt,
+		&usedAt,
+	)
+	if err != nil || usedAt.Valid || time.Now().After(token.ExpiresAt) {
+		return nil, errors.New("invalid or expired token")
+	}
+
+	return &token, nil
+}
+
+// usePasswordToken marks a token as used. It fails if the token was used concurrently.
+func (app *Config) usePasswordToken(id int) error {
+	result, err := app.DB.Exec(`update password_tokens set used_at = $1 where id = $2 and used_at is null`, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errors.New("invalid or expired token")
+	}
+
+	return nil
+}
+
+// passwordResetLink builds the link sent in reset emails from PASSWORD_RESET_URL
+func passwordResetLink(token string) string {
+	base := os.Getenv("PASSWORD_RESET_URL")
+	if base == "" {
+		base = "http://localhost:8001/password-reset/confirm?token="
+	}
+
+	return base + token
+}
+
+func (app *Config) ChangePassword(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		app.errorJSON(c, errors.New("invalid id parameter"), http.StatusBadRequest)
+		return
+	}
+
+	var requestPayload struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+
+	err = app.readJSON(c, &requestPayload)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.getByID(id)
+	if err != nil {
+		app.errorJSON(c, errors.New("user not found"), http.StatusNotFound)
+		return
+	}
+
+	valid, err := app.passwordMatches(user, requestPayload.CurrentPassword)
+	if err != nil || !valid {
+		app.validationErrorJSON(c, []FieldError{{Field: "current_password", Message: "current password is incorrect"}})
+		return
+	}
+
+	if errs := app.Policy.validate(requestPayload.NewPassword, *user); len(errs) > 0 {
+		for i := range errs {
+			errs[i].Field = "new_password"
+		}
+		app.validationErrorJSON(c, errs)
+		return
+	}
+
+	err = app.updatePassword(user.ID, requestPayload.NewPassword)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.revokeAllSessions(user.ID)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = app.logRequest("password", fmt.Sprintf("User %s changed their password", user.Email))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Password changed",
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+// RequestPasswordReset emails a reset link. It answers the same way whether or not the
+// email belongs to an account, so it can't be used to discover accounts.
+func (app *Config) RequestPasswordReset(c *gin.Context) {
+	var requestPayload struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(c, &requestPayload)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.getByEmail(requestPayload.Email)
+	if err == nil && user.Active == 1 {
+		token, err := app.insertPasswordToken(user.ID, passwordTokenReset, passwordResetTTL)
+		if err != nil {
+			app.errorJSON(c, err, http.StatusInternalServerError)
+			return
+		}
+
+		body := fmt.Sprintf("Use this link within the next hour to choose a new password:\n\n%s\n\nIf you didn't ask to reset your password you can ignore this email.", passwordResetLink(token))
+		_ = app.sendMail(user.Email, "Reset your password", body)
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "If an account exists for that email, a password reset link has been sent",
+	}
+
+	app.writeJSON(c, http.StatusAccepted, payload)
+}
+
+func (app *Config) ConfirmPasswordReset(c *gin.Context) {
+	var requestPayload struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}
+
+	err := app.readJSON(c, &requestPayload)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	token, err := app.getPasswordToken(requestPayload.Token)
+	if err != nil {
+		app.validationErrorJSON(c, []FieldError{{Field: "token", Message: err.Error()}})
+		return
+	}
+
+	user, err := app.getByID(token.UserID)
+	if err != nil {
+		app.validationErrorJSON(c, []FieldError{{Field: "token", Message: "invalid or expired token"}})
+		return
+	}
+
+	if errs := app.Policy.validate(requestPayload.NewPassword, *user); len(errs) > 0 {
+		for i := range errs {
+			errs[i].Field = "new_password"
+		}
+		app.validationErrorJSON(c, errs)
+		return
+	}
+
+	err = app.usePasswordToken(token.ID)
+	if err != nil {
+		app.validationErrorJSON(c, []FieldError{{Field: "token", Message: err.Error()}})
+		return
+	}
+
+	err = app.updatePassword(user.ID, requestPayload.NewPassword)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = app.revokeAllSessions(user.ID)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = app.logRequest("password", fmt.Sprintf("User %s reset their password", user.Email))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Password has been reset",
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
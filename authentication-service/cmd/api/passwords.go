@@ -0,0 +1,254 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordHasher hashes and verifies passwords with one algorithm. Hashes are stored in
+// a self describing format (modular crypt / PHC strings), so the algorithm and its
+// parameters live alongside every hash.
+type PasswordHasher interface {
+	// Hash returns the encoded hash of a plain text password
+	Hash(plainText string) (string, error)
+	// Verify reports whether plainText matches an encoded hash made by this algorithm
+	Verify(plainText, encoded string) (bool, error)
+	// Recognizes reports whether encoded was produced by this algorithm
+	Recognizes(encoded string) bool
+	// Outdated reports whether encoded uses different parameters than the hasher
+	Outdated(encoded string) bool
+}
+
+// bcryptHasher hashes passwords with bcrypt
+type bcryptHasher struct {
+	cost int
+}
+
+func (h bcryptHasher) Hash(plainText string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(plainText), h.cost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hashed), nil
+}
+
+func (h bcryptHasher) Verify(plainText, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(plainText))
+	if err != nil {
+		switch {
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+func (h bcryptHasher) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func (h bcryptHasher) Outdated(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.cost
+}
+
+// argon2idHasher hashes passwords with argon2id, encoded in the PHC string format:
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+type argon2idHasher struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	keyLen  uint32
+	saltLen int
+}
+
+// argon2idParams holds the parameters decoded from an argon2id hash
+type argon2idParams struct {
+	version int
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func (h argon2idHasher) Hash(plainText string) (string, error) {
+	salt := make([]byte, h.saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(plainText), salt, h.time, h.memory, h.threads, h.keyLen)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		h.memory,
+		h.time,
+		h.threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// decodeArgon2id parses a PHC formatted argon2id hash
+func decodeArgon2id(encoded string) (*argon2idParams, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, errors.New("malformed argon2id hash")
+	}
+
+	var p argon2idParams
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &p.version); err != nil {
+		return nil, errors.New("malformed argon2id version")
+	}
+
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.memory, &p.time, &p.threads); err != nil {
+		return nil, errors.New("malformed argon2id parameters")
+	}
+
+	var err error
+	if p.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, errors.New("malformed argon2id salt")
+	}
+
+	if p.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, errors.New("malformed argon2id key")
+	}
+
+	return &p, nil
+}
+
+func (h argon2idHasher) Verify(plainText, encoded string) (bool, error) {
+	p, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	if p.version != argon2.Version {
+		return false, fmt.Errorf("unsupported argon2 version: %d", p.version)
+	}
+
+	key := argon2.IDKey([]byte(plainText), p.salt, p.time, p.memory, p.threads, uint32(len(p.key)))
+
+	return subtle.ConstantTimeCompare(key, p.key) == 1, nil
+}
+
+func (h argon2idHasher) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$argon2id$")
+}
+
+func (h argon2idHasher) Outdated(encoded string) bool {
+	p, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+
+	return p.version != argon2.Version ||
+		p.memory != h.memory ||
+		p.time != h.time ||
+		p.threads != h.threads ||
+		uint32(len(p.key)) != h.keyLen ||
+		len(p.salt) != h.saltLen
+}
+
+// passwordHashing hashes new passwords with the preferred hasher and verifies existing
+// hashes with whichever hasher produced them
+type passwordHashing struct {
+	preferred PasswordHasher
+	hashers   []PasswordHasher
+}
+
+// newPasswordHashing configures password hashing from the environment:
+// PASSWORD_HASH_ALGORITHM (bcrypt or argon2id, default bcrypt), BCRYPT_COST (default 12),
+// and ARGON2_MEMORY (KiB, default 65536), ARGON2_TIME (default 3) and ARGON2_THREADS
+// (default 2).
+func newPasswordHashing() (*passwordHashing, error) {
+	bcryptCost := envInt("BCRYPT_COST", 12)
+	if bcryptCost < bcrypt.MinCost || bcryptCost > bcrypt.MaxCost {
+		return nil, fmt.Errorf("BCRYPT_COST must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+	}
+
+	argonMemory := envInt("ARGON2_MEMORY", 64*1024)
+	if argonMemory < 1 || int64(argonMemory) > math.MaxUint32 {
+		return nil, fmt.Errorf("ARGON2_MEMORY must be between 1 and %d", uint32(math.MaxUint32))
+	}
+	argonTime := envInt("ARGON2_TIME", 3)
+	if argonTime < 1 || int64(argonTime) > math.MaxUint32 {
+		return nil, fmt.Errorf("ARGON2_TIME must be between 1 and %d", uint32(math.MaxUint32))
+	}
+	argonThreads := envInt("ARGON2_THREADS", 2)
+	if argonThreads < 1 || argonThreads > math.MaxUint8 {
+		return nil, fmt.Errorf("ARGON2_THREADS must be between 1 and %d", math.MaxUint8)
+	}
+
+	bcryptH := bcryptHasher{cost: bcryptCost}
+	argonH := argon2idHasher{
+		memory:  uint32(argonMemory),
+		time:    uint32(argonTime),
+		threads: uint8(argonThreads),
+		keyLen:  32,
+		saltLen: 16,
+	}
+
+	ph := &passwordHashing{hashers: []PasswordHasher{bcryptH, argonH}}
+
+	switch algorithm := os.Getenv("PASSWORD_HASH_ALGORITHM"); algorithm {
+	case "", "bcrypt":
+		ph.preferred = bcryptH
+	case "argon2id":
+		ph.preferred = argonH
+	default:
+		return nil, fmt.Errorf("unsupported PASSWORD_HASH_ALGORITHM: %s", algorithm)
+	}
+
+	return ph, nil
+}
+
+// hash hashes a password with the preferred algorithm
+func (ph *passwordHashing) hash(plainText string) (string, error) {
+	return ph.preferred.Hash(plainText)
+}
+
+// verify checks a password against an encoded hash. needsRehash is true when the
+// password matched but the hash was made with another algorithm or outdated parameters.
+func (ph *passwordHashing) verify(plainText, encoded string) (matches bool, needsRehash bool, err error) {
+	for _, hasher := range ph.hashers {
+		if !hasher.Recognizes(encoded) {
+			continue
+		}
+
+		matches, err = hasher.Verify(plainText, encoded)
+		if err != nil || !matches {
+			return false, false, err
+		}
+
+		needsRehash = !ph.preferred.Recognizes(encoded) || ph.preferred.Outdated(encoded)
+		return true, needsRehash, nil
+	}
+
+	return false, false, errors.New("unrecognized password hash format")
+}
+
+// envInt reads an integer from the environment, falling back when unset or invalid
+func envInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return fallback
+	}
+
+	return value
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Cheap parameters, so hashing doesn't slow the tests down
+var (
+	testBcrypt = bcryptHasher{cost: bcrypt.MinCost}
+	testArgon  = argon2idHasher{memory: 64, time: 1, threads: 1, keyLen: 32, saltLen: 16}
+)
+
+func TestPasswordHashers(t *testing.T) {
+	for _, hasher := range []PasswordHasher{testBcrypt, testArgon} {
+		encoded, err := hasher.Hash("correct horse")
+		if err != nil {
+			t.Fatalf("%T.Hash() = %v", hasher, err)
+		}
+
+		if !hasher.Recognizes(encoded) {
+			t.Errorf("%T doesn't recognize its own hash %q", hasher, encoded)
+		}
+		if hasher.Outdated(encoded) {
+			t.Errorf("%T considers its own hash outdated", hasher)
+		}
+
+		if ok, err := hasher.Verify("correct horse", encoded); !ok || err != nil {
+			t.Errorf("%T.Verify(right password) = %v, %v", hasher, ok, err)
+		}
+		if ok, err := hasher.Verify("battery staple", encoded); ok || err != nil {
+			t.Errorf("%T.Verify(wrong password) = %v, %v", hasher, ok, err)
+		}
+
+		// Salts differ, so hashing twice doesn't give the same hash
+		if again, _ := hasher.Hash("correct horse"); again == encoded {
+			t.Errorf("%T hashed the same password to the same hash twice", hasher)
+		}
+	}
+}
+
+func TestArgon2idHash(t *testing.T) {
+	encoded, err := testArgon.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("Hash() = %q, want the PHC format with the hasher's parameters", encoded)
+	}
+
+	tests := []struct {
+		name    string
+		encoded string
+		wantErr string
+	}{
+		{"too few parts", "$argon2id$v=19$m=64,t=1,p=1$c2FsdA", "malformed argon2id hash"},
+		{"other algorithm", "$argon2i$v=19$m=64,t=1,p=1$c2FsdA$a2V5", "malformed argon2id hash"},
+		{"bad version", "$argon2id$v=x$m=64,t=1,p=1$c2FsdA$a2V5", "malformed argon2id version"},
+		{"bad parameters", "$argon2id$v=19$m=64$c2FsdA$a2V5", "malformed argon2id parameters"},
+		{"bad salt", "$argon2id$v=19$m=64,t=1,p=1$!!!$a2V5", "malformed argon2id salt"},
+		{"bad key", "$argon2id$v=19$m=64,t=1,p=1$c2FsdA$!!!", "malformed argon2id key"},
+		{"old version", "$argon2id$v=16$m=64,t=1,p=1$c2FsdA$a2V5", "unsupported argon2 version: 16"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, err := testArgon.Verify("correct horse", tt.encoded)
+			if ok || err == nil || err.Error() != tt.wantErr {
+				t.Errorf("Verify() = %v, %v, want %q", ok, err, tt.wantErr)
+			}
+			if !testArgon.Outdated(tt.encoded) {
+				t.Error("Outdated() = false for a hash that can't be used")
+			}
+		})
+	}
+}
+
+func TestOutdated(t *testing.T) {
+	bcryptHash, _ := testBcrypt.Hash("correct horse")
+	argonHash, _ := testArgon.Hash("correct horse")
+
+	tests := []struct {
+		name    string
+		hasher  PasswordHasher
+		encoded string
+		want    bool
+	}{
+		{"same bcrypt cost", testBcrypt, bcryptHash, false},
+		{"higher bcrypt cost", bcryptHasher{cost: bcrypt.MinCost + 1}, bcryptHash, true},
+		{"not a bcrypt hash", testBcrypt, argonHash, true},
+		{"same argon2 parameters", testArgon, argonHash, false},
+		{"more memory", argon2idHasher{memory: 128, time: 1, threads: 1, keyLen: 32, saltLen: 16}, argonHash, true},
+		{"more passes", argon2idHasher{memory: 64, time: 2, threads: 1, keyLen: 32, saltLen: 16}, argonHash, true},
+		{"more threads", argon2idHasher{memory: 64, time: 1, threads: 2, keyLen: 32, saltLen: 16}, argonHash, true},
+		{"longer key", argon2idHasher{memory: 64, time: 1, threads: 1, keyLen: 64, saltLen: 16}, argonHash, true},
+		{"longer salt", argon2idHasher{memory: 64, time: 1, threads: 1, keyLen: 32, saltLen: 32}, argonHash, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.hasher.Outdated(tt.encoded); got != tt.want {
+				t.Errorf("Outdated() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPasswordHashingVerify(t *testing.T) {
+	bcryptHash, _ := testBcrypt.Hash("correct horse")
+	argonHash, _ := testArgon.Hash("correct horse")
+	oldBcryptHash, _ := bcryptHasher{cost: bcrypt.MinCost + 1}.Hash("correct horse")
+
+	preferBcrypt := &passwordHashing{preferred: testBcrypt, hashers: []PasswordHasher{testBcrypt, testArgon}}
+	preferArgon := &passwordHashing{preferred: testArgon, hashers: []PasswordHasher{testBcrypt, testArgon}}
+
+	tests := []struct {
+		name            string
+		ph              *passwordHashing
+		password        string
+		encoded         string
+		wantMatch       bool
+		wantNeedsRehash bool
+		wantErr         bool
+	}{
+		{"preferred algorithm", preferBcrypt, "correct horse", bcryptHash, true, false, false},
+		{"other algorithm", preferBcrypt, "correct horse", argonHash, true, true, false},
+		{"switched to argon2id", preferArgon, "correct horse", bcryptHash, true, true, false},
+		{"outdated cost", preferBcrypt, "correct horse", oldBcryptHash, true, true, false},
+		{"wrong password on an old hash", preferArgon, "battery staple", bcryptHash, false, false, false},
+		{"unknown format", preferBcrypt, "correct horse", "5f4dcc3b5aa765d61d8327deb882cf99", false, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches, needsRehash, err := tt.ph.verify(tt.password, tt.encoded)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("verify() error = %v, want error %v", err, tt.wantErr)
+			}
+			if matches != tt.wantMatch || needsRehash != tt.wantNeedsRehash {
+				t.Errorf("verify() = %v, %v, want %v, %v", matches, needsRehash, tt.wantMatch, tt.wantNeedsRehash)
+			}
+		})
+	}
+}
+
+func TestNewPasswordHashing(t *testing.T) {
+	tests := []struct {
+		name          string
+		env           map[string]string
+		wantPreferred string
+		wantErr       string
+	}{
+		{"defaults", nil, "$2a$12$", ""},
+		{"argon2id", map[string]string{"PASSWORD_HASH_ALGORITHM": "argon2id", "ARGON2_MEMORY": "64", "ARGON2_TIME": "1", "ARGON2_THREADS": "1"}, "$argon2id$v=19$m=64,t=1,p=1$", ""},
+		{"bcrypt cost", map[string]string{"BCRYPT_COST": "4"}, "$2a$04$", ""},
+		{"unparseable values fall back", map[string]string{"BCRYPT_COST": "cheap"}, "$2a$12$", ""},
+		{"bcrypt cost too low", map[string]string{"BCRYPT_COST": "3"}, "", "BCRYPT_COST must be between 4 and 31"},
+		{"bcrypt cost too high", map[string]string{"BCRYPT_COST": "32"}, "", "BCRYPT_COST must be between 4 and 31"},
+		{"no memory", map[string]string{"ARGON2_MEMORY": "0"}, "", "ARGON2_MEMORY must be between 1 and 4294967295"},
+		{"memory overflows", map[string]string{"ARGON2_MEMORY": "4294967296"}, "", "ARGON2_MEMORY must be between 1 and 4294967295"},
+		{"no passes", map[string]string{"ARGON2_TIME": "0"}, "", "ARGON2_TIME must be between 1 and 4294967295"},
+		{"negative passes", map[string]string{"ARGON2_TIME": "-1"}, "", "ARGON2_TIME must be between 1 and 4294967295"},
+		{"no threads", map[string]string{"ARGON2_THREADS": "0"}, "", "ARGON2_THREADS must be between 1 and 255"},
+		{"threads overflow", map[string]string{"ARGON2_THREADS": "256"}, "", "ARGON2_THREADS must be between 1 and 255"},
+		{"unknown algorithm", map[string]string{"PASSWORD_HASH_ALGORITHM": "md5"}, "", "unsupported PASSWORD_HASH_ALGORITHM: md5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, key := range []string{"PASSWORD_HASH_ALGORITHM", "BCRYPT_COST", "ARGON2_MEMORY", "ARGON2_TIME", "ARGON2_THREADS"} {
+				t.Setenv(key, tt.env[key])
+			}
+
+			ph, err := newPasswordHashing()
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("newPasswordHashing() = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("newPasswordHashing() = %v", err)
+			}
+
+			encoded, err := ph.hash("correct horse")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(encoded, tt.wantPreferred) {
+				t.Errorf("hash() = %q, want a hash starting with %q", encoded, tt.wantPreferred)
+			}
+		})
+	}
+}
@@ -10,6 +10,58 @@ func (app *Config) setupRoutes() {
 	app.router.POST("/authenticate", app.Authenticate)
 	app.router.POST("/user", app.CreateUser)
 	app.router.GET("/users", app.GetAllUsers)
+	app.router.POST("/users/import", app.ImportUsers)
+	app.router.GET("/users/export", app.ExportUsers)
+	app.router.PUT("/users/:id/password", app.ChangePassword)
+	app.router.POST("/password-reset", app.RequestPasswordReset)
+	app.router.POST("/password-reset/confirm", app.ConfirmPasswordReset)
+
+	// Sessions and login history
+	app.router.GET("/users/:id/sessions", app.GetSessions)
+	app.router.DELETE("/users/:id/sessions", app.RevokeAllSessions)
+	app.router.DELETE("/users/:id/sessions/:session_id", app.RevokeSession)
+	app.router.GET("/users/:id/login-history", app.GetLoginHistory)
+	app.router.POST("/sessions/refresh", app.RefreshSession)
+	app.router.POST("/logout", app.Logout)
+
+	// Personal data export and erasure, orchestrated by the broker
+	app.router.GET("/users/:id/data-export", app.ExportUserData)
+	app.router.POST("/users/:id/erase", app.EraseUserData)
+
+	// Customer profiles and delivery addresses
+	app.router.GET("/customers/:id", app.GetCustomer)
+	app.router.PUT("/customers/:id/profile", app.UpdateCustomerProfile)
+	app.router.GET("/customers/:id/addresses", app.GetCustomerAddresses)
+	app.router.POST("/customers/:id/addresses", app.SaveCustomerAddress)
+	app.router.PUT("/customers/:id/addresses/:address_id", app.SaveCustomerAddress)
+	app.router.DELETE("/customers/:id/addresses/:address_id", app.DeleteCustomerAddress)
+
+	// API keys for kiosks and service-to-service calls. Managing keys needs the admin
+	// token; introspection only tells the caller about a key they already hold.
+	app.router.POST("/api-keys/introspect", app.IntrospectAPIKey)
+	apiKeys := app.router.Group("/api-keys", app.requireAdminToken())
+	apiKeys.POST("", app.CreateAPIKey)
+	apiKeys.GET("", app.GetAPIKeys)
+	apiKeys.PUT("/:id/scopes", app.UpdateAPIKeyScopes)
+	apiKeys.DELETE("/:id", app.RevokeAPIKey)
+
+	// OAuth2/OpenID Connect provider. Clients are registered with the admin token.
+	oauthClients := app.router.Group("/oauth/clients", app.requireAdminToken())
+	oauthClients.POST("", app.RegisterOAuthClient)
+	oauthClients.GET("", app.GetOAuthClients)
+	app.router.GET("/authorize", app.Authorize)
+	app.router.POST("/authorize", app.AuthorizeSubmit)
+	app.router.POST("/token", app.Token)
+	app.router.GET("/userinfo", app.UserInfo)
+	app.router.POST("/userinfo", app.UserInfo)
+	app.router.GET("/.well-known/openid-configuration", app.OpenIDConfiguration)
+	app.router.GET("/.well-known/jwks.json", app.JWKS)
+
+	// Token signing keys, managed with the admin token. Verifiers use the public
+	// JWKS above.
+	signingKeys := app.router.Group("/signing-keys", app.requireAdminToken())
+	signingKeys.GET("", app.GetSigningKeys)
+	signingKeys.POST("/rotate", app.RotateSigningKeys)
 	
 	// Health check endpoint
 	app.router.GET("/ping", func(c *gin.Context) {
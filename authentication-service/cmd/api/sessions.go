@@ -0,0 +1,541 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Reasons recorded for failed logins
+const (
+	loginFailureUnknownUser   = "unknown_user"
+	loginFailureWrongPassword = "wrong_password"
+	loginFailureInactive      = "inactive"
+)
+
+const defaultLoginHistoryLimit = 50
+
+// Session is a device that is logged in to an account. Each session is backed by one
+// live refresh token at a time; rotating the token keeps the session.
+type Session struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	ClientID   string     `json:"client_id,omitempty"`
+	Device     string     `json:"device"`
+	IPAddress  string     `json:"ip_address"`
+	UserAgent  string     `json:"user_agent"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	Current    bool       `json:"current,omitempty"`
+}
+
+// LoginAttempt is an entry in a user's login history
+type LoginAttempt struct {
+	ID        int       `json:"id"`
+	UserID    *int      `json:"user_id,omitempty"`
+	Email     string    `json:"email"`
+	Success   bool      `json:"success"`
+	Reason    string    `json:"reason,omitempty"`
+	IPAddress string    `json:"ip_address"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// deviceFromUserAgent makes a short human readable description, such as
+// "Chrome on Android", from a user agent string
+func deviceFromUserAgent(userAgent string) string {
+	var browser, platform string
+
+	switch {
+	case strings.Contains(userAgent, "Edg/"):
+		browser = "Edge"
+	case strings.Contains(userAgent, "Chrome/"):
+		browser = "Chrome"
+	case strings.Contains(userAgent, "Firefox/"):
+		browser = "Firefox"
+	case strings.Contains(userAgent, "Safari/"):
+		browser = "Safari"
+	}
+
+	switch {
+	case strings.Contains(userAgent, "iPhone"):
+		platform = "iPhone"
+	case strings.Contains(userAgent, "iPad"):
+		platform = "iPad"
+	case strings.Contains(userAgent, "Android"):
+		platform = "Android"
+	case strings.Contains(userAgent, "Windows"):
+		platform = "Windows"
+	case strings.Contains(userAgent, "Mac OS X"):
+		platform = "macOS"
+	case strings.Contains(userAgent, "Linux"):
+		platform = "Linux"
+	}
+
+	switch {
+	case browser != "" && platform != "":
+		return browser + " on " + platform
+	case browser != "":
+		return browser
+	case platform != "":
+		return platform
+	case userAgent != "":
+		return userAgent
+	default:
+		return "Unknown device"
+	}
+}
+
+// insertSession starts a session for a user logging in from the request's device
+func (app *Config) insertSession(c *gin.Context, userID int, clientID, device string) (int, error) {
+	if device == "" {
+		device = deviceFromUserAgent(c.Request.UserAgent())
+	}
+
+	now := time.Now()
+	stmt := `insert into sessions (user_id, client_id, device, ip_address, user_agent, created_at, last_seen_at, expires_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+
+	var id int
+	err := app.DB.QueryRow(stmt,
+		userID,
+		sql.NullString{String: clientID, Valid: clientID != ""},
+		device,
+		c.ClientIP(),
+		c.Request.UserAgent(),
+		now,
+		now,
+		now.Add(refreshTokenTTL),
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// touchSession records that a session was just used from the request's address and
+// extends it for the lifetime of its new refresh token
+func (app *Config) touchSession(c *gin.Context, id int) error {
+	now := time.Now()
+	stmt := `update sessions set last_seen_at = $1, ip_address = $2, user_agent = $3, expires_at = $4
+		where id = $5 and revoked_at is null`
+
+	result, err := app.DB.Exec(stmt, now, c.ClientIP(), c.Request.UserAgent(), now.Add(refreshTokenTTL), id)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errors.New("session has been revoked")
+	}
+
+	return nil
+}
+
+// getSessions returns a user's sessions that are still live, most recently used first.
+// With includeEnded, revoked and expired sessions are returned too.
+func (app *Config) getSessions(userID int, includeEnded bool) ([]Session, error) {
+	query := `select id, user_id, client_id, device, ip_address, user_agent, created_at, last_seen_at, expires_at, revoked_at
+		from sessions where user_id = $1 and ($2 or (revoked_at is null and expires_at > $3)) order by last_seen_at desc`
+
+	rows, err := app.DB.Query(query, userID, includeEnded, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var session Session
+		var clientID sql.NullString
+		var revokedAt sql.NullTime
+
+		err := rows.Scan(
+			&session.ID,
+			&session.UserID,
+			&clientID,
+			&session.Device,
+			&session.IPAddress,
+			&session.UserAgent,
+			&session.CreatedAt,
+			&session.LastSeenAt,
+			&session.ExpiresAt,
+			&revokedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		session.ClientID = clientID.String
+		if revokedAt.Valid {
+			session.RevokedAt = &revokedAt.Time
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// revokeSession ends one of a user's sessions along with its refresh tokens
+func (app *Config) revokeSession(userID, sessionID int) error {
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	result, err := tx.Exec(`update sessions set revoked_at = $1 where id = $2 and user_id = $3 and revoked_at is null`, now, sessionID, userID)
+	if err != nil {
+		return err
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+
+	_, err = tx.Exec(`update refresh_tokens set revoked_at = $1 where session_id = $2 and revoked_at is null`, now, sessionID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// revokeAllSessions logs a user out everywhere by ending all of their sessions and
+// revoking every refresh token they hold
+func (app *Config) revokeAllSessions(userID int) error {
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	_, err = tx.Exec(`update sessions set revoked_at = $1 where user_id = $2 and revoked_at is null`, now, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`update refresh_tokens set revoked_at = $1 where user_id = $2 and revoked_at is null`, now, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// recordLogin adds a login attempt to the login history. Failures to record are
+// logged rather than failing the login.
+func (app *Config) recordLogin(c *gin.Context, email string, user *User, success bool, reason string) {
+	var userID sql.NullInt64
+	if user != nil {
+		userID = sql.NullInt64{Int64: int64(user.ID), Valid: true}
+	}
+
+	stmt := `insert into login_history (user_id, email, success, reason, ip_address, user_agent, created_at)
+		values ($1, $2, $3, $4, $5, $6, $7)`
+
+	_, err := app.DB.Exec(stmt, userID, email, success, reason, c.ClientIP(), c.Request.UserAgent(), time.Now())
+	if err != nil {
+		_ = app.logRequest("authentication", fmt.Sprintf("Error recording login for %s: %v", email, err))
+	}
+}
+
+// getLoginHistory returns a user's most recent login attempts. A limit of 0 returns
+// every attempt.
+func (app *Config) getLoginHistory(userID int, success *bool, limit int) ([]LoginAttempt, error) {
+	query := `select id, user_id, email, success, reason, ip_address, user_agent, created_at
+		from login_history where user_id = $1 and ($2::boolean is null or success = $2)
+		order by created_at desc limit nullif($3, 0)`
+
+	rows, err := app.DB.Query(query, userID, success, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attempts := []LoginAttempt{}
+	for rows.Next() {
+		var attempt LoginAttempt
+		var id sql.NullInt64
+
+		err := rows.Scan(
+			&attempt.ID,
+			&id,
+			&attempt.Email,
+			&attempt.Success,
+			&attempt.Reason,
+			&attempt.IPAddress,
+			&attempt.UserAgent,
+			&attempt.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if id.Valid {
+			userID := int(id.Int64)
+			attempt.UserID = &userID
+		}
+
+		attempts = append(attempts, attempt)
+	}
+
+	return attempts, rows.Err()
+}
+
+// currentSessionID returns the session of the bearer token on the request, if any
+func (app *Config) currentSessionID(c *gin.Context) int {
+	token, ok := bearerToken(c)
+	if !ok {
+		return 0
+	}
+
+	claims, err := app.verifyAccessToken(token)
+	if err != nil {
+		return 0
+	}
+
+	sid, _ := claims["sid"].(float64)
+	return int(sid)
+}
+
+func (app *Config) GetSessions(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		app.errorJSON(c, errors.New("invalid id parameter"), http.StatusBadRequest)
+		return
+	}
+
+	sessions, err := app.getSessions(userID, false)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	if current := app.currentSessionID(c); current != 0 {
+		for i := range sessions {
+			sessions[i].Current = sessions[i].ID == current
+		}
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Sessions retrieved",
+		Data:    sessions,
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+func (app *Config) RevokeSession(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		app.errorJSON(c, errors.New("invalid id parameter"), http.StatusBadRequest)
+		return
+	}
+
+	sessionID, err := strconv.Atoi(c.Param("session_id"))
+	if err != nil {
+		app.errorJSON(c, errors.New("invalid session_id parameter"), http.StatusBadRequest)
+		return
+	}
+
+	err = app.revokeSession(userID, sessionID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(c, errors.New("session not found"), http.StatusNotFound)
+			return
+		}
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Session %d revoked", sessionID),
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+// RevokeAllSessions logs a user out everywhere
+func (app *Config) RevokeAllSessions(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		app.errorJSON(c, errors.New("invalid id parameter"), http.StatusBadRequest)
+		return
+	}
+
+	err = app.revokeAllSessions(userID)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = app.logRequest("authentication", fmt.Sprintf("User %d logged out everywhere", userID))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "All sessions revoked",
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+// RefreshSession exchanges a refresh token from a password login for new tokens. OAuth
+// clients use the token endpoint instead.
+func (app *Config) RefreshSession(c *gin.Context) {
+	var requestPayload struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := app.readJSON(c, &requestPayload)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	token, err := app.getRefreshToken(requestPayload.RefreshToken)
+	if err != nil || token.ClientID != "" || time.Now().After(token.ExpiresAt) {
+		app.errorJSON(c, errors.New("invalid refresh token"), http.StatusUnauthorized)
+		return
+	}
+
+	if token.RevokedAt != nil {
+		// A rotated token being used again means it has leaked, so end the session
+		if token.SessionID != 0 {
+			_ = app.revokeSession(token.UserID, token.SessionID)
+		}
+		app.errorJSON(c, errors.New("invalid refresh token"), http.StatusUnauthorized)
+		return
+	}
+
+	user, err := app.getByID(token.UserID)
+	if err != nil || user.Active != 1 {
+		app.errorJSON(c, errors.New("invalid refresh token"), http.StatusUnauthorized)
+		return
+	}
+
+	// Losing a race to rotate the token is treated like reusing it
+	err = app.revokeRefreshToken(token.ID)
+	if errors.Is(err, errRefreshTokenReused) {
+		if token.SessionID != 0 {
+			_ = app.revokeSession(token.UserID, token.SessionID)
+		}
+		app.errorJSON(c, errors.New("invalid refresh token"), http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	if token.SessionID != 0 {
+		err = app.touchSession(c, token.SessionID)
+		if err != nil {
+			app.errorJSON(c, errors.New("invalid refresh token"), http.StatusUnauthorized)
+			return
+		}
+	}
+
+	response, err := app.issueTokens(user, "", token.Scope, "", time.Now(), token.SessionID)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Session refreshed",
+		Data:    response,
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+// Logout ends the session of the bearer token on the request
+func (app *Config) Logout(c *gin.Context) {
+	token, ok := bearerToken(c)
+	if !ok {
+		app.errorJSON(c, errors.New("a bearer token is required"), http.StatusUnauthorized)
+		return
+	}
+
+	claims, err := app.verifyAccessToken(token)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusUnauthorized)
+		return
+	}
+
+	sub, _ := claims["sub"].(string)
+	userID, _ := strconv.Atoi(sub)
+	sid, _ := claims["sid"].(float64)
+
+	if sid != 0 {
+		err = app.revokeSession(userID, int(sid))
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(c, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Logged out",
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+func (app *Config) GetLoginHistory(c *gin.Context) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		app.errorJSON(c, errors.New("invalid id parameter"), http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultLoginHistoryLimit
+	if value := c.Query("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > 500 {
+			app.errorJSON(c, errors.New("limit must be between 1 and 500"), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var success *bool
+	if value := c.Query("success"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			app.errorJSON(c, errors.New("success must be true or false"), http.StatusBadRequest)
+			return
+		}
+		success = &parsed
+	}
+
+	attempts, err := app.getLoginHistory(userID, success, limit)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Login history retrieved",
+		Data:    attempts,
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
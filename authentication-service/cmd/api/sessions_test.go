@@ -0,0 +1,220 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestDeviceFromUserAgent(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		want      string
+	}{
+		{"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Mobile Safari/537.36", "Chrome on Android"},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_2 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.2 Mobile/15E148 Safari/604.1", "Safari on iPhone"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36 Edg/120.0", "Edge on Windows"},
+		{"Mozilla/5.0 (Macintosh; Intel Mac OS X 14.2; rv:121.0) Gecko/20100101 Firefox/121.0", "Firefox on macOS"},
+		{"Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0", "Firefox on Linux"},
+		{"Mozilla/5.0 (iPad; CPU OS 17_2 like Mac OS X)", "iPad"},
+		{"Chrome/120.0", "Chrome"},
+		{"delivery-app/2.1", "delivery-app/2.1"},
+		{"", "Unknown device"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := deviceFromUserAgent(tt.userAgent); got != tt.want {
+				t.Errorf("deviceFromUserAgent(%q) = %q, want %q", tt.userAgent, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRefreshSession(t *testing.T) {
+	const plainText = "refresh-token"
+	now := time.Now()
+	later := now.Add(time.Hour)
+
+	tests := []struct {
+		name        string
+		tokenFound  bool
+		clientID    any
+		expiresAt   time.Time
+		revokedAt   any
+		active      int64
+		rotated     int64
+		touched     int64
+		wantStatus  int
+		wantRevoked bool
+	}{
+		{"rotates the token", true, nil, later, nil, 1, 1, 1, http.StatusOK, false},
+		{"unknown token", false, nil, later, nil, 1, 1, 1, http.StatusUnauthorized, false},
+		{"expired token", true, nil, now.Add(-time.Minute), nil, 1, 1, 1, http.StatusUnauthorized, false},
+		{"token from an OAuth client", true, "delivery-app", later, nil, 1, 1, 1, http.StatusUnauthorized, false},
+		{"reused token ends the session", true, nil, later, now.Add(-time.Minute), 1, 1, 1, http.StatusUnauthorized, true},
+		{"inactive user", true, nil, later, nil, 0, 1, 1, http.StatusUnauthorized, false},
+		{"lost the race to rotate it", true, nil, later, nil, 1, 0, 1, http.StatusUnauthorized, true},
+		{"session revoked meanwhile", true, nil, later, nil, 1, 1, 0, http.StatusUnauthorized, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, fake := newFakeDB(t, func(q fakeQuery) fakeResult {
+				switch {
+				case q.has("from refresh_tokens where token_hash = $1"):
+					if !tt.tokenFound || q.args[0] != hashToken(plainText) {
+						return fakeResult{}
+					}
+					return fakeResult{rows: [][]driver.Value{{int64(7), int64(42), tt.clientID, int64(3), "offline_access", tt.expiresAt, tt.revokedAt}}}
+				case q.has("from users where id = $1"):
+					return fakeResult{rows: [][]driver.Value{{int64(42), "ada@example.com", "Ada", "Lovelace", "", tt.active, "customer", now, now}}}
+				case q.has("update refresh_tokens set revoked_at = $1 where id = $2"):
+					return fakeResult{rowsAffected: tt.rotated}
+				case q.has("update sessions set last_seen_at"):
+					return fakeResult{rowsAffected: tt.touched}
+				case q.has("update sessions set revoked_at"):
+					return fakeResult{rowsAffected: 1}
+				}
+				return fakeResult{}
+			})
+			app := &Config{DB: db, Issuer: "https://auth.example.com", keys: testKeyManager(t)}
+
+			status, response := performRequest(t, http.MethodPost, "/sessions/refresh", "/sessions/refresh", `{"refresh_token":"`+plainText+`"}`, nil, app.RefreshSession)
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", status, tt.wantStatus, response.Message)
+			}
+
+			revoked := fake.ran("update sessions set revoked_at = $1 where id = $2 and user_id = $3")
+			if revoked := len(revoked) > 0; revoked != tt.wantRevoked {
+				t.Errorf("session revoked = %v, want %v", revoked, tt.wantRevoked)
+			}
+			if tt.wantRevoked {
+				if tokens := fake.ran("update refresh_tokens set revoked_at = $1 where session_id = $2"); len(tokens) != 1 || tokens[0].args[1] != int64(3) {
+					t.Errorf("the session's refresh tokens weren't revoked: %v", tokens)
+				}
+				if fake.commits != 1 {
+					t.Errorf("commits = %d, want 1", fake.commits)
+				}
+			}
+
+			inserted := fake.ran("insert into refresh_tokens")
+			if status != http.StatusOK {
+				if len(inserted) != 0 {
+					t.Error("a new refresh token was issued")
+				}
+				return
+			}
+
+			// The new token belongs to the same session and the access token names it
+			if len(inserted) != 1 || inserted[0].args[3] != int64(3) {
+				t.Fatalf("refresh tokens inserted = %v, want one for session 3", inserted)
+			}
+			data, _ := response.Data.(map[string]any)
+			if data["refresh_token"] == "" || data["refresh_token"] == plainText {
+				t.Errorf("refresh_token = %v, want a new token", data["refresh_token"])
+			}
+			accessToken, _ := data["access_token"].(string)
+			claims, err := app.verifyAccessToken(accessToken)
+			if err != nil {
+				t.Fatalf("verifyAccessToken() = %v", err)
+			}
+			if claims["sub"] != "42" || claims["sid"] != float64(3) {
+				t.Errorf("access token claims = %v", claims)
+			}
+		})
+	}
+}
+
+func TestRevokeAllSessions(t *testing.T) {
+	db, fake := newFakeDB(t, func(q fakeQuery) fakeResult {
+		return fakeResult{rowsAffected: 2}
+	})
+	app := &Config{DB: db}
+
+	status, response := performRequest(t, http.MethodDelete, "/users/:id/sessions", "/users/42/sessions", "", nil, app.RevokeAllSessions)
+	if status != http.StatusOK {
+		t.Fatalf("status = %d: %s", status, response.Message)
+	}
+
+	for _, stmt := range []string{
+		"update sessions set revoked_at = $1 where user_id = $2 and revoked_at is null",
+		"update refresh_tokens set revoked_at = $1 where user_id = $2 and revoked_at is null",
+	} {
+		if ran := fake.ran(stmt); len(ran) != 1 || ran[0].args[1] != int64(42) {
+			t.Errorf("%q ran as %v, want once for user 42", stmt, ran)
+		}
+	}
+	if fake.commits != 1 {
+		t.Errorf("commits = %d, want 1", fake.commits)
+	}
+
+	status, _ = performRequest(t, http.MethodDelete, "/users/:id/sessions", "/users/me/sessions", "", nil, app.RevokeAllSessions)
+	if status != http.StatusBadRequest {
+		t.Errorf("status for a bad id = %d, want 400", status)
+	}
+}
+
+func TestGetLoginHistory(t *testing.T) {
+	now := time.Now()
+	attempts := [][]driver.Value{
+		{int64(3), int64(42), "ada@example.com", true, "", "10.0.0.1", "curl/8.0", now},
+		{int64(2), int64(42), "ada@example.com", false, loginFailureWrongPassword, "10.0.0.1", "curl/8.0", now.Add(-time.Minute)},
+	}
+
+	tests := []struct {
+		name        string
+		query       string
+		wantStatus  int
+		wantSuccess any
+		wantLimit   any
+	}{
+		{"defaults", "", http.StatusOK, nil, int64(defaultLoginHistoryLimit)},
+		{"a page of failures", "?limit=10&success=false", http.StatusOK, false, int64(10)},
+		{"only successes", "?success=true", http.StatusOK, true, int64(defaultLoginHistoryLimit)},
+		{"largest page", "?limit=500", http.StatusOK, nil, int64(500)},
+		{"page too large", "?limit=501", http.StatusBadRequest, nil, nil},
+		{"empty page", "?limit=0", http.StatusBadRequest, nil, nil},
+		{"limit isn't a number", "?limit=all", http.StatusBadRequest, nil, nil},
+		{"success isn't a bool", "?success=sometimes", http.StatusBadRequest, nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, fake := newFakeDB(t, func(q fakeQuery) fakeResult {
+				return fakeResult{rows: attempts}
+			})
+			app := &Config{DB: db}
+
+			status, response := performRequest(t, http.MethodGet, "/users/:id/login-history", "/users/42/login-history"+tt.query, "", nil, app.GetLoginHistory)
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", status, tt.wantStatus, response.Message)
+			}
+
+			queries := fake.ran("from login_history")
+			if status != http.StatusOK {
+				if len(queries) != 0 {
+					t.Error("the login history was queried for a bad request")
+				}
+				return
+			}
+
+			if len(queries) != 1 {
+				t.Fatalf("login history queried %d times, want once", len(queries))
+			}
+			args := queries[0].args
+			if args[0] != int64(42) || args[1] != tt.wantSuccess || args[2] != tt.wantLimit {
+				t.Errorf("query args = %v, want [42 %v %v]", args, tt.wantSuccess, tt.wantLimit)
+			}
+
+			data, _ := response.Data.([]any)
+			if len(data) != 2 {
+				t.Fatalf("returned %d attempts, want 2", len(data))
+			}
+			if failure, _ := data[1].(map[string]any); failure["reason"] != loginFailureWrongPassword || failure["success"] != false {
+				t.Errorf("second attempt = %v", failure)
+			}
+		})
+	}
+}
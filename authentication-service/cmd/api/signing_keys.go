@@ -0,0 +1,346 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"database/sql"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Signing key states. A "next" key is published in the JWKS before it signs anything,
+// so verifiers have it cached by the time it becomes "active". "retired" keys no
+// longer sign but stay published until every token they signed has expired.
+const (
+	keyStatusNext    = "next"
+	keyStatusActive  = "active"
+	keyStatusRetired = "retired"
+)
+
+// retiredKeyRetention is how long retired keys stay published. It must outlive the
+// longest lived token a key can sign.
+const retiredKeyRetention = 24 * time.Hour
+
+// SigningKey is a token signing key. The private half never leaves the service.
+type SigningKey struct {
+	ID          int        `json:"id"`
+	Kid         string     `json:"kid"`
+	Status      string     `json:"status"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ActivatedAt *time.Time `json:"activated_at,omitempty"`
+	RetiredAt   *time.Time `json:"retired_at,omitempty"`
+
+	privateKey *rsa.PrivateKey
+}
+
+// keyManager holds the signing keys loaded from the database
+type keyManager struct {
+	mu   sync.RWMutex
+	db   *sql.DB
+	keys []SigningKey
+}
+
+// newKeyManager loads the signing keys, creating an active and a next key if needed.
+// A key in the file named by OIDC_SIGNING_KEY is imported as the first active key.
+func newKeyManager(db *sql.DB) (*keyManager, error) {
+	km := &keyManager{db: db}
+
+	err := km.reload()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := km.active(); err != nil {
+		if path := os.Getenv("OIDC_SIGNING_KEY"); path != "" {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+
+			key, err := parsePrivateKeyPEM(data)
+			if err != nil {
+				return nil, err
+			}
+
+			if err := insertSigningKey(db, key, keyStatusActive); err != nil {
+				return nil, err
+			}
+		} else if err := km.rotate(0); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := km.ensureNext(); err != nil {
+		return nil, err
+	}
+
+	return km, km.reload()
+}
+
+// reload reads all unpurged signing keys from the database
+func (km *keyManager) reload() error {
+	query := `select id, kid, private_key, status, created_at, activated_at, retired_at
+		from signing_keys order by created_at desc`
+
+	rows, err := km.db.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	keys := []SigningKey{}
+	for rows.Next() {
+		var key SigningKey
+		var privatePEM string
+		var activatedAt, retiredAt sql.NullTime
+
+		err := rows.Scan(&key.ID, &key.Kid, &privatePEM, &key.Status, &key.CreatedAt, &activatedAt, &retiredAt)
+		if err != nil {
+			return err
+		}
+
+		key.privateKey, err = parsePrivateKeyPEM([]byte(privatePEM))
+		if err != nil {
+			return fmt.Errorf("signing key %s: %w", key.Kid, err)
+		}
+		if activatedAt.Valid {
+			key.ActivatedAt = &activatedAt.Time
+		}
+		if retiredAt.Valid {
+			key.RetiredAt = &retiredAt.Time
+		}
+
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	km.mu.Lock()
+	km.keys = keys
+	km.mu.Unlock()
+
+	return nil
+}
+
+// all returns a copy of the loaded keys
+func (km *keyManager) all() []SigningKey {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+
+	keys := make([]SigningKey, len(km.keys))
+	copy(keys, km.keys)
+
+	return keys
+}
+
+// active returns the key currently used to sign tokens
+func (km *keyManager) active() (SigningKey, error) {
+	for _, key := range km.all() {
+		if key.Status == keyStatusActive {
+			return key, nil
+		}
+	}
+
+	return SigningKey{}, errors.New("no active signing key")
+}
+
+// publicKey returns the public key for kid, if it is still published
+func (km *keyManager) publicKey(kid string) (*rsa.PublicKey, error) {
+	for _, key := range km.all() {
+		if key.Kid == kid {
+			return &key.privateKey.PublicKey, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown signing key: %s", kid)
+}
+
+// insertSigningKey stores a key with the given status, generating one if key is nil
+func insertSigningKey(q interface {
+	Exec(string, ...any) (sql.Result, error)
+}, key *rsa.PrivateKey, status string) error {
+	var err error
+	if key == nil {
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+		if err != nil {
+			return err
+		}
+	}
+
+	privatePEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	})
+
+	now := time.Now()
+	var activatedAt *time.Time
+	if status == keyStatusActive {
+		activatedAt = &now
+	}
+
+	stmt := `insert into signing_keys (kid, private_key, status, created_at, activated_at)
+		values ($1, $2, $3, $4, $5)`
+
+	_, err = q.Exec(stmt, keyThumbprint(&key.PublicKey), string(privatePEM), status, now, activatedAt)
+	return err
+}
+
+// ensureNext makes sure a next key exists and is published
+func (km *keyManager) ensureNext() error {
+	for _, key := range km.all() {
+		if key.Status == keyStatusNext {
+			return nil
+		}
+	}
+
+	if err := insertSigningKey(km.db, nil, keyStatusNext); err != nil {
+		return err
+	}
+
+	return km.reload()
+}
+
+// rotate retires the active key, promotes the next key to active and generates a new
+// next key. Retired keys past their retention period are purged. With a non-zero
+// minAge, nothing happens unless the active key is at least that old, which keeps
+// replicas from rotating twice when their schedules fire together.
+func (km *keyManager) rotate(minAge time.Duration) error {
+	tx, err := km.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Serialize rotations across replicas
+	_, err = tx.Exec(`lock table signing_keys in exclusive mode`)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	if minAge > 0 {
+		var activatedAt time.Time
+		err = tx.QueryRow(`select activated_at from signing_keys where status = $1`, keyStatusActive).Scan(&activatedAt)
+		if err == nil && now.Sub(activatedAt) < minAge {
+			return nil
+		}
+	}
+
+	_, err = tx.Exec(`update signing_keys set status = $1, retired_at = $2 where status = $3`,
+		keyStatusRetired, now, keyStatusActive)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(`update signing_keys set status = $1, activated_at = $2 where status = $3`,
+		keyStatusActive, now, keyStatusNext)
+	if err != nil {
+		return err
+	}
+
+	// Without a published next key (first start), a new key has to become active at once
+	if n, _ := result.RowsAffected(); n == 0 {
+		if err := insertSigningKey(tx, nil, keyStatusActive); err != nil {
+			return err
+		}
+	}
+
+	if err := insertSigningKey(tx, nil, keyStatusNext); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`delete from signing_keys where status = $1 and retired_at < $2`,
+		keyStatusRetired, now.Add(-retiredKeyRetention))
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	return km.reload()
+}
+
+// rotationInterval returns how often signing keys are rotated, configured with
+// SIGNING_KEY_ROTATION_INTERVAL (a Go duration, default 30 days)
+func rotationInterval() time.Duration {
+	interval, err := time.ParseDuration(os.Getenv("SIGNING_KEY_ROTATION_INTERVAL"))
+	if err != nil || interval <= 0 {
+		return 30 * 24 * time.Hour
+	}
+
+	return interval
+}
+
+// scheduleRotation periodically reloads the keys, so replicas pick up rotations made
+// elsewhere, and rotates once the active key is older than the rotation interval
+func (km *keyManager) scheduleRotation(interval time.Duration) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := km.reload(); err != nil {
+			log.Printf("Error reloading signing keys: %v", err)
+			continue
+		}
+
+		active, err := km.active()
+		if err == nil && active.ActivatedAt != nil && time.Since(*active.ActivatedAt) < interval {
+			continue
+		}
+
+		if err := km.rotate(interval); err != nil {
+			log.Printf("Error rotating signing keys: %v", err)
+			continue
+		}
+
+		log.Println("Rotated signing keys")
+	}
+}
+
+func (app *Config) GetSigningKeys(c *gin.Context) {
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Signing keys retrieved",
+		Data:    app.keys.all(),
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+func (app *Config) RotateSigningKeys(c *gin.Context) {
+	err := app.keys.rotate(0)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	active, err := app.keys.active()
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	_ = app.logRequest("signing keys", fmt.Sprintf("Rotated signing keys, active key is now %s", active.Kid))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Signing keys rotated",
+		Data:    app.keys.all(),
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
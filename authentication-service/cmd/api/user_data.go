@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// erasedEmailDomain is used for the placeholder email of an erased user. The .invalid
+// top level domain is reserved, so mail can never be delivered to it.
+const erasedEmailDomain = "erased.invalid"
+
+// ExportUserData returns everything this service holds about a user
+func (app *Config) ExportUserData(c *gin.Context) {
+	user := app.customerFromParam(c)
+	if user == nil {
+		return
+	}
+	user.Password = ""
+
+	profile, err := app.getCustomerProfile(user.ID)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	addresses, err := app.getCustomerAddresses(user.ID)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	sessions, err := app.getSessions(user.ID, true)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	history, err := app.getLoginHistory(user.ID, nil, 0)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	apiKeys, err := app.getAPIKeys(user.ID)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "User data exported",
+		Data: gin.H{
+			"user":          user,
+			"profile":       profile,
+			"addresses":     addresses,
+			"sessions":      sessions,
+			"login_history": history,
+			"api_keys":      apiKeys,
+		},
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+// EraseUserData removes a user's personal data. The users row is kept, pseudonymized
+// and deactivated, so that ids held by other services (such as on orders) stay valid.
+func (app *Config) EraseUserData(c *gin.Context) {
+	user := app.customerFromParam(c)
+	if user == nil {
+		return
+	}
+
+	// Nobody knows this password, so the account can't be logged in to again
+	placeholder, err := randomToken(32)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+	hashedPlaceholder, err := app.Passwords.hash(placeholder)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	tx, err := app.DB.Begin()
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	statements := []string{
+		`delete from customer_profiles where user_id = $1`,
+		`delete from customer_addresses where user_id = $1`,
+		`delete from refresh_tokens where user_id = $1`,
+		`delete from sessions where user_id = $1`,
+		`delete from login_history where user_id = $1`,
+		`delete from password_tokens where user_id = $1`,
+		`delete from oauth_authorization_codes where user_id = $1`,
+		`delete from api_keys where user_id = $1`,
+	}
+	for _, stmt := range statements {
+		if _, err = tx.Exec(stmt, user.ID); err != nil {
+			app.errorJSON(c, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Failed logins may have been recorded against the email before the account existed
+	_, err = tx.Exec(`delete from login_history where email = $1`, user.Email)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	stmt := `update users set email = $1, first_name = 'Erased', last_name = 'User', password = $2, active = 0, updated_at = $3
+		where id = $4`
+
+	_, err = tx.Exec(stmt, fmt.Sprintf("erased-%d@%s", user.ID, erasedEmailDomain), hashedPlaceholder, time.Now(), user.ID)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	// The log entry deliberately doesn't mention the old email
+	_ = app.logRequest("privacy", fmt.Sprintf("Erased personal data of user %d", user.ID))
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Personal data of user %d erased", user.ID),
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Roles a user can have
+const (
+	roleCustomer = "customer"
+	roleStaff    = "staff"
+	roleAdmin    = "admin"
+)
+
+var validRoles = map[string]bool{
+	roleCustomer: true,
+	roleStaff:    true,
+	roleAdmin:    true,
+}
+
+const (
+	defaultUserPageSize = 50
+	maxUserPageSize     = 200
+)
+
+// userSortColumns maps the sort options accepted by the listing to their column and
+// the SQL type used to compare cursor values
+var userSortColumns = map[string]struct {
+	column string
+	cast   string
+}{
+	"id":         {"id", "integer"},
+	"email":      {"email", "text"},
+	"first_name": {"first_name", "text"},
+	"last_name":  {"last_name", "text"},
+	"created_at": {"created_at", "timestamptz"},
+}
+
+// userListQuery is a parsed request for a page of users
+type userListQuery struct {
+	Search     string
+	Email      string
+	Name       string
+	Active     *int
+	Role       string
+	Sort       string
+	Descending bool
+	Limit      int
+	Cursor     *userCursor
+}
+
+// userCursor marks the last user on a page: the value of the sort column and the id,
+// which breaks ties between users with the same value
+type userCursor struct {
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+// encode turns a cursor into the opaque string handed to clients
+func (uc userCursor) encode() string {
+	data, _ := json.Marshal(uc)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeUserCursor(s string) (*userCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+
+	var cursor userCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+
+	return &cursor, nil
+}
+
+// parseUserListQuery reads the filters, sort and page from the query string
+func parseUserListQuery(values url.Values) (*userListQuery, error) {
+	q := &userListQuery{
+		Search: strings.TrimSpace(values.Get("q")),
+		Email:  strings.TrimSpace(values.Get("email")),
+		Name:   strings.TrimSpace(values.Get("name")),
+		Role:   values.Get("role"),
+		Sort:   values.Get("sort"),
+		Limit:  defaultUserPageSize,
+	}
+
+	if value := values.Get("active"); value != "" {
+		active, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, errors.New("active must be true or false")
+		}
+		q.Active = new(int)
+		if active {
+			*q.Active = 1
+		}
+	}
+
+	if q.Role != "" && !validRoles[q.Role] {
+		return nil, fmt.Errorf("unknown role: %s", q.Role)
+	}
+
+	// A leading "-" sorts descending, e.g. sort=-created_at
+	if strings.HasPrefix(q.Sort, "-") {
+		q.Sort = q.Sort[1:]
+		q.Descending = true
+	}
+	if q.Sort == "" {
+		q.Sort = "last_name"
+	}
+	if _, ok := userSortColumns[q.Sort]; !ok {
+		return nil, fmt.Errorf("cannot sort by %s", q.Sort)
+	}
+	if order := values.Get("order"); order != "" {
+		if order != "asc" && order != "desc" {
+			return nil, errors.New("order must be asc or desc")
+		}
+		q.Descending = order == "desc"
+	}
+
+	if value := values.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxUserPageSize {
+			return nil, fmt.Errorf("limit must be between 1 and %d", maxUserPageSize)
+		}
+		q.Limit = limit
+	}
+
+	if value := values.Get("cursor"); value != "" {
+		cursor, err := decodeUserCursor(value)
+		if err != nil {
+			return nil, err
+		}
+		q.Cursor = cursor
+	}
+
+	return q, nil
+}
+
+// likePattern builds an ILIKE pattern matching s anywhere, with wildcards in s escaped
+func likePattern(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+	return "%" + s + "%"
+}
+
+// listUsers returns one page of users matching the query, the total number of
+// matching users and the cursor for the next page ("" on the last page)
+func (app *Config) listUsers(q *userListQuery) ([]User, int, string, error) {
+	var conditions []string
+	var args []any
+
+	// where adds a condition, replacing ? with the placeholder for arg
+	where := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, strings.ReplaceAll(condition, "?", "$"+strconv.Itoa(len(args))))
+	}
+
+	if q.Search != "" {
+		where(`(email ILIKE ? OR first_name ILIKE ? OR last_name ILIKE ? OR first_name || ' ' || last_name ILIKE ?)`, likePattern(q.Search))
+	}
+	if q.Email != "" {
+		where(`email ILIKE ?`, likePattern(q.Email))
+	}
+	if q.Name != "" {
+		where(`first_name || ' ' || last_name ILIKE ?`, likePattern(q.Name))
+	}
+	if q.Active != nil {
+		where(`active = ?`, *q.Active)
+	}
+	if q.Role != "" {
+		where(`role = ?`, q.Role)
+	}
+
+	filter := ""
+	if len(conditions) > 0 {
+		filter = " where " + strings.Join(conditions, " and ")
+	}
+
+	var total int
+	err := app.DB.QueryRow(`select count(*) from users`+filter, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, "", err
+	}
+
+	sort := userSortColumns[q.Sort]
+	direction, comparison := "asc", ">"
+	if q.Descending {
+		direction, comparison = "desc", "<"
+	}
+
+	if q.Cursor != nil {
+		if q.Sort == "id" {
+			where(`id `+comparison+` ?`, q.Cursor.ID)
+		} else {
+			args = append(args, q.Cursor.Value, q.Cursor.ID)
+			conditions = append(conditions, fmt.Sprintf("(%s, id) %s ($%d::%s, $%d)",
+				sort.column, comparison, len(args)-1, sort.cast, len(args)))
+		}
+		filter = " where " + strings.Join(conditions, " and ")
+	}
+
+	// Fetch one extra row to find out whether there is another page
+	query := fmt.Sprintf(`select id, email, first_name, last_name, active, role, created_at, updated_at from users%s
+		order by %s %s, id %s limit %d`, filter, sort.column, direction, direction, q.Limit+1)
+
+	rows, err := app.DB.Query(query, args...)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var user User
+		err := rows.Scan(
+			&user.ID,
+			&user.Email,
+			&user.FirstName,
+			&user.LastName,
+			&user.Active,
+			&user.Role,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+		)
+		if err != nil {
+			return nil, 0, "", err
+		}
+
+		users = append(users, user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, "", err
+	}
+
+	nextCursor := ""
+	if len(users) > q.Limit {
+		users = users[:q.Limit]
+		last := users[len(users)-1]
+
+		cursor := userCursor{ID: last.ID}
+		switch q.Sort {
+		case "email":
+			cursor.Value = last.Email
+		case "first_name":
+			cursor.Value = last.FirstName
+		case "last_name":
+			cursor.Value = last.LastName
+		case "created_at":
+			cursor.Value = last.CreatedAt.Format(time.RFC3339Nano)
+		}
+		nextCursor = cursor.encode()
+	}
+
+	return users, total, nextCursor, nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"database/sql/driver"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseUserListQuery(t *testing.T) {
+	active, inactive := 1, 0
+	cursor := userCursor{Value: "Lovelace", ID: 42}
+
+	tests := []struct {
+		name    string
+		query   string
+		want    userListQuery
+		wantErr string
+	}{
+		{"defaults", "", userListQuery{Sort: "last_name", Limit: defaultUserPageSize}, ""},
+		{"filters", "q=+ada+&email=example.com&name=Ada%20L&role=staff", userListQuery{Search: "ada", Email: "example.com", Name: "Ada L", Role: "staff", Sort: "last_name", Limit: defaultUserPageSize}, ""},
+		{"active", "active=true", userListQuery{Active: &active, Sort: "last_name", Limit: defaultUserPageSize}, ""},
+		{"inactive", "active=0", userListQuery{Active: &inactive, Sort: "last_name", Limit: defaultUserPageSize}, ""},
+		{"descending sort", "sort=-created_at", userListQuery{Sort: "created_at", Descending: true, Limit: defaultUserPageSize}, ""},
+		{"order overrides the prefix", "sort=-email&order=asc", userListQuery{Sort: "email", Limit: defaultUserPageSize}, ""},
+		{"order", "sort=id&order=desc", userListQuery{Sort: "id", Descending: true, Limit: defaultUserPageSize}, ""},
+		{"page", "limit=200&cursor=" + cursor.encode(), userListQuery{Sort: "last_name", Limit: 200, Cursor: &cursor}, ""},
+		{"bad active", "active=sometimes", userListQuery{}, "active must be true or false"},
+		{"unknown role", "role=owner", userListQuery{}, "unknown role: owner"},
+		{"unknown sort", "sort=password", userListQuery{}, "cannot sort by password"},
+		{"bad order", "order=up", userListQuery{}, "order must be asc or desc"},
+		{"limit too small", "limit=0", userListQuery{}, "limit must be between 1 and 200"},
+		{"limit too large", "limit=201", userListQuery{}, "limit must be between 1 and 200"},
+		{"limit isn't a number", "limit=all", userListQuery{}, "limit must be between 1 and 200"},
+		{"cursor isn't base64", "cursor=!!!", userListQuery{}, "invalid cursor"},
+		{"cursor isn't json", "cursor=bm90IGpzb24", userListQuery{}, "invalid cursor"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			q, err := parseUserListQuery(values)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("parseUserListQuery() = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseUserListQuery() = %v", err)
+			}
+			if !reflect.DeepEqual(*q, tt.want) {
+				t.Errorf("parseUserListQuery() = %+v, want %+v", *q, tt.want)
+			}
+		})
+	}
+}
+
+func TestUserCursor(t *testing.T) {
+	cursor := userCursor{Value: "2024-03-01T12:00:00.123456Z", ID: 7}
+
+	decoded, err := decodeUserCursor(cursor.encode())
+	if err != nil {
+		t.Fatalf("decodeUserCursor() = %v", err)
+	}
+	if *decoded != cursor {
+		t.Errorf("decodeUserCursor() = %+v, want %+v", *decoded, cursor)
+	}
+}
+
+func TestLikePattern(t *testing.T) {
+	tests := []struct {
+		s, want string
+	}{
+		{"ada", "%ada%"},
+		{"100%", `%100\%%`},
+		{"first_name", `%first\_name%`},
+		{`back\slash`, `%back\\slash%`},
+	}
+
+	for _, tt := range tests {
+		if got := likePattern(tt.s); got != tt.want {
+			t.Errorf("likePattern(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestListUsers(t *testing.T) {
+	now := time.Now()
+	user := func(id int64, lastName string) []driver.Value {
+		return []driver.Value{id, "user@example.com", "Ada", lastName, int64(1), "staff", now, now}
+	}
+
+	active := 1
+	tests := []struct {
+		name       string
+		query      userListQuery
+		rows       [][]driver.Value
+		wantWhere  string
+		wantArgs   []driver.Value
+		wantOrder  string
+		wantUsers  int
+		wantCursor *userCursor
+	}{
+		{
+			name:      "no filters",
+			query:     userListQuery{Sort: "last_name", Limit: 2},
+			rows:      [][]driver.Value{user(1, "Hopper")},
+			wantOrder: "order by last_name asc, id asc limit 3",
+			wantUsers: 1,
+		},
+		{
+			name:      "filters",
+			query:     userListQuery{Search: "ada", Active: &active, Role: "staff", Sort: "id", Descending: true, Limit: 2},
+			rows:      [][]driver.Value{user(3, "Lovelace")},
+			wantWhere: "where (email ILIKE $1 OR first_name ILIKE $1 OR last_name ILIKE $1 OR first_name || ' ' || last_name ILIKE $1) and active = $2 and role = $3",
+			wantArgs:  []driver.Value{"%ada%", int64(1), "staff"},
+			wantOrder: "order by id desc, id desc limit 3",
+			wantUsers: 1,
+		},
+		{
+			name:       "a full page has a next cursor",
+			query:      userListQuery{Sort: "last_name", Limit: 2},
+			rows:       [][]driver.Value{user(1, "Hopper"), user(2, "Lovelace"), user(3, "Turing")},
+			wantOrder:  "order by last_name asc, id asc limit 3",
+			wantUsers:  2,
+			wantCursor: &userCursor{Value: "Lovelace", ID: 2},
+		},
+		{
+			name:      "after a cursor",
+			query:     userListQuery{Role: "staff", Sort: "last_name", Limit: 2, Cursor: &userCursor{Value: "Lovelace", ID: 2}},
+			rows:      [][]driver.Value{user(3, "Turing")},
+			wantWhere: "where role = $1 and (last_name, id) > ($2::text, $3)",
+			wantArgs:  []driver.Value{"staff", "Lovelace", int64(2)},
+			wantOrder: "order by last_name asc, id asc limit 3",
+			wantUsers: 1,
+		},
+		{
+			name:      "descending after an id cursor",
+			query:     userListQuery{Sort: "id", Descending: true, Limit: 2, Cursor: &userCursor{ID: 9}},
+			rows:      [][]driver.Value{user(8, "Turing")},
+			wantWhere: "where id < $1",
+			wantArgs:  []driver.Value{int64(9)},
+			wantOrder: "order by id desc, id desc limit 3",
+			wantUsers: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, fake := newFakeDB(t, func(q fakeQuery) fakeResult {
+				if q.has("select count(*) from users") {
+					return fakeResult{rows: [][]driver.Value{{int64(10)}}}
+				}
+				return fakeResult{rows: tt.rows}
+			})
+			app := &Config{DB: db}
+
+			users, total, next, err := app.listUsers(&tt.query)
+			if err != nil {
+				t.Fatalf("listUsers() = %v", err)
+			}
+			if total != 10 || len(users) != tt.wantUsers {
+				t.Errorf("listUsers() returned %d users of %d, want %d of 10", len(users), total, tt.wantUsers)
+			}
+
+			wantNext := ""
+			if tt.wantCursor != nil {
+				wantNext = tt.wantCursor.encode()
+			}
+			if next != wantNext {
+				t.Errorf("next cursor = %q, want %q", next, wantNext)
+			}
+
+			pages := fake.ran("select id, email", tt.wantOrder)
+			if len(pages) != 1 {
+				t.Fatalf("no page query ordered by %q in %v", tt.wantOrder, fake.queries)
+			}
+			args := pages[0].args
+			if len(args) == 0 {
+				args = nil
+			}
+			if !pages[0].has(tt.wantWhere) || !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("page query = %q %v, want %q %v", pages[0].query, args, tt.wantWhere, tt.wantArgs)
+			}
+
+			// The count ignores the cursor, so it covers every page
+			counts := fake.ran("select count(*) from users")
+			if len(counts) != 1 || counts[0].has("id <") || counts[0].has(", id)") {
+				t.Errorf("count query = %v", counts)
+			}
+		})
+	}
+}
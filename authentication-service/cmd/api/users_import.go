@@ -0,0 +1,436 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// How an import treats rows whose email already has an account
+const (
+	onDuplicateError  = "error"
+	onDuplicateSkip   = "skip"
+	onDuplicateUpdate = "update"
+)
+
+// Outcomes of an imported row
+const (
+	importStatusValid   = "valid"
+	importStatusCreated = "created"
+	importStatusUpdated = "updated"
+	importStatusSkipped = "skipped"
+	importStatusFailed  = "failed"
+)
+
+const (
+	passwordTokenInvite = "invite"
+	inviteTTL           = 7 * 24 * time.Hour
+	maxImportRows       = 5000
+)
+
+// importRow is one user to import. Active is a pointer so that a missing value can
+// default to active.
+type importRow struct {
+	Email     string `json:"email"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Password  string `json:"password"`
+	Role      string `json:"role"`
+	Active    *int   `json:"active"`
+}
+
+// importRowResult reports what happened to one row. Rows are numbered from 1; for CSV
+// files the header is not counted.
+type importRowResult struct {
+	Row    int          `json:"row"`
+	Email  string       `json:"email"`
+	Status string       `json:"status"`
+	UserID int          `json:"user_id,omitempty"`
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+// importResult summarizes an import
+type importResult struct {
+	DryRun  bool              `json:"dry_run"`
+	Total   int               `json:"total"`
+	Created int               `json:"created"`
+	Updated int               `json:"updated"`
+	Skipped int               `json:"skipped"`
+	Failed  int               `json:"failed"`
+	Rows    []importRowResult `json:"rows"`
+}
+
+// parseImportCSV reads users from a CSV file with a header row. Columns are matched by
+// name (email, first_name, last_name, password, role, active) and unknown columns are
+// ignored.
+func parseImportCSV(r io.Reader) ([]importRow, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, errors.New("csv file must start with a header row")
+	}
+
+	columns := make(map[string]int)
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["email"]; !ok {
+		return nil, errors.New("csv header must include an email column")
+	}
+
+	rows := []importRow{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		get := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		row := importRow{
+			Email:     get("email"),
+			FirstName: get("first_name"),
+			LastName:  get("last_name"),
+			Password:  get("password"),
+			Role:      get("role"),
+		}
+
+		switch strings.ToLower(get("active")) {
+		case "":
+		case "1", "true", "yes":
+			active := 1
+			row.Active = &active
+		default:
+			active := 0
+			row.Active = &active
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// validateImportRow checks a row on its own, without looking at the database
+func (app *Config) validateImportRow(row importRow, invite bool) []FieldError {
+	errs := []FieldError{}
+
+	if row.Email == "" {
+		errs = append(errs, FieldError{Field: "email", Message: "email is required"})
+	} else if !strings.Contains(row.Email, "@") {
+		errs = append(errs, FieldError{Field: "email", Message: "email is not valid"})
+	}
+
+	if row.FirstName == "" {
+		errs = append(errs, FieldError{Field: "first_name", Message: "first_name is required"})
+	}
+	if row.LastName == "" {
+		errs = append(errs, FieldError{Field: "last_name", Message: "last_name is required"})
+	}
+	if row.Role != "" && !validRoles[row.Role] {
+		errs = append(errs, FieldError{Field: "role", Message: "role must be customer, staff or admin"})
+	}
+
+	switch {
+	case row.Password != "":
+		user := User{Email: row.Email, FirstName: row.FirstName, LastName: row.LastName}
+		errs = append(errs, app.Policy.validate(row.Password, user)...)
+	case !invite:
+		errs = append(errs, FieldError{Field: "password", Message: "password is required unless users are invited"})
+	}
+
+	return errs
+}
+
+// updateImportedUser updates an existing user's details from an import row. The
+// password and role are only changed when the row has them.
+func (app *Config) updateImportedUser(id int, row importRow, active int) error {
+	stmt := `update users set first_name = $1, last_name = $2, active = $3, role = coalesce(nullif($4, ''), role), updated_at = $5
+		where id = $6`
+
+	_, err := app.DB.Exec(stmt, row.FirstName, row.LastName, active, row.Role, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	if row.Password != "" {
+		return app.updatePassword(id, row.Password)
+	}
+
+	return nil
+}
+
+// inviteUser emails a new user a link to choose their password
+func (app *Config) inviteUser(user User) error {
+	token, err := app.insertPasswordToken(user.ID, passwordTokenInvite, inviteTTL)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Hi %s,\n\nAn account has been created for you. Use this link within the next week to choose your password:\n\n%s", user.FirstName, passwordResetLink(token))
+	return app.sendMail(user.Email, "You're invited", body)
+}
+
+// ImportUsers creates users in bulk from a CSV or JSON body. Query parameters:
+// format (csv or json, defaulting to the Content-Type), dry_run, on_duplicate (error,
+// skip or update) and invite, which emails users without a password a link to set one.
+func (app *Config) ImportUsers(c *gin.Context) {
+	dryRun := c.Query("dry_run") == "true"
+	invite := c.Query("invite") == "true"
+
+	onDuplicate := c.DefaultQuery("on_duplicate", onDuplicateError)
+	if onDuplicate != onDuplicateError && onDuplicate != onDuplicateSkip && onDuplicate != onDuplicateUpdate {
+		app.errorJSON(c, errors.New("on_duplicate must be error, skip or update"), http.StatusBadRequest)
+		return
+	}
+
+	format := c.Query("format")
+	if format == "" {
+		format = "json"
+		if strings.Contains(c.ContentType(), "csv") {
+			format = "csv"
+		}
+	}
+
+	var rows []importRow
+	var err error
+
+	switch format {
+	case "csv":
+		rows, err = parseImportCSV(c.Request.Body)
+	case "json":
+		err = json.NewDecoder(c.Request.Body).Decode(&rows)
+	default:
+		err = errors.New("format must be csv or json")
+	}
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	if len(rows) > maxImportRows {
+		app.errorJSON(c, fmt.Errorf("imports are limited to %d users", maxImportRows), http.StatusBadRequest)
+		return
+	}
+
+	result := importResult{DryRun: dryRun, Total: len(rows), Rows: []importRowResult{}}
+	seen := make(map[string]int)
+
+	for i, row := range rows {
+		row.Email = strings.TrimSpace(row.Email)
+		rowResult := importRowResult{Row: i + 1, Email: row.Email}
+
+		errs := app.validateImportRow(row, invite)
+
+		key := strings.ToLower(row.Email)
+		if first, ok := seen[key]; ok && row.Email != "" {
+			errs = append(errs, FieldError{Field: "email", Message: fmt.Sprintf("email is repeated from row %d", first)})
+		} else {
+			seen[key] = i + 1
+		}
+
+		var existing *User
+		if row.Email != "" {
+			existing, _ = app.getByEmail(row.Email)
+		}
+		if existing != nil && onDuplicate == onDuplicateError {
+			errs = append(errs, FieldError{Field: "email", Message: "a user with this email already exists"})
+		}
+
+		active := 1
+		if row.Active != nil {
+			active = *row.Active
+		}
+
+		switch {
+		case existing != nil && onDuplicate == onDuplicateSkip:
+			rowResult.Status = importStatusSkipped
+			rowResult.UserID = existing.ID
+			result.Skipped++
+
+		case len(errs) > 0:
+			rowResult.Status = importStatusFailed
+			rowResult.Errors = errs
+			result.Failed++
+
+		case dryRun:
+			rowResult.Status = importStatusValid
+			if existing != nil {
+				rowResult.UserID = existing.ID
+				result.Updated++
+			} else {
+				result.Created++
+			}
+
+		case existing != nil:
+			err = app.updateImportedUser(existing.ID, row, active)
+			if err != nil {
+				rowResult.Status = importStatusFailed
+				rowResult.Errors = []FieldError{{Field: "email", Message: err.Error()}}
+				result.Failed++
+				break
+			}
+			rowResult.Status = importStatusUpdated
+			rowResult.UserID = existing.ID
+			result.Updated++
+
+		default:
+			user := User{
+				Email:     row.Email,
+				FirstName: row.FirstName,
+				LastName:  row.LastName,
+				Password:  row.Password,
+				Active:    active,
+				Role:      row.Role,
+			}
+
+			// Invited users get a random password nobody knows until they choose their own
+			invited := user.Password == ""
+			if invited {
+				user.Password, err = randomToken(32)
+				if err != nil {
+					app.errorJSON(c, err, http.StatusInternalServerError)
+					return
+				}
+			}
+
+			user.ID, err = app.InsertUser(user)
+			if err != nil {
+				rowResult.Status = importStatusFailed
+				rowResult.Errors = []FieldError{{Field: "email", Message: err.Error()}}
+				result.Failed++
+				break
+			}
+
+			if invited {
+				if err := app.inviteUser(user); err != nil {
+					rowResult.Errors = []FieldError{{Field: "email", Message: "user created but the invite could not be sent: " + err.Error()}}
+				}
+			}
+
+			rowResult.Status = importStatusCreated
+			rowResult.UserID = user.ID
+			result.Created++
+		}
+
+		result.Rows = append(result.Rows, rowResult)
+	}
+
+	if !dryRun {
+		_ = app.logRequest("users", fmt.Sprintf("Imported users: %d created, %d updated, %d skipped, %d failed",
+			result.Created, result.Updated, result.Skipped, result.Failed))
+	}
+
+	message := "Users imported"
+	if dryRun {
+		message = "Import validated, no changes made"
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: message,
+		Data:    result,
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+// ExportUsers returns every user as CSV or JSON (?format=csv|json). Password hashes
+// are never included.
+func (app *Config) ExportUsers(c *gin.Context) {
+	query := `select id, email, first_name, last_name, active, role, created_at, updated_at from users order by id`
+
+	rows, err := app.DB.Query(query)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var user User
+		err := rows.Scan(
+			&user.ID,
+			&user.Email,
+			&user.FirstName,
+			&user.LastName,
+			&user.Active,
+			&user.Role,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+		)
+		if err != nil {
+			app.errorJSON(c, err, http.StatusInternalServerError)
+			return
+		}
+
+		users = append(users, user)
+	}
+
+	switch c.DefaultQuery("format", "json") {
+	case "csv":
+		c.Header("Content-Type", "text/csv")
+		c.Header("Content-Disposition", `attachment; filename="users.csv"`)
+		c.Status(http.StatusOK)
+
+		writer := csv.NewWriter(c.Writer)
+		_ = writer.Write([]string{"id", "email", "first_name", "last_name", "active", "role", "created_at", "updated_at"})
+		for _, user := range users {
+			_ = writer.Write([]string{
+				strconv.Itoa(user.ID),
+				user.Email,
+				user.FirstName,
+				user.LastName,
+				strconv.Itoa(user.Active),
+				user.Role,
+				user.CreatedAt.Format(time.RFC3339),
+				user.UpdatedAt.Format(time.RFC3339),
+			})
+		}
+		writer.Flush()
+
+	case "json":
+		exported := make([]any, 0, len(users))
+		for _, user := range users {
+			exported = append(exported, struct {
+				ID        int       `json:"id"`
+				Email     string    `json:"email"`
+				FirstName string    `json:"first_name"`
+				LastName  string    `json:"last_name"`
+				Active    int       `json:"active"`
+				Role      string    `json:"role"`
+				CreatedAt time.Time `json:"created_at"`
+				UpdatedAt time.Time `json:"updated_at"`
+			}{user.ID, user.Email, user.FirstName, user.LastName, user.Active, user.Role, user.CreatedAt, user.UpdatedAt})
+		}
+
+		c.Header("Content-Disposition", `attachment; filename="users.json"`)
+		app.writeJSON(c, http.StatusOK, jsonResponse{
+			Error:   false,
+			Message: "Users exported",
+			Data:    exported,
+		})
+
+	default:
+		app.errorJSON(c, errors.New("format must be csv or json"), http.StatusBadRequest)
+	}
+}
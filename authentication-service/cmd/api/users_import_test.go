@@ -0,0 +1,189 @@
+package main
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseImportCSV(t *testing.T) {
+	active, inactive := 1, 0
+
+	tests := []struct {
+		name    string
+		csv     string
+		want    []importRow
+		wantErr string
+	}{
+		{
+			name: "columns in any order",
+			csv:  "Role, Email ,last_name,first_name,notes\nstaff, ada@example.com ,Lovelace,Ada,ignored\n",
+			want: []importRow{{Email: "ada@example.com", FirstName: "Ada", LastName: "Lovelace", Role: "staff"}},
+		},
+		{
+			name: "active values",
+			csv:  "email,active\na@example.com,yes\nb@example.com,TRUE\nc@example.com,0\nd@example.com,\ne@example.com,no\n",
+			want: []importRow{
+				{Email: "a@example.com", Active: &active},
+				{Email: "b@example.com", Active: &active},
+				{Email: "c@example.com", Active: &inactive},
+				{Email: "d@example.com"},
+				{Email: "e@example.com", Active: &inactive},
+			},
+		},
+		{
+			name: "quoted fields",
+			csv:  "email,first_name,password\nada@example.com,\"Ada, Countess\",\"p@ss,word\"\n",
+			want: []importRow{{Email: "ada@example.com", FirstName: "Ada, Countess", Password: "p@ss,word"}},
+		},
+		{
+			name: "header only",
+			csv:  "email,first_name\n",
+			want: []importRow{},
+		},
+		{
+			name:    "empty file",
+			csv:     "",
+			wantErr: "csv file must start with a header row",
+		},
+		{
+			name:    "no email column",
+			csv:     "first_name,last_name\nAda,Lovelace\n",
+			wantErr: "csv header must include an email column",
+		},
+		{
+			name:    "rows with too few fields",
+			csv:     "email,first_name\nada@example.com\n",
+			wantErr: "record on line 2: wrong number of fields",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := parseImportCSV(strings.NewReader(tt.csv))
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("parseImportCSV() = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseImportCSV() = %v", err)
+			}
+			if !reflect.DeepEqual(rows, tt.want) {
+				t.Errorf("parseImportCSV() = %+v, want %+v", rows, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateImportRow(t *testing.T) {
+	app := &Config{Policy: &passwordPolicy{minLength: 8}}
+	valid := importRow{Email: "ada@example.com", FirstName: "Ada", LastName: "Lovelace", Password: "correct horse", Role: "staff"}
+
+	tests := []struct {
+		name   string
+		row    func(importRow) importRow
+		invite bool
+		want   []string
+	}{
+		{"valid", func(r importRow) importRow { return r }, false, nil},
+		{"no role defaults later", func(r importRow) importRow { r.Role = ""; return r }, false, nil},
+		{"no email", func(r importRow) importRow { r.Email = ""; return r }, false, []string{"email: email is required"}},
+		{"bad email", func(r importRow) importRow { r.Email = "ada"; return r }, false, []string{"email: email is not valid"}},
+		{"no names", func(r importRow) importRow { r.FirstName, r.LastName = "", ""; return r }, false, []string{"first_name: first_name is required", "last_name: last_name is required"}},
+		{"unknown role", func(r importRow) importRow { r.Role = "owner"; return r }, false, []string{"role: role must be customer, staff or admin"}},
+		{"weak password", func(r importRow) importRow { r.Password = "short"; return r }, false, []string{"password: password must be at least 8 characters long"}},
+		{"no password", func(r importRow) importRow { r.Password = ""; return r }, false, []string{"password: password is required unless users are invited"}},
+		{"no password when inviting", func(r importRow) importRow { r.Password = ""; return r }, true, nil},
+		{"a given password is still checked when inviting", func(r importRow) importRow { r.Password = "short"; return r }, true, []string{"password: password must be at least 8 characters long"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, err := range app.validateImportRow(tt.row(valid), tt.invite) {
+				got = append(got, err.Field+": "+err.Message)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("validateImportRow() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportUsersDryRun(t *testing.T) {
+	now := time.Now()
+	db, fake := newFakeDB(t, func(q fakeQuery) fakeResult {
+		if q.has("from users where email = $1") && q.args[0] == "grace@example.com" {
+			return fakeResult{rows: [][]driver.Value{{int64(7), "grace@example.com", "Grace", "Hopper", "", int64(1), "staff", now, now}}}
+		}
+		return fakeResult{}
+	})
+	app := &Config{DB: db, Policy: &passwordPolicy{minLength: 8}}
+
+	body := "email,first_name,last_name,password\n" +
+		"ada@example.com,Ada,Lovelace,correct horse\n" +
+		"grace@example.com,Grace,Hopper,\n" +
+		"ADA@example.com,Ada,Again,correct horse\n" +
+		"alan@example.com,Alan,,correct horse\n"
+
+	tests := []struct {
+		name         string
+		query        string
+		wantStatus   int
+		wantStatuses []string
+		wantCounts   [4]int
+	}{
+		{"duplicates are errors", "?dry_run=true&format=csv&invite=true", http.StatusOK, []string{"valid", "failed", "failed", "failed"}, [4]int{1, 0, 0, 3}},
+		{"duplicates are skipped", "?dry_run=true&format=csv&invite=true&on_duplicate=skip", http.StatusOK, []string{"valid", "skipped", "failed", "failed"}, [4]int{1, 0, 1, 2}},
+		{"duplicates are updated", "?dry_run=true&format=csv&invite=true&on_duplicate=update", http.StatusOK, []string{"valid", "valid", "failed", "failed"}, [4]int{1, 1, 0, 2}},
+		{"passwords required without invites", "?dry_run=true&format=csv&on_duplicate=update", http.StatusOK, []string{"valid", "failed", "failed", "failed"}, [4]int{1, 0, 0, 3}},
+		{"unknown on_duplicate", "?dry_run=true&format=csv&on_duplicate=merge", http.StatusBadRequest, nil, [4]int{}},
+		{"unknown format", "?dry_run=true&format=xml", http.StatusBadRequest, nil, [4]int{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := serveRequest(http.MethodPost, "/users/import", "/users/import"+tt.query, body, nil, app.ImportUsers)
+			if recorder.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", recorder.Code, tt.wantStatus, recorder.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var response struct {
+				Data importResult `json:"data"`
+			}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatal(err)
+			}
+
+			result := response.Data
+			var statuses []string
+			for _, row := range result.Rows {
+				statuses = append(statuses, row.Status)
+			}
+			if !result.DryRun || result.Total != 4 || !reflect.DeepEqual(statuses, tt.wantStatuses) {
+				t.Errorf("result = %+v, want the statuses %v", result, tt.wantStatuses)
+			}
+			if counts := [4]int{result.Created, result.Updated, result.Skipped, result.Failed}; counts != tt.wantCounts {
+				t.Errorf("created, updated, skipped, failed = %v, want %v", counts, tt.wantCounts)
+			}
+
+			// The repeated email is reported against the row it repeats
+			if errs := result.Rows[2].Errors; len(errs) == 0 || errs[0].Message != "email is repeated from row 1" {
+				t.Errorf("row 3 errors = %+v", errs)
+			}
+		})
+	}
+
+	if writes := append(fake.ran("insert into"), fake.ran("update users")...); len(writes) != 0 {
+		t.Errorf("a dry run wrote to the database: %v", writes)
+	}
+}
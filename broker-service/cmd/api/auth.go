@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Context keys for the credentials a request was authenticated with
+const (
+	apiKeyContextKey      = "apiKey"
+	tokenClaimsContextKey = "tokenClaims"
+)
+
+// actionScopes maps broker actions to the API key scope required to perform them
+var actionScopes = map[string]string{
+	"auth":      "auth",
+	"menu":      "menu",
+	"order":     "orders",
+	"inventory": "inventory",
+	"log":       "logs",
+	"customer":  "users",
+}
+
+// APIKeyInfo is the introspection result returned by the authentication service
+type APIKeyInfo struct {
+	Active    bool       `json:"active"`
+	KeyID     int        `json:"key_id,omitempty"`
+	UserID    *int       `json:"user_id,omitempty"`
+	Name      string     `json:"name,omitempty"`
+	Scopes    []string   `json:"scopes,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// hasScope reports whether the key was granted the given scope
+func (k *APIKeyInfo) hasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == "*" || s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+// maxAPIKeyCacheEntries bounds the cache, however many different keys are sent
+const maxAPIKeyCacheEntries = 10000
+
+type apiKeyCacheEntry struct {
+	info    APIKeyInfo
+	expires time.Time
+}
+
+// apiKeyCache keeps introspection results for a short time so that the broker doesn't
+// call the authentication service on every request. Entries are keyed by a hash of the
+// key so plain text keys are never held in memory longer than a request.
+type apiKeyCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]apiKeyCacheEntry
+}
+
+// newAPIKeyCache creates a cache whose entries live for ttl
+func newAPIKeyCache(ttl time.Duration) *apiKeyCache {
+	return &apiKeyCache{
+		ttl:     ttl,
+		entries: make(map[string]apiKeyCacheEntry),
+	}
+}
+
+func (ac *apiKeyCache) get(hash string) (APIKeyInfo, bool) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	entry, ok := ac.entries[hash]
+	if !ok {
+		return APIKeyInfo{}, false
+	}
+
+	if time.Now().After(entry.expires) {
+		delete(ac.entries, hash)
+		return APIKeyInfo{}, false
+	}
+
+	return entry.info, true
+}
+
+func (ac *apiKeyCache) set(hash string, info APIKeyInfo) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	now := time.Now()
+	expires := now.Add(ac.ttl)
+	if info.ExpiresAt != nil && info.ExpiresAt.Before(expires) {
+		expires = *info.ExpiresAt
+	}
+
+	// Make room by sweeping out expired entries, then by dropping any entry
+	if _, ok := ac.entries[hash]; !ok && len(ac.entries) >= maxAPIKeyCacheEntries {
+		for h, entry := range ac.entries {
+			if now.After(entry.expires) {
+				delete(ac.entries, h)
+			}
+		}
+		for h := range ac.entries {
+			if len(ac.entries) < maxAPIKeyCacheEntries {
+				break
+			}
+			delete(ac.entries, h)
+		}
+	}
+
+	ac.entries[hash] = apiKeyCacheEntry{info: info, expires: expires}
+}
+
+// introspectAPIKey asks the authentication service whether a key is active, using the
+// cache when possible
+func (app *Config) introspectAPIKey(key string) (APIKeyInfo, error) {
+	sum := sha256.Sum256([]byte(key))
+	hash := hex.EncodeToString(sum[:])
+
+	if info, ok := app.apiKeys.get(hash); ok {
+		return info, nil
+	}
+
+	jsonData, _ := json.Marshal(map[string]string{"key": key})
+
+	request, err := http.NewRequest("POST", "http://0.0.0.0:8001/api-keys/introspect", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return APIKeyInfo{}, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	response, err := client.Do(request)
+	if err != nil {
+		return APIKeyInfo{}, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return APIKeyInfo{}, errors.New("error calling auth service")
+	}
+
+	var result struct {
+		Error   bool       `json:"error"`
+		Message string     `json:"message"`
+		Data    APIKeyInfo `json:"data"`
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&result)
+	if err != nil {
+		return APIKeyInfo{}, err
+	}
+
+	// Only active keys are cached, so made up keys can't fill the cache
+	if result.Data.Active {
+		app.apiKeys.set(hash, result.Data)
+	}
+
+	return result.Data, nil
+}
+
+// authenticateRequest is middleware that authenticates requests carrying either an
+// "Authorization: ApiKey <key>" header or an "Authorization: Bearer <token>" access
+// token issued by the authentication service. Requests without credentials pass through.
+func (app *Config) authenticateRequest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scheme, credentials, found := strings.Cut(c.GetHeader("Authorization"), " ")
+		credentials = strings.TrimSpace(credentials)
+
+		switch {
+		case found && strings.EqualFold(scheme, "ApiKey"):
+			info, err := app.introspectAPIKey(credentials)
+			if err != nil {
+				app.errorJSON(c, errors.New("unable to verify api key"), http.StatusServiceUnavailable)
+				c.Abort()
+				return
+			}
+
+			if !info.Active {
+				app.errorJSON(c, errors.New("invalid api key"), http.StatusUnauthorized)
+				c.Abort()
+				return
+			}
+
+			c.Set(apiKeyContextKey, &info)
+
+		case found && strings.EqualFold(scheme, "Bearer"):
+			claims, err := app.jwks.verifyJWT(credentials)
+			if err == nil && (claims["iss"] != app.issuer || claims["token_use"] != "access") {
+				err = errors.New("token was not issued by the authentication service")
+			}
+
+			if err != nil {
+				c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+				app.errorJSON(c, err, http.StatusUnauthorized)
+				c.Abort()
+				return
+			}
+
+			c.Set(tokenClaimsContextKey, claims)
+		}
+
+		c.Next()
+	}
+}
+
+// requestActor names who made an authenticated request, for services that keep an
+// audit trail: "user:<id>" for access tokens and "api-key:<id>" for API keys. It is ""
+// for requests made without credentials.
+func requestActor(c *gin.Context) string {
+	if value, ok := c.Get(tokenClaimsContextKey); ok {
+		if sub, ok := value.(map[string]any)["sub"].(string); ok {
+			return "user:" + sub
+		}
+	}
+
+	if value, ok := c.Get(apiKeyContextKey); ok {
+		return fmt.Sprintf("api-key:%d", value.(*APIKeyInfo).KeyID)
+	}
+
+	return ""
+}
+
+// authorizeAction checks that an API key authenticated request may perform the action.
+// Requests that were not made with an API key are left alone.
+func (app *Config) authorizeAction(c *gin.Context, action string) error {
+	value, ok := c.Get(apiKeyContextKey)
+	if !ok {
+		return nil
+	}
+
+	info := value.(*APIKeyInfo)
+	scope, ok := actionScopes[action]
+	if !ok || !info.hasScope(scope) {
+		return errors.New("api key is not allowed to perform this action")
+	}
+
+	return nil
+}
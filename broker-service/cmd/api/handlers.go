@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
@@ -18,6 +20,7 @@ type RequestPayload struct {
 	Order     OrderPayload     `json:"order,omitempty"`
 	Inventory InventoryPayload `json:"inventory,omitempty"`
 	Log       LogPayload       `json:"log,omitempty"`
+	Customer  CustomerPayload  `json:"customer,omitempty"`
 }
 
 // AuthPayload is the data needed for authentication
@@ -43,12 +46,25 @@ type OrderPayload struct {
 	Status     string      `json:"status,omitempty"`
 	Total      float64     `json:"total,omitempty"`
 	CreatedAt  string      `json:"created_at,omitempty"`
+	// RedeemPoints is the number of loyalty points to spend as a discount on a new order
+	RedeemPoints int `json:"redeem_points,omitempty"`
 }
 
 type OrderItem struct {
 	MenuItemID int     `json:"menu_item_id"`
 	Quantity   int     `json:"quantity"`
 	Price      float64 `json:"price"`
+	OptionIDs  []int   `json:"option_ids,omitempty"`
+	// ComboID orders a combo instead, with the items chosen for its slots
+	ComboID    *int             `json:"combo_id,omitempty"`
+	Selections []ComboSelection `json:"selections,omitempty"`
+}
+
+// ComboSelection is the menu item chosen for one slot of a combo
+type ComboSelection struct {
+	SlotID     int   `json:"slot_id"`
+	MenuItemID int   `json:"menu_item_id"`
+	OptionIDs  []int `json:"option_ids,omitempty"`
 }
 
 // InventoryPayload is the data needed for inventory operations
@@ -58,6 +74,18 @@ type InventoryPayload struct {
 	Quantity  int    `json:"quantity,omitempty"`
 	Unit      string `json:"unit,omitempty"`
 	Threshold int    `json:"threshold,omitempty"`
+	// Allergens and Nutrition are passed through to the inventory service as they are
+	Allergens []string        `json:"allergens,omitempty"`
+	Nutrition json.RawMessage `json:"nutrition,omitempty"`
+}
+
+// CustomerPayload is the data needed for customer profile operations. With a profile
+// the customer's profile is replaced, with an address an address is added, and with
+// neither the customer is returned.
+type CustomerPayload struct {
+	ID      int             `json:"id"`
+	Profile json.RawMessage `json:"profile,omitempty"`
+	Address json.RawMessage `json:"address,omitempty"`
 }
 
 // LogPayload is the data needed for logging
@@ -90,6 +118,12 @@ func (app *Config) HandleSubmission(c *gin.Context) {
 		return
 	}
 
+	err = app.authorizeAction(c, requestPayload.Action)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusForbidden)
+		return
+	}
+
 	switch requestPayload.Action {
 	case "auth":
 		app.authenticate(c, requestPayload.Auth)
@@ -101,6 +135,8 @@ func (app *Config) HandleSubmission(c *gin.Context) {
 		app.handleInventoryRequest(c, requestPayload.Inventory)
 	case "log":
 		app.logItem(c, requestPayload.Log)
+	case "customer":
+		app.handleCustomerRequest(c, requestPayload.Customer)
 	default:
 		app.errorJSON(c, errors.New("unknown action"))
 	}
@@ -159,6 +195,11 @@ func (app *Config) handleMenuRequest(c *gin.Context, payload MenuPayload) {
 		return
 	}
 
+	// The menu service records who changed each item
+	if actor := requestActor(c); actor != "" {
+		request.Header.Set("X-User", actor)
+	}
+
 	client := &http.Client{}
 	response, err := client.Do(request)
 	if err != nil {
@@ -262,6 +303,80 @@ func (app *Config) handleInventoryRequest(c *gin.Context, payload InventoryPaylo
 	c.Writer.Write(responseBody)
 }
 
+// handleCustomerRequest calls the authentication service's customer endpoints.
+// Customers logged in with an access token may only see and change their own profile,
+// API keys need the users scope, and requests without credentials are refused.
+func (app *Config) handleCustomerRequest(c *gin.Context, payload CustomerPayload) {
+	if payload.ID == 0 {
+		app.errorJSON(c, errors.New("customer id is required"))
+		return
+	}
+
+	if value, ok := c.Get(apiKeyContextKey); ok {
+		if !value.(*APIKeyInfo).hasScope("users") {
+			app.errorJSON(c, errors.New("api key is not allowed to perform this action"), http.StatusForbidden)
+			return
+		}
+	} else if value, ok := c.Get(tokenClaimsContextKey); ok {
+		if value.(map[string]any)["sub"] != strconv.Itoa(payload.ID) {
+			app.errorJSON(c, errors.New("you may only access your own customer profile"), http.StatusForbidden)
+			return
+		}
+	} else {
+		c.Header("WWW-Authenticate", "Bearer")
+		app.errorJSON(c, errors.New("authentication required"), http.StatusUnauthorized)
+		return
+	}
+
+	url := fmt.Sprintf("http://0.0.0.0:8001/customers/%d", payload.ID)
+	method := "GET"
+	var body []byte
+
+	switch {
+	case len(payload.Profile) > 0:
+		method, url, body = "PUT", url+"/profile", payload.Profile
+	case len(payload.Address) > 0:
+		method, url, body = "POST", url+"/addresses", payload.Address
+	}
+
+	// Call the service
+	request, err := http.NewRequest(method, url, bytes.NewBuffer(body))
+	if err != nil {
+		app.errorJSON(c, err)
+		return
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{}
+	response, err := client.Do(request)
+	if err != nil {
+		app.errorJSON(c, err)
+		return
+	}
+	defer response.Body.Close()
+
+	// Make sure we get the correct status code. Not found and validation errors are
+	// passed on to the client.
+	switch response.StatusCode {
+	case http.StatusOK, http.StatusCreated, http.StatusNotFound, http.StatusUnprocessableEntity:
+	default:
+		app.errorJSON(c, errors.New("error calling auth service"), http.StatusInternalServerError)
+		return
+	}
+
+	// Read response.Body
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		app.errorJSON(c, err)
+		return
+	}
+
+	// Send JSON back to the client
+	c.Header("Content-Type", "application/json")
+	c.Writer.WriteHeader(response.StatusCode)
+	c.Writer.Write(responseBody)
+}
+
 // logItem logs an event using the logger-service (via RPC)
 func (app *Config) logItem(c *gin.Context, payload LogPayload) {
 	jsonData, _ := json.Marshal(payload)
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwksRefetchInterval limits how often an unknown kid can trigger a JWKS fetch, so
+// tokens with made up key ids can't be used to hammer the authentication service
+const jwksRefetchInterval = 30 * time.Second
+
+// jwksCache fetches the authentication service's published signing keys and caches
+// them by kid. Keys are refreshed after ttl, or early when a token names a kid that
+// isn't cached yet (which happens right after a rotation).
+type jwksCache struct {
+	mu        sync.Mutex
+	url       string
+	ttl       time.Duration
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+// newJWKSCache creates a cache for the JWKS document at url
+func newJWKSCache(url string, ttl time.Duration) *jwksCache {
+	return &jwksCache{
+		url:  url,
+		ttl:  ttl,
+		keys: make(map[string]*rsa.PublicKey),
+	}
+}
+
+// fetch downloads and parses the JWKS document. The caller must hold the lock.
+func (jc *jwksCache) fetch() error {
+	client := &http.Client{Timeout: 5 * time.Second}
+	response, err := client.Get(jc.url)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return errors.New("error fetching signing keys from auth service")
+	}
+
+	var document struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&document)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, jwk := range document.Keys {
+		if jwk.Kty != "RSA" {
+			continue
+		}
+
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(jwk.E)
+		if err != nil {
+			continue
+		}
+
+		keys[jwk.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	jc.keys = keys
+	jc.fetchedAt = time.Now()
+
+	return nil
+}
+
+// key returns the public key for kid, fetching the JWKS when the cache is stale or
+// doesn't know the kid
+func (jc *jwksCache) key(kid string) (*rsa.PublicKey, error) {
+	jc.mu.Lock()
+	defer jc.mu.Unlock()
+
+	age := time.Since(jc.fetchedAt)
+	key, ok := jc.keys[kid]
+
+	if age > jc.ttl || (!ok && age > jwksRefetchInterval) {
+		if err := jc.fetch(); err != nil {
+			// Keep using what we have if the auth service is briefly unavailable
+			if ok {
+				return key, nil
+			}
+			return nil, err
+		}
+		key, ok = jc.keys[kid]
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key: %s", kid)
+	}
+
+	return key, nil
+}
+
+// verifyJWT checks an RS256 token's signature and expiry against the cached JWKS and
+// returns its claims
+func (jc *jwksCache) verifyJWT(token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.New("malformed token header")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, errors.New("malformed token header")
+	}
+
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("unsupported token algorithm: %s", header.Alg)
+	}
+
+	pub, err := jc.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errors.New("malformed token signature")
+	}
+
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, errors.New("invalid token signature")
+	}
+
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errors.New("malformed token claims")
+	}
+
+	claims := make(map[string]any)
+	if err := json.Unmarshal(claimsJSON, &claims); err != nil {
+		return nil, errors.New("malformed token claims")
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok || time.Now().Unix() >= int64(exp) {
+		return nil, errors.New("token expired")
+	}
+
+	return claims, nil
+}
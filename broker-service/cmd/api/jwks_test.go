@@ -0,0 +1,199 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// jwksServer serves a JWKS document for a set of keys that tests can swap out, and
+// counts how often it is fetched
+type jwksServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	keys    map[string]*rsa.PrivateKey
+	down    bool
+	fetches int
+}
+
+func newJWKSServer(t *testing.T, keys map[string]*rsa.PrivateKey) *jwksServer {
+	s := &jwksServer{keys: keys}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.fetches++
+		if s.down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		document := struct {
+			Keys []map[string]string `json:"keys"`
+		}{}
+		for kid, key := range s.keys {
+			document.Keys = append(document.Keys, map[string]string{
+				"kty": "RSA",
+				"kid": kid,
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		json.NewEncoder(w).Encode(document)
+	}))
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func (s *jwksServer) set(keys map[string]*rsa.PrivateKey, down bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = keys
+	s.down = down
+}
+
+func (s *jwksServer) fetchCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fetches
+}
+
+func generateKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// signToken builds an RS256 token with the given header and claims
+func signToken(t *testing.T, key *rsa.PrivateKey, header, claims map[string]any) string {
+	encode := func(v any) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	signed := encode(header) + "." + encode(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestVerifyJWT(t *testing.T) {
+	key := generateKey(t)
+	other := generateKey(t)
+	server := newJWKSServer(t, map[string]*rsa.PrivateKey{"key-1": key})
+	cache := newJWKSCache(server.URL, time.Hour)
+
+	header := map[string]any{"alg": "RS256", "kid": "key-1"}
+	valid := map[string]any{"sub": "42", "exp": time.Now().Add(time.Hour).Unix()}
+	token := signToken(t, key, header, valid)
+	parts := strings.Split(token, ".")
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr string
+	}{
+		{"valid", token, ""},
+		{"expired", signToken(t, key, header, map[string]any{"sub": "42", "exp": time.Now().Add(-time.Minute).Unix()}), "token expired"},
+		{"no expiry", signToken(t, key, header, map[string]any{"sub": "42"}), "token expired"},
+		{"signed with another key", signToken(t, other, header, valid), "invalid token signature"},
+		{"unknown kid", signToken(t, key, map[string]any{"alg": "RS256", "kid": "key-2"}, valid), "unknown signing key: key-2"},
+		{"other algorithm", signToken(t, key, map[string]any{"alg": "HS256", "kid": "key-1"}, valid), "unsupported token algorithm: HS256"},
+		{"no algorithm", base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"key-1"}`)) + "." + parts[1] + ".", "unsupported token algorithm: none"},
+		{"claims changed after signing", parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"1","exp":9999999999}`)) + "." + parts[2], "invalid token signature"},
+		{"too few parts", parts[0] + "." + parts[1], "malformed token"},
+		{"too many parts", token + ".extra", "malformed token"},
+		{"header isn't base64", "!!!." + parts[1] + "." + parts[2], "malformed token header"},
+		{"header isn't json", base64.RawURLEncoding.EncodeToString([]byte("RS256")) + "." + parts[1] + "." + parts[2], "malformed token header"},
+		{"signature isn't base64", parts[0] + "." + parts[1] + ".!!!", "malformed token signature"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := cache.verifyJWT(tt.token)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("verifyJWT() = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("verifyJWT() = %v", err)
+			}
+			if claims["sub"] != "42" {
+				t.Errorf("sub = %v, want 42", claims["sub"])
+			}
+		})
+	}
+
+	// Only the first token fetched the keys. key-2 can't trigger a refetch so soon
+	// after it.
+	if fetches := server.fetchCount(); fetches != 1 {
+		t.Errorf("fetched the JWKS %d times, want 1", fetches)
+	}
+}
+
+func TestJWKSCacheRotation(t *testing.T) {
+	oldKey := generateKey(t)
+	newKey := generateKey(t)
+	server := newJWKSServer(t, map[string]*rsa.PrivateKey{"old": oldKey})
+	cache := newJWKSCache(server.URL, time.Hour)
+
+	claims := map[string]any{"sub": "42", "exp": time.Now().Add(time.Hour).Unix()}
+	oldToken := signToken(t, oldKey, map[string]any{"alg": "RS256", "kid": "old"}, claims)
+	newToken := signToken(t, newKey, map[string]any{"alg": "RS256", "kid": "new"}, claims)
+
+	if _, err := cache.verifyJWT(oldToken); err != nil {
+		t.Fatalf("verifyJWT(old) = %v", err)
+	}
+
+	// The auth service rotates its key. Right after a fetch an unknown kid doesn't
+	// trigger another one.
+	server.set(map[string]*rsa.PrivateKey{"old": oldKey, "new": newKey}, false)
+	if _, err := cache.verifyJWT(newToken); err == nil {
+		t.Fatal("verifyJWT(new) succeeded before the keys were refetched")
+	}
+
+	// Once the refetch interval has passed, the unknown kid fetches the new keys
+	cache.fetchedAt = time.Now().Add(-jwksRefetchInterval - time.Second)
+	if _, err := cache.verifyJWT(newToken); err != nil {
+		t.Fatalf("verifyJWT(new) after the refetch interval = %v", err)
+	}
+	if fetches := server.fetchCount(); fetches != 2 {
+		t.Errorf("fetched the JWKS %d times, want 2", fetches)
+	}
+
+	// When the cache has gone stale and the auth service is down, cached keys are
+	// still used
+	server.set(nil, true)
+	cache.fetchedAt = time.Now().Add(-2 * time.Hour)
+	if _, err := cache.verifyJWT(newToken); err != nil {
+		t.Fatalf("verifyJWT(new) while the auth service is down = %v", err)
+	}
+
+	// but a kid that was never cached can't be verified
+	unknown := signToken(t, newKey, map[string]any{"alg": "RS256", "kid": "unknown"}, claims)
+	if _, err := cache.verifyJWT(unknown); err == nil {
+		t.Fatal("verifyJWT(unknown) succeeded while the auth service is down")
+	}
+}
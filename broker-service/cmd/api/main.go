@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -15,7 +18,10 @@ const (
 )
 
 type Config struct {
-	router *gin.Engine
+	router  *gin.Engine
+	apiKeys *apiKeyCache
+	jwks    *jwksCache
+	issuer  string
 }
 
 func main() {
@@ -38,7 +44,10 @@ func main() {
 
 	// Create app config
 	app := Config{
-		router: router,
+		router:  router,
+		apiKeys: newAPIKeyCache(apiKeyCacheTTL()),
+		jwks:    newJWKSCache(envOr("JWKS_URL", "http://0.0.0.0:8001/.well-known/jwks.json"), jwksCacheTTL()),
+		issuer:  strings.TrimSuffix(envOr("OIDC_ISSUER", "http://localhost:8001"), "/"),
 	}
 
 	// Define routes
@@ -55,3 +64,34 @@ func main() {
 		log.Fatalf("Failed to listen and serve: %v", err)
 	}
 }
+
+// apiKeyCacheTTL returns how long API key introspection results are cached.
+// Revoked keys keep working for at most this long.
+func apiKeyCacheTTL() time.Duration {
+	ttl, err := time.ParseDuration(os.Getenv("API_KEY_CACHE_TTL"))
+	if err != nil || ttl <= 0 {
+		return time.Minute
+	}
+
+	return ttl
+}
+
+// jwksCacheTTL returns how long the authentication service's signing keys are cached
+// before being fetched again
+func jwksCacheTTL() time.Duration {
+	ttl, err := time.ParseDuration(os.Getenv("JWKS_CACHE_TTL"))
+	if err != nil || ttl <= 0 {
+		return 5 * time.Minute
+	}
+
+	return ttl
+}
+
+// envOr returns the value of the environment variable key, or fallback when it is unset
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+
+	return fallback
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serviceResponse is the envelope every service wraps its responses in
+type serviceResponse struct {
+	Error   bool            `json:"error"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data,omitempty"`
+}
+
+var errUserNotFound = errors.New("user not found")
+
+// callService sends a JSON request to one of the services and decodes its response.
+// A 404 is returned as errUserNotFound so callers can tell it apart from failures.
+func callService(method, url string, body any) (*serviceResponse, error) {
+	var requestBody io.Reader
+	if body != nil {
+		jsonData, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		requestBody = bytes.NewBuffer(jsonData)
+	}
+
+	request, err := http.NewRequest(method, url, requestBody)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return nil, errUserNotFound
+	}
+
+	var result serviceResponse
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusOK || result.Error {
+		return nil, fmt.Errorf("%s %s: %s", method, url, result.Message)
+	}
+
+	return &result, nil
+}
+
+// authorizePrivacyRequest checks that the caller may export or erase the data of the
+// user in the id parameter: API keys need the users scope, and users logged in with an
+// access token may only act on themselves
+func (app *Config) authorizePrivacyRequest(c *gin.Context) (int, bool) {
+	userID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		app.errorJSON(c, errors.New("invalid id parameter"))
+		return 0, false
+	}
+
+	if value, ok := c.Get(apiKeyContextKey); ok {
+		if !value.(*APIKeyInfo).hasScope("users") {
+			app.errorJSON(c, errors.New("api key is not allowed to perform this action"), http.StatusForbidden)
+			return 0, false
+		}
+		return userID, true
+	}
+
+	if value, ok := c.Get(tokenClaimsContextKey); ok {
+		if value.(map[string]any)["sub"] != strconv.Itoa(userID) {
+			app.errorJSON(c, errors.New("you may only access your own data"), http.StatusForbidden)
+			return 0, false
+		}
+		return userID, true
+	}
+
+	c.Header("WWW-Authenticate", "Bearer")
+	app.errorJSON(c, errors.New("authentication required"), http.StatusUnauthorized)
+	return 0, false
+}
+
+// userLogTerms are the ways log entries refer to a user
+func userLogTerms(userID int, email string) []string {
+	terms := []string{fmt.Sprintf("user %d", userID)}
+	if email != "" {
+		terms = append(terms, email)
+	}
+	return terms
+}
+
+// userEmail reads the email out of the authentication service's export
+func userEmail(authData json.RawMessage) string {
+	var export struct {
+		User struct {
+			Email string `json:"email"`
+		} `json:"user"`
+	}
+	_ = json.Unmarshal(authData, &export)
+
+	return export.User.Email
+}
+
+// ExportUserData collects everything held about a user across the services into a zip
+// archive: their account and profile, their orders and loyalty history, and the log
+// entries that mention them
+func (app *Config) ExportUserData(c *gin.Context) {
+	userID, ok := app.authorizePrivacyRequest(c)
+	if !ok {
+		return
+	}
+
+	auth, err := callService("GET", fmt.Sprintf("http://0.0.0.0:8001/users/%d/data-export", userID), nil)
+	if errors.Is(err, errUserNotFound) {
+		app.errorJSON(c, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadGateway)
+		return
+	}
+
+	orders, err := callService("GET", fmt.Sprintf("http://0.0.0.0:8004/orders/customer/%d/data-export", userID), nil)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadGateway)
+		return
+	}
+
+	query := url.Values{"term": userLogTerms(userID, userEmail(auth.Data))}
+	logs, err := callService("GET", "http://0.0.0.0:8005/logs/search?"+query.Encode(), nil)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadGateway)
+		return
+	}
+
+	exportedAt := time.Now().UTC()
+	manifest, _ := json.Marshal(gin.H{
+		"user_id":     userID,
+		"exported_at": exportedAt,
+		"files": gin.H{
+			"auth.json":   "account, profile, addresses, sessions, login history and api keys",
+			"orders.json": "orders with their items, and loyalty points history",
+			"logs.json":   "log entries mentioning the user",
+		},
+	})
+
+	var archive bytes.Buffer
+	zipWriter := zip.NewWriter(&archive)
+
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"manifest.json", manifest},
+		{"auth.json", auth.Data},
+		{"orders.json", orders.Data},
+		{"logs.json", logs.Data},
+	}
+	for _, file := range files {
+		header := &zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: exportedAt}
+		w, err := zipWriter.CreateHeader(header)
+		if err == nil {
+			_, err = w.Write(file.data)
+		}
+		if err != nil {
+			app.errorJSON(c, err, http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := zipWriter.Close(); err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("user-%d-data-%s.zip", userID, exportedAt.Format("20060102"))
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Data(http.StatusOK, "application/zip", archive.Bytes())
+}
+
+// erasureStep is one service's part of erasing a user's personal data. Every step can
+// be run again safely: running it a second time finds nothing left to erase.
+type erasureStep struct {
+	name string
+	run  func() (*serviceResponse, error)
+}
+
+// EraseUserData erases a user's personal data across the services. Orders are kept for
+// the accounts; they only refer to the user by id. The authentication service goes last,
+// since the email it holds is needed to find the user's log entries.
+//
+// The services can't share a transaction, so if a step fails the earlier steps stay
+// done. The response names the steps that completed and the one that failed; as every
+// step is safe to repeat, the whole request can simply be retried.
+func (app *Config) EraseUserData(c *gin.Context) {
+	userID, ok := app.authorizePrivacyRequest(c)
+	if !ok {
+		return
+	}
+
+	auth, err := callService("GET", fmt.Sprintf("http://0.0.0.0:8001/users/%d/data-export", userID), nil)
+	if errors.Is(err, errUserNotFound) {
+		app.errorJSON(c, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadGateway)
+		return
+	}
+
+	redact := struct {
+		Terms       []string `json:"terms"`
+		Replacement string   `json:"replacement"`
+	}{
+		Terms:       userLogTerms(userID, userEmail(auth.Data)),
+		Replacement: "[redacted]",
+	}
+
+	steps := []erasureStep{
+		{"orders", func() (*serviceResponse, error) {
+			return callService("POST", fmt.Sprintf("http://0.0.0.0:8004/orders/customer/%d/erase", userID), nil)
+		}},
+		{"logs", func() (*serviceResponse, error) {
+			return callService("POST", "http://0.0.0.0:8005/logs/redact", redact)
+		}},
+		{"auth", func() (*serviceResponse, error) {
+			return callService("POST", fmt.Sprintf("http://0.0.0.0:8001/users/%d/erase", userID), nil)
+		}},
+	}
+
+	completed := []string{}
+	summary := gin.H{}
+	for _, step := range steps {
+		result, err := step.run()
+		if err != nil {
+			payload := jsonResponse{
+				Error: true,
+				Message: fmt.Sprintf("Erasing personal data of user %d failed at the %s step, retry the request to finish it: %v",
+					userID, step.name, err),
+				Data: gin.H{
+					"completed": completed,
+					"failed":    step.name,
+					"summary":   summary,
+				},
+			}
+			app.writeJSON(c, http.StatusBadGateway, payload)
+			return
+		}
+
+		completed = append(completed, step.name)
+		if len(result.Data) > 0 {
+			summary[step.name] = result.Data
+		} else {
+			summary[step.name] = gin.H{"erased": true}
+		}
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("Personal data of user %d erased", userID),
+		Data: gin.H{
+			"completed": completed,
+			"summary":   summary,
+		},
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
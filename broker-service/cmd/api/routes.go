@@ -14,5 +14,8 @@ func (app *Config) routes() {
 	})
 
 	app.router.POST("/", app.Broker)
-	app.router.POST("/handle", app.HandleSubmission)
+	app.router.POST("/handle", app.authenticateRequest(), app.HandleSubmission)
+
+	app.router.GET("/users/:id/data-export", app.authenticateRequest(), app.ExportUserData)
+	app.router.POST("/users/:id/erase", app.authenticateRequest(), app.EraseUserData)
 }
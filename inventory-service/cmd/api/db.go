@@ -3,13 +3,44 @@ package main
 import (
 	"errors"
 	"time"
+
+	"github.com/lib/pq"
 )
 
+const inventoryItemColumns = `id, item_name, quantity, unit, threshold, allergens, nutrition, created_at, updated_at`
+
+// scanInventoryItem reads a row of inventoryItemColumns
+func scanInventoryItem(row interface{ Scan(...any) error }) (InventoryItem, error) {
+	var item InventoryItem
+	var nutrition []byte
+	err := row.Scan(
+		&item.ID,
+		&item.ItemName,
+		&item.Quantity,
+		&item.Unit,
+		&item.Threshold,
+		pq.Array(&item.Allergens),
+		&nutrition,
+		&item.CreatedAt,
+		&item.UpdatedAt,
+	)
+	if err != nil {
+		return InventoryItem{}, err
+	}
+
+	item.Nutrition, err = scanNutrition(nutrition)
+	if err != nil {
+		return InventoryItem{}, err
+	}
+
+	return item, nil
+}
+
 // getAllInventoryItems retrieves all inventory items from the database
 func (app *Config) getAllInventoryItems() ([]InventoryItem, error) {
 	var items []InventoryItem
 
-	query := `select id, item_name, quantity, unit, threshold, created_at, updated_at from inventory_items order by item_name`
+	query := `select ` + inventoryItemColumns + ` from inventory_items order by item_name`
 
 	rows, err := app.DB.Query(query)
 	if err != nil {
@@ -18,16 +49,7 @@ func (app *Config) getAllInventoryItems() ([]InventoryItem, error) {
 	defer rows.Close()
 
 	for rows.Next() {
-		var item InventoryItem
-		err := rows.Scan(
-			&item.ID,
-			&item.ItemName,
-			&item.Quantity,
-			&item.Unit,
-			&item.Threshold,
-			&item.CreatedAt,
-			&item.UpdatedAt,
-		)
+		item, err := scanInventoryItem(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -40,21 +62,9 @@ func (app *Config) getAllInventoryItems() ([]InventoryItem, error) {
 
 // getInventoryItemByID retrieves an inventory item by its ID
 func (app *Config) getInventoryItemByID(id int) (InventoryItem, error) {
-	var item InventoryItem
-
-	query := `select id, item_name, quantity, unit, threshold, created_at, updated_at from inventory_items where id = $1`
-
-	row := app.DB.QueryRow(query, id)
-	err := row.Scan(
-		&item.ID,
-		&item.ItemName,
-		&item.Quantity,
-		&item.Unit,
-		&item.Threshold,
-		&item.CreatedAt,
-		&item.UpdatedAt,
-	)
+	query := `select ` + inventoryItemColumns + ` from inventory_items where id = $1`
 
+	item, err := scanInventoryItem(app.DB.QueryRow(query, id))
 	if err != nil {
 		return InventoryItem{}, err
 	}
@@ -68,8 +78,8 @@ func (app *Config) insertInventoryItem(item InventoryItem) (int, error) {
 	now := time.Now()
 
 	var newID int
-	stmt := `insert into inventory_items (item_name, quantity, unit, threshold, created_at, updated_at)
-		values ($1, $2, $3, $4, $5, $6) returning id`
+	stmt := `insert into inventory_items (item_name, quantity, unit, threshold, allergens, nutrition, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
 
 	err := app.DB.QueryRow(
 		stmt,
@@ -77,6 +87,8 @@ func (app *Config) insertInventoryItem(item InventoryItem) (int, error) {
 		item.Quantity,
 		item.Unit,
 		item.Threshold,
+		pq.Array(item.Allergens),
+		nutritionValue(item.Nutrition),
 		now,
 		now,
 	).Scan(&newID)
@@ -88,8 +100,10 @@ func (app *Config) insertInventoryItem(item InventoryItem) (int, error) {
 	return newID, nil
 }
 
-// updateInventoryItem updates an existing inventory item
-func (app *Config) updateInventoryItem(item InventoryItem) error {
+// updateInventoryItem updates an existing inventory item. Its allergens and nutrition
+// are only changed when setAllergens and setNutrition say so, so that callers which
+// don't know about them leave them alone.
+func (app *Config) updateInventoryItem(item InventoryItem, setAllergens, setNutrition bool) error {
 	// Check if the item exists
 	_, err := app.getInventoryItemByID(item.ID)
 	if err != nil {
@@ -104,8 +118,10 @@ func (app *Config) updateInventoryItem(item InventoryItem) error {
 		quantity = $2,
 		unit = $3,
 		threshold = $4,
-		updated_at = $5
-		where id = $6`
+		allergens = case when $5 then $6 else allergens end,
+		nutrition = case when $7 then $8::jsonb else nutrition end,
+		updated_at = $9
+		where id = $10`
 
 	_, err = app.DB.Exec(
 		stmt,
@@ -113,6 +129,10 @@ func (app *Config) updateInventoryItem(item InventoryItem) error {
 		item.Quantity,
 		item.Unit,
 		item.Threshold,
+		setAllergens,
+		pq.Array(item.Allergens),
+		setNutrition,
+		nutritionValue(item.Nutrition),
 		now,
 		item.ID,
 	)
@@ -184,7 +204,7 @@ func (app *Config) adjustInventoryQuantity(id int, adjustment int) error {
 func (app *Config) getLowInventoryItems() ([]InventoryItem, error) {
 	var items []InventoryItem
 
-	query := `select id, item_name, quantity, unit, threshold, created_at, updated_at
+	query := `select ` + inventoryItemColumns + `
 		from inventory_items
 		where quantity <= threshold
 		order by item_name`
@@ -196,16 +216,7 @@ func (app *Config) getLowInventoryItems() ([]InventoryItem, error) {
 	defer rows.Close()
 
 	for rows.Next() {
-		var item InventoryItem
-		err := rows.Scan(
-			&item.ID,
-			&item.ItemName,
-			&item.Quantity,
-			&item.Unit,
-			&item.Threshold,
-			&item.CreatedAt,
-			&item.UpdatedAt,
-		)
+		item, err := scanInventoryItem(rows)
 		if err != nil {
 			return nil, err
 		}
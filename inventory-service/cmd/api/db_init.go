@@ -24,6 +24,16 @@ func initDB(db *sql.DB) error {
 		return err
 	}
 
+	// Ingredient data used by the menu service for allergens and nutrition
+	ingredientQuery := `
+	ALTER TABLE inventory_items ADD COLUMN IF NOT EXISTS allergens TEXT[] NOT NULL DEFAULT '{}';
+	ALTER TABLE inventory_items ADD COLUMN IF NOT EXISTS nutrition JSONB;`
+
+	_, err = db.Exec(ingredientQuery)
+	if err != nil {
+		return err
+	}
+
 	log.Println("Inventory service database tables initialized")
 	return nil
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"net/http"
 	"strconv"
@@ -69,6 +70,12 @@ func (app *Config) CreateInventoryItem(c *gin.Context) {
 		return
 	}
 
+	err = validateIngredientData(&item)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
 	// Create the inventory item
 	newID, err := app.insertInventoryItem(item)
 	if err != nil {
@@ -76,6 +83,8 @@ func (app *Config) CreateInventoryItem(c *gin.Context) {
 		return
 	}
 
+	app.notifyInventoryChanged("inventory.created", newID)
+
 	// Get the newly created item
 	newItem, err := app.getInventoryItemByID(newID)
 	if err != nil {
@@ -103,23 +112,51 @@ func (app *Config) UpdateInventoryItem(c *gin.Context) {
 		return
 	}
 
-	var item InventoryItem
-	err = app.readJSON(c, &item)
+	// Allergens and nutrition are kept as they are when the request leaves them out. An
+	// empty list clears the allergens and a null clears the nutrition.
+	var request struct {
+		InventoryItem
+		Allergens *[]string       `json:"allergens"`
+		Nutrition json.RawMessage `json:"nutrition"`
+	}
+	err = app.readJSON(c, &request)
 	if err != nil {
 		app.errorJSON(c, err, http.StatusBadRequest)
 		return
 	}
 
+	item := request.InventoryItem
+	setAllergens := request.Allergens != nil
+	if setAllergens {
+		item.Allergens = *request.Allergens
+	}
+	setNutrition := request.Nutrition != nil
+	if setNutrition {
+		item.Nutrition, err = scanNutrition(request.Nutrition)
+		if err != nil {
+			app.errorJSON(c, errors.New("invalid nutrition"), http.StatusBadRequest)
+			return
+		}
+	}
+
 	// Make sure ID matches
 	item.ID = id
 
+	err = validateIngredientData(&item)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
 	// Update the inventory item
-	err = app.updateInventoryItem(item)
+	err = app.updateInventoryItem(item, setAllergens, setNutrition)
 	if err != nil {
 		app.errorJSON(c, err, http.StatusInternalServerError)
 		return
 	}
 
+	app.notifyInventoryChanged("inventory.updated", id)
+
 	// Get the updated item
 	updatedItem, err := app.getInventoryItemByID(id)
 	if err != nil {
@@ -153,6 +190,8 @@ func (app *Config) DeleteInventoryItem(c *gin.Context) {
 		return
 	}
 
+	app.notifyInventoryChanged("inventory.deleted", id)
+
 	payload := struct {
 		Error   bool   `json:"error"`
 		Message string `json:"message"`
@@ -188,6 +227,8 @@ func (app *Config) AdjustInventory(c *gin.Context) {
 		return
 	}
 
+	app.notifyInventoryChanged("inventory.adjusted", id)
+
 	// Get the updated item
 	updatedItem, err := app.getInventoryItemByID(id)
 	if err != nil {
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// majorAllergens are the 14 allergens that must be declared on food. The menu service
+// keeps the same list to work out the allergens in menu items from their recipes.
+var majorAllergens = []string{
+	"celery",
+	"gluten",
+	"crustaceans",
+	"eggs",
+	"fish",
+	"lupin",
+	"milk",
+	"molluscs",
+	"mustard",
+	"tree-nuts",
+	"peanuts",
+	"sesame",
+	"soya",
+	"sulphites",
+}
+
+// Nutrition is the nutrition facts of an ingredient per 100 g for items counted by
+// mass, per 100 ml for items counted by volume, and per item otherwise. Energy is in
+// kcal and everything else in grams.
+type Nutrition struct {
+	Energy       float64 `json:"energy_kcal"`
+	Fat          float64 `json:"fat_g"`
+	SaturatedFat float64 `json:"saturated_fat_g"`
+	Carbohydrate float64 `json:"carbohydrate_g"`
+	Sugars       float64 `json:"sugars_g"`
+	Fibre        float64 `json:"fibre_g"`
+	Protein      float64 `json:"protein_g"`
+	Salt         float64 `json:"salt_g"`
+}
+
+// validateIngredientData normalizes an item's allergens and checks its nutrition facts
+func validateIngredientData(item *InventoryItem) error {
+	found := make(map[string]bool, len(item.Allergens))
+	for _, name := range item.Allergens {
+		name = strings.ToLower(strings.Join(strings.Fields(name), "-"))
+		if name == "" {
+			continue
+		}
+		known := false
+		for _, allergen := range majorAllergens {
+			known = known || allergen == name
+		}
+		if !known {
+			return fmt.Errorf("unknown allergen: %s (expected one of %s)", name, strings.Join(majorAllergens, ", "))
+		}
+		found[name] = true
+	}
+
+	item.Allergens = []string{}
+	for _, allergen := range majorAllergens {
+		if found[allergen] {
+			item.Allergens = append(item.Allergens, allergen)
+		}
+	}
+
+	if n := item.Nutrition; n != nil {
+		for _, value := range []float64{n.Energy, n.Fat, n.SaturatedFat, n.Carbohydrate, n.Sugars, n.Fibre, n.Protein, n.Salt} {
+			if value < 0 {
+				return errors.New("nutrition values can't be negative")
+			}
+		}
+	}
+
+	return nil
+}
+
+// nutritionValue turns nutrition facts into a value for the JSONB nutrition column
+func nutritionValue(n *Nutrition) any {
+	if n == nil {
+		return nil
+	}
+	data, _ := json.Marshal(n)
+	return string(data)
+}
+
+// scanNutrition reads the nutrition column, or nutrition facts sent in a request
+func scanNutrition(data []byte) (*Nutrition, error) {
+	if len(data) == 0 || string(data) == "null" {
+		return nil, nil
+	}
+
+	var n Nutrition
+	if err := json.Unmarshal(data, &n); err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
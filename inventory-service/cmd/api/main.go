@@ -21,6 +21,8 @@ var counts int64
 type Config struct {
 	DB     *sql.DB
 	router *gin.Engine
+	// WebhookURLs are notified whenever inventory changes
+	WebhookURLs []string
 }
 
 type InventoryItem struct {
@@ -31,6 +33,10 @@ type InventoryItem struct {
 	Threshold int       `json:"threshold"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	// Allergens and Nutrition are used by the menu service to work out the allergens
+	// and nutrition of menu items made with this item
+	Allergens []string   `json:"allergens"`
+	Nutrition *Nutrition `json:"nutrition"`
 }
 
 func main() {
@@ -44,7 +50,8 @@ func main() {
 
 	// Set up application config
 	app := Config{
-		DB: conn,
+		DB:          conn,
+		WebhookURLs: webhookURLs(),
 	}
 
 	// Set up Gin router with middleware
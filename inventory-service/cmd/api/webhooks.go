@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaultWebhookURLs are notified of inventory changes when INVENTORY_WEBHOOK_URLS isn't
+// set. The menu service uses them to mark items sold out when stock runs out.
+var defaultWebhookURLs = []string{"http://0.0.0.0:8002/menu/inventory-changed"}
+
+// webhookURLs reads the comma separated INVENTORY_WEBHOOK_URLS environment variable
+func webhookURLs() []string {
+	value, ok := os.LookupEnv("INVENTORY_WEBHOOK_URLS")
+	if !ok {
+		return defaultWebhookURLs
+	}
+
+	var urls []string
+	for _, url := range strings.Split(value, ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			urls = append(urls, url)
+		}
+	}
+
+	return urls
+}
+
+// notifyInventoryChanged tells the webhook subscribers that an inventory item changed.
+// It doesn't wait for them, and failures are only logged.
+func (app *Config) notifyInventoryChanged(event string, itemID int) {
+	if len(app.WebhookURLs) == 0 {
+		return
+	}
+
+	jsonData, _ := json.Marshal(map[string]any{
+		"event":             event,
+		"inventory_item_id": itemID,
+		"occurred_at":       time.Now(),
+	})
+
+	for _, url := range app.WebhookURLs {
+		go func(url string) {
+			client := &http.Client{Timeout: 10 * time.Second}
+			response, err := client.Post(url, "application/json", bytes.NewBuffer(jsonData))
+			if err != nil {
+				log.Printf("Error calling inventory webhook %s: %v", url, err)
+				return
+			}
+			defer response.Body.Close()
+
+			if response.StatusCode >= 300 {
+				log.Printf("Inventory webhook %s returned status %d", url, response.StatusCode)
+			}
+		}(url)
+	}
+}
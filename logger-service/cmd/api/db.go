@@ -3,6 +3,8 @@ package main
 import (
   "fmt"
   "log"
+  "regexp"
+  "strings"
   "sync"
   "time"
 )
@@ -103,3 +105,87 @@ func (l *LogEntryModel) DropCollection() error {
   
   return nil
 }
+
+// termsPattern builds a case insensitive pattern matching any of terms as whole words,
+// so that searching for "User 5" doesn't match "User 50"
+func termsPattern(terms []string) (*regexp.Regexp, error) {
+  quoted := make([]string, 0, len(terms))
+  for _, term := range terms {
+    if term = strings.TrimSpace(term); term != "" {
+      quoted = append(quoted, regexp.QuoteMeta(term))
+    }
+  }
+  if len(quoted) == 0 {
+    return nil, fmt.Errorf("at least one search term is required")
+  }
+
+  return regexp.Compile(`(?i)(^|\W)(` + strings.Join(quoted, "|") + `)(\W|$)`)
+}
+
+// replaceTerms replaces every term matched by a termsPattern pattern with replacement,
+// keeping the characters around it. ReplaceAllString would skip a term that shares its
+// separator with the one before, as in "bob bob"
+func replaceTerms(pattern *regexp.Regexp, s, replacement string) string {
+  var b strings.Builder
+  for {
+    match := pattern.FindStringSubmatchIndex(s)
+    if match == nil {
+      break
+    }
+    b.WriteString(s[:match[4]])
+    b.WriteString(replacement)
+    s = s[match[5]:]
+  }
+  b.WriteString(s)
+
+  return b.String()
+}
+
+// Search returns the log entries whose name or data mention any of terms, newest first
+func (l *LogEntryModel) Search(terms []string) ([]LogEntry, error) {
+  pattern, err := termsPattern(terms)
+  if err != nil {
+    return nil, err
+  }
+
+  l.mu.Lock()
+  defer l.mu.Unlock()
+
+  matches := make([]LogEntry, 0)
+  for i := len(l.Logs) - 1; i >= 0; i-- {
+    entry := l.Logs[i]
+    if pattern.MatchString(entry.Name) || pattern.MatchString(entry.Data) {
+      matches = append(matches, entry)
+    }
+  }
+
+  return matches, nil
+}
+
+// Redact replaces every mention of terms in log entries with replacement and returns
+// the number of entries changed
+func (l *LogEntryModel) Redact(terms []string, replacement string) (int, error) {
+  pattern, err := termsPattern(terms)
+  if err != nil {
+    return 0, err
+  }
+
+  l.mu.Lock()
+  defer l.mu.Unlock()
+
+  changed := 0
+  for i := range l.Logs {
+    entry := &l.Logs[i]
+    name := replaceTerms(pattern, entry.Name, replacement)
+    data := replaceTerms(pattern, entry.Data, replacement)
+
+    if name != entry.Name || data != entry.Data {
+      entry.Name = name
+      entry.Data = data
+      entry.UpdatedAt = time.Now()
+      changed++
+    }
+  }
+
+  return changed, nil
+}
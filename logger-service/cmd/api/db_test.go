@@ -0,0 +1,97 @@
+package main
+
+import "testing"
+
+func TestTermsPattern(t *testing.T) {
+  tests := []struct {
+    name  string
+    terms []string
+    text  string
+    want  bool
+  }{
+    {"whole word", []string{"User 5"}, "order placed by User 5", true},
+    {"longer number", []string{"User 5"}, "order placed by User 50", false},
+    {"inside a word", []string{"bob"}, "bobby logged in", false},
+    {"ignores case", []string{"Bob"}, "BOB logged in", true},
+    {"next to punctuation", []string{"bob"}, `{"user":"bob"}`, true},
+    {"email address", []string{"bob@example.com"}, "sent to bob@example.com.", true},
+    {"dots are literal", []string{"bob@example.com"}, "sent to bob@exampleXcom", false},
+    {"any of the terms", []string{"alice", "bob"}, "bob logged in", true},
+    {"blank terms are skipped", []string{" ", "bob"}, "bob logged in", true},
+    {"terms are trimmed", []string{" bob "}, "bob logged in", true},
+    {"no mention", []string{"alice"}, "bob logged in", false},
+  }
+
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      pattern, err := termsPattern(tt.terms)
+      if err != nil {
+        t.Fatalf("termsPattern(%q) = %v", tt.terms, err)
+      }
+      if got := pattern.MatchString(tt.text); got != tt.want {
+        t.Errorf("termsPattern(%q) matching %q = %v, want %v", tt.terms, tt.text, got, tt.want)
+      }
+    })
+  }
+}
+
+func TestTermsPatternNoTerms(t *testing.T) {
+  for _, terms := range [][]string{nil, {}, {"", "  "}} {
+    if _, err := termsPattern(terms); err == nil {
+      t.Errorf("termsPattern(%q) succeeded, want an error", terms)
+    }
+  }
+}
+
+func TestReplaceTerms(t *testing.T) {
+  tests := []struct {
+    name  string
+    terms []string
+    text  string
+    want  string
+  }{
+    {"whole word", []string{"User 5"}, "User 5 and User 50", "[redacted] and User 50"},
+    {"keeps the separators", []string{"bob"}, `{"user":"bob"}`, `{"user":"[redacted]"}`},
+    {"terms sharing a separator", []string{"bob"}, "bob bob bob, bob", "[redacted] [redacted] [redacted], [redacted]"},
+    {"several terms", []string{"alice", "bob@example.com"}, "alice <bob@example.com>", "[redacted] <[redacted]>"},
+    {"no mention", []string{"alice"}, "bob logged in", "bob logged in"},
+  }
+
+  for _, tt := range tests {
+    t.Run(tt.name, func(t *testing.T) {
+      pattern, err := termsPattern(tt.terms)
+      if err != nil {
+        t.Fatalf("termsPattern(%q) = %v", tt.terms, err)
+      }
+      if got := replaceTerms(pattern, tt.text, "[redacted]"); got != tt.want {
+        t.Errorf("replaceTerms(%q) = %q, want %q", tt.text, got, tt.want)
+      }
+    })
+  }
+}
+
+func TestRedact(t *testing.T) {
+  model := LogEntryModel{}
+  model.Insert(LogEntry{Name: "login", Data: "bob@example.com logged in"})
+  model.Insert(LogEntry{Name: "login", Data: "alice logged in"})
+  model.Insert(LogEntry{Name: "bob", Data: "$5 paid"})
+
+  changed, err := model.Redact([]string{"bob@example.com", "bob"}, "$1")
+  if err != nil {
+    t.Fatalf("Redact() = %v", err)
+  }
+  if changed != 2 {
+    t.Errorf("Redact() changed %d entries, want 2", changed)
+  }
+
+  want := []LogEntry{
+    {Name: "login", Data: "$1 logged in"},
+    {Name: "login", Data: "alice logged in"},
+    {Name: "$1", Data: "$5 paid"},
+  }
+  for i, entry := range model.Logs {
+    if entry.Name != want[i].Name || entry.Data != want[i].Data {
+      t.Errorf("entry %d = %q, %q, want %q, %q", i, entry.Name, entry.Data, want[i].Name, want[i].Data)
+    }
+  }
+}
@@ -65,3 +65,61 @@ func (app *Config) GetAllLogs(c *gin.Context) {
 
 	app.writeJSON(c, http.StatusOK, payload)
 }
+
+// SearchLogs returns the log entries mentioning any of the "term" query parameters
+func (app *Config) SearchLogs(c *gin.Context) {
+	logs, err := app.Models.LogEntry.Search(c.QueryArray("term"))
+	if err != nil {
+		app.errorJSON(c, err)
+		return
+	}
+
+	payload := struct {
+		Error   bool       `json:"error"`
+		Message string     `json:"message"`
+		Data    []LogEntry `json:"data"`
+	}{
+		Error:   false,
+		Message: "Logs retrieved",
+		Data:    logs,
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+// RedactLogs replaces mentions of the given terms, such as a user's email address, in
+// every log entry
+func (app *Config) RedactLogs(c *gin.Context) {
+	var requestPayload struct {
+		Terms       []string `json:"terms"`
+		Replacement string   `json:"replacement"`
+	}
+
+	err := app.readJSON(c, &requestPayload)
+	if err != nil {
+		app.errorJSON(c, err)
+		return
+	}
+
+	if requestPayload.Replacement == "" {
+		requestPayload.Replacement = "[redacted]"
+	}
+
+	changed, err := app.Models.LogEntry.Redact(requestPayload.Terms, requestPayload.Replacement)
+	if err != nil {
+		app.errorJSON(c, err)
+		return
+	}
+
+	payload := struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+		Data    any    `json:"data"`
+	}{
+		Error:   false,
+		Message: "Logs redacted",
+		Data:    gin.H{"redacted": changed},
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
@@ -3,4 +3,6 @@ package main
 func (app *Config) setupRoutes() {
 	app.router.POST("/log", app.WriteLog)
 	app.router.GET("/logs", app.GetAllLogs)
+	app.router.GET("/logs/search", app.SearchLogs)
+	app.router.POST("/logs/redact", app.RedactLogs)
 }
\ No newline at end of file
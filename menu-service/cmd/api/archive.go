@@ -0,0 +1,207 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const orderServiceURL = "http://0.0.0.0:8004"
+
+var (
+	errNotArchived             = errors.New("only archived menu items can be purged")
+	errItemReferenced          = errors.New("menu item is referenced by orders and can't be purged")
+	errOrderServiceUnavailable = errors.New("unable to reach order service")
+)
+
+// archiveMenuItem takes a menu item off the menu. Archived items can still be looked
+// up by id, so orders that include them keep making sense.
+func (app *Config) archiveMenuItem(id int, actor string) error {
+	return app.versionedChange(id, changeArchived, actor, true,
+		`update menu_items set archived_at = coalesce(archived_at, now()) where id = $1`, id)
+}
+
+// unarchiveMenuItem puts an archived menu item back on the menu
+func (app *Config) unarchiveMenuItem(id int, actor string) error {
+	return app.versionedChange(id, changeUnarchived, actor, false, `update menu_items set archived_at = null where id = $1`, id)
+}
+
+// getArchivedMenuItems returns archived menu items, most recently archived first
+func (app *Config) getArchivedMenuItems() ([]MenuItem, error) {
+	rows, err := app.DB.Query(menuItemSelect + ` where m.archived_at is not null order by m.archived_at desc, m.id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []MenuItem{}
+	for rows.Next() {
+		item, err := scanMenuItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = app.attachOptionGroups(items)
+	if err != nil {
+		return nil, err
+	}
+
+	return items, nil
+}
+
+// orderReferences asks the order service how many orders include a menu item
+func (app *Config) orderReferences(id int) (int, error) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	response, err := client.Get(fmt.Sprintf("%s/orders/menu-items/%d/references", orderServiceURL, id))
+	if err != nil {
+		return 0, fmt.Errorf("%w: %v", errOrderServiceUnavailable, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("%w: status %d", errOrderServiceUnavailable, response.StatusCode)
+	}
+
+	var result struct {
+		Data struct {
+			OrderCount int `json:"order_count"`
+		} `json:"data"`
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&result)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.Data.OrderCount, nil
+}
+
+// purgeMenuItem deletes an archived menu item for good, along with its history and
+// images. It refuses while any order includes the item, or if the order service can't
+// be asked.
+func (app *Config) purgeMenuItem(item MenuItem) error {
+	if item.ArchivedAt == nil {
+		return errNotArchived
+	}
+
+	count, err := app.orderReferences(item.ID)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("%w: it is in %d orders", errItemReferenced, count)
+	}
+
+	_, err = app.DB.Exec(`delete from menu_items where id = $1 and archived_at is not null`, item.ID)
+	if err != nil {
+		return err
+	}
+
+	return app.Images.Delete(fmt.Sprintf("menu-items/%d", item.ID))
+}
+
+func (app *Config) GetArchivedMenuItems(c *gin.Context) {
+	items, err := app.getArchivedMenuItems()
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool       `json:"error"`
+		Message string     `json:"message"`
+		Data    []MenuItem `json:"data"`
+	}{
+		Error:   false,
+		Message: "Archived menu items retrieved",
+		Data:    items,
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+// RestoreMenuItem puts an archived menu item back on the menu
+func (app *Config) RestoreMenuItem(c *gin.Context) {
+	item, ok := app.menuItemFromParam(c)
+	if !ok {
+		return
+	}
+
+	if item.ArchivedAt == nil {
+		app.errorJSON(c, errors.New("menu item is not archived"), http.StatusConflict)
+		return
+	}
+
+	err := app.unarchiveMenuItem(item.ID, requestActor(c))
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	restoredItem, err := app.getMenuItemByID(item.ID)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool     `json:"error"`
+		Message string   `json:"message"`
+		Data    MenuItem `json:"data"`
+	}{
+		Error:   false,
+		Message: "Menu item restored",
+		Data:    restoredItem,
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+// PurgeMenuItem deletes an archived menu item that no order refers to
+func (app *Config) PurgeMenuItem(c *gin.Context) {
+	item, ok := app.menuItemFromParam(c)
+	if !ok {
+		return
+	}
+
+	err := app.purgeMenuItem(item)
+	switch {
+	case errors.Is(err, errNotArchived), errors.Is(err, errItemReferenced):
+		app.errorJSON(c, err, http.StatusConflict)
+		return
+	case errors.Is(err, errOrderServiceUnavailable):
+		app.errorJSON(c, err, http.StatusServiceUnavailable)
+		return
+	case err != nil:
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}{
+		Error:   false,
+		Message: "Menu item purged",
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+// archivedAt reads the archived_at column
+func archivedAt(value sql.NullString) *string {
+	if !value.Valid {
+		return nil
+	}
+	return &value.String
+}
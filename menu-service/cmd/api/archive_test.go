@@ -0,0 +1,28 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+)
+
+func TestArchivedAt(t *testing.T) {
+	if got := archivedAt(sql.NullString{}); got != nil {
+		t.Errorf("archivedAt(NULL) = %q, want nil", *got)
+	}
+
+	got := archivedAt(sql.NullString{String: "2024-03-01T12:00:00Z", Valid: true})
+	if got == nil || *got != "2024-03-01T12:00:00Z" {
+		t.Errorf("archivedAt() = %v, want the timestamp", got)
+	}
+}
+
+func TestPurgeMenuItemNotArchived(t *testing.T) {
+	// Live items are refused before the order service or database is asked anything
+	app := &Config{}
+
+	err := app.purgeMenuItem(MenuItem{ID: 7, Name: "Flat white"})
+	if !errors.Is(err, errNotArchived) {
+		t.Errorf("purgeMenuItem() = %v, want %v", err, errNotArchived)
+	}
+}
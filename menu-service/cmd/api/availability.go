@@ -0,0 +1,196 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Availability states of a menu item
+const (
+	availabilityAvailable = "available"
+	availabilitySoldOut   = "sold_out"
+	availabilityHidden    = "hidden"
+)
+
+var validAvailability = map[string]bool{
+	availabilityAvailable: true,
+	availabilitySoldOut:   true,
+	availabilityHidden:    true,
+}
+
+// menuItemAvailability works out an item's availability from the state set by staff
+// and whether there is stock to make it. Hidden wins over sold out.
+const menuItemAvailability = `case
+		when m.availability = 'hidden' then 'hidden'
+		when m.availability = 'sold_out' or not m.in_stock then 'sold_out'
+		else 'available'
+	end`
+
+// recomputeAvailability marks menu items and options in or out of stock from their
+// recipes and the current inventory. An item is out of stock when its recipe can't be
+// made, or when a required option group doesn't have enough options in stock. It
+// returns the number of items and options that changed. The allergens and nutrition
+// worked out from recipes are refreshed at the same time, as they come from the same
+// inventory data.
+func (app *Config) recomputeAvailability() (int, error) {
+	inventory, err := app.getInventory()
+	if err != nil {
+		return 0, err
+	}
+
+	recipes, err := app.loadRecipes(``)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	changed := 0
+	exec := func(query string, args ...any) error {
+		result, err := tx.Exec(query, args...)
+		if err != nil {
+			return err
+		}
+		n, _ := result.RowsAffected()
+		changed += int(n)
+		return nil
+	}
+
+	// Anything without a recipe is never limited by stock
+	err = exec(`update menu_items set in_stock = true where not in_stock
+		and id not in (select menu_item_id from recipe_ingredients where option_id is null)`)
+	if err != nil {
+		return 0, err
+	}
+	err = exec(`update menu_options set in_stock = true where not in_stock
+		and id not in (select option_id from recipe_ingredients where option_id is not null)`)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, recipe := range recipes {
+		if len(recipe.Ingredients) > 0 {
+			n, _ := canMake(recipe.Ingredients, inventory)
+			err = exec(`update menu_items set in_stock = $1 where id = $2 and in_stock <> $1`, n > 0, recipe.MenuItemID)
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		// An option is only in stock if the item can be made with it
+		for _, option := range recipe.Options {
+			n, _ := canMake(recipe.recipeIngredients([]int{option.OptionID}), inventory)
+			err = exec(`update menu_options set in_stock = $1 where id = $2 and in_stock <> $1`, n > 0, option.OptionID)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	err = exec(`update menu_items m set in_stock = false where m.in_stock and exists (
+		select 1 from menu_option_groups g
+		where g.menu_item_id = m.id and g.min_select > (
+			select count(*) from menu_options o where o.group_id = g.id and o.in_stock))`)
+	if err != nil {
+		return 0, err
+	}
+
+	err = deriveDietaryInfo(tx, recipes, inventory)
+	if err != nil {
+		return 0, err
+	}
+
+	return changed, tx.Commit()
+}
+
+// refreshAvailability recomputes availability after a change that affects it, such as
+// a new recipe. Failures are only logged, as the next inventory change will fix them.
+func (app *Config) refreshAvailability() {
+	if _, err := app.recomputeAvailability(); err != nil {
+		log.Printf("Error recomputing menu availability: %v", err)
+	}
+}
+
+// SetAvailability sets the availability state of a menu item, such as marking it sold
+// out before the inventory catches up, or hiding it from the menu
+func (app *Config) SetAvailability(c *gin.Context) {
+	item, ok := app.menuItemFromParam(c)
+	if !ok {
+		return
+	}
+
+	var requestPayload struct {
+		Availability string `json:"availability"`
+	}
+
+	err := app.readJSON(c, &requestPayload)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	if !validAvailability[requestPayload.Availability] {
+		app.errorJSON(c, fmt.Errorf("availability must be %s, %s or %s",
+			availabilityAvailable, availabilitySoldOut, availabilityHidden), http.StatusBadRequest)
+		return
+	}
+
+	err = app.versionedChange(item.ID, changeAvailability, requestActor(c), false,
+		`update menu_items set availability = $1 where id = $2`, requestPayload.Availability, item.ID)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	updatedItem, err := app.getMenuItemByID(item.ID)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool     `json:"error"`
+		Message string   `json:"message"`
+		Data    MenuItem `json:"data"`
+	}{
+		Error:   false,
+		Message: "Menu item availability updated",
+		Data:    updatedItem,
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+// InventoryChanged is the webhook the inventory service calls whenever stock changes.
+// It recomputes which items and options are in stock.
+func (app *Config) InventoryChanged(c *gin.Context) {
+	changed, err := app.recomputeAvailability()
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, errInventoryUnavailable) {
+			status = http.StatusServiceUnavailable
+		}
+		app.errorJSON(c, err, status)
+		return
+	}
+
+	payload := struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+		Data    any    `json:"data"`
+	}{
+		Error:   false,
+		Message: "Menu availability recomputed",
+		Data:    gin.H{"changed": changed},
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
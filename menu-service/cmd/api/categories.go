@@ -0,0 +1,705 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Category groups menu items. Categories can be nested by giving them a parent.
+type Category struct {
+	ID           int    `json:"id"`
+	Name         string `json:"name"`
+	Slug         string `json:"slug"`
+	Description  string `json:"description"`
+	DisplayOrder int    `json:"display_order"`
+	ParentID     *int   `json:"parent_id"`
+	Active       *bool  `json:"active,omitempty"`
+	CreatedAt    string `json:"created_at"`
+	UpdatedAt    string `json:"updated_at"`
+}
+
+// CategoryNode is a category with its items and subcategories, as returned by the
+// grouped menu
+type CategoryNode struct {
+	Category
+	Items    []MenuItem      `json:"items"`
+	Children []*CategoryNode `json:"children"`
+}
+
+var (
+	errCategoryNotFound = errors.New("category not found")
+	errCategoryInUse    = errors.New("category is still in use")
+)
+
+// categoryAliases maps category names that mean the same thing onto one name, so that
+// free text categories like "Beverages" end up in the same category as "Drinks"
+var categoryAliases = map[string]string{
+	"beverage":   "drinks",
+	"beverages":  "drinks",
+	"drink":      "drinks",
+	"dessert":    "desserts",
+	"sweets":     "desserts",
+	"starter":    "starters",
+	"appetizer":  "starters",
+	"appetizers": "starters",
+	"main":       "mains",
+	"entree":     "mains",
+	"entrees":    "mains",
+	"side":       "sides",
+}
+
+// slugify turns a category name into the lowercase, hyphenated form used in URLs
+func slugify(name string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(name) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			hyphen = false
+		} else {
+			hyphen = true
+		}
+	}
+
+	return b.String()
+}
+
+// canonicalCategoryName tidies a free text category name: whitespace is collapsed,
+// known aliases are replaced and all lowercase names are capitalized
+func canonicalCategoryName(name string) string {
+	name = strings.Join(strings.Fields(name), " ")
+	if name == "" {
+		return "Uncategorized"
+	}
+
+	if alias, ok := categoryAliases[strings.ToLower(name)]; ok {
+		name = alias
+	}
+
+	if name == strings.ToLower(name) {
+		words := strings.Fields(name)
+		for i, word := range words {
+			runes := []rune(word)
+			runes[0] = unicode.ToUpper(runes[0])
+			words[i] = string(runes)
+		}
+		name = strings.Join(words, " ")
+	}
+
+	return name
+}
+
+// nullableID stores an unset id as NULL
+func nullableID(id int) any {
+	if id == 0 {
+		return nil
+	}
+	return id
+}
+
+// queryRower is satisfied by both *sql.DB and *sql.Tx
+type queryRower interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// findOrCreateCategory returns the category a free text category name belongs to,
+// creating it if there is none yet
+func findOrCreateCategory(db queryRower, name string) (Category, error) {
+	name, slug := categoryNameAndSlug(name)
+
+	// The no-op update makes returning work when the slug already exists
+	var category Category
+	err := db.QueryRow(`insert into menu_categories (name, slug) values ($1, $2)
+		on conflict (slug) do update set slug = excluded.slug
+		returning id, name, slug`, name, slug).Scan(&category.ID, &category.Name, &category.Slug)
+	if err != nil {
+		return Category{}, err
+	}
+
+	return category, nil
+}
+
+// categoryNameAndSlug returns the name and slug a free text category is filed under
+func categoryNameAndSlug(name string) (string, string) {
+	name = canonicalCategoryName(name)
+	slug := slugify(name)
+	if slug == "" {
+		slug = "uncategorized"
+	}
+
+	return name, slug
+}
+
+// migrateCategories moves menu items that only have a free text category onto a
+// category, so "Drinks", "drinks" and "Beverages" all share one
+func migrateCategories(db *sql.DB) error {
+	rows, err := db.Query(`select distinct category from menu_items where category_id is null`)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	if len(names) == 0 {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, name := range names {
+		category, err := findOrCreateCategory(tx, name)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`update menu_items set category_id = $1, category = $2 where category_id is null and category = $3`,
+			category.ID, category.Name, name)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// resolveItemCategory points a menu item at its category. A category id takes
+// precedence; otherwise the free text category is matched to a category, creating one
+// if needed, so that older clients keep working.
+func (app *Config) resolveItemCategory(item *MenuItem) error {
+	if item.CategoryID != 0 {
+		category, err := app.getCategoryByID(item.CategoryID)
+		if err != nil {
+			return err
+		}
+		item.Category = category.Name
+		return nil
+	}
+
+	if strings.TrimSpace(item.Category) == "" {
+		return errors.New("category_id or category is required")
+	}
+
+	category, err := findOrCreateCategory(app.DB, item.Category)
+	if err != nil {
+		return err
+	}
+	item.CategoryID = category.ID
+	item.Category = category.Name
+
+	return nil
+}
+
+// matchItemCategory points a drafted menu item at its category like resolveItemCategory,
+// but without creating one. A free text category that doesn't exist yet is created when
+// the draft is published, so discarded drafts don't leave empty categories behind.
+func (app *Config) matchItemCategory(item *MenuItem) error {
+	if item.CategoryID != 0 {
+		return app.resolveItemCategory(item)
+	}
+
+	if strings.TrimSpace(item.Category) == "" {
+		return errors.New("category_id or category is required")
+	}
+
+	name, slug := categoryNameAndSlug(item.Category)
+	err := app.DB.QueryRow(`select id, name from menu_categories where slug = $1`, slug).Scan(&item.CategoryID, &item.Category)
+	if errors.Is(err, sql.ErrNoRows) {
+		item.CategoryID = 0
+		item.Category = name
+		return nil
+	}
+
+	return err
+}
+
+const categoryColumns = `id, name, slug, description, display_order, parent_id, active, created_at, updated_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanCategory(row rowScanner) (Category, error) {
+	var category Category
+	var parentID sql.NullInt64
+	var active bool
+
+	err := row.Scan(
+		&category.ID,
+		&category.Name,
+		&category.Slug,
+		&category.Description,
+		&category.DisplayOrder,
+		&parentID,
+		&active,
+		&category.CreatedAt,
+		&category.UpdatedAt,
+	)
+	if err != nil {
+		return Category{}, err
+	}
+
+	if parentID.Valid {
+		id := int(parentID.Int64)
+		category.ParentID = &id
+	}
+	category.Active = &active
+
+	return category, nil
+}
+
+// getAllCategories returns every category in display order
+func (app *Config) getAllCategories() ([]Category, error) {
+	rows, err := app.DB.Query(`select ` + categoryColumns + ` from menu_categories order by display_order, name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := []Category{}
+	for rows.Next() {
+		category, err := scanCategory(rows)
+		if err != nil {
+			return nil, err
+		}
+		categories = append(categories, category)
+	}
+
+	return categories, rows.Err()
+}
+
+// getCategoryByID retrieves a category by its ID
+func (app *Config) getCategoryByID(id int) (Category, error) {
+	row := app.DB.QueryRow(`select `+categoryColumns+` from menu_categories where id = $1`, id)
+
+	category, err := scanCategory(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Category{}, errCategoryNotFound
+	}
+
+	return category, err
+}
+
+// validateCategory checks a category before it is saved
+func (app *Config) validateCategory(category *Category) error {
+	category.Name = strings.Join(strings.Fields(category.Name), " ")
+	if category.Name == "" {
+		return errors.New("name is required")
+	}
+
+	if category.Slug == "" {
+		category.Slug = slugify(category.Name)
+	}
+	if category.Slug != slugify(category.Slug) {
+		return errors.New("slug may only contain lowercase letters, digits and hyphens")
+	}
+
+	var taken bool
+	err := app.DB.QueryRow(`select exists (select 1 from menu_categories where slug = $1 and id <> $2)`,
+		category.Slug, category.ID).Scan(&taken)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return errors.New("slug is already used by another category")
+	}
+
+	if category.ParentID == nil {
+		return nil
+	}
+
+	// Walk up from the new parent to make sure the category isn't being put under itself
+	parentID := *category.ParentID
+	for depth := 0; ; depth++ {
+		if category.ID != 0 && parentID == category.ID {
+			return errors.New("a category cannot be its own ancestor")
+		}
+		if depth > 100 {
+			return errors.New("category tree is too deep")
+		}
+
+		parent, err := app.getCategoryByID(parentID)
+		if errors.Is(err, errCategoryNotFound) {
+			return errors.New("parent category not found")
+		}
+		if err != nil {
+			return err
+		}
+
+		if parent.ParentID == nil {
+			return nil
+		}
+		parentID = *parent.ParentID
+	}
+}
+
+// insertCategory adds a new category to the database
+func (app *Config) insertCategory(category Category) (int, error) {
+	now := time.Now().Format(time.RFC3339)
+
+	var newID int
+	stmt := `insert into menu_categories (name, slug, description, display_order, parent_id, active, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
+
+	err := app.DB.QueryRow(
+		stmt,
+		category.Name,
+		category.Slug,
+		category.Description,
+		category.DisplayOrder,
+		category.ParentID,
+		category.Active == nil || *category.Active,
+		now,
+		now,
+	).Scan(&newID)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// updateCategory updates an existing category and the category name copied onto its
+// menu items
+func (app *Config) updateCategory(category Category) error {
+	now := time.Now().Format(time.RFC3339)
+
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `update menu_categories set
+		name = $1,
+		slug = $2,
+		description = $3,
+		display_order = $4,
+		parent_id = $5,
+		active = $6,
+		updated_at = $7
+		where id = $8`
+
+	_, err = tx.Exec(
+		stmt,
+		category.Name,
+		category.Slug,
+		category.Description,
+		category.DisplayOrder,
+		category.ParentID,
+		category.Active == nil || *category.Active,
+		now,
+		category.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`update menu_items set category = $1 where category_id = $2`, category.Name, category.ID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// categoryUses are the things that stop a category being deleted. Price overrides and
+// combo slots would otherwise be deleted along with it, silently changing prices and
+// what goes in a combo, and drafts would be published into a category that is gone.
+var categoryUses = []struct {
+	query string
+	what  string
+}{
+	{`select exists (select 1 from menu_items where category_id = $1)`, "menu items"},
+	{`select exists (select 1 from menu_categories where parent_id = $1)`, "subcategories"},
+	{`select exists (select 1 from price_overrides where category_id = $1)`, "price overrides"},
+	{`select exists (select 1 from combo_slots where category_id = $1)`, "combo slots"},
+	{`select exists (select 1 from menu_item_drafts where (data->>'category_id')::int = $1)`, "draft changes"},
+}
+
+// deleteCategory removes a category that nothing uses any more
+func (app *Config) deleteCategory(id int) error {
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the category, so nothing can start using it between the checks and the delete
+	_, err = tx.Exec(`select id from menu_categories where id = $1 for update`, id)
+	if err != nil {
+		return err
+	}
+
+	for _, use := range categoryUses {
+		var inUse bool
+		if err := tx.QueryRow(use.query, id).Scan(&inUse); err != nil {
+			return err
+		}
+		if inUse {
+			return fmt.Errorf("%w: it still has %s", errCategoryInUse, use.what)
+		}
+	}
+
+	_, err = tx.Exec(`delete from menu_categories where id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// buildCategoryTree nests the active categories and puts each item under its category.
+// Subcategories of an inactive category are left out along with it.
+func buildCategoryTree(categories []Category, items []MenuItem) []*CategoryNode {
+	nodes := make(map[int]*CategoryNode, len(categories))
+	for _, category := range categories {
+		if category.Active != nil && !*category.Active {
+			continue
+		}
+		nodes[category.ID] = &CategoryNode{Category: category, Items: []MenuItem{}, Children: []*CategoryNode{}}
+	}
+
+	for _, item := range items {
+		if node, ok := nodes[item.CategoryID]; ok {
+			node.Items = append(node.Items, item)
+		}
+	}
+
+	// categories are in display order, so appending keeps the children in order too
+	tree := []*CategoryNode{}
+	for _, category := range categories {
+		node, ok := nodes[category.ID]
+		if !ok {
+			continue
+		}
+
+		if category.ParentID == nil {
+			tree = append(tree, node)
+		} else if parent, ok := nodes[*category.ParentID]; ok {
+			parent.Children = append(parent.Children, node)
+		}
+	}
+
+	return tree
+}
+
+func (app *Config) GetAllCategories(c *gin.Context) {
+	categories, err := app.getAllCategories()
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	c.Header("Vary", "Accept-Language")
+	err = app.localizeCategories(categories, requestLocales(c))
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool       `json:"error"`
+		Message string     `json:"message"`
+		Data    []Category `json:"data"`
+	}{
+		Error:   false,
+		Message: "Categories retrieved",
+		Data:    categories,
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+func (app *Config) GetCategory(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		app.errorJSON(c, errors.New("invalid id parameter"), http.StatusBadRequest)
+		return
+	}
+
+	category, err := app.getCategoryByID(id)
+	if errors.Is(err, errCategoryNotFound) {
+		app.errorJSON(c, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	c.Header("Vary", "Accept-Language")
+	categories := []Category{category}
+	err = app.localizeCategories(categories, requestLocales(c))
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+	category = categories[0]
+
+	payload := struct {
+		Error   bool     `json:"error"`
+		Message string   `json:"message"`
+		Data    Category `json:"data"`
+	}{
+		Error:   false,
+		Message: "Category retrieved",
+		Data:    category,
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+func (app *Config) CreateCategory(c *gin.Context) {
+	var category Category
+
+	err := app.readJSON(c, &category)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+	category.ID = 0
+
+	err = app.validateCategory(&category)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	newID, err := app.insertCategory(category)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	newCategory, err := app.getCategoryByID(newID)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool     `json:"error"`
+		Message string   `json:"message"`
+		Data    Category `json:"data"`
+	}{
+		Error:   false,
+		Message: "Category created",
+		Data:    newCategory,
+	}
+
+	app.writeJSON(c, http.StatusCreated, payload)
+}
+
+func (app *Config) UpdateCategory(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		app.errorJSON(c, errors.New("invalid id parameter"), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := app.getCategoryByID(id); err != nil {
+		app.errorJSON(c, err, http.StatusNotFound)
+		return
+	}
+
+	var category Category
+	err = app.readJSON(c, &category)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	// Make sure ID matches
+	category.ID = id
+
+	err = app.validateCategory(&category)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.updateCategory(category)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	updatedCategory, err := app.getCategoryByID(id)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool     `json:"error"`
+		Message string   `json:"message"`
+		Data    Category `json:"data"`
+	}{
+		Error:   false,
+		Message: "Category updated",
+		Data:    updatedCategory,
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+func (app *Config) DeleteCategory(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		app.errorJSON(c, errors.New("invalid id parameter"), http.StatusBadRequest)
+		return
+	}
+
+	if _, err := app.getCategoryByID(id); err != nil {
+		app.errorJSON(c, err, http.StatusNotFound)
+		return
+	}
+
+	err = app.deleteCategory(id)
+	if errors.Is(err, errCategoryInUse) {
+		app.errorJSON(c, err, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}{
+		Error:   false,
+		Message: "Category deleted",
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
@@ -0,0 +1,78 @@
+package main
+
+import "testing"
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Drinks", "drinks"},
+		{"Hot Drinks", "hot-drinks"},
+		{"  Hot   Drinks  ", "hot-drinks"},
+		{"Fish & Chips", "fish-chips"},
+		{"Kids' Menu", "kids-menu"},
+		{"--Sides--", "sides"},
+		{"Top 10", "top-10"},
+		{"Crêpes", "crêpes"},
+		{"", ""},
+		{"&!", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := slugify(tt.name); got != tt.want {
+				t.Errorf("slugify(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCanonicalCategoryName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Drinks", "Drinks"},
+		{"drinks", "Drinks"},
+		{"  hot   drinks ", "Hot Drinks"},
+		{"Beverages", "Drinks"},
+		{"BEVERAGE", "Drinks"},
+		{"appetizer", "Starters"},
+		{"Entrees", "Mains"},
+		{"BBQ", "BBQ"},
+		{"iPhone specials", "iPhone specials"},
+		{"", "Uncategorized"},
+		{"   ", "Uncategorized"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := canonicalCategoryName(tt.name); got != tt.want {
+				t.Errorf("canonicalCategoryName(%q) = %q, want %q", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCategoryNameAndSlug(t *testing.T) {
+	tests := []struct {
+		name     string
+		wantName string
+		wantSlug string
+	}{
+		{"hot drinks", "Hot Drinks", "hot-drinks"},
+		{"Beverages", "Drinks", "drinks"},
+		{"", "Uncategorized", "uncategorized"},
+		{"&!", "&!", "uncategorized"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name, slug := categoryNameAndSlug(tt.name)
+			if name != tt.wantName || slug != tt.wantSlug {
+				t.Errorf("categoryNameAndSlug(%q) = %q, %q, want %q, %q", tt.name, name, slug, tt.wantName, tt.wantSlug)
+			}
+		})
+	}
+}
@@ -0,0 +1,598 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Combo pricing rules. A fixed combo costs Price; the others take Discount off the
+// total of the chosen items, as a percentage or an amount. Chosen options are always
+// charged on top.
+const (
+	comboPricingFixed      = "fixed"
+	comboPricingPercentOff = "percent_off"
+	comboPricingAmountOff  = "amount_off"
+)
+
+// Combo is a bundle of menu items sold together, such as a sandwich, drink and cookie,
+// made up of slots that are each filled with one item
+type Combo struct {
+	ID          int         `json:"id"`
+	Name        string      `json:"name"`
+	Description string      `json:"description"`
+	Pricing     string      `json:"pricing"`
+	Price       float64     `json:"price"`
+	Discount    float64     `json:"discount"`
+	Active      *bool       `json:"active,omitempty"`
+	Slots       []ComboSlot `json:"slots"`
+	CreatedAt   string      `json:"created_at"`
+	UpdatedAt   string      `json:"updated_at"`
+}
+
+// ComboSlot is a place in a combo filled by one menu item, chosen from a category (and
+// its subcategories) or from a list of items
+type ComboSlot struct {
+	ID           int    `json:"id"`
+	Name         string `json:"name"`
+	CategoryID   *int   `json:"category_id"`
+	ItemIDs      []int  `json:"item_ids"`
+	Optional     bool   `json:"optional"`
+	DisplayOrder int    `json:"display_order"`
+}
+
+// ComboSelection is the item, and its options, chosen for a slot
+type ComboSelection struct {
+	SlotID     int   `json:"slot_id"`
+	MenuItemID int   `json:"menu_item_id"`
+	OptionIDs  []int `json:"option_ids"`
+}
+
+// ComboComponent is an item chosen for a combo. AllocatedPrice is its share of the
+// bundle price; the shares add up to the bundle price exactly.
+type ComboComponent struct {
+	SlotID int    `json:"slot_id"`
+	Slot   string `json:"slot"`
+	PriceQuote
+	AllocatedPrice float64 `json:"allocated_price"`
+}
+
+// ComboQuote is the price of a combo with a choice of items
+type ComboQuote struct {
+	ComboID        int              `json:"combo_id"`
+	Name           string           `json:"name"`
+	Components     []ComboComponent `json:"components"`
+	ComponentTotal float64          `json:"component_total"`
+	UnitPrice      float64          `json:"unit_price"`
+	Savings        float64          `json:"savings"`
+}
+
+var errComboNotFound = errors.New("combo not found")
+
+// validateCombo checks a combo before it is saved
+func (app *Config) validateCombo(combo *Combo) error {
+	combo.Name = strings.TrimSpace(combo.Name)
+	if combo.Name == "" {
+		return errors.New("name is required")
+	}
+
+	switch combo.Pricing {
+	case comboPricingFixed:
+		if combo.Price <= 0 {
+			return errors.New("fixed price combos need a price above zero")
+		}
+	case comboPricingPercentOff:
+		if combo.Discount <= 0 || combo.Discount > 100 {
+			return errors.New("discount must be greater than 0 and at most 100")
+		}
+	case comboPricingAmountOff:
+		if combo.Discount <= 0 {
+			return errors.New("discount must be above zero")
+		}
+	default:
+		return fmt.Errorf("pricing must be %s, %s or %s", comboPricingFixed, comboPricingPercentOff, comboPricingAmountOff)
+	}
+
+	if len(combo.Slots) == 0 {
+		return errors.New("combos need at least one slot")
+	}
+
+	required := 0
+	for i := range combo.Slots {
+		slot := &combo.Slots[i]
+		slot.Name = strings.TrimSpace(slot.Name)
+		if slot.Name == "" {
+			return errors.New("combo slots must have a name")
+		}
+		if (slot.CategoryID == nil) == (len(slot.ItemIDs) == 0) {
+			return fmt.Errorf("slot %s needs either a category_id or item_ids", slot.Name)
+		}
+		if !slot.Optional {
+			required++
+		}
+
+		if slot.CategoryID != nil {
+			if _, err := app.getCategoryByID(*slot.CategoryID); err != nil {
+				return fmt.Errorf("slot %s: %w", slot.Name, err)
+			}
+			continue
+		}
+
+		slot.ItemIDs = uniqueInts(slot.ItemIDs)
+		var found int
+		err := app.DB.QueryRow(`select count(*) from menu_items where id = any($1) and archived_at is null`,
+			pq.Array(slot.ItemIDs)).Scan(&found)
+		if err != nil {
+			return err
+		}
+		if found != len(slot.ItemIDs) {
+			return fmt.Errorf("slot %s lists menu items that don't exist", slot.Name)
+		}
+	}
+	if required == 0 {
+		return errors.New("combos need at least one slot that isn't optional")
+	}
+
+	return nil
+}
+
+// getCombos returns combos with their slots, optionally only active ones
+func (app *Config) getCombos(activeOnly bool) ([]Combo, error) {
+	query := `select id, name, description, pricing, price, discount, active, created_at, updated_at from combos`
+	if activeOnly {
+		query += ` where active`
+	}
+
+	rows, err := app.DB.Query(query + ` order by name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	combos := []Combo{}
+	index := make(map[int]int)
+	for rows.Next() {
+		var combo Combo
+		var active bool
+		err := rows.Scan(&combo.ID, &combo.Name, &combo.Description, &combo.Pricing, &combo.Price, &combo.Discount,
+			&active, &combo.CreatedAt, &combo.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		combo.Active = &active
+		combo.Slots = []ComboSlot{}
+		index[combo.ID] = len(combos)
+		combos = append(combos, combo)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	slotRows, err := app.DB.Query(`select id, combo_id, name, category_id, item_ids, optional, display_order
+		from combo_slots order by combo_id, display_order, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer slotRows.Close()
+
+	for slotRows.Next() {
+		var slot ComboSlot
+		var comboID int
+		var categoryID sql.NullInt64
+		var itemIDs pq.Int64Array
+		err := slotRows.Scan(&slot.ID, &comboID, &slot.Name, &categoryID, &itemIDs, &slot.Optional, &slot.DisplayOrder)
+		if err != nil {
+			return nil, err
+		}
+
+		if categoryID.Valid {
+			id := int(categoryID.Int64)
+			slot.CategoryID = &id
+		}
+		slot.ItemIDs = make([]int, len(itemIDs))
+		for i, id := range itemIDs {
+			slot.ItemIDs[i] = int(id)
+		}
+
+		if i, ok := index[comboID]; ok {
+			combos[i].Slots = append(combos[i].Slots, slot)
+		}
+	}
+
+	return combos, slotRows.Err()
+}
+
+// getCombo returns a combo by id
+func (app *Config) getCombo(id int) (Combo, error) {
+	combos, err := app.getCombos(false)
+	if err != nil {
+		return Combo{}, err
+	}
+
+	for _, combo := range combos {
+		if combo.ID == id {
+			return combo, nil
+		}
+	}
+
+	return Combo{}, errComboNotFound
+}
+
+// saveCombo inserts or updates a combo and replaces its slots
+func (app *Config) saveCombo(combo Combo) (int, error) {
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().Format(time.RFC3339)
+	active := combo.Active == nil || *combo.Active
+
+	if combo.ID == 0 {
+		err = tx.QueryRow(`insert into combos (name, description, pricing, price, discount, active, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`,
+			combo.Name, combo.Description, combo.Pricing, combo.Price, combo.Discount, active, now, now).Scan(&combo.ID)
+	} else {
+		_, err = tx.Exec(`update combos set name = $1, description = $2, pricing = $3, price = $4, discount = $5,
+			active = $6, updated_at = $7 where id = $8`,
+			combo.Name, combo.Description, combo.Pricing, combo.Price, combo.Discount, active, now, combo.ID)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`delete from combo_slots where combo_id = $1`, combo.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, slot := range combo.Slots {
+		itemIDs := make([]int64, len(slot.ItemIDs))
+		for i, id := range slot.ItemIDs {
+			itemIDs[i] = int64(id)
+		}
+
+		_, err = tx.Exec(`insert into combo_slots (combo_id, name, category_id, item_ids, optional, display_order)
+			values ($1, $2, $3, $4, $5, $6)`,
+			combo.ID, slot.Name, slot.CategoryID, pq.Array(itemIDs), slot.Optional, slot.DisplayOrder)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return combo.ID, tx.Commit()
+}
+
+// slotAllows reports whether an item can fill a slot
+func (app *Config) slotAllows(slot ComboSlot, itemID int) (bool, error) {
+	for _, id := range slot.ItemIDs {
+		if id == itemID {
+			return true, nil
+		}
+	}
+	if slot.CategoryID == nil {
+		return false, nil
+	}
+
+	var allowed bool
+	err := app.DB.QueryRow(`select exists (
+		with recursive tree as (
+			select id from menu_categories where id = $1
+			union all
+			select c.id from menu_categories c join tree t on c.parent_id = t.id
+		)
+		select 1 from menu_items where id = $2 and category_id in (select id from tree))`,
+		*slot.CategoryID, itemID).Scan(&allowed)
+	return allowed, err
+}
+
+// bundlePrice applies a combo's pricing rule to the total of its items' base prices
+func (combo Combo) bundlePrice(componentTotal float64) float64 {
+	var price float64
+	switch combo.Pricing {
+	case comboPricingFixed:
+		price = combo.Price
+	case comboPricingPercentOff:
+		price = componentTotal * (100 - combo.Discount) / 100
+	case comboPricingAmountOff:
+		price = componentTotal - combo.Discount
+	}
+
+	return math.Max(math.Round(price*100)/100, 0)
+}
+
+// allocatePrice splits a bundle price across its components in proportion to their own
+// prices, in whole cents, so each order item carries a fair share for reporting
+func allocatePrice(components []ComboComponent, total float64) {
+	if len(components) == 0 {
+		return
+	}
+	cents := int(math.Round(total * 100))
+
+	var weight float64
+	for _, component := range components {
+		weight += component.UnitPrice
+	}
+
+	shares := make([]int, len(components))
+	allocated, largest := 0, 0
+	for i, component := range components {
+		shares[i] = cents / len(components)
+		if weight > 0 {
+			shares[i] = int(math.Floor(float64(cents) * component.UnitPrice / weight))
+		}
+		allocated += shares[i]
+
+		if component.UnitPrice > components[largest].UnitPrice {
+			largest = i
+		}
+	}
+
+	// Rounding down leaves a few cents over, which go on the dearest item
+	shares[largest] += cents - allocated
+
+	for i := range components {
+		components[i].AllocatedPrice = float64(shares[i]) / 100
+	}
+}
+
+// priceCombo works out the price of a combo with a choice of items at a given time.
+// Every slot that isn't optional must be filled, each item must be allowed in its slot,
+// and the items are checked and priced as they would be on their own.
+func (app *Config) priceCombo(comboID int, selections []ComboSelection, at time.Time) (ComboQuote, error) {
+	combo, err := app.getCombo(comboID)
+	if err != nil {
+		return ComboQuote{}, err
+	}
+	if combo.Active != nil && !*combo.Active {
+		return ComboQuote{}, fmt.Errorf("%w: %s is not on the menu", errItemUnavailable, combo.Name)
+	}
+
+	chosen := make(map[int]ComboSelection, len(selections))
+	for _, selection := range selections {
+		if _, ok := chosen[selection.SlotID]; ok {
+			return ComboQuote{}, fmt.Errorf("%w: slot %d was filled more than once", errInvalidSelection, selection.SlotID)
+		}
+		chosen[selection.SlotID] = selection
+	}
+
+	quote := ComboQuote{ComboID: combo.ID, Name: combo.Name, Components: []ComboComponent{}}
+	var optionTotal float64
+	for _, slot := range combo.Slots {
+		selection, ok := chosen[slot.ID]
+		if !ok {
+			if slot.Optional {
+				continue
+			}
+			return ComboQuote{}, fmt.Errorf("%w: choose an item for %s", errInvalidSelection, slot.Name)
+		}
+		delete(chosen, slot.ID)
+
+		allowed, err := app.slotAllows(slot, selection.MenuItemID)
+		if err != nil {
+			return ComboQuote{}, err
+		}
+		if !allowed {
+			return ComboQuote{}, fmt.Errorf("%w: menu item %d can't be chosen for %s", errInvalidSelection, selection.MenuItemID, slot.Name)
+		}
+
+		itemQuote, err := app.priceSelection(selection.MenuItemID, selection.OptionIDs, at)
+		if errors.Is(err, errMenuItemNotFound) {
+			return ComboQuote{}, fmt.Errorf("%w: menu item %d not found", errInvalidSelection, selection.MenuItemID)
+		}
+		if err != nil {
+			return ComboQuote{}, err
+		}
+
+		quote.Components = append(quote.Components, ComboComponent{SlotID: slot.ID, Slot: slot.Name, PriceQuote: itemQuote})
+		quote.ComponentTotal += itemQuote.BasePrice
+		optionTotal += itemQuote.UnitPrice - itemQuote.BasePrice
+	}
+
+	for slotID := range chosen {
+		return ComboQuote{}, fmt.Errorf("%w: combo has no slot %d", errInvalidSelection, slotID)
+	}
+
+	quote.UnitPrice = math.Round((combo.bundlePrice(quote.ComponentTotal)+optionTotal)*100) / 100
+	quote.Savings = math.Max(math.Round((quote.ComponentTotal+optionTotal-quote.UnitPrice)*100)/100, 0)
+	quote.ComponentTotal = math.Round(quote.ComponentTotal*100) / 100
+	allocatePrice(quote.Components, quote.UnitPrice)
+
+	return quote, nil
+}
+
+func (app *Config) GetCombos(c *gin.Context) {
+	combos, err := app.getCombos(false)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool    `json:"error"`
+		Message string  `json:"message"`
+		Data    []Combo `json:"data"`
+	}{
+		Error:   false,
+		Message: "Combos retrieved",
+		Data:    combos,
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+func (app *Config) GetCombo(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		app.errorJSON(c, errors.New("invalid id parameter"), http.StatusBadRequest)
+		return
+	}
+
+	combo, err := app.getCombo(id)
+	if errors.Is(err, errComboNotFound) {
+		app.errorJSON(c, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+		Data    Combo  `json:"data"`
+	}{
+		Error:   false,
+		Message: "Combo retrieved",
+		Data:    combo,
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+// SaveCombo creates a combo, or replaces one when called with an id parameter. Slots
+// are replaced, so they get new ids.
+func (app *Config) SaveCombo(c *gin.Context) {
+	var combo Combo
+	err := app.readJSON(c, &combo)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	status, message := http.StatusCreated, "Combo created"
+	combo.ID = 0
+	if c.Param("id") != "" {
+		combo.ID, err = strconv.Atoi(c.Param("id"))
+		if err != nil {
+			app.errorJSON(c, errors.New("invalid id parameter"), http.StatusBadRequest)
+			return
+		}
+		if _, err := app.getCombo(combo.ID); err != nil {
+			app.errorJSON(c, err, http.StatusNotFound)
+			return
+		}
+		status, message = http.StatusOK, "Combo updated"
+	}
+
+	err = app.validateCombo(&combo)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	id, err := app.saveCombo(combo)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	saved, err := app.getCombo(id)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+		Data    Combo  `json:"data"`
+	}{
+		Error:   false,
+		Message: message,
+		Data:    saved,
+	}
+
+	app.writeJSON(c, status, payload)
+}
+
+func (app *Config) DeleteCombo(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		app.errorJSON(c, errors.New("invalid id parameter"), http.StatusBadRequest)
+		return
+	}
+
+	result, err := app.DB.Exec(`delete from combos where id = $1`, id)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		app.errorJSON(c, errComboNotFound, http.StatusNotFound)
+		return
+	}
+
+	payload := struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}{
+		Error:   false,
+		Message: "Combo deleted",
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+// PriceCombo returns the price of a combo with the chosen items. The order service uses
+// it to price combos and split them into their items.
+func (app *Config) PriceCombo(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		app.errorJSON(c, errors.New("invalid id parameter"), http.StatusBadRequest)
+		return
+	}
+
+	// At defaults to now
+	var requestPayload struct {
+		Selections []ComboSelection `json:"selections"`
+		At         *time.Time       `json:"at"`
+	}
+
+	err = app.readJSON(c, &requestPayload)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	at := time.Now()
+	if requestPayload.At != nil {
+		at = *requestPayload.At
+	}
+
+	quote, err := app.priceCombo(id, requestPayload.Selections, at)
+	switch {
+	case errors.Is(err, errComboNotFound):
+		app.errorJSON(c, err, http.StatusNotFound)
+		return
+	case errors.Is(err, errInvalidSelection), errors.Is(err, errItemUnavailable):
+		app.errorJSON(c, err, http.StatusUnprocessableEntity)
+		return
+	case err != nil:
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool       `json:"error"`
+		Message string     `json:"message"`
+		Data    ComboQuote `json:"data"`
+	}{
+		Error:   false,
+		Message: "Combo priced",
+		Data:    quote,
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
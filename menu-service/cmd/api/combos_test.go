@@ -0,0 +1,75 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestBundlePrice(t *testing.T) {
+	tests := []struct {
+		name  string
+		combo Combo
+		total float64
+		want  float64
+	}{
+		{"fixed", Combo{Pricing: comboPricingFixed, Price: 8.50}, 11.20, 8.50},
+		{"fixed ignores the items", Combo{Pricing: comboPricingFixed, Price: 8.50}, 5, 8.50},
+		{"percent off", Combo{Pricing: comboPricingPercentOff, Discount: 20}, 11.20, 8.96},
+		{"percent off rounds to cents", Combo{Pricing: comboPricingPercentOff, Discount: 15}, 9.99, 8.49},
+		{"percent off everything", Combo{Pricing: comboPricingPercentOff, Discount: 100}, 11.20, 0},
+		{"amount off", Combo{Pricing: comboPricingAmountOff, Discount: 2}, 11.20, 9.20},
+		{"amount off never goes below zero", Combo{Pricing: comboPricingAmountOff, Discount: 15}, 11.20, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.combo.bundlePrice(tt.total); got != tt.want {
+				t.Errorf("bundlePrice(%v) = %v, want %v", tt.total, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAllocatePrice(t *testing.T) {
+	component := func(price float64) ComboComponent {
+		return ComboComponent{PriceQuote: PriceQuote{UnitPrice: price}}
+	}
+
+	tests := []struct {
+		name   string
+		prices []float64
+		total  float64
+		want   []float64
+	}{
+		{"single item gets everything", []float64{6}, 4.99, []float64{4.99}},
+		{"in proportion to price", []float64{6, 3, 1}, 5, []float64{3, 1.5, 0.5}},
+		{"leftover cents go on the dearest item", []float64{1, 1, 2}, 1, []float64{0.25, 0.25, 0.5}},
+		{"leftover cents from an even split", []float64{2, 2, 2}, 1, []float64{0.34, 0.33, 0.33}},
+		{"free items split evenly", []float64{0, 0}, 3, []float64{1.5, 1.5}},
+		{"free item among paid ones", []float64{0, 4}, 3, []float64{0, 3}},
+		{"free combo", []float64{3, 2}, 0, []float64{0, 0}},
+		{"no items", nil, 5, []float64{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			components := []ComboComponent{}
+			for _, price := range tt.prices {
+				components = append(components, component(price))
+			}
+
+			allocatePrice(components, tt.total)
+
+			var sum float64
+			for i, c := range components {
+				sum += c.AllocatedPrice
+				if c.AllocatedPrice != tt.want[i] {
+					t.Errorf("component %d got %v, want %v", i, c.AllocatedPrice, tt.want[i])
+				}
+			}
+			if len(components) > 0 && math.Round(sum*100) != math.Round(tt.total*100) {
+				t.Errorf("shares add up to %v, want %v", sum, tt.total)
+			}
+		})
+	}
+}
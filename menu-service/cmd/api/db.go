@@ -1,82 +1,157 @@
 package main
 
 import (
-	"errors"
+	"database/sql"
+	"encoding/json"
+	"strings"
 	"time"
-)
-
-// getAllMenuItems retrieves all menu items from the database
-func (app *Config) getAllMenuItems() ([]MenuItem, error) {
-	var items []MenuItem
 
-	query := `select id, name, description, price, category, created_at, updated_at from menu_items order by name`
+	"github.com/lib/pq"
+)
 
-	rows, err := app.DB.Query(query)
+// menuItemSelect selects menu item columns, taking the category name from the item's
+// category rather than the legacy free text column
+const menuItemSelect = `select m.id, m.name, m.description, m.price, coalesce(m.category_id, 0), coalesce(c.name, m.category),
+	` + menuItemAvailability + `, m.availability, m.in_stock, m.dietary_tags,
+	m.allergens, m.recipe_allergens, m.nutrition, m.recipe_nutrition, m.popularity, coalesce(m.sku, ''), m.archived_at,
+	coalesce(m.image_key, ''), coalesce(m.image_format, ''),
+	m.created_at, m.updated_at
+	from menu_items m left join menu_categories c on c.id = m.category_id`
+
+// scanMenuItem reads a row selected with menuItemSelect
+func scanMenuItem(row rowScanner) (MenuItem, error) {
+	var item MenuItem
+	var nutrition, recipeNutrition []byte
+	var archived sql.NullString
+	var imageKey, imageFormat string
+	err := row.Scan(
+		&item.ID,
+		&item.Name,
+		&item.Description,
+		&item.Price,
+		&item.CategoryID,
+		&item.Category,
+		&item.Availability,
+		&item.AvailabilitySetting,
+		&item.InStock,
+		pq.Array(&item.DietaryTags),
+		pq.Array(&item.Allergens),
+		pq.Array(&item.RecipeAllergens),
+		&nutrition,
+		&recipeNutrition,
+		&item.Popularity,
+		&item.SKU,
+		&archived,
+		&imageKey,
+		&imageFormat,
+		&item.CreatedAt,
+		&item.UpdatedAt,
+	)
 	if err != nil {
-		return nil, err
+		return MenuItem{}, err
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var item MenuItem
-		err := rows.Scan(
-			&item.ID,
-			&item.Name,
-			&item.Description,
-			&item.Price,
-			&item.Category,
-			&item.CreatedAt,
-			&item.UpdatedAt,
-		)
-		if err != nil {
-			return nil, err
-		}
 
-		items = append(items, item)
+	for _, n := range []struct {
+		data []byte
+		into **Nutrition
+	}{{nutrition, &item.Nutrition}, {recipeNutrition, &item.RecipeNutrition}} {
+		if len(n.data) > 0 {
+			if err := json.Unmarshal(n.data, n.into); err != nil {
+				return MenuItem{}, err
+			}
+		}
 	}
 
-	return items, nil
+	item.ArchivedAt = archivedAt(archived)
+	item.Image = menuItemImage(imageKey, imageFormat)
+
+	// Both lists only hold major allergens, so this can't fail
+	item.ContainsAllergens, _ = normalizeAllergens(append(append([]string{}, item.Allergens...), item.RecipeAllergens...))
+
+	return item, nil
 }
 
 // getMenuItemByID retrieves a menu item by its ID
 func (app *Config) getMenuItemByID(id int) (MenuItem, error) {
-	var item MenuItem
+	return getMenuItemRow(app.DB, id)
+}
 
-	query := `select id, name, description, price, category, created_at, updated_at from menu_items where id = $1`
+// getMenuItemRow reads a menu item and its option groups using a database or transaction
+func getMenuItemRow(db dbtx, id int) (MenuItem, error) {
+	query := menuItemSelect + ` where m.id = $1`
 
-	row := app.DB.QueryRow(query, id)
-	err := row.Scan(
-		&item.ID,
-		&item.Name,
-		&item.Description,
-		&item.Price,
-		&item.Category,
-		&item.CreatedAt,
-		&item.UpdatedAt,
-	)
+	item, err := scanMenuItem(db.QueryRow(query, id))
+	if err != nil {
+		return MenuItem{}, err
+	}
 
+	items := []MenuItem{item}
+	err = loadOptionGroups(db, items)
 	if err != nil {
 		return MenuItem{}, err
 	}
 
-	return item, nil
+	return items[0], nil
 }
 
-// insertMenuItem adds a new menu item to the database
-func (app *Config) insertMenuItem(item MenuItem) (int, error) {
+// dbtx is satisfied by both *sql.DB and *sql.Tx
+type dbtx interface {
+	queryRower
+	Query(query string, args ...any) (*sql.Rows, error)
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// createMenuItem adds a new menu item with its option groups, and records it as the
+// item's first version
+func (app *Config) createMenuItem(item MenuItem, actor string) (int, error) {
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	newID, err := insertMenuItemRow(tx, item)
+	if err != nil {
+		return 0, err
+	}
+
+	if item.OptionGroups != nil {
+		err = saveOptionGroupsTx(tx, newID, item.OptionGroups)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = recordVersion(tx, newID, changeCreated, actor, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, tx.Commit()
+}
+
+// insertMenuItemRow adds a new menu item using a database or transaction
+func insertMenuItemRow(db dbtx, item MenuItem) (int, error) {
 	// Set timestamp
 	now := time.Now().Format(time.RFC3339)
 
 	var newID int
-	stmt := `insert into menu_items (name, description, price, category, created_at, updated_at)
-		values ($1, $2, $3, $4, $5, $6) returning id`
+	stmt := `insert into menu_items (name, description, price, category, category_id, availability, dietary_tags,
+		allergens, nutrition, sku, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, nullif($10, ''), $11, $12) returning id`
 
-	err := app.DB.QueryRow(
+	err := db.QueryRow(
 		stmt,
 		item.Name,
 		item.Description,
 		item.Price,
 		item.Category,
+		nullableID(item.CategoryID),
+		item.AvailabilitySetting,
+		pq.Array(normalizeTags(item.DietaryTags)),
+		pq.Array(item.Allergens),
+		nutritionJSON(item.Nutrition),
+		strings.TrimSpace(item.SKU),
 		now,
 		now,
 	).Scan(&newID)
@@ -88,14 +163,9 @@ func (app *Config) insertMenuItem(item MenuItem) (int, error) {
 	return newID, nil
 }
 
-// updateMenuItem updates an existing menu item
-func (app *Config) updateMenuItem(item MenuItem) error {
-	// Check if the item exists
-	_, err := app.getMenuItemByID(item.ID)
-	if err != nil {
-		return errors.New("menu item not found")
-	}
-
+// updateMenuItemRow updates a menu item using a database or transaction. An empty SKU
+// leaves the item's SKU as it is.
+func updateMenuItemRow(db dbtx, item MenuItem) error {
 	// Set updated timestamp
 	now := time.Now().Format(time.RFC3339)
 
@@ -104,15 +174,25 @@ func (app *Config) updateMenuItem(item MenuItem) error {
 		description = $2,
 		price = $3,
 		category = $4,
-		updated_at = $5
-		where id = $6`
-
-	_, err = app.DB.Exec(
+		category_id = $5,
+		dietary_tags = $6,
+		allergens = $7,
+		nutrition = $8,
+		sku = coalesce(nullif($9, ''), sku),
+		updated_at = $10
+		where id = $11`
+
+	_, err := db.Exec(
 		stmt,
 		item.Name,
 		item.Description,
 		item.Price,
 		item.Category,
+		nullableID(item.CategoryID),
+		pq.Array(normalizeTags(item.DietaryTags)),
+		pq.Array(item.Allergens),
+		nutritionJSON(item.Nutrition),
+		strings.TrimSpace(item.SKU),
 		now,
 		item.ID,
 	)
@@ -124,20 +204,22 @@ func (app *Config) updateMenuItem(item MenuItem) error {
 	return nil
 }
 
-// deleteMenuItem removes a menu item from the database
-func (app *Config) deleteMenuItem(id int) error {
-	// Check if the item exists
-	_, err := app.getMenuItemByID(id)
+// recordSales adds sold quantities to the popularity of menu items. Refunds are
+// recorded as negative quantities.
+func (app *Config) recordSales(sales []Sale) error {
+	tx, err := app.DB.Begin()
 	if err != nil {
-		return errors.New("menu item not found")
+		return err
 	}
+	defer tx.Rollback()
 
-	stmt := `delete from menu_items where id = $1`
-
-	_, err = app.DB.Exec(stmt, id)
-	if err != nil {
-		return err
+	for _, sale := range sales {
+		_, err = tx.Exec(`update menu_items set popularity = greatest(popularity + $1, 0) where id = $2`,
+			sale.Quantity, sale.MenuItemID)
+		if err != nil {
+			return err
+		}
 	}
 
-	return nil
+	return tx.Commit()
 }
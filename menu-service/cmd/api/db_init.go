@@ -25,6 +25,327 @@ func initDB(db *sql.DB) error {
 		return err
 	}
 
+	// Create menu_categories table if it doesn't exist
+	categoriesQuery := `
+	CREATE TABLE IF NOT EXISTS menu_categories (
+		id SERIAL PRIMARY KEY,
+		name VARCHAR(100) NOT NULL,
+		slug VARCHAR(100) NOT NULL UNIQUE,
+		description TEXT NOT NULL DEFAULT '',
+		display_order INTEGER NOT NULL DEFAULT 0,
+		parent_id INTEGER REFERENCES menu_categories(id),
+		active BOOLEAN NOT NULL DEFAULT TRUE,
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+	);
+	`
+
+	_, err = db.Exec(categoriesQuery)
+	if err != nil {
+		return err
+	}
+
+	// Menu items reference a category; the free text category column is kept in step
+	// with the category name for older readers
+	categoryIDQuery := `
+	ALTER TABLE menu_items ADD COLUMN IF NOT EXISTS category_id INTEGER REFERENCES menu_categories(id);
+	CREATE INDEX IF NOT EXISTS menu_items_category_id_idx ON menu_items (category_id);
+	`
+
+	_, err = db.Exec(categoryIDQuery)
+	if err != nil {
+		return err
+	}
+
+	// Columns used to filter and sort the menu, and the full text search index. The
+	// index expression must match menuSearchDocument.
+	listingQuery := `
+	ALTER TABLE menu_items ADD COLUMN IF NOT EXISTS dietary_tags TEXT[] NOT NULL DEFAULT '{}';
+	ALTER TABLE menu_items ADD COLUMN IF NOT EXISTS popularity INTEGER NOT NULL DEFAULT 0;
+	CREATE INDEX IF NOT EXISTS menu_items_search_idx ON menu_items
+		USING GIN (to_tsvector('english', name || ' ' || coalesce(description, '')));
+	CREATE INDEX IF NOT EXISTS menu_items_dietary_tags_idx ON menu_items USING GIN (dietary_tags);
+	CREATE INDEX IF NOT EXISTS menu_items_price_idx ON menu_items (price, id);
+	CREATE INDEX IF NOT EXISTS menu_items_popularity_idx ON menu_items (popularity, id);
+	`
+
+	_, err = db.Exec(listingQuery)
+	if err != nil {
+		return err
+	}
+
+	// Create option group and option tables for item modifiers, such as sizes
+	modifiersQuery := `
+	CREATE TABLE IF NOT EXISTS menu_option_groups (
+		id SERIAL PRIMARY KEY,
+		menu_item_id INTEGER NOT NULL REFERENCES menu_items(id) ON DELETE CASCADE,
+		name VARCHAR(100) NOT NULL,
+		required BOOLEAN NOT NULL DEFAULT FALSE,
+		min_select INTEGER NOT NULL DEFAULT 0,
+		max_select INTEGER NOT NULL DEFAULT 1,
+		display_order INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE INDEX IF NOT EXISTS menu_option_groups_menu_item_id_idx ON menu_option_groups (menu_item_id);
+
+	CREATE TABLE IF NOT EXISTS menu_options (
+		id SERIAL PRIMARY KEY,
+		group_id INTEGER NOT NULL REFERENCES menu_option_groups(id) ON DELETE CASCADE,
+		name VARCHAR(100) NOT NULL,
+		price_delta DECIMAL(10, 2) NOT NULL DEFAULT 0.00,
+		display_order INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE INDEX IF NOT EXISTS menu_options_group_id_idx ON menu_options (group_id);
+	`
+
+	_, err = db.Exec(modifiersQuery)
+	if err != nil {
+		return err
+	}
+
+	// Create recipe table linking menu items, and their options, to inventory items
+	recipesQuery := `
+	CREATE TABLE IF NOT EXISTS recipe_ingredients (
+		id SERIAL PRIMARY KEY,
+		menu_item_id INTEGER NOT NULL REFERENCES menu_items(id) ON DELETE CASCADE,
+		option_id INTEGER REFERENCES menu_options(id) ON DELETE CASCADE,
+		inventory_item_id INTEGER NOT NULL,
+		quantity DECIMAL(12, 3) NOT NULL CHECK (quantity > 0),
+		unit VARCHAR(50) NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS recipe_ingredients_menu_item_id_idx ON recipe_ingredients (menu_item_id);
+	CREATE INDEX IF NOT EXISTS recipe_ingredients_inventory_item_id_idx ON recipe_ingredients (inventory_item_id);
+	`
+
+	_, err = db.Exec(recipesQuery)
+	if err != nil {
+		return err
+	}
+
+	// Availability is a state set by staff, and whether there is stock to make the item
+	// or option, which is worked out from recipes and inventory. Items marked unavailable
+	// with the earlier available flag become sold out.
+	availabilityQuery := `
+	ALTER TABLE menu_items ADD COLUMN IF NOT EXISTS availability VARCHAR(20) NOT NULL DEFAULT 'available';
+	ALTER TABLE menu_items ADD COLUMN IF NOT EXISTS in_stock BOOLEAN NOT NULL DEFAULT TRUE;
+	ALTER TABLE menu_options ADD COLUMN IF NOT EXISTS in_stock BOOLEAN NOT NULL DEFAULT TRUE;
+
+	DO $$
+	BEGIN
+		IF EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'menu_items' AND column_name = 'available') THEN
+			UPDATE menu_items SET availability = 'sold_out' WHERE NOT available;
+			ALTER TABLE menu_items DROP COLUMN available;
+		END IF;
+	END $$;
+	`
+
+	_, err = db.Exec(availabilityQuery)
+	if err != nil {
+		return err
+	}
+
+	// Menus are collections of items served on a schedule, and price overrides change
+	// prices for a while, such as a happy hour. Schedules and time windows are JSON
+	// lists of days of the week and HH:MM times.
+	schedulesQuery := `
+	CREATE TABLE IF NOT EXISTS menus (
+		id SERIAL PRIMARY KEY,
+		name VARCHAR(100) NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+		active BOOLEAN NOT NULL DEFAULT TRUE,
+		schedules JSONB NOT NULL DEFAULT '[]',
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+	);
+
+	CREATE TABLE IF NOT EXISTS menu_collection_items (
+		menu_id INTEGER NOT NULL REFERENCES menus(id) ON DELETE CASCADE,
+		menu_item_id INTEGER NOT NULL REFERENCES menu_items(id) ON DELETE CASCADE,
+		PRIMARY KEY (menu_id, menu_item_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS price_overrides (
+		id SERIAL PRIMARY KEY,
+		name VARCHAR(100) NOT NULL DEFAULT '',
+		menu_item_id INTEGER REFERENCES menu_items(id) ON DELETE CASCADE,
+		category_id INTEGER REFERENCES menu_categories(id) ON DELETE CASCADE,
+		price DECIMAL(10, 2),
+		percent_off DECIMAL(5, 2),
+		starts_at TIMESTAMP WITH TIME ZONE,
+		ends_at TIMESTAMP WITH TIME ZONE,
+		time_window JSONB NOT NULL DEFAULT '{}',
+		timezone VARCHAR(64) NOT NULL DEFAULT 'UTC',
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+	);
+	`
+
+	_, err = db.Exec(schedulesQuery)
+	if err != nil {
+		return err
+	}
+
+	// Allergens and nutrition are declared by staff, or worked out from recipes and the
+	// ingredient data held by the inventory service
+	dietaryQuery := `
+	ALTER TABLE menu_items ADD COLUMN IF NOT EXISTS allergens TEXT[] NOT NULL DEFAULT '{}';
+	ALTER TABLE menu_items ADD COLUMN IF NOT EXISTS recipe_allergens TEXT[] NOT NULL DEFAULT '{}';
+	ALTER TABLE menu_items ADD COLUMN IF NOT EXISTS nutrition JSONB;
+	ALTER TABLE menu_items ADD COLUMN IF NOT EXISTS recipe_nutrition JSONB;
+	`
+
+	_, err = db.Exec(dietaryQuery)
+	if err != nil {
+		return err
+	}
+
+	// Every change to a menu item is kept as a version holding the whole item as it was
+	// after the change
+	versionsQuery := `
+	CREATE TABLE IF NOT EXISTS menu_item_versions (
+		id SERIAL PRIMARY KEY,
+		menu_item_id INTEGER NOT NULL REFERENCES menu_items(id) ON DELETE CASCADE,
+		version INTEGER NOT NULL,
+		change VARCHAR(20) NOT NULL,
+		actor VARCHAR(255) NOT NULL,
+		restored_from INTEGER,
+		data JSONB NOT NULL,
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+		UNIQUE (menu_item_id, version)
+	);
+	`
+
+	_, err = db.Exec(versionsQuery)
+	if err != nil {
+		return err
+	}
+
+	// Deleted menu items are archived rather than removed, as orders refer to them
+	archiveQuery := `
+	ALTER TABLE menu_items ADD COLUMN IF NOT EXISTS archived_at TIMESTAMP WITH TIME ZONE;
+	`
+
+	_, err = db.Exec(archiveQuery)
+	if err != nil {
+		return err
+	}
+
+	// Items can carry an external SKU, used to match them when importing
+	skuQuery := `
+	ALTER TABLE menu_items ADD COLUMN IF NOT EXISTS sku VARCHAR(64);
+	CREATE UNIQUE INDEX IF NOT EXISTS menu_items_sku_idx ON menu_items (sku) WHERE sku IS NOT NULL;
+	`
+
+	_, err = db.Exec(skuQuery)
+	if err != nil {
+		return err
+	}
+
+	// Combos bundle items at a bundle price; each slot is filled with one item from a
+	// category or a list of items
+	combosQuery := `
+	CREATE TABLE IF NOT EXISTS combos (
+		id SERIAL PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		pricing VARCHAR(20) NOT NULL,
+		price DECIMAL(10, 2) NOT NULL DEFAULT 0,
+		discount DECIMAL(10, 2) NOT NULL DEFAULT 0,
+		active BOOLEAN NOT NULL DEFAULT TRUE,
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+		updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+	);
+
+	CREATE TABLE IF NOT EXISTS combo_slots (
+		id SERIAL PRIMARY KEY,
+		combo_id INTEGER NOT NULL REFERENCES combos(id) ON DELETE CASCADE,
+		name VARCHAR(100) NOT NULL,
+		category_id INTEGER REFERENCES menu_categories(id) ON DELETE CASCADE,
+		item_ids INTEGER[] NOT NULL DEFAULT '{}',
+		optional BOOLEAN NOT NULL DEFAULT FALSE,
+		display_order INTEGER NOT NULL DEFAULT 0
+	);
+	`
+
+	_, err = db.Exec(combosQuery)
+	if err != nil {
+		return err
+	}
+
+	// Uploaded images are kept in the image store, under image_key
+	imageColumnsQuery := `
+	ALTER TABLE menu_items ADD COLUMN IF NOT EXISTS image_key VARCHAR(255);
+	ALTER TABLE menu_items ADD COLUMN IF NOT EXISTS image_format VARCHAR(10);`
+
+	_, err = db.Exec(imageColumnsQuery)
+	if err != nil {
+		return err
+	}
+
+	// Translations of item and category names into locales other than the default
+	translationsQuery := `
+	CREATE TABLE IF NOT EXISTS menu_item_translations (
+		menu_item_id INTEGER NOT NULL REFERENCES menu_items(id) ON DELETE CASCADE,
+		locale VARCHAR(16) NOT NULL,
+		name VARCHAR(255) NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (menu_item_id, locale)
+	);
+
+	CREATE TABLE IF NOT EXISTS category_translations (
+		category_id INTEGER NOT NULL REFERENCES menu_categories(id) ON DELETE CASCADE,
+		locale VARCHAR(16) NOT NULL,
+		name VARCHAR(100) NOT NULL,
+		description TEXT NOT NULL DEFAULT '',
+		updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+		PRIMARY KEY (category_id, locale)
+	);`
+
+	_, err = db.Exec(translationsQuery)
+	if err != nil {
+		return err
+	}
+
+	// Edits are staged as drafts, and published to the live menu all at once
+	draftsQuery := `
+	CREATE TABLE IF NOT EXISTS menu_item_drafts (
+		menu_item_id INTEGER PRIMARY KEY REFERENCES menu_items(id) ON DELETE CASCADE,
+		data JSONB NOT NULL,
+		actor VARCHAR(255) NOT NULL DEFAULT '',
+		updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+	);
+
+	CREATE TABLE IF NOT EXISTS menu_publications (
+		id SERIAL PRIMARY KEY,
+		status VARCHAR(20) NOT NULL,
+		actor VARCHAR(255) NOT NULL DEFAULT '',
+		scheduled_for TIMESTAMP WITH TIME ZONE,
+		published_at TIMESTAMP WITH TIME ZONE,
+		rolled_back_at TIMESTAMP WITH TIME ZONE,
+		error TEXT NOT NULL DEFAULT '',
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+	);
+
+	CREATE TABLE IF NOT EXISTS menu_publication_items (
+		publication_id INTEGER NOT NULL REFERENCES menu_publications(id) ON DELETE CASCADE,
+		menu_item_id INTEGER NOT NULL REFERENCES menu_items(id) ON DELETE CASCADE,
+		before_version INTEGER NOT NULL,
+		PRIMARY KEY (publication_id, menu_item_id)
+	);`
+
+	_, err = db.Exec(draftsQuery)
+	if err != nil {
+		return err
+	}
+
+	err = migrateCategories(db)
+	if err != nil {
+		return err
+	}
+
 	log.Println("Menu service database tables initialized")
 	return nil
 }
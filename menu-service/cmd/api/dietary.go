@@ -0,0 +1,230 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// majorAllergens are the 14 allergens that must be declared on food, in the order they
+// are listed to customers
+var majorAllergens = []string{
+	"celery",
+	"gluten",
+	"crustaceans",
+	"eggs",
+	"fish",
+	"lupin",
+	"milk",
+	"molluscs",
+	"mustard",
+	"tree-nuts",
+	"peanuts",
+	"sesame",
+	"soya",
+	"sulphites",
+}
+
+// allergenAliases maps other common names onto the names in majorAllergens
+var allergenAliases = map[string]string{
+	"cereals-containing-gluten": "gluten", "wheat": "gluten",
+	"crustacean": "crustaceans", "shellfish": "crustaceans",
+	"egg":    "eggs",
+	"lupine": "lupin",
+	"dairy":  "milk", "lactose": "milk",
+	"mollusc": "molluscs", "mollusk": "molluscs", "mollusks": "molluscs",
+	"nuts": "tree-nuts", "tree-nut": "tree-nuts",
+	"peanut":       "peanuts",
+	"sesame-seeds": "sesame",
+	"soy":          "soya", "soybeans": "soya",
+	"sulphur-dioxide": "sulphites", "sulfites": "sulphites", "sulfur-dioxide": "sulphites",
+}
+
+// dietaryTags are the dietary tags a menu item can carry
+var dietaryTags = map[string]bool{
+	"vegan":       true,
+	"vegetarian":  true,
+	"gluten-free": true,
+	"halal":       true,
+}
+
+// Nutrition is the nutrition facts for one serving. Energy is in kcal and everything
+// else in grams.
+type Nutrition struct {
+	Energy       float64 `json:"energy_kcal"`
+	Fat          float64 `json:"fat_g"`
+	SaturatedFat float64 `json:"saturated_fat_g"`
+	Carbohydrate float64 `json:"carbohydrate_g"`
+	Sugars       float64 `json:"sugars_g"`
+	Fibre        float64 `json:"fibre_g"`
+	Protein      float64 `json:"protein_g"`
+	Salt         float64 `json:"salt_g"`
+}
+
+// fields lists the nutrition values, so they can be checked and added up together
+func (n *Nutrition) fields() []*float64 {
+	return []*float64{&n.Energy, &n.Fat, &n.SaturatedFat, &n.Carbohydrate, &n.Sugars, &n.Fibre, &n.Protein, &n.Salt}
+}
+
+// normalizeAllergen returns the name of a major allergen, or "" if it isn't one
+func normalizeAllergen(name string) string {
+	name = slugify(name)
+	if alias, ok := allergenAliases[name]; ok {
+		name = alias
+	}
+	for _, allergen := range majorAllergens {
+		if allergen == name {
+			return name
+		}
+	}
+	return ""
+}
+
+// normalizeAllergens turns a list of allergen names into major allergen names, sorted
+// in the order of majorAllergens
+func normalizeAllergens(names []string) ([]string, error) {
+	found := make(map[string]bool, len(names))
+	for _, name := range names {
+		if strings.TrimSpace(name) == "" {
+			continue
+		}
+		allergen := normalizeAllergen(name)
+		if allergen == "" {
+			return nil, fmt.Errorf("unknown allergen: %s", name)
+		}
+		found[allergen] = true
+	}
+
+	allergens := []string{}
+	for _, allergen := range majorAllergens {
+		if found[allergen] {
+			allergens = append(allergens, allergen)
+		}
+	}
+	return allergens, nil
+}
+
+// validateDietaryInfo normalizes and checks the allergens, dietary tags and nutrition
+// facts of a menu item
+func validateDietaryInfo(item *MenuItem) error {
+	allergens, err := normalizeAllergens(item.Allergens)
+	if err != nil {
+		return err
+	}
+	item.Allergens = allergens
+
+	item.DietaryTags = normalizeTags(item.DietaryTags)
+	for _, tag := range item.DietaryTags {
+		if !dietaryTags[tag] {
+			return fmt.Errorf("unknown dietary tag: %s", tag)
+		}
+	}
+
+	if item.Nutrition != nil {
+		for _, value := range item.Nutrition.fields() {
+			if *value < 0 || math.IsNaN(*value) {
+				return fmt.Errorf("nutrition values can't be negative")
+			}
+		}
+	}
+
+	return nil
+}
+
+// nutritionJSON turns nutrition facts into a value for a JSONB column, NULL when there
+// are none
+func nutritionJSON(n *Nutrition) any {
+	if n == nil {
+		return nil
+	}
+	data, _ := json.Marshal(n)
+	return string(data)
+}
+
+// nutritionBasis is the amount of an inventory item its nutrition facts are given for:
+// 100 g, 100 ml or a single item, in the base unit of its dimension
+var nutritionBasis = map[string]float64{
+	"mass":   100,
+	"volume": 100,
+	"count":  1,
+}
+
+// recipeNutrition adds up the nutrition of a list of ingredients. It returns nil when
+// any ingredient has no nutrition facts, or is in a unit that can't be converted, as a
+// partial total would understate the item's nutrition.
+func recipeNutrition(ingredients []RecipeIngredient, inventory map[int]InventoryItem) *Nutrition {
+	if len(ingredients) == 0 {
+		return nil
+	}
+
+	total := &Nutrition{}
+	for _, ingredient := range ingredients {
+		stock, ok := inventory[ingredient.InventoryItemID]
+		if !ok || stock.Nutrition == nil {
+			return nil
+		}
+
+		unit, ok := units[normalizeUnit(ingredient.Unit)]
+		if !ok {
+			return nil
+		}
+		portion := ingredient.Quantity * unit.factor / nutritionBasis[unit.dimension]
+
+		values := stock.Nutrition.fields()
+		for i, sum := range total.fields() {
+			*sum += *values[i] * portion
+		}
+	}
+
+	for _, value := range total.fields() {
+		*value = math.Round(*value*10) / 10
+	}
+	return total
+}
+
+// recipeAllergens lists the allergens in any of a list of ingredients. The inventory
+// service only accepts major allergens, so there are no unknown names to reject.
+func recipeAllergens(ingredients []RecipeIngredient, inventory map[int]InventoryItem) []string {
+	var names []string
+	for _, ingredient := range ingredients {
+		for _, name := range inventory[ingredient.InventoryItemID].Allergens {
+			if allergen := normalizeAllergen(name); allergen != "" {
+				names = append(names, allergen)
+			}
+		}
+	}
+
+	allergens, _ := normalizeAllergens(names)
+	return allergens
+}
+
+// deriveDietaryInfo stores the allergens and nutrition worked out from each menu item's
+// recipe. Allergens in the ingredients of any option are included, as the item may be
+// made with them.
+func deriveDietaryInfo(tx *sql.Tx, recipes map[int]*Recipe, inventory map[int]InventoryItem) error {
+	_, err := tx.Exec(`update menu_items set recipe_allergens = '{}', recipe_nutrition = null
+		where id not in (select menu_item_id from recipe_ingredients)`)
+	if err != nil {
+		return err
+	}
+
+	for _, recipe := range recipes {
+		ingredients := recipe.Ingredients
+		for _, option := range recipe.Options {
+			ingredients = append(ingredients[:len(ingredients):len(ingredients)], option.Ingredients...)
+		}
+
+		_, err = tx.Exec(`update menu_items set recipe_allergens = $1, recipe_nutrition = $2 where id = $3`,
+			pq.Array(recipeAllergens(ingredients, inventory)), nutritionJSON(recipeNutrition(recipe.Ingredients, inventory)),
+			recipe.MenuItemID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeAllergens(t *testing.T) {
+	tests := []struct {
+		name    string
+		names   []string
+		want    []string
+		wantErr string
+	}{
+		{"listed in the usual order", []string{"Sesame", "milk", "Celery"}, []string{"celery", "milk", "sesame"}, ""},
+		{"aliases", []string{"Dairy", "wheat", "Sulphur Dioxide", "soy", "nuts"}, []string{"gluten", "milk", "tree-nuts", "soya", "sulphites"}, ""},
+		{"duplicates and blanks", []string{"egg", "Eggs", " ", ""}, []string{"eggs"}, ""},
+		{"none", nil, []string{}, ""},
+		{"unknown", []string{"milk", "chocolate"}, nil, "unknown allergen: chocolate"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeAllergens(tt.names)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("normalizeAllergens() = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("normalizeAllergens() = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("normalizeAllergens(%q) = %q, want %q", tt.names, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateDietaryInfo(t *testing.T) {
+	tests := []struct {
+		name          string
+		item          MenuItem
+		wantAllergens []string
+		wantTags      []string
+		wantErr       string
+	}{
+		{
+			name:          "normalizes allergens and tags",
+			item:          MenuItem{Allergens: []string{"Soy", "egg"}, DietaryTags: []string{"Gluten Free", "vegetarian", "VEGETARIAN"}, Nutrition: &Nutrition{Energy: 420, Salt: 0}},
+			wantAllergens: []string{"eggs", "soya"},
+			wantTags:      []string{"gluten-free", "vegetarian"},
+		},
+		{
+			name:    "unknown allergen",
+			item:    MenuItem{Allergens: []string{"cocoa"}},
+			wantErr: "unknown allergen: cocoa",
+		},
+		{
+			name:    "unknown tag",
+			item:    MenuItem{DietaryTags: []string{"keto"}},
+			wantErr: "unknown dietary tag: keto",
+		},
+		{
+			name:    "negative nutrition",
+			item:    MenuItem{Nutrition: &Nutrition{Energy: 420, Sugars: -1}},
+			wantErr: "nutrition values can't be negative",
+		},
+		{
+			name:    "nutrition that isn't a number",
+			item:    MenuItem{Nutrition: &Nutrition{Protein: math.NaN()}},
+			wantErr: "nutrition values can't be negative",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := tt.item
+			err := validateDietaryInfo(&item)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("validateDietaryInfo() = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validateDietaryInfo() = %v", err)
+			}
+			if !reflect.DeepEqual(item.Allergens, tt.wantAllergens) || !reflect.DeepEqual(item.DietaryTags, tt.wantTags) {
+				t.Errorf("validated item = %q, %q, want %q, %q", item.Allergens, item.DietaryTags, tt.wantAllergens, tt.wantTags)
+			}
+		})
+	}
+}
+
+func TestRecipeNutrition(t *testing.T) {
+	inventory := map[int]InventoryItem{
+		// Per 100 g
+		1: {ItemName: "Flour", Unit: "kg", Nutrition: &Nutrition{Energy: 364, Carbohydrate: 76.3, Protein: 10.3, Salt: 0.01}},
+		// Per 100 ml
+		2: {ItemName: "Milk", Unit: "l", Nutrition: &Nutrition{Energy: 64, Fat: 3.6, Sugars: 4.8, Protein: 3.4}},
+		// Per item
+		3: {ItemName: "Eggs", Unit: "each", Nutrition: &Nutrition{Energy: 72, Fat: 4.8, Protein: 6.3, Salt: 0.18}},
+		4: {ItemName: "Sugar", Unit: "kg"},
+	}
+
+	tests := []struct {
+		name        string
+		ingredients []RecipeIngredient
+		want        *Nutrition
+	}{
+		{
+			name: "adds up each ingredient's share",
+			ingredients: []RecipeIngredient{
+				{InventoryItemID: 1, Quantity: 0.04, Unit: "kg"},
+				{InventoryItemID: 2, Quantity: 150, Unit: "ml"},
+				{InventoryItemID: 3, Quantity: 2, Unit: "each"},
+			},
+			want: &Nutrition{Energy: 385.6, Fat: 15, Carbohydrate: 30.5, Sugars: 7.2, Protein: 21.8, Salt: 0.4},
+		},
+		{
+			name:        "no ingredients",
+			ingredients: nil,
+			want:        nil,
+		},
+		{
+			name:        "an ingredient without nutrition facts",
+			ingredients: []RecipeIngredient{{InventoryItemID: 1, Quantity: 50, Unit: "g"}, {InventoryItemID: 4, Quantity: 10, Unit: "g"}},
+			want:        nil,
+		},
+		{
+			name:        "an ingredient that isn't in the inventory",
+			ingredients: []RecipeIngredient{{InventoryItemID: 9, Quantity: 50, Unit: "g"}},
+			want:        nil,
+		},
+		{
+			name:        "a unit that can't be converted",
+			ingredients: []RecipeIngredient{{InventoryItemID: 3, Quantity: 1, Unit: "slice"}},
+			want:        nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := recipeNutrition(tt.ingredients, inventory); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("recipeNutrition() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecipeAllergens(t *testing.T) {
+	inventory := map[int]InventoryItem{
+		1: {ItemName: "Flour", Allergens: []string{"gluten"}},
+		2: {ItemName: "Milk", Allergens: []string{"milk"}},
+		3: {ItemName: "Pesto", Allergens: []string{"milk", "tree-nuts"}},
+		4: {ItemName: "Tomatoes"},
+	}
+
+	ingredients := []RecipeIngredient{{InventoryItemID: 3}, {InventoryItemID: 1}, {InventoryItemID: 2}, {InventoryItemID: 4}, {InventoryItemID: 9}}
+	want := []string{"gluten", "milk", "tree-nuts"}
+	if got := recipeAllergens(ingredients, inventory); !reflect.DeepEqual(got, want) {
+		t.Errorf("recipeAllergens() = %q, want %q", got, want)
+	}
+
+	if got := recipeAllergens(nil, inventory); len(got) != 0 {
+		t.Errorf("recipeAllergens(nil) = %q, want none", got)
+	}
+}
+
+func TestNutritionJSON(t *testing.T) {
+	if got := nutritionJSON(nil); got != nil {
+		t.Errorf("nutritionJSON(nil) = %v, want nil", got)
+	}
+
+	want := `{"energy_kcal":250,"fat_g":0,"saturated_fat_g":0,"carbohydrate_g":0,"sugars_g":0,"fibre_g":0,"protein_g":12.5,"salt_g":0}`
+	if got := nutritionJSON(&Nutrition{Energy: 250, Protein: 12.5}); got != want {
+		t.Errorf("nutritionJSON() = %v, want %s", got, want)
+	}
+}
@@ -0,0 +1,861 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Publication statuses
+const (
+	publicationScheduled  = "scheduled"
+	// publicationPublishing is a publication being published straight away, which the
+	// scheduler leaves alone
+	publicationPublishing = "publishing"
+	publicationPublished  = "published"
+	publicationRolledBack = "rolled_back"
+	publicationCancelled  = "cancelled"
+	publicationFailed     = "failed"
+)
+
+// Changes recorded in a menu item's version history by publishing
+const (
+	changePublished  = "published"
+	changeRolledBack = "rolled_back"
+)
+
+var (
+	errNothingToPublish      = errors.New("there are no draft changes to publish")
+	errNothingToRollBack     = errors.New("there is no publication to roll back")
+	errPublicationNotFound   = errors.New("publication not found")
+	errPublicationNotPending = errors.New("publication is not scheduled")
+	errPublicationInThePast  = errors.New("publications can't be scheduled in the past")
+	errDraftNotFound         = errors.New("menu item has no draft")
+	errItemHasDraft          = errors.New("menu item has draft changes waiting to be published; publish or discard them first")
+)
+
+// Draft is a change to a menu item that has been saved but not published yet. Item is
+// the menu item as it will be once published.
+type Draft struct {
+	MenuItemID int                    `json:"menu_item_id"`
+	Actor      string                 `json:"actor"`
+	Item       MenuItem               `json:"item"`
+	Changes    map[string]FieldChange `json:"changes"`
+	UpdatedAt  string                 `json:"updated_at"`
+}
+
+// Publication is one release of draft changes to the live menu, published straight
+// away or at a scheduled time
+type Publication struct {
+	ID           int     `json:"id"`
+	Status       string  `json:"status"`
+	Actor        string  `json:"actor"`
+	ScheduledFor *string `json:"scheduled_for,omitempty"`
+	PublishedAt  *string `json:"published_at,omitempty"`
+	RolledBackAt *string `json:"rolled_back_at,omitempty"`
+	Error        string  `json:"error,omitempty"`
+	MenuItemIDs  []int   `json:"menu_item_ids"`
+	CreatedAt    string  `json:"created_at"`
+}
+
+// saveDraft stages changes to a menu item. Option groups and the SKU are kept from any
+// earlier draft when they are left out, just as they are left alone by a live update.
+func (app *Config) saveDraft(item MenuItem, actor string) error {
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Live changes that a draft would undo check for drafts under the same lock
+	err = lockMenuItem(tx, item.ID)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	err = tx.QueryRow(`select data from menu_item_drafts where menu_item_id = $1`, item.ID).Scan(&data)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return err
+	default:
+		var earlier MenuItem
+		if err := json.Unmarshal(data, &earlier); err != nil {
+			return err
+		}
+		if item.OptionGroups == nil {
+			item.OptionGroups = earlier.OptionGroups
+		}
+		if item.SKU == "" {
+			item.SKU = earlier.SKU
+		}
+	}
+
+	data, err = json.Marshal(item)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`insert into menu_item_drafts (menu_item_id, data, actor) values ($1, $2, $3)
+		on conflict (menu_item_id) do update set data = excluded.data, actor = excluded.actor, updated_at = now()`,
+		item.ID, string(data), actor)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// lockMenuItem locks a menu item for the rest of a transaction, so that changes to it,
+// drafts included, are made one at a time
+func lockMenuItem(tx *sql.Tx, id int) error {
+	err := tx.QueryRow(`select id from menu_items where id = $1 for update`, id).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errMenuItemNotFound
+	}
+	return err
+}
+
+// refuseDrafted stops a live change to a menu item while it has a pending draft, as
+// publishing the draft would silently undo the change. The item must be locked.
+func refuseDrafted(tx *sql.Tx, itemID int) error {
+	var drafted bool
+	err := tx.QueryRow(`select exists (select 1 from menu_item_drafts where menu_item_id = $1)`, itemID).Scan(&drafted)
+	if err != nil {
+		return err
+	}
+	if drafted {
+		return errItemHasDraft
+	}
+
+	return nil
+}
+
+// getDrafts returns the drafted menu items among ids, or every draft when ids is nil
+func (app *Config) getDrafts(ids []int) (map[int]MenuItem, error) {
+	rows, err := app.DB.Query(`select menu_item_id, data from menu_item_drafts
+		where $1::int[] is null or menu_item_id = any($1)`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	drafts := make(map[int]MenuItem)
+	for rows.Next() {
+		var id int
+		var data []byte
+		err := rows.Scan(&id, &data)
+		if err != nil {
+			return nil, err
+		}
+
+		var item MenuItem
+		if err := json.Unmarshal(data, &item); err != nil {
+			return nil, err
+		}
+		drafts[id] = item
+	}
+
+	return drafts, rows.Err()
+}
+
+// overlayDraft shows a drafted change in place of the published item
+func overlayDraft(item *MenuItem, draft MenuItem) {
+	item.Name = draft.Name
+	item.Description = draft.Description
+	item.Price = draft.Price
+	item.CategoryID = draft.CategoryID
+	item.Category = draft.Category
+	item.DietaryTags = draft.DietaryTags
+	item.Allergens = draft.Allergens
+	item.Nutrition = draft.Nutrition
+	if draft.SKU != "" {
+		item.SKU = draft.SKU
+	}
+	if draft.OptionGroups != nil {
+		item.OptionGroups = draft.OptionGroups
+	}
+
+	// Both lists only hold major allergens, so this can't fail
+	item.ContainsAllergens, _ = normalizeAllergens(append(append([]string{}, item.Allergens...), item.RecipeAllergens...))
+	item.Draft = true
+}
+
+// applyDrafts shows the drafted changes to menu items in place of the published ones
+func (app *Config) applyDrafts(items []MenuItem) error {
+	ids := make([]int, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.ID)
+	}
+
+	drafts, err := app.getDrafts(ids)
+	if err != nil {
+		return err
+	}
+
+	for i := range items {
+		if draft, ok := drafts[items[i].ID]; ok {
+			overlayDraft(&items[i], draft)
+		}
+	}
+
+	return nil
+}
+
+// listDrafts returns every pending draft with what it changes, oldest first
+func (app *Config) listDrafts() ([]Draft, error) {
+	rows, err := app.DB.Query(`select menu_item_id, actor, updated_at from menu_item_drafts order by updated_at, menu_item_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	drafts := []Draft{}
+	for rows.Next() {
+		var draft Draft
+		err := rows.Scan(&draft.MenuItemID, &draft.Actor, &draft.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		drafts = append(drafts, draft)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range drafts {
+		live, err := app.getMenuItemByID(drafts[i].MenuItemID)
+		if err != nil {
+			return nil, err
+		}
+
+		drafted := []MenuItem{live}
+		err = app.applyDrafts(drafted)
+		if err != nil {
+			return nil, err
+		}
+
+		drafts[i].Item = drafted[0]
+		drafts[i].Changes, err = diffSnapshots(snapshotOf(live), snapshotOf(drafted[0]))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return drafts, nil
+}
+
+// discardDraft throws away the pending changes to a menu item
+func (app *Config) discardDraft(id int) error {
+	result, err := app.DB.Exec(`delete from menu_item_drafts where menu_item_id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errDraftNotFound
+	}
+
+	return nil
+}
+
+const publicationSelect = `select p.id, p.status, p.actor, p.scheduled_for, p.published_at, p.rolled_back_at, p.error,
+	coalesce(array(select menu_item_id from menu_publication_items where publication_id = p.id order by menu_item_id), '{}'),
+	p.created_at
+	from menu_publications p`
+
+func scanPublication(row rowScanner) (Publication, error) {
+	var p Publication
+	var scheduledFor, publishedAt, rolledBackAt sql.NullString
+	var ids []int64
+	err := row.Scan(&p.ID, &p.Status, &p.Actor, &scheduledFor, &publishedAt, &rolledBackAt, &p.Error,
+		pq.Array(&ids), &p.CreatedAt)
+	if err != nil {
+		return Publication{}, err
+	}
+
+	p.ScheduledFor = nullableString(scheduledFor)
+	p.PublishedAt = nullableString(publishedAt)
+	p.RolledBackAt = nullableString(rolledBackAt)
+	p.MenuItemIDs = make([]int, len(ids))
+	for i, id := range ids {
+		p.MenuItemIDs[i] = int(id)
+	}
+
+	return p, nil
+}
+
+func nullableString(value sql.NullString) *string {
+	if !value.Valid {
+		return nil
+	}
+	return &value.String
+}
+
+// getPublications returns publications, newest first
+func (app *Config) getPublications() ([]Publication, error) {
+	rows, err := app.DB.Query(publicationSelect + ` order by p.created_at desc, p.id desc`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	publications := []Publication{}
+	for rows.Next() {
+		p, err := scanPublication(rows)
+		if err != nil {
+			return nil, err
+		}
+		publications = append(publications, p)
+	}
+
+	return publications, rows.Err()
+}
+
+func (app *Config) getPublication(id int) (Publication, error) {
+	p, err := scanPublication(app.DB.QueryRow(publicationSelect+` where p.id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Publication{}, errPublicationNotFound
+	}
+	return p, err
+}
+
+// schedulePublication sets the drafts to be published at a time. Whatever drafts are
+// pending when that time comes are published.
+func (app *Config) schedulePublication(at time.Time, actor string) (Publication, error) {
+	return app.insertPublication(publicationScheduled, at, actor)
+}
+
+// insertPublication records a publication that hasn't been published yet
+func (app *Config) insertPublication(status string, at time.Time, actor string) (Publication, error) {
+	var id int
+	err := app.DB.QueryRow(`insert into menu_publications (status, actor, scheduled_for) values ($1, $2, $3) returning id`,
+		status, actor, at).Scan(&id)
+	if err != nil {
+		return Publication{}, err
+	}
+
+	return app.getPublication(id)
+}
+
+// publish applies every pending draft to the live menu in one transaction, so the menu
+// never shows half of a release. Each item's new version is recorded in the same
+// transaction.
+func (app *Config) publish(publicationID int) error {
+	drafts, err := app.getDrafts(nil)
+	if err != nil {
+		return err
+	}
+	if len(drafts) == 0 {
+		return app.publishNothing(publicationID)
+	}
+
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`update menu_publications set status = $1, published_at = now() where id = $2 and status in ($3, $4)`,
+		publicationPublished, publicationID, publicationScheduled, publicationPublishing)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errPublicationNotPending
+	}
+
+	// Lock the drafts, so none can change between being published and being cleared
+	rows, err := tx.Query(`select menu_item_id, data, actor from menu_item_drafts for update`)
+	if err != nil {
+		return err
+	}
+
+	type pendingDraft struct {
+		item  MenuItem
+		actor string
+	}
+	var pending []pendingDraft
+	for rows.Next() {
+		var d pendingDraft
+		var data []byte
+		err := rows.Scan(&d.item.ID, &data, &d.actor)
+		if err == nil {
+			err = json.Unmarshal(data, &d.item)
+		}
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		pending = append(pending, d)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		tx.Rollback()
+		return app.publishNothing(publicationID)
+	}
+
+	for _, d := range pending {
+		item := d.item
+
+		taken, err := skuTaken(tx, item.SKU, item.ID)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return fmt.Errorf("menu item %d: %w", item.ID, errSKUTaken)
+		}
+
+		// A category that didn't exist when the change was drafted, or that has been
+		// deleted since, is found or created by name now
+		exists := false
+		if item.CategoryID != 0 {
+			err = tx.QueryRow(`select exists (select 1 from menu_categories where id = $1)`, item.CategoryID).Scan(&exists)
+			if err != nil {
+				return err
+			}
+		}
+		if !exists {
+			category, err := findOrCreateCategory(tx, item.Category)
+			if err != nil {
+				return err
+			}
+			item.CategoryID = category.ID
+			item.Category = category.Name
+		}
+
+		// Items changed before versioning get a baseline, so they can be rolled back to it
+		err = ensureVersioned(tx, item.ID)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`insert into menu_publication_items (publication_id, menu_item_id, before_version)
+			select $1, $2, coalesce(max(version), 0) from menu_item_versions where menu_item_id = $2`,
+			publicationID, item.ID)
+		if err != nil {
+			return err
+		}
+
+		err = updateMenuItemRow(tx, item)
+		if err != nil {
+			return err
+		}
+
+		if item.OptionGroups != nil {
+			err = saveOptionGroupsTx(tx, item.ID, item.OptionGroups)
+			if err != nil {
+				return fmt.Errorf("menu item %d: %w", item.ID, err)
+			}
+		}
+
+		err = recordVersion(tx, item.ID, changePublished, d.actor, nil)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`delete from menu_item_drafts where menu_item_id = $1`, item.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// publishNothing cancels a publication that found no drafts to publish
+func (app *Config) publishNothing(id int) error {
+	_, err := app.DB.Exec(`update menu_publications set status = $1, error = $2 where id = $3 and status in ($4, $5)`,
+		publicationCancelled, errNothingToPublish.Error(), id, publicationScheduled, publicationPublishing)
+	if err != nil {
+		return err
+	}
+
+	return errNothingToPublish
+}
+
+// failPublication records why a publication could not be published
+func (app *Config) failPublication(id int, cause error) {
+	_, err := app.DB.Exec(`update menu_publications set status = $1, error = $2 where id = $3 and status in ($4, $5)`,
+		publicationFailed, cause.Error(), id, publicationScheduled, publicationPublishing)
+	if err != nil {
+		log.Printf("Error recording failed publication %d: %v", id, err)
+	}
+}
+
+// publishNow publishes every pending draft straight away. The publication is recorded
+// as publishing rather than scheduled, so the scheduler can't pick it up at the same
+// time.
+func (app *Config) publishNow(actor string) (Publication, error) {
+	p, err := app.insertPublication(publicationPublishing, time.Now(), actor)
+	if err != nil {
+		return Publication{}, err
+	}
+
+	err = app.publish(p.ID)
+	if err != nil {
+		if !errors.Is(err, errNothingToPublish) {
+			app.failPublication(p.ID, err)
+		}
+		return Publication{}, err
+	}
+
+	return app.getPublication(p.ID)
+}
+
+// publishDue publishes any scheduled publications whose time has come
+func (app *Config) publishDue() error {
+	rows, err := app.DB.Query(`select id from menu_publications where status = $1 and scheduled_for <= now()
+		order by scheduled_for, id`, publicationScheduled)
+	if err != nil {
+		return err
+	}
+
+	var due []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		due = append(due, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range due {
+		err := app.publish(id)
+		switch {
+		case err == nil:
+			log.Printf("Published scheduled menu publication %d", id)
+		case errors.Is(err, errNothingToPublish), errors.Is(err, errPublicationNotPending):
+		default:
+			log.Printf("Error publishing menu publication %d: %v", id, err)
+			app.failPublication(id, err)
+		}
+	}
+
+	return nil
+}
+
+// schedulePublishing publishes scheduled publications every interval
+func (app *Config) schedulePublishing(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := app.publishDue(); err != nil {
+			log.Printf("Error publishing scheduled menu changes: %v", err)
+		}
+	}
+}
+
+// cancelPublication stops a scheduled publication from going ahead
+func (app *Config) cancelPublication(id int) error {
+	result, err := app.DB.Exec(`update menu_publications set status = $1 where id = $2 and status = $3`,
+		publicationCancelled, id, publicationScheduled)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		if _, err := app.getPublication(id); err != nil {
+			return err
+		}
+		return errPublicationNotPending
+	}
+
+	return nil
+}
+
+// rollBack undoes the most recent publication still in place, putting each item it
+// changed back as it was before, in one transaction. Rolling back again undoes the
+// publication before that. Availability is left alone, as publishing never changes it.
+func (app *Config) rollBack(actor string) (Publication, error) {
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return Publication{}, err
+	}
+	defer tx.Rollback()
+
+	var publicationID int
+	err = tx.QueryRow(`select id from menu_publications where status = $1
+		order by published_at desc, id desc limit 1 for update`, publicationPublished).Scan(&publicationID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Publication{}, errNothingToRollBack
+	}
+	if err != nil {
+		return Publication{}, err
+	}
+
+	rows, err := tx.Query(`select p.menu_item_id, p.before_version, v.data
+		from menu_publication_items p
+		join menu_item_versions v on v.menu_item_id = p.menu_item_id and v.version = p.before_version
+		where p.publication_id = $1 order by p.menu_item_id`, publicationID)
+	if err != nil {
+		return Publication{}, err
+	}
+
+	type restore struct {
+		itemID   int
+		version  int
+		snapshot MenuItemSnapshot
+	}
+	var restores []restore
+	for rows.Next() {
+		var r restore
+		var data []byte
+		err := rows.Scan(&r.itemID, &r.version, &data)
+		if err == nil {
+			err = json.Unmarshal(data, &r.snapshot)
+		}
+		if err != nil {
+			rows.Close()
+			return Publication{}, err
+		}
+		restores = append(restores, r)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return Publication{}, err
+	}
+
+	for _, r := range restores {
+		item, err := getMenuItemRow(tx, r.itemID)
+		if err != nil {
+			return Publication{}, err
+		}
+
+		err = app.applySnapshot(tx, item, r.snapshot)
+		if err != nil {
+			return Publication{}, fmt.Errorf("menu item %d: %w", r.itemID, err)
+		}
+
+		version := r.version
+		err = recordVersion(tx, r.itemID, changeRolledBack, actor, &version)
+		if err != nil {
+			return Publication{}, err
+		}
+	}
+
+	_, err = tx.Exec(`update menu_publications set status = $1, rolled_back_at = now() where id = $2`,
+		publicationRolledBack, publicationID)
+	if err != nil {
+		return Publication{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return Publication{}, err
+	}
+
+	return app.getPublication(publicationID)
+}
+
+// GetDrafts lists the menu changes waiting to be published
+func (app *Config) GetDrafts(c *gin.Context) {
+	drafts, err := app.listDrafts()
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool    `json:"error"`
+		Message string  `json:"message"`
+		Data    []Draft `json:"data"`
+	}{
+		Error:   false,
+		Message: "Drafts retrieved",
+		Data:    drafts,
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+// DiscardDraft throws away the unpublished changes to a menu item
+func (app *Config) DiscardDraft(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		app.errorJSON(c, errors.New("invalid id parameter"), http.StatusBadRequest)
+		return
+	}
+
+	err = app.discardDraft(id)
+	if errors.Is(err, errDraftNotFound) {
+		app.errorJSON(c, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}{
+		Error:   false,
+		Message: "Draft discarded",
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+// PublishMenu publishes every pending draft, straight away or, given a time in at,
+// when that time comes
+func (app *Config) PublishMenu(c *gin.Context) {
+	var requestPayload struct {
+		At *time.Time `json:"at"`
+	}
+
+	// The body is optional
+	if c.Request.ContentLength != 0 {
+		err := app.readJSON(c, &requestPayload)
+		if err != nil {
+			app.errorJSON(c, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	if requestPayload.At != nil && requestPayload.At.After(time.Now()) {
+		publication, err := app.schedulePublication(*requestPayload.At, requestActor(c))
+		if err != nil {
+			app.errorJSON(c, err, http.StatusInternalServerError)
+			return
+		}
+
+		payload := struct {
+			Error   bool        `json:"error"`
+			Message string      `json:"message"`
+			Data    Publication `json:"data"`
+		}{
+			Error:   false,
+			Message: "Menu publication scheduled",
+			Data:    publication,
+		}
+
+		app.writeJSON(c, http.StatusAccepted, payload)
+		return
+	}
+	if requestPayload.At != nil && time.Since(*requestPayload.At) > time.Minute {
+		app.errorJSON(c, errPublicationInThePast, http.StatusBadRequest)
+		return
+	}
+
+	publication, err := app.publishNow(requestActor(c))
+	if errors.Is(err, errNothingToPublish) {
+		app.errorJSON(c, err, http.StatusConflict)
+		return
+	}
+	if errors.Is(err, errSKUTaken) {
+		app.errorJSON(c, err, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool        `json:"error"`
+		Message string      `json:"message"`
+		Data    Publication `json:"data"`
+	}{
+		Error:   false,
+		Message: "Menu published",
+		Data:    publication,
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+// GetPublications lists publications, newest first
+func (app *Config) GetPublications(c *gin.Context) {
+	publications, err := app.getPublications()
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool          `json:"error"`
+		Message string        `json:"message"`
+		Data    []Publication `json:"data"`
+	}{
+		Error:   false,
+		Message: "Publications retrieved",
+		Data:    publications,
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+// CancelPublication cancels a scheduled publication
+func (app *Config) CancelPublication(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		app.errorJSON(c, errors.New("invalid id parameter"), http.StatusBadRequest)
+		return
+	}
+
+	err = app.cancelPublication(id)
+	switch {
+	case errors.Is(err, errPublicationNotFound):
+		app.errorJSON(c, err, http.StatusNotFound)
+		return
+	case errors.Is(err, errPublicationNotPending):
+		app.errorJSON(c, err, http.StatusConflict)
+		return
+	case err != nil:
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}{
+		Error:   false,
+		Message: "Publication cancelled",
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+// RollBackMenu puts the menu back as it was before the most recent publication
+func (app *Config) RollBackMenu(c *gin.Context) {
+	publication, err := app.rollBack(requestActor(c))
+	if errors.Is(err, errNothingToRollBack) {
+		app.errorJSON(c, err, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool        `json:"error"`
+		Message string      `json:"message"`
+		Data    Publication `json:"data"`
+	}{
+		Error:   false,
+		Message: "Menu rolled back",
+		Data:    publication,
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
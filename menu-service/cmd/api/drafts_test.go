@@ -0,0 +1,94 @@
+package main
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+)
+
+func TestOverlayDraft(t *testing.T) {
+	published := MenuItem{
+		ID:                  7,
+		SKU:                 "FW-1",
+		Name:                "Flat white",
+		Description:         "Double ristretto, steamed milk",
+		Price:               3.2,
+		CategoryID:          2,
+		Category:            "Coffee",
+		AvailabilitySetting: availabilityAvailable,
+		InStock:             true,
+		DietaryTags:         []string{"vegetarian"},
+		Allergens:           []string{"milk"},
+		RecipeAllergens:     []string{"milk"},
+		ContainsAllergens:   []string{"milk"},
+		OptionGroups:        []OptionGroup{{ID: 1, Name: "Milk"}},
+		Popularity:          120,
+	}
+
+	tests := []struct {
+		name  string
+		draft MenuItem
+		want  func(MenuItem) MenuItem
+	}{
+		{
+			name: "shows the drafted fields",
+			draft: MenuItem{
+				SKU:          "FW-2",
+				Name:         "Flat White",
+				Description:  "Ristretto, steamed oat milk",
+				Price:        3.4,
+				CategoryID:   3,
+				Category:     "Espresso",
+				DietaryTags:  []string{"vegan"},
+				Allergens:    []string{"sesame", "gluten"},
+				Nutrition:    &Nutrition{Energy: 110},
+				OptionGroups: []OptionGroup{},
+			},
+			want: func(item MenuItem) MenuItem {
+				item.SKU, item.Name, item.Description, item.Price = "FW-2", "Flat White", "Ristretto, steamed oat milk", 3.4
+				item.CategoryID, item.Category = 3, "Espresso"
+				item.DietaryTags = []string{"vegan"}
+				item.Allergens = []string{"sesame", "gluten"}
+				item.Nutrition = &Nutrition{Energy: 110}
+				item.OptionGroups = []OptionGroup{}
+				// The recipe's allergens still count, listed in the usual order
+				item.ContainsAllergens = []string{"gluten", "milk", "sesame"}
+				item.Draft = true
+				return item
+			},
+		},
+		{
+			name:  "keeps the SKU and options the draft leaves out",
+			draft: MenuItem{Name: "Flat white", Price: 3.2, CategoryID: 2, Category: "Coffee"},
+			want: func(item MenuItem) MenuItem {
+				item.Description = ""
+				item.DietaryTags = nil
+				item.Allergens = nil
+				item.Draft = true
+				return item
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := published
+			overlayDraft(&item, tt.draft)
+
+			if want := tt.want(published); !reflect.DeepEqual(item, want) {
+				t.Errorf("overlayDraft() = %+v, want %+v", item, want)
+			}
+		})
+	}
+}
+
+func TestNullableString(t *testing.T) {
+	if got := nullableString(sql.NullString{}); got != nil {
+		t.Errorf("nullableString(NULL) = %q, want nil", *got)
+	}
+
+	got := nullableString(sql.NullString{String: "no drafts to publish", Valid: true})
+	if got == nil || *got != "no drafts to publish" {
+		t.Errorf("nullableString() = %v, want the string", got)
+	}
+}
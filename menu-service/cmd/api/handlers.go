@@ -1,28 +1,87 @@
 package main
 
 import (
+	"database/sql"
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
 
+// GetAllMenuItems lists menu items. See parseMenuListQuery for the filters, sorting and
+// paging it accepts.
 func (app *Config) GetAllMenuItems(c *gin.Context) {
-	items, err := app.getAllMenuItems()
+	query, err := parseMenuListQuery(c.Request.URL.Query())
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	// ?group=category returns every matching item nested in the category tree
+	grouped := c.Query("group") == "category"
+	if grouped {
+		query.Limit = 0
+		query.Cursor = nil
+	}
+
+	items, total, nextCursor, err := app.listMenuItems(query)
+	if errors.Is(err, errCategoryNotFound) {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Active combos are listed alongside the items
+	combos, err := app.getCombos(true)
 	if err != nil {
 		app.errorJSON(c, err, http.StatusInternalServerError)
 		return
 	}
 
+	// Names and descriptions are read in the language asked for by ?lang= or the
+	// Accept-Language header
+	c.Header("Vary", "Accept-Language")
+	locales := requestLocales(c)
+	err = app.localizeMenuItems(items, locales)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	var data any = items
+	if grouped {
+		categories, err := app.getAllCategories()
+		if err == nil {
+			err = app.localizeCategories(categories, locales)
+		}
+		if err != nil {
+			app.errorJSON(c, err, http.StatusInternalServerError)
+			return
+		}
+		data = buildCategoryTree(categories, items)
+	}
+
 	payload := struct {
-		Error   bool       `json:"error"`
-		Message string     `json:"message"`
-		Data    []MenuItem `json:"data,omitempty"`
+		Error   bool    `json:"error"`
+		Message string  `json:"message"`
+		Data    any     `json:"data"`
+		Combos  []Combo `json:"combos"`
+		Meta    gin.H   `json:"meta"`
 	}{
 		Error:   false,
 		Message: "Menu items retrieved",
-		Data:    items,
+		Data:    data,
+		Combos:  combos,
+		Meta: gin.H{
+			"total":       total,
+			"limit":       query.Limit,
+			"next_cursor": nextCursor,
+		},
 	}
 
 	app.writeJSON(c, http.StatusOK, payload)
@@ -41,6 +100,22 @@ func (app *Config) GetMenuItem(c *gin.Context) {
 		return
 	}
 
+	c.Header("Vary", "Accept-Language")
+	items := []MenuItem{item}
+	if c.Query("draft") == "true" {
+		err = app.applyDrafts(items)
+		if err != nil {
+			app.errorJSON(c, err, http.StatusInternalServerError)
+			return
+		}
+	}
+	err = app.localizeMenuItems(items, requestLocales(c))
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+	item = items[0]
+
 	payload := struct {
 		Error   bool     `json:"error"`
 		Message string   `json:"message"`
@@ -69,8 +144,45 @@ func (app *Config) CreateMenuItem(c *gin.Context) {
 		return
 	}
 
+	err = app.resolveItemCategory(&item)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	err = validateOptionGroups(item.OptionGroups)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	err = validateDietaryInfo(&item)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	taken, err := skuTaken(app.DB, item.SKU, item.ID)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+	if taken {
+		app.errorJSON(c, errSKUTaken, http.StatusConflict)
+		return
+	}
+
+	// Items are available unless the request says otherwise
+	if item.AvailabilitySetting == "" {
+		item.AvailabilitySetting = availabilityAvailable
+	}
+	if !validAvailability[item.AvailabilitySetting] {
+		app.errorJSON(c, fmt.Errorf("unknown availability: %s", item.AvailabilitySetting), http.StatusBadRequest)
+		return
+	}
+
 	// Create the menu item
-	newID, err := app.insertMenuItem(item)
+	newID, err := app.createMenuItem(item, requestActor(c))
 	if err != nil {
 		app.errorJSON(c, err, http.StatusInternalServerError)
 		return
@@ -113,15 +225,63 @@ func (app *Config) UpdateMenuItem(c *gin.Context) {
 	// Make sure ID matches
 	item.ID = id
 
-	// Update the menu item
-	err = app.updateMenuItem(item)
+	// Validate the menu item before staging it, as publishing applies drafts unchecked
+	if item.Name == "" || item.Price <= 0 {
+		app.errorJSON(c, errors.New("invalid menu item data"), http.StatusBadRequest)
+		return
+	}
+
+	err = app.matchItemCategory(&item)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	err = validateOptionGroups(item.OptionGroups)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	err = validateDietaryInfo(&item)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	taken, err := skuTaken(app.DB, item.SKU, item.ID)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+	if taken {
+		app.errorJSON(c, errSKUTaken, http.StatusConflict)
+		return
+	}
+
+	liveItem, err := app.getMenuItemByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(c, errMenuItemNotFound, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Changes are staged in a draft, and go live when the menu is published
+	err = app.saveDraft(item, requestActor(c))
+	if errors.Is(err, errMenuItemNotFound) {
+		app.errorJSON(c, err, http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		app.errorJSON(c, err, http.StatusInternalServerError)
 		return
 	}
 
-	// Get the updated item
-	updatedItem, err := app.getMenuItemByID(id)
+	draftItems := []MenuItem{liveItem}
+	err = app.applyDrafts(draftItems)
 	if err != nil {
 		app.errorJSON(c, err, http.StatusInternalServerError)
 		return
@@ -133,11 +293,11 @@ func (app *Config) UpdateMenuItem(c *gin.Context) {
 		Data    MenuItem `json:"data,omitempty"`
 	}{
 		Error:   false,
-		Message: "Menu item updated",
-		Data:    updatedItem,
+		Message: "Menu item changes saved as a draft",
+		Data:    draftItems[0],
 	}
 
-	app.writeJSON(c, http.StatusOK, payload)
+	app.writeJSON(c, http.StatusAccepted, payload)
 }
 
 func (app *Config) DeleteMenuItem(c *gin.Context) {
@@ -147,7 +307,46 @@ func (app *Config) DeleteMenuItem(c *gin.Context) {
 		return
 	}
 
-	err = app.deleteMenuItem(id)
+	// Deleting archives the item, as orders may still refer to it. See PurgeMenuItem.
+	err = app.archiveMenuItem(id, requestActor(c))
+	if errors.Is(err, errMenuItemNotFound) {
+		app.errorJSON(c, err, http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, errItemHasDraft) {
+		app.errorJSON(c, err, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}{
+		Error:   false,
+		Message: "Menu item archived",
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+// RecordSales adds quantities sold to the popularity of menu items. The order service
+// calls it when orders are completed or refunded.
+func (app *Config) RecordSales(c *gin.Context) {
+	var requestPayload struct {
+		Sales []Sale `json:"sales"`
+	}
+
+	err := app.readJSON(c, &requestPayload)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.recordSales(requestPayload.Sales)
 	if err != nil {
 		app.errorJSON(c, err, http.StatusInternalServerError)
 		return
@@ -158,7 +357,7 @@ func (app *Config) DeleteMenuItem(c *gin.Context) {
 		Message string `json:"message"`
 	}{
 		Error:   false,
-		Message: "Menu item deleted",
+		Message: "Sales recorded",
 	}
 
 	app.writeJSON(c, http.StatusOK, payload)
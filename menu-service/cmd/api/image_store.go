@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+var errImageNotFound = errors.New("image not found")
+
+// imageStore keeps menu images. Keys are slash separated paths, such as
+// menu-items/12/3f9c0a1b2c3d4e5f/small.jpg.
+type imageStore interface {
+	// Put saves data under key, replacing anything already there
+	Put(key string, data []byte) error
+	// Open returns the image saved under key and when it was saved
+	Open(key string) (io.ReadSeekCloser, time.Time, error)
+	// Delete removes every image whose key starts with prefix
+	Delete(prefix string) error
+}
+
+// localImageStore keeps images in a directory on the local filesystem
+type localImageStore struct {
+	root string
+}
+
+// newLocalImageStore stores images under IMAGE_DIR, or ./images when it isn't set
+func newLocalImageStore() *localImageStore {
+	root := os.Getenv("IMAGE_DIR")
+	if root == "" {
+		root = "images"
+	}
+
+	return &localImageStore{root: root}
+}
+
+// path turns a key into a file path. Keys can't climb out of the root directory.
+func (s *localImageStore) path(key string) string {
+	return filepath.Join(s.root, filepath.FromSlash(path.Clean("/"+key)))
+}
+
+func (s *localImageStore) Put(key string, data []byte) error {
+	name := s.path(key)
+	err := os.MkdirAll(filepath.Dir(name), 0o755)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so a half written image is never served
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), name)
+}
+
+func (s *localImageStore) Open(key string) (io.ReadSeekCloser, time.Time, error) {
+	file, err := os.Open(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, time.Time{}, errImageNotFound
+	}
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, time.Time{}, err
+	}
+	if info.IsDir() {
+		file.Close()
+		return nil, time.Time{}, errImageNotFound
+	}
+
+	return file, info.ModTime(), nil
+}
+
+func (s *localImageStore) Delete(prefix string) error {
+	if path.Clean("/"+prefix) == "/" {
+		return errors.New("refusing to delete every image")
+	}
+
+	return os.RemoveAll(s.path(prefix))
+}
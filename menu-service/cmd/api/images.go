@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// maxImageBytes is the largest image file that can be uploaded
+	maxImageBytes = 5 << 20
+	// maxImagePixels stops small files that decode into huge images
+	maxImagePixels = 25_000_000
+)
+
+// thumbnailSizes are the thumbnails made for every image, each fitting in a square of
+// the given size. Images are never scaled up.
+var thumbnailSizes = []struct {
+	name string
+	size int
+}{
+	{"small", 160},
+	{"medium", 480},
+	{"large", 1024},
+}
+
+// imageExtensions are the supported image formats and their file extensions
+var imageExtensions = map[string]string{
+	"jpeg": "jpg",
+	"png":  "png",
+}
+
+var (
+	errUnsupportedImage = errors.New("images must be JPEG or PNG")
+	errImageTooLarge    = fmt.Errorf("images can be at most %d MB", maxImageBytes>>20)
+)
+
+// MenuItemImage holds the URLs of a menu item's image and its thumbnails
+type MenuItemImage struct {
+	URL        string            `json:"url"`
+	Thumbnails map[string]string `json:"thumbnails"`
+}
+
+// menuItemImage builds the image URLs of a menu item from where its images are stored
+func menuItemImage(key, format string) *MenuItemImage {
+	if key == "" {
+		return nil
+	}
+
+	ext := imageExtensions[format]
+	img := &MenuItemImage{
+		URL:        imageURL(fmt.Sprintf("%s/original.%s", key, ext)),
+		Thumbnails: map[string]string{},
+	}
+	for _, size := range thumbnailSizes {
+		img.Thumbnails[size.name] = imageURL(fmt.Sprintf("%s/%s.%s", key, size.name, ext))
+	}
+
+	return img
+}
+
+// imageURL is where an image is served, under IMAGE_BASE_URL when it is set, for
+// example to put a CDN in front of the menu service
+func imageURL(key string) string {
+	base := strings.TrimSuffix(os.Getenv("IMAGE_BASE_URL"), "/")
+	if base == "" {
+		base = "/images"
+	}
+
+	return base + "/" + key
+}
+
+// processedImage is an uploaded image encoded in each size it is served in
+type processedImage struct {
+	format string
+	hash   string
+	sizes  map[string][]byte
+}
+
+// processImage checks an uploaded image and makes its thumbnails. The original is
+// encoded again too, which drops any metadata such as the location a photo was taken.
+func processImage(data []byte) (processedImage, error) {
+	if len(data) > maxImageBytes {
+		return processedImage{}, errImageTooLarge
+	}
+
+	switch http.DetectContentType(data) {
+	case "image/jpeg", "image/png":
+	default:
+		return processedImage{}, errUnsupportedImage
+	}
+
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return processedImage{}, fmt.Errorf("%w: %v", errUnsupportedImage, err)
+	}
+	if config.Width*config.Height > maxImagePixels {
+		return processedImage{}, fmt.Errorf("images can be at most %d megapixels", maxImagePixels/1_000_000)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return processedImage{}, fmt.Errorf("%w: %v", errUnsupportedImage, err)
+	}
+
+	hash := sha256.Sum256(data)
+	processed := processedImage{
+		format: format,
+		hash:   hex.EncodeToString(hash[:8]),
+		sizes:  map[string][]byte{},
+	}
+
+	processed.sizes["original"], err = encodeImage(src, format)
+	if err != nil {
+		return processedImage{}, err
+	}
+
+	for _, size := range thumbnailSizes {
+		processed.sizes[size.name], err = encodeImage(fitImage(src, size.size), format)
+		if err != nil {
+			return processedImage{}, err
+		}
+	}
+
+	return processed, nil
+}
+
+// encodeImage encodes an image as JPEG or PNG
+func encodeImage(img image.Image, format string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	if format == "png" {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 85})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// fitImage scales an image down to fit in a square of the given size, keeping its
+// shape. Each new pixel is the average of the pixels it covers.
+func fitImage(src image.Image, size int) image.Image {
+	bounds := src.Bounds()
+	sw, sh := bounds.Dx(), bounds.Dy()
+	if sw <= size && sh <= size {
+		return src
+	}
+
+	dw, dh := size, sh*size/sw
+	if sh > sw {
+		dw, dh = sw*size/sh, size
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+
+	rgba := image.NewRGBA(image.Rect(0, 0, sw, sh))
+	draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*sh/dh, (y+1)*sh/dh
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*sw/dw, (x+1)*sw/dw
+
+			var sum [4]int
+			for sy := y0; sy < y1; sy++ {
+				row := rgba.Pix[sy*rgba.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					for i := 0; i < 4; i++ {
+						sum[i] += int(row[sx*4+i])
+					}
+				}
+			}
+
+			count := (x1 - x0) * (y1 - y0)
+			offset := y*dst.Stride + x*4
+			for i := 0; i < 4; i++ {
+				dst.Pix[offset+i] = uint8(sum[i] / count)
+			}
+		}
+	}
+
+	return dst
+}
+
+// menuItemImageKey returns where a menu item's images are stored, or "" if it has none
+func (app *Config) menuItemImageKey(id int) (string, error) {
+	var key string
+	err := app.DB.QueryRow(`select coalesce(image_key, '') from menu_items where id = $1`, id).Scan(&key)
+	return key, err
+}
+
+// saveMenuItemImage stores an image in every size and points the menu item at it,
+// then removes the image it replaces. Each image is stored under its own hash, so its
+// URLs never change and can be cached forever.
+func (app *Config) saveMenuItemImage(id int, img processedImage) error {
+	oldKey, err := app.menuItemImageKey(id)
+	if err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf("menu-items/%d/%s", id, img.hash)
+	ext := imageExtensions[img.format]
+	for name, data := range img.sizes {
+		err = app.Images.Put(fmt.Sprintf("%s/%s.%s", key, name, ext), data)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = app.DB.Exec(`update menu_items set image_key = $1, image_format = $2, updated_at = now() where id = $3`,
+		key, img.format, id)
+	if err != nil {
+		return err
+	}
+
+	if oldKey != "" && oldKey != key {
+		if err := app.Images.Delete(oldKey); err != nil {
+			log.Printf("Error removing old image %s: %v", oldKey, err)
+		}
+	}
+
+	return nil
+}
+
+// deleteMenuItemImage removes a menu item's image
+func (app *Config) deleteMenuItemImage(id int) error {
+	key, err := app.menuItemImageKey(id)
+	if err != nil {
+		return err
+	}
+	if key == "" {
+		return nil
+	}
+
+	_, err = app.DB.Exec(`update menu_items set image_key = null, image_format = null, updated_at = now() where id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return app.Images.Delete(key)
+}
+
+// UploadMenuItemImage sets the image of a menu item from a JPEG or PNG file sent as
+// the image field of a multipart form
+func (app *Config) UploadMenuItemImage(c *gin.Context) {
+	item, ok := app.menuItemFromParam(c)
+	if !ok {
+		return
+	}
+
+	// Leave room for the rest of the form around the file
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImageBytes+1<<20)
+
+	file, header, err := c.Request.FormFile("image")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			app.errorJSON(c, errImageTooLarge, http.StatusRequestEntityTooLarge)
+			return
+		}
+		app.errorJSON(c, errors.New("an image file is required in the image field"), http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	if header.Size > maxImageBytes {
+		app.errorJSON(c, errImageTooLarge, http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(file, maxImageBytes+1))
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	img, err := processImage(data)
+	switch {
+	case errors.Is(err, errImageTooLarge):
+		app.errorJSON(c, err, http.StatusRequestEntityTooLarge)
+		return
+	case errors.Is(err, errUnsupportedImage):
+		app.errorJSON(c, err, http.StatusUnsupportedMediaType)
+		return
+	case err != nil:
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	err = app.saveMenuItemImage(item.ID, img)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	updatedItem, err := app.getMenuItemByID(item.ID)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool     `json:"error"`
+		Message string   `json:"message"`
+		Data    MenuItem `json:"data"`
+	}{
+		Error:   false,
+		Message: "Menu item image uploaded",
+		Data:    updatedItem,
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+// DeleteMenuItemImage removes the image of a menu item
+func (app *Config) DeleteMenuItemImage(c *gin.Context) {
+	item, ok := app.menuItemFromParam(c)
+	if !ok {
+		return
+	}
+
+	if item.Image == nil {
+		app.errorJSON(c, errors.New("menu item has no image"), http.StatusNotFound)
+		return
+	}
+
+	err := app.deleteMenuItemImage(item.ID)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}{
+		Error:   false,
+		Message: "Menu item image deleted",
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+// ServeImage serves a stored image. Image URLs change whenever the image does, so
+// browsers and proxies may keep them for a year without checking back.
+func (app *Config) ServeImage(c *gin.Context) {
+	key := strings.TrimPrefix(c.Param("key"), "/")
+	if _, ok := imageContentTypes[path.Ext(key)]; !ok {
+		app.errorJSON(c, errImageNotFound, http.StatusNotFound)
+		return
+	}
+
+	file, modified, err := app.Images.Open(key)
+	if errors.Is(err, errImageNotFound) {
+		app.errorJSON(c, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+	defer file.Close()
+
+	etag := sha256.Sum256([]byte(key))
+	c.Header("Content-Type", imageContentTypes[path.Ext(key)])
+	c.Header("Cache-Control", "public, max-age=31536000, immutable")
+	c.Header("ETag", fmt.Sprintf(`"%x"`, etag[:8]))
+
+	// ServeContent answers conditional and range requests
+	http.ServeContent(c.Writer, c.Request, key, modified, file)
+}
+
+// imageContentTypes are the content types of the image files that are served
+var imageContentTypes = map[string]string{
+	".jpg": "image/jpeg",
+	".png": "image/png",
+}
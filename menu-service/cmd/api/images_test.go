@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testImage makes a solid image of the given size
+func testImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = 200
+	}
+	return img
+}
+
+func encodeTestImage(t *testing.T, img image.Image, format string) []byte {
+	var buf bytes.Buffer
+	var err error
+	if format == "png" {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestFitImage(t *testing.T) {
+	tests := []struct {
+		name                string
+		width, height, size int
+		wantW, wantH        int
+	}{
+		{"landscape", 1000, 500, 480, 480, 240},
+		{"portrait", 300, 1200, 160, 40, 160},
+		{"square", 2048, 2048, 1024, 1024, 1024},
+		{"already fits", 400, 300, 480, 400, 300},
+		{"never scaled up", 100, 100, 1024, 100, 100},
+		{"very thin", 4000, 3, 160, 160, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bounds := fitImage(testImage(tt.width, tt.height), tt.size).Bounds()
+			if bounds.Dx() != tt.wantW || bounds.Dy() != tt.wantH {
+				t.Errorf("fitImage() is %dx%d, want %dx%d", bounds.Dx(), bounds.Dy(), tt.wantW, tt.wantH)
+			}
+		})
+	}
+}
+
+func TestFitImageAverages(t *testing.T) {
+	// Black and white columns shrink to grey
+	src := image.NewRGBA(image.Rect(10, 10, 14, 12))
+	for y := 10; y < 12; y++ {
+		for x := 10; x < 14; x++ {
+			c := color.RGBA{A: 255}
+			if x%2 == 0 {
+				c = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+			}
+			src.Set(x, y, c)
+		}
+	}
+
+	dst := fitImage(src, 2)
+	if bounds := dst.Bounds(); bounds != image.Rect(0, 0, 2, 1) {
+		t.Fatalf("fitImage() bounds = %v, want 2x1 from the origin", bounds)
+	}
+	for x := 0; x < 2; x++ {
+		if got := dst.At(x, 0).(color.RGBA); got != (color.RGBA{R: 127, G: 127, B: 127, A: 255}) {
+			t.Errorf("pixel %d = %v, want grey", x, got)
+		}
+	}
+}
+
+// pngHeader returns the start of a PNG file claiming the given size, which is enough
+// for image.DecodeConfig
+func pngHeader(width, height uint32) []byte {
+	ihdr := make([]byte, 17)
+	copy(ihdr, "IHDR")
+	binary.BigEndian.PutUint32(ihdr[4:], width)
+	binary.BigEndian.PutUint32(ihdr[8:], height)
+	ihdr[12], ihdr[13] = 8, 6 // 8 bit RGBA
+
+	var buf bytes.Buffer
+	buf.WriteString("\x89PNG\r\n\x1a\n")
+	binary.Write(&buf, binary.BigEndian, uint32(13))
+	buf.Write(ihdr)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(ihdr))
+	return buf.Bytes()
+}
+
+func TestProcessImage(t *testing.T) {
+	data := encodeTestImage(t, testImage(1200, 600), "png")
+
+	processed, err := processImage(data)
+	if err != nil {
+		t.Fatalf("processImage() = %v", err)
+	}
+	if processed.format != "png" || len(processed.hash) != 16 {
+		t.Errorf("processImage() format = %q, hash = %q", processed.format, processed.hash)
+	}
+
+	want := map[string][2]int{"original": {1200, 600}, "small": {160, 80}, "medium": {480, 240}, "large": {1024, 512}}
+	got := map[string][2]int{}
+	for name, encoded := range processed.sizes {
+		config, format, err := image.DecodeConfig(bytes.NewReader(encoded))
+		if err != nil || format != "png" {
+			t.Fatalf("%s isn't a PNG: %v", name, err)
+		}
+		got[name] = [2]int{config.Width, config.Height}
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("processImage() sizes = %v, want %v", got, want)
+	}
+
+	// The same upload always gets the same hash
+	again, _ := processImage(data)
+	if again.hash != processed.hash {
+		t.Error("processing the same image twice gave different hashes")
+	}
+
+	jpg, err := processImage(encodeTestImage(t, testImage(100, 100), "jpeg"))
+	if err != nil || jpg.format != "jpeg" {
+		t.Errorf("processImage(jpeg) = %q, %v", jpg.format, err)
+	}
+}
+
+func TestProcessImageLimits(t *testing.T) {
+	gif := []byte("GIF89a\x01\x00\x01\x00\x00\x00\x00;")
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr error
+		wantMsg string
+	}{
+		{"too many bytes", make([]byte, maxImageBytes+1), errImageTooLarge, ""},
+		{"gif", gif, errUnsupportedImage, ""},
+		{"not an image", []byte("<svg xmlns='http://www.w3.org/2000/svg'/>"), errUnsupportedImage, ""},
+		{"truncated png", pngHeader(10, 10)[:20], errUnsupportedImage, ""},
+		{"too many pixels", pngHeader(5001, 5000), nil, "images can be at most 25 megapixels"},
+		{"header without pixels", pngHeader(10, 10), errUnsupportedImage, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := processImage(tt.data)
+			if err == nil {
+				t.Fatal("processImage() succeeded")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("processImage() = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantMsg != "" && err.Error() != tt.wantMsg {
+				t.Errorf("processImage() = %v, want %q", err, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestMenuItemImage(t *testing.T) {
+	if got := menuItemImage("", "png"); got != nil {
+		t.Errorf("menuItemImage() without a key = %+v, want nil", got)
+	}
+
+	t.Setenv("IMAGE_BASE_URL", "https://cdn.example.com/menu/")
+	want := &MenuItemImage{
+		URL: "https://cdn.example.com/menu/menu-items/7/abc/original.jpg",
+		Thumbnails: map[string]string{
+			"small":  "https://cdn.example.com/menu/menu-items/7/abc/small.jpg",
+			"medium": "https://cdn.example.com/menu/menu-items/7/abc/medium.jpg",
+			"large":  "https://cdn.example.com/menu/menu-items/7/abc/large.jpg",
+		},
+	}
+	if got := menuItemImage("menu-items/7/abc", "jpeg"); !reflect.DeepEqual(got, want) {
+		t.Errorf("menuItemImage() = %+v, want %+v", got, want)
+	}
+
+	t.Setenv("IMAGE_BASE_URL", "")
+	if got := imageURL("menu-items/7/abc/small.png"); got != "/images/menu-items/7/abc/small.png" {
+		t.Errorf("imageURL() = %q", got)
+	}
+}
+
+func TestLocalImageStore(t *testing.T) {
+	root := t.TempDir()
+	store := &localImageStore{root: filepath.Join(root, "images")}
+
+	if err := store.Put("menu-items/7/abc/small.png", []byte("small")); err != nil {
+		t.Fatalf("Put() = %v", err)
+	}
+
+	file, _, err := store.Open("menu-items/7/abc/small.png")
+	if err != nil {
+		t.Fatalf("Open() = %v", err)
+	}
+	data, _ := io.ReadAll(file)
+	file.Close()
+	if string(data) != "small" {
+		t.Errorf("Open() read %q", data)
+	}
+
+	// Keys can't reach outside the store
+	if err := os.WriteFile(filepath.Join(root, "secret.txt"), []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := store.Open("../secret.txt"); !errors.Is(err, errImageNotFound) {
+		t.Errorf("Open(../secret.txt) = %v, want %v", err, errImageNotFound)
+	}
+	if _, _, err := store.Open("menu-items/7"); !errors.Is(err, errImageNotFound) {
+		t.Errorf("Open() of a directory = %v, want %v", err, errImageNotFound)
+	}
+
+	for _, prefix := range []string{"", "/", ".."} {
+		if err := store.Delete(prefix); err == nil {
+			t.Errorf("Delete(%q) succeeded", prefix)
+		}
+	}
+
+	if err := store.Delete("menu-items/7"); err != nil {
+		t.Fatalf("Delete() = %v", err)
+	}
+	if _, _, err := store.Open("menu-items/7/abc/small.png"); !errors.Is(err, errImageNotFound) {
+		t.Errorf("Open() after Delete() = %v, want %v", err, errImageNotFound)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const inventoryServiceURL = "http://0.0.0.0:8003"
+
+// InventoryItem is the part of an inventory-service item that recipes need
+type InventoryItem struct {
+	ID       int    `json:"id"`
+	ItemName string `json:"item_name"`
+	Quantity int    `json:"quantity"`
+	Unit     string `json:"unit"`
+	// Allergens and Nutrition describe the ingredient. Nutrition is given per 100 g,
+	// per 100 ml or per item, depending on the unit.
+	Allergens []string   `json:"allergens"`
+	Nutrition *Nutrition `json:"nutrition"`
+}
+
+var errInventoryUnavailable = errors.New("unable to reach inventory service")
+
+// getInventory fetches every inventory item from the inventory service, by id
+func (app *Config) getInventory() (map[int]InventoryItem, error) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	response, err := client.Get(inventoryServiceURL + "/inventory")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errInventoryUnavailable, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%w: status %d", errInventoryUnavailable, response.StatusCode)
+	}
+
+	var result struct {
+		Data []InventoryItem `json:"data"`
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+
+	inventory := make(map[int]InventoryItem, len(result.Data))
+	for _, item := range result.Data {
+		inventory[item.ID] = item
+	}
+
+	return inventory, nil
+}
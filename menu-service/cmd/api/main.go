@@ -20,17 +20,61 @@ var counts int64
 
 type Config struct {
 	DB     *sql.DB
+	Images imageStore
 	router *gin.Engine
 }
 
 type MenuItem struct {
 	ID          int     `json:"id"`
+	SKU         string  `json:"sku,omitempty"`
 	Name        string  `json:"name"`
 	Description string  `json:"description"`
 	Price       float64 `json:"price"`
-	Category    string  `json:"category"`
-	CreatedAt   string  `json:"created_at"`
-	UpdatedAt   string  `json:"updated_at"`
+	// RegularPrice is the usual price, set when a price override applies at the time
+	// asked for
+	RegularPrice *float64 `json:"regular_price,omitempty"`
+	CategoryID   int      `json:"category_id"`
+	Category     string   `json:"category"`
+	// Availability is whether the item can be ordered: available, sold_out or hidden. It
+	// combines AvailabilitySetting, set by staff, with InStock, which is worked out from
+	// the item's recipe and the inventory.
+	Availability        string `json:"availability"`
+	AvailabilitySetting string `json:"availability_setting"`
+	InStock             bool   `json:"in_stock"`
+	// DietaryTags are any of vegan, vegetarian, gluten-free and halal
+	DietaryTags []string `json:"dietary_tags"`
+	// Allergens are declared by staff, and RecipeAllergens are found in the ingredients
+	// of the item's recipe. ContainsAllergens combines them, and is what customers see.
+	Allergens         []string `json:"allergens"`
+	RecipeAllergens   []string `json:"recipe_allergens"`
+	ContainsAllergens []string `json:"contains_allergens"`
+	// Nutrition is declared by staff for one serving and wins over RecipeNutrition,
+	// which is added up from the recipe when every ingredient has nutrition facts
+	Nutrition       *Nutrition `json:"nutrition"`
+	RecipeNutrition *Nutrition `json:"recipe_nutrition"`
+	// Image is set once an image has been uploaded for the item
+	Image *MenuItemImage `json:"image,omitempty"`
+	// Popularity is the number of units sold, kept up to date by the order service
+	Popularity int `json:"popularity"`
+	// OptionGroups are always returned. When creating or updating an item they replace
+	// the item's groups, unless left out.
+	OptionGroups []OptionGroup `json:"option_groups"`
+	// Locale is the locale the name and description are in, when they are read in the
+	// language the request asked for
+	Locale string `json:"locale,omitempty"`
+	// Draft is set when the item is shown with changes that haven't been published yet
+	Draft bool `json:"draft,omitempty"`
+	// ArchivedAt is set once the item is deleted. Archived items are left off the menu
+	// but can still be looked up by id.
+	ArchivedAt *string `json:"archived_at,omitempty"`
+	CreatedAt  string  `json:"created_at"`
+	UpdatedAt  string  `json:"updated_at"`
+}
+
+// Sale is a quantity of a menu item sold, reported by the order service
+type Sale struct {
+	MenuItemID int `json:"menu_item_id"`
+	Quantity   int `json:"quantity"`
 }
 
 func main() {
@@ -44,7 +88,8 @@ func main() {
 
 	// Set up application config
 	app := Config{
-		DB: conn,
+		DB:     conn,
+		Images: newLocalImageStore(),
 	}
 
 	// Set up Gin router with middleware
@@ -60,6 +105,9 @@ func main() {
 	}))
 	app.router = router
 
+	// Publish scheduled menu changes in the background
+	go app.schedulePublishing(time.Minute)
+
 	// Set up routes
 	app.setupRoutes()
 
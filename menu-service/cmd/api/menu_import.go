@@ -0,0 +1,487 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxImportSize limits the size of an import file
+const maxImportSize = 10 << 20
+
+// menuCSVColumns are the columns of an exported CSV file. An import file can have any
+// of them, in any order; list values are separated by "|".
+var menuCSVColumns = []string{"sku", "name", "description", "price", "category", "availability", "dietary_tags", "allergens"}
+
+var errSKUTaken = errors.New("sku is already used by another menu item")
+
+// MenuImportRow is one item in an import or export file. Fields left out of an import
+// row are left as they are on an existing item.
+type MenuImportRow struct {
+	SKU          *string   `json:"sku,omitempty"`
+	Name         *string   `json:"name,omitempty"`
+	Description  *string   `json:"description,omitempty"`
+	Price        *float64  `json:"price,omitempty"`
+	Category     *string   `json:"category,omitempty"`
+	Availability *string   `json:"availability,omitempty"`
+	DietaryTags  *[]string `json:"dietary_tags,omitempty"`
+	Allergens    *[]string `json:"allergens,omitempty"`
+}
+
+// ImportResult is what an import did, or would do, with one row. Rows are numbered
+// from 1, not counting a CSV header.
+type ImportResult struct {
+	Row        int    `json:"row"`
+	Action     string `json:"action"`
+	MenuItemID int    `json:"menu_item_id,omitempty"`
+	Name       string `json:"name,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// ImportReport sums up an import
+type ImportReport struct {
+	DryRun  bool           `json:"dry_run"`
+	Applied bool           `json:"applied"`
+	Created int            `json:"created"`
+	Updated int            `json:"updated"`
+	Errors  int            `json:"errors"`
+	Rows    []ImportResult `json:"rows"`
+}
+
+// skuTaken reports whether a SKU belongs to a menu item other than exceptID
+func skuTaken(db queryRower, sku string, exceptID int) (bool, error) {
+	sku = strings.TrimSpace(sku)
+	if sku == "" {
+		return false, nil
+	}
+
+	var taken bool
+	err := db.QueryRow(`select exists (select 1 from menu_items where sku = $1 and id <> $2)`, sku, exceptID).Scan(&taken)
+	return taken, err
+}
+
+// splitList splits a CSV list value such as "vegan|gluten-free"
+func splitList(value string) []string {
+	list := []string{}
+	for _, part := range strings.Split(value, "|") {
+		if part = strings.TrimSpace(part); part != "" {
+			list = append(list, part)
+		}
+	}
+	return list
+}
+
+// parseCSVImport reads import rows from CSV with a header row
+func parseCSVImport(r io.Reader) ([]MenuImportRow, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("import file is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[string]bool, len(menuCSVColumns))
+	for _, column := range menuCSVColumns {
+		known[column] = true
+	}
+	for i, column := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(column))
+		if !known[header[i]] {
+			return nil, fmt.Errorf("unknown column: %s", column)
+		}
+	}
+
+	rows := []MenuImportRow{}
+	for line := 2; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var row MenuImportRow
+		for i, column := range header {
+			value := strings.TrimSpace(record[i])
+			switch column {
+			case "sku":
+				row.SKU = &value
+			case "name":
+				row.Name = &value
+			case "description":
+				row.Description = &value
+			case "price":
+				if value == "" {
+					continue
+				}
+				price, err := strconv.ParseFloat(value, 64)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: invalid price %q", line, value)
+				}
+				row.Price = &price
+			case "category":
+				row.Category = &value
+			case "availability":
+				if value != "" {
+					row.Availability = &value
+				}
+			case "dietary_tags":
+				tags := splitList(value)
+				row.DietaryTags = &tags
+			case "allergens":
+				allergens := splitList(value)
+				row.Allergens = &allergens
+			}
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// readImportRows reads an import file, as CSV or JSON depending on its content type or
+// the format query parameter
+func readImportRows(c *gin.Context) ([]MenuImportRow, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxImportSize))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read import file: %w", err)
+	}
+
+	format := c.Query("format")
+	if format == "" {
+		format = "json"
+		if strings.Contains(c.ContentType(), "csv") {
+			format = "csv"
+		}
+	}
+
+	switch format {
+	case "csv":
+		return parseCSVImport(bytes.NewReader(body))
+	case "json":
+		var rows []MenuImportRow
+		if err := json.Unmarshal(body, &rows); err != nil {
+			return nil, fmt.Errorf("invalid JSON import file: %w", err)
+		}
+		return rows, nil
+	default:
+		return nil, fmt.Errorf("unknown format: %s", format)
+	}
+}
+
+// findImportMatch finds the menu item an import row updates: the item with its SKU, or
+// failing that, the item on the menu with its name
+func findImportMatch(tx *sql.Tx, row MenuImportRow) (int, error) {
+	var id int
+	var err error
+
+	switch {
+	case row.SKU != nil && strings.TrimSpace(*row.SKU) != "":
+		err = tx.QueryRow(`select id from menu_items where sku = $1`, strings.TrimSpace(*row.SKU)).Scan(&id)
+		if !errors.Is(err, sql.ErrNoRows) || row.Name == nil {
+			break
+		}
+		fallthrough
+	case row.Name != nil && strings.TrimSpace(*row.Name) != "":
+		err = tx.QueryRow(`select id from menu_items where lower(name) = lower($1) and archived_at is null
+			order by id limit 1`, strings.TrimSpace(*row.Name)).Scan(&id)
+	default:
+		return 0, errors.New("each row needs a sku or a name")
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	return id, err
+}
+
+// importRow creates or updates the menu item for one row within the import transaction
+func (app *Config) importRow(tx *sql.Tx, row MenuImportRow, actor string, result *ImportResult) error {
+	id, err := findImportMatch(tx, row)
+	if err != nil {
+		return err
+	}
+
+	item := MenuItem{AvailabilitySetting: availabilityAvailable}
+	if id != 0 {
+		// Publishing a pending draft would overwrite the imported values
+		if err := lockMenuItem(tx, id); err != nil {
+			return err
+		}
+		if err := refuseDrafted(tx, id); err != nil {
+			return err
+		}
+
+		item, err = scanMenuItem(tx.QueryRow(menuItemSelect+` where m.id = $1`, id))
+		if err != nil {
+			return err
+		}
+	}
+
+	if row.SKU != nil {
+		item.SKU = strings.TrimSpace(*row.SKU)
+	}
+	if row.Name != nil {
+		item.Name = strings.TrimSpace(*row.Name)
+	}
+	if row.Description != nil {
+		item.Description = *row.Description
+	}
+	if row.Price != nil {
+		item.Price = *row.Price
+	}
+	if row.Category != nil {
+		item.Category = *row.Category
+	}
+	if row.Availability != nil {
+		item.AvailabilitySetting = *row.Availability
+	}
+	if row.DietaryTags != nil {
+		item.DietaryTags = *row.DietaryTags
+	}
+	if row.Allergens != nil {
+		item.Allergens = *row.Allergens
+	}
+	result.Name = item.Name
+
+	if item.Name == "" || item.Price <= 0 {
+		return errors.New("name and a price above zero are required")
+	}
+	if !validAvailability[item.AvailabilitySetting] {
+		return fmt.Errorf("unknown availability: %s", item.AvailabilitySetting)
+	}
+	if err := validateDietaryInfo(&item); err != nil {
+		return err
+	}
+
+	if taken, err := skuTaken(tx, item.SKU, id); err != nil || taken {
+		if err == nil {
+			err = errSKUTaken
+		}
+		return err
+	}
+
+	if id == 0 || row.Category != nil {
+		if strings.TrimSpace(item.Category) == "" {
+			return errors.New("category is required")
+		}
+		category, err := findOrCreateCategory(tx, item.Category)
+		if err != nil {
+			return err
+		}
+		item.CategoryID = category.ID
+		item.Category = category.Name
+	}
+
+	if id == 0 {
+		result.Action = "create"
+		result.MenuItemID, err = insertMenuItemRow(tx, item)
+		if err != nil {
+			return err
+		}
+		return recordVersion(tx, result.MenuItemID, changeCreated, actor, nil)
+	}
+
+	// Items without a history get their state from before the import as the first version
+	err = ensureVersioned(tx, id)
+	if err != nil {
+		return err
+	}
+
+	result.Action = "update"
+	result.MenuItemID = id
+	err = updateMenuItemRow(tx, item)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`update menu_items set availability = $1 where id = $2`, item.AvailabilitySetting, id)
+	if err != nil {
+		return err
+	}
+
+	return recordVersion(tx, id, changeUpdated, actor, nil)
+}
+
+// importMenu creates and updates menu items from import rows in a single transaction.
+// Nothing is applied if any row fails, or on a dry run; either way every row is
+// reported.
+func (app *Config) importMenu(rows []MenuImportRow, dryRun bool, actor string) (ImportReport, error) {
+	report := ImportReport{DryRun: dryRun, Rows: []ImportResult{}}
+
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return report, err
+	}
+	defer tx.Rollback()
+
+	for i, row := range rows {
+		result := ImportResult{Row: i + 1}
+
+		// A savepoint lets later rows carry on after a row fails
+		if _, err := tx.Exec(`savepoint import_row`); err != nil {
+			return report, err
+		}
+
+		err := app.importRow(tx, row, actor, &result)
+		if err != nil {
+			if _, err := tx.Exec(`rollback to savepoint import_row`); err != nil {
+				return report, err
+			}
+			result.Action = "error"
+			result.Error = err.Error()
+			report.Errors++
+		} else if result.Action == "create" {
+			report.Created++
+		} else {
+			report.Updated++
+		}
+
+		report.Rows = append(report.Rows, result)
+	}
+
+	if dryRun || report.Errors > 0 {
+		return report, nil
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return report, err
+	}
+	report.Applied = true
+
+	return report, nil
+}
+
+// exportRow turns a menu item into an export row
+func exportRow(item MenuItem) MenuImportRow {
+	sku, name, description, price, category, availability :=
+		item.SKU, item.Name, item.Description, item.Price, item.Category, item.AvailabilitySetting
+	tags, allergens := item.DietaryTags, item.Allergens
+	if tags == nil {
+		tags = []string{}
+	}
+	if allergens == nil {
+		allergens = []string{}
+	}
+
+	return MenuImportRow{
+		SKU:          &sku,
+		Name:         &name,
+		Description:  &description,
+		Price:        &price,
+		Category:     &category,
+		Availability: &availability,
+		DietaryTags:  &tags,
+		Allergens:    &allergens,
+	}
+}
+
+// ImportMenu creates and updates menu items from a CSV or JSON file. Rows are matched to
+// existing items by SKU, then by name. With dry_run=true the import is checked and
+// reported but not applied.
+func (app *Config) ImportMenu(c *gin.Context) {
+	dryRun, _ := strconv.ParseBool(c.Query("dry_run"))
+
+	rows, err := readImportRows(c)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+	if len(rows) == 0 {
+		app.errorJSON(c, errors.New("import file has no rows"), http.StatusBadRequest)
+		return
+	}
+
+	report, err := app.importMenu(rows, dryRun, requestActor(c))
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	status, message := http.StatusOK, "Menu imported"
+	switch {
+	case report.Errors > 0:
+		status, message = http.StatusUnprocessableEntity, "Menu import has errors; nothing was applied"
+	case dryRun:
+		message = "Menu import checked; nothing was applied"
+	}
+
+	if report.Applied {
+		app.refreshAvailability()
+	}
+
+	payload := struct {
+		Error   bool         `json:"error"`
+		Message string       `json:"message"`
+		Data    ImportReport `json:"data"`
+	}{
+		Error:   report.Errors > 0,
+		Message: message,
+		Data:    report,
+	}
+
+	app.writeJSON(c, status, payload)
+}
+
+// ExportMenu downloads every menu item that isn't archived, hidden ones included, as
+// CSV or JSON (format=csv or format=json) in the format ImportMenu reads
+func (app *Config) ExportMenu(c *gin.Context) {
+	format := c.DefaultQuery("format", "json")
+	if format != "csv" && format != "json" {
+		app.errorJSON(c, fmt.Errorf("unknown format: %s", format), http.StatusBadRequest)
+		return
+	}
+
+	items, _, _, err := app.listMenuItems(&menuListQuery{Sort: "name", IncludeHidden: true})
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	rows := make([]MenuImportRow, len(items))
+	for i, item := range items {
+		rows[i] = exportRow(item)
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="menu.%s"`, format))
+
+	if format == "json" {
+		c.JSON(http.StatusOK, rows)
+		return
+	}
+
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	writer.Write(menuCSVColumns)
+	for _, row := range rows {
+		writer.Write([]string{
+			*row.SKU,
+			*row.Name,
+			*row.Description,
+			strconv.FormatFloat(*row.Price, 'f', 2, 64),
+			*row.Category,
+			*row.Availability,
+			strings.Join(*row.DietaryTags, "|"),
+			strings.Join(*row.Allergens, "|"),
+		})
+	}
+	writer.Flush()
+
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func stringRef(v string) *string { return &v }
+
+func listRef(v ...string) *[]string {
+	if v == nil {
+		v = []string{}
+	}
+	return &v
+}
+
+func TestSplitList(t *testing.T) {
+	tests := []struct {
+		value string
+		want  []string
+	}{
+		{"vegan|gluten-free", []string{"vegan", "gluten-free"}},
+		{" vegan | | halal ", []string{"vegan", "halal"}},
+		{"", []string{}},
+	}
+
+	for _, tt := range tests {
+		if got := splitList(tt.value); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("splitList(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestParseCSVImport(t *testing.T) {
+	tests := []struct {
+		name    string
+		csv     string
+		want    []MenuImportRow
+		wantErr string
+	}{
+		{
+			name: "every column",
+			csv: "sku,name,description,price,category,availability,dietary_tags,allergens\n" +
+				"LAT-1,Latte,\"Espresso, steamed milk\",3.40,Coffee,sold_out,vegetarian|gluten-free,milk\n",
+			want: []MenuImportRow{{
+				SKU:          stringRef("LAT-1"),
+				Name:         stringRef("Latte"),
+				Description:  stringRef("Espresso, steamed milk"),
+				Price:        floatRef(3.4),
+				Category:     stringRef("Coffee"),
+				Availability: stringRef("sold_out"),
+				DietaryTags:  listRef("vegetarian", "gluten-free"),
+				Allergens:    listRef("milk"),
+			}},
+		},
+		{
+			name: "columns left out stay unset",
+			csv:  " Name , PRICE\nLatte,3.5\nMocha,\n",
+			want: []MenuImportRow{
+				{Name: stringRef("Latte"), Price: floatRef(3.5)},
+				{Name: stringRef("Mocha")},
+			},
+		},
+		{
+			name: "blank lists clear them, blank availability leaves it",
+			csv:  "name,availability,dietary_tags,allergens\nLatte,,,\n",
+			want: []MenuImportRow{{Name: stringRef("Latte"), DietaryTags: listRef(), Allergens: listRef()}},
+		},
+		{
+			name: "header only",
+			csv:  "name,price\n",
+			want: []MenuImportRow{},
+		},
+		{
+			name:    "empty file",
+			csv:     "",
+			wantErr: "import file is empty",
+		},
+		{
+			name:    "unknown column",
+			csv:     "name,cost\nLatte,3.5\n",
+			wantErr: "unknown column: cost",
+		},
+		{
+			name:    "bad price",
+			csv:     "name,price\nLatte,3.5\nMocha,three\n",
+			wantErr: `line 3: invalid price "three"`,
+		},
+		{
+			name:    "wrong number of fields",
+			csv:     "name,price\nLatte\n",
+			wantErr: "record on line 2: wrong number of fields",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := parseCSVImport(strings.NewReader(tt.csv))
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("parseCSVImport() = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseCSVImport() = %v", err)
+			}
+			if !reflect.DeepEqual(rows, tt.want) {
+				t.Errorf("parseCSVImport() = %+v, want %+v", rows, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadImportRows(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name        string
+		target      string
+		contentType string
+		body        string
+		wantNames   []string
+		wantErr     string
+	}{
+		{"json by default", "/menu/import", "", `[{"name":"Latte"},{"name":"Mocha","price":3.6}]`, []string{"Latte", "Mocha"}, ""},
+		{"csv by content type", "/menu/import", "text/csv", "name\nLatte\n", []string{"Latte"}, ""},
+		{"format wins over content type", "/menu/import?format=csv", "application/json", "name\nLatte\n", []string{"Latte"}, ""},
+		{"unknown format", "/menu/import?format=xml", "", "<menu/>", nil, "unknown format: xml"},
+		{"bad json", "/menu/import", "application/json", `{"name":"Latte"}`, nil, "invalid JSON import file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("POST", tt.target, strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				c.Request.Header.Set("Content-Type", tt.contentType)
+			}
+
+			rows, err := readImportRows(c)
+			if tt.wantErr != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+					t.Fatalf("readImportRows() = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("readImportRows() = %v", err)
+			}
+
+			var names []string
+			for _, row := range rows {
+				names = append(names, *row.Name)
+			}
+			if !reflect.DeepEqual(names, tt.wantNames) {
+				t.Errorf("read the rows %q, want %q", names, tt.wantNames)
+			}
+		})
+	}
+}
+
+func TestExportRow(t *testing.T) {
+	item := MenuItem{
+		ID:                  7,
+		SKU:                 "LAT-1",
+		Name:                "Latte",
+		Description:         "Espresso, steamed milk",
+		Price:               3.4,
+		Category:            "Coffee",
+		Availability:        availabilitySoldOut,
+		AvailabilitySetting: availabilityAvailable,
+		DietaryTags:         []string{"vegetarian"},
+	}
+
+	// Exports carry the availability set by staff, and empty lists rather than nulls,
+	// so that importing an export changes nothing
+	want := MenuImportRow{
+		SKU:          stringRef("LAT-1"),
+		Name:         stringRef("Latte"),
+		Description:  stringRef("Espresso, steamed milk"),
+		Price:        floatRef(3.4),
+		Category:     stringRef("Coffee"),
+		Availability: stringRef(availabilityAvailable),
+		DietaryTags:  listRef("vegetarian"),
+		Allergens:    listRef(),
+	}
+	if got := exportRow(item); !reflect.DeepEqual(got, want) {
+		t.Errorf("exportRow() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	defaultMenuPageSize = 50
+	maxMenuPageSize     = 200
+)
+
+// menuSearchDocument is the text searched by q. It must match the expression of the
+// menu_items_search_idx index for the index to be used.
+const menuSearchDocument = `to_tsvector('english', m.name || ' ' || coalesce(m.description, ''))`
+
+// menuSortColumns maps the sort options accepted by the listing to their column and
+// the SQL type used to compare cursor values
+var menuSortColumns = map[string]struct {
+	column string
+	cast   string
+}{
+	"name":       {"m.name", "text"},
+	"price":      {"m.price", "numeric"},
+	"popularity": {"m.popularity", "integer"},
+}
+
+// menuListQuery is a parsed request for a page of menu items
+type menuListQuery struct {
+	Search   string
+	Category string
+	MinPrice *float64
+	MaxPrice *float64
+	// Availability filters on the item's availability state. Hidden items are left out
+	// unless they are asked for or IncludeHidden is set.
+	Availability  string
+	IncludeHidden bool
+	Dietary       []string
+	// ExcludeAllergens leaves out items containing any of these allergens, whether
+	// declared or found in the recipe
+	ExcludeAllergens []string
+	Sort             string
+	Descending       bool
+	// Limit is the page size; 0 returns every matching item
+	Limit  int
+	Cursor *menuCursor
+	// At asks for the menu being served at a moment: items on menus that aren't on
+	// are left out, and prices include any overrides. Filters and sorting use the
+	// regular price.
+	At *time.Time
+	// Draft shows items with their unpublished changes. Filters and sorting use the
+	// published item.
+	Draft bool
+}
+
+// menuCursor marks the last item on a page: the value of the sort column and the id,
+// which breaks ties between items with the same value
+type menuCursor struct {
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+// encode turns a cursor into the opaque string handed to clients
+func (mc menuCursor) encode() string {
+	data, _ := json.Marshal(mc)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeMenuCursor(s string) (*menuCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+
+	var cursor menuCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+
+	return &cursor, nil
+}
+
+// parseMenuListQuery reads the filters, sort and page from the query string
+func parseMenuListQuery(values url.Values) (*menuListQuery, error) {
+	q := &menuListQuery{
+		Search:   strings.TrimSpace(values.Get("q")),
+		Category: strings.TrimSpace(values.Get("category")),
+		Sort:     values.Get("sort"),
+		Limit:    defaultMenuPageSize,
+	}
+
+	for _, param := range []struct {
+		name  string
+		value **float64
+	}{{"min_price", &q.MinPrice}, {"max_price", &q.MaxPrice}} {
+		if value := values.Get(param.name); value != "" {
+			price, err := strconv.ParseFloat(value, 64)
+			if err != nil || price < 0 {
+				return nil, fmt.Errorf("%s must be a non-negative number", param.name)
+			}
+			*param.value = &price
+		}
+	}
+	if q.MinPrice != nil && q.MaxPrice != nil && *q.MinPrice > *q.MaxPrice {
+		return nil, errors.New("min_price cannot be greater than max_price")
+	}
+
+	q.Availability = values.Get("availability")
+	if q.Availability != "" && !validAvailability[q.Availability] {
+		return nil, fmt.Errorf("unknown availability: %s", q.Availability)
+	}
+
+	// available=true is short for availability=available
+	if value := values.Get("available"); value != "" {
+		available, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, errors.New("available must be true or false")
+		}
+		if available {
+			q.Availability = availabilityAvailable
+		} else if q.Availability == "" {
+			q.Availability = availabilitySoldOut
+		}
+	}
+
+	if value := values.Get("include_hidden"); value != "" {
+		includeHidden, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, errors.New("include_hidden must be true or false")
+		}
+		q.IncludeHidden = includeHidden
+	}
+
+	if value := values.Get("draft"); value != "" {
+		draft, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, errors.New("draft must be true or false")
+		}
+		q.Draft = draft
+	}
+
+	// Dietary tags can be repeated or comma separated: dietary=vegan,gluten-free
+	for _, value := range values["dietary"] {
+		for _, tag := range strings.Split(value, ",") {
+			if tag = normalizeTag(tag); tag != "" {
+				q.Dietary = append(q.Dietary, tag)
+			}
+		}
+	}
+
+	// Allergens work the same way: exclude_allergens=milk,peanuts
+	var allergens []string
+	for _, value := range values["exclude_allergens"] {
+		allergens = append(allergens, strings.Split(value, ",")...)
+	}
+	excluded, err := normalizeAllergens(allergens)
+	if err != nil {
+		return nil, err
+	}
+	if len(excluded) > 0 {
+		q.ExcludeAllergens = excluded
+	}
+
+	// A leading "-" sorts descending, e.g. sort=-popularity
+	if strings.HasPrefix(q.Sort, "-") {
+		q.Sort = q.Sort[1:]
+		q.Descending = true
+	}
+	if q.Sort == "" {
+		q.Sort = "name"
+	}
+	if _, ok := menuSortColumns[q.Sort]; !ok {
+		return nil, fmt.Errorf("cannot sort by %s", q.Sort)
+	}
+	if order := values.Get("order"); order != "" {
+		if order != "asc" && order != "desc" {
+			return nil, errors.New("order must be asc or desc")
+		}
+		q.Descending = order == "desc"
+	}
+
+	if value := values.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxMenuPageSize {
+			return nil, fmt.Errorf("limit must be between 1 and %d", maxMenuPageSize)
+		}
+		q.Limit = limit
+	}
+
+	if value := values.Get("cursor"); value != "" {
+		cursor, err := decodeMenuCursor(value)
+		if err != nil {
+			return nil, err
+		}
+		q.Cursor = cursor
+	}
+
+	q.At, err = parseAt(values.Get("at"))
+	if err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// normalizeTag lowercases a dietary tag and hyphenates it, so "Gluten Free" and
+// "gluten-free" are the same tag
+func normalizeTag(tag string) string {
+	return slugify(tag)
+}
+
+// normalizeTags normalizes a list of tags, dropping blanks and duplicates
+func normalizeTags(tags []string) []string {
+	seen := make(map[string]bool, len(tags))
+	normalized := []string{}
+	for _, tag := range tags {
+		tag = normalizeTag(tag)
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+
+	return normalized
+}
+
+// categoryFilterID finds the category named by the category filter, which can be an
+// id or a slug
+func (app *Config) categoryFilterID(category string) (int, error) {
+	if id, err := strconv.Atoi(category); err == nil {
+		return id, nil
+	}
+
+	var id int
+	err := app.DB.QueryRow(`select id from menu_categories where slug = $1`, slugify(category)).Scan(&id)
+	if err != nil {
+		return 0, errCategoryNotFound
+	}
+
+	return id, nil
+}
+
+// listMenuItems returns one page of menu items matching the query, the total number of
+// matching items and the cursor for the next page ("" on the last page)
+func (app *Config) listMenuItems(q *menuListQuery) ([]MenuItem, int, string, error) {
+	conditions := []string{`m.archived_at is null`}
+	var args []any
+
+	// where adds a condition, replacing ? with the placeholder for arg
+	where := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, strings.ReplaceAll(condition, "?", "$"+strconv.Itoa(len(args))))
+	}
+
+	if q.Search != "" {
+		where(menuSearchDocument+` @@ websearch_to_tsquery('english', ?)`, q.Search)
+	}
+	if q.Category != "" {
+		categoryID, err := app.categoryFilterID(q.Category)
+		if err != nil {
+			return nil, 0, "", err
+		}
+
+		// Items in subcategories belong to the category too
+		where(`m.category_id in (
+			with recursive tree as (
+				select id from menu_categories where id = ?
+				union all
+				select c.id from menu_categories c join tree t on c.parent_id = t.id
+			)
+			select id from tree)`, categoryID)
+	}
+	if q.MinPrice != nil {
+		where(`m.price >= ?`, *q.MinPrice)
+	}
+	if q.MaxPrice != nil {
+		where(`m.price <= ?`, *q.MaxPrice)
+	}
+	switch {
+	case q.Availability != "":
+		where(menuItemAvailability+` = ?`, q.Availability)
+	case !q.IncludeHidden:
+		conditions = append(conditions, `m.availability <> 'hidden'`)
+	}
+	if len(q.Dietary) > 0 {
+		where(`m.dietary_tags @> ?`, pq.Array(q.Dietary))
+	}
+	if len(q.ExcludeAllergens) > 0 {
+		where(`not (m.allergens || m.recipe_allergens) && ?`, pq.Array(q.ExcludeAllergens))
+	}
+
+	var served *schedule
+	if q.At != nil {
+		var err error
+		served, err = app.loadSchedule()
+		if err != nil {
+			return nil, 0, "", err
+		}
+		if notServed := served.notServed(*q.At); len(notServed) > 0 {
+			where(`m.id <> all(?)`, pq.Array(notServed))
+		}
+	}
+
+	filter := " where " + strings.Join(conditions, " and ")
+
+	var total int
+	err := app.DB.QueryRow(`select count(*) from menu_items m`+filter, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, "", err
+	}
+
+	sort := menuSortColumns[q.Sort]
+	direction, comparison := "asc", ">"
+	if q.Descending {
+		direction, comparison = "desc", "<"
+	}
+
+	if q.Cursor != nil {
+		args = append(args, q.Cursor.Value, q.Cursor.ID)
+		conditions = append(conditions, fmt.Sprintf("(%s, m.id) %s ($%d::%s, $%d)",
+			sort.column, comparison, len(args)-1, sort.cast, len(args)))
+		filter = " where " + strings.Join(conditions, " and ")
+	}
+
+	query := fmt.Sprintf(`%s%s order by %s %s, m.id %s`, menuItemSelect, filter, sort.column, direction, direction)
+
+	// Fetch one extra row to find out whether there is another page
+	if q.Limit > 0 {
+		query += fmt.Sprintf(" limit %d", q.Limit+1)
+	}
+
+	rows, err := app.DB.Query(query, args...)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	defer rows.Close()
+
+	items := []MenuItem{}
+	for rows.Next() {
+		item, err := scanMenuItem(rows)
+		if err != nil {
+			return nil, 0, "", err
+		}
+
+		items = append(items, item)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, 0, "", err
+	}
+
+	nextCursor := ""
+	if q.Limit > 0 && len(items) > q.Limit {
+		items = items[:q.Limit]
+		last := items[len(items)-1]
+
+		cursor := menuCursor{ID: last.ID}
+		switch q.Sort {
+		case "name":
+			cursor.Value = last.Name
+		case "price":
+			cursor.Value = strconv.FormatFloat(last.Price, 'f', -1, 64)
+		case "popularity":
+			cursor.Value = strconv.Itoa(last.Popularity)
+		}
+		nextCursor = cursor.encode()
+	}
+
+	err = app.attachOptionGroups(items)
+	if err != nil {
+		return nil, 0, "", err
+	}
+
+	if q.Draft {
+		err = app.applyDrafts(items)
+		if err != nil {
+			return nil, 0, "", err
+		}
+	}
+
+	// Prices change after the cursor is made, as paging goes by the regular price
+	if served != nil {
+		served.applyPrices(items, *q.At)
+	}
+
+	return items, total, nextCursor, nil
+}
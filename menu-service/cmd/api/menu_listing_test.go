@@ -0,0 +1,177 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMenuListQuery(t *testing.T) {
+	floatPtr := func(v float64) *float64 { return &v }
+	cursor := menuCursor{Value: "Latte", ID: 12}
+	at := time.Date(2024, time.May, 1, 9, 30, 0, 0, time.UTC)
+
+	defaults := func(change func(q *menuListQuery)) *menuListQuery {
+		q := &menuListQuery{Sort: "name", Limit: defaultMenuPageSize}
+		if change != nil {
+			change(q)
+		}
+		return q
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  *menuListQuery
+	}{
+		{"defaults", "", defaults(nil)},
+		{"search and category", "q=+iced+latte+&category=drinks", defaults(func(q *menuListQuery) {
+			q.Search = "iced latte"
+			q.Category = "drinks"
+		})},
+		{"price range", "min_price=2&max_price=7.5", defaults(func(q *menuListQuery) {
+			q.MinPrice = floatPtr(2)
+			q.MaxPrice = floatPtr(7.5)
+		})},
+		{"availability", "availability=sold_out", defaults(func(q *menuListQuery) {
+			q.Availability = availabilitySoldOut
+		})},
+		{"available", "available=true", defaults(func(q *menuListQuery) {
+			q.Availability = availabilityAvailable
+		})},
+		{"not available", "available=false", defaults(func(q *menuListQuery) {
+			q.Availability = availabilitySoldOut
+		})},
+		{"not available keeps an explicit availability", "availability=hidden&available=false", defaults(func(q *menuListQuery) {
+			q.Availability = availabilityHidden
+		})},
+		{"include hidden and draft", "include_hidden=true&draft=1", defaults(func(q *menuListQuery) {
+			q.IncludeHidden = true
+			q.Draft = true
+		})},
+		{"dietary tags repeated and comma separated", "dietary=Vegan,Gluten Free&dietary=halal&dietary=,", defaults(func(q *menuListQuery) {
+			q.Dietary = []string{"vegan", "gluten-free", "halal"}
+		})},
+		{"excluded allergens in standard order", "exclude_allergens=peanuts,milk&exclude_allergens=celery", defaults(func(q *menuListQuery) {
+			q.ExcludeAllergens = []string{"celery", "milk", "peanuts"}
+		})},
+		{"descending sort", "sort=-popularity", defaults(func(q *menuListQuery) {
+			q.Sort = "popularity"
+			q.Descending = true
+		})},
+		{"order overrides the sort prefix", "sort=-price&order=asc", defaults(func(q *menuListQuery) {
+			q.Sort = "price"
+		})},
+		{"order desc", "sort=price&order=desc", defaults(func(q *menuListQuery) {
+			q.Sort = "price"
+			q.Descending = true
+		})},
+		{"limit", "limit=200", defaults(func(q *menuListQuery) {
+			q.Limit = 200
+		})},
+		{"cursor", "cursor=" + cursor.encode(), defaults(func(q *menuListQuery) {
+			q.Cursor = &cursor
+		})},
+		{"at", "at=2024-05-01T09:30:00Z", defaults(func(q *menuListQuery) {
+			q.At = &at
+		})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := parseMenuListQuery(values)
+			if err != nil {
+				t.Fatalf("parseMenuListQuery(%q) = %v", tt.query, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseMenuListQuery(%q) = %+v, want %+v", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseMenuListQueryErrors(t *testing.T) {
+	tests := []struct {
+		query   string
+		wantErr string
+	}{
+		{"min_price=cheap", "min_price must be a non-negative number"},
+		{"max_price=-1", "max_price must be a non-negative number"},
+		{"min_price=10&max_price=5", "min_price cannot be greater than max_price"},
+		{"availability=maybe", "unknown availability"},
+		{"available=sometimes", "available must be true or false"},
+		{"include_hidden=yes", "include_hidden must be true or false"},
+		{"draft=yes", "draft must be true or false"},
+		{"exclude_allergens=bananas", "unknown allergen"},
+		{"sort=calories", "cannot sort by calories"},
+		{"order=up", "order must be asc or desc"},
+		{"limit=0", "limit must be between 1 and 200"},
+		{"limit=201", "limit must be between 1 and 200"},
+		{"limit=ten", "limit must be between 1 and 200"},
+		{"cursor=not-a-cursor!", "invalid cursor"},
+		{"at=tomorrow", "at must be an RFC 3339 timestamp"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = parseMenuListQuery(values)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseMenuListQuery(%q) = %v, want an error containing %q", tt.query, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMenuCursor(t *testing.T) {
+	tests := []menuCursor{
+		{Value: "Latte", ID: 12},
+		{Value: "4.50", ID: 3},
+		{Value: "", ID: 1},
+		{Value: "Crème brûlée & \"friends\"", ID: 99},
+	}
+
+	for _, cursor := range tests {
+		t.Run(cursor.Value, func(t *testing.T) {
+			encoded := cursor.encode()
+			if strings.ContainsAny(encoded, "+/=") {
+				t.Errorf("cursor %q is not safe to put in a URL", encoded)
+			}
+
+			decoded, err := decodeMenuCursor(encoded)
+			if err != nil {
+				t.Fatalf("decodeMenuCursor(%q) = %v", encoded, err)
+			}
+			if *decoded != cursor {
+				t.Errorf("decodeMenuCursor(%q) = %+v, want %+v", encoded, *decoded, cursor)
+			}
+		})
+	}
+}
+
+func TestDecodeMenuCursorInvalid(t *testing.T) {
+	tests := []string{
+		"%%%",
+		"bm90IGpzb24", // "not json"
+		"eyJ2IjoxfQ",  // {"v":1}, a value that isn't a string
+	}
+
+	for _, value := range tests {
+		t.Run(value, func(t *testing.T) {
+			if _, err := decodeMenuCursor(value); err == nil {
+				t.Errorf("decodeMenuCursor(%q) succeeded, want an error", value)
+			}
+		})
+	}
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// OptionGroup is a choice customers make when ordering an item, such as its size or
+// milk. Customers pick between MinSelect and MaxSelect of the group's options.
+type OptionGroup struct {
+	ID           int      `json:"id"`
+	Name         string   `json:"name"`
+	Required     bool     `json:"required"`
+	MinSelect    int      `json:"min_select"`
+	MaxSelect    int      `json:"max_select"`
+	DisplayOrder int      `json:"display_order"`
+	Options      []Option `json:"options"`
+}
+
+// Option is one of the choices in an option group. PriceDelta is added to the item's
+// price when the option is chosen and can be negative.
+type Option struct {
+	ID           int     `json:"id"`
+	Name         string  `json:"name"`
+	PriceDelta   float64 `json:"price_delta"`
+	DisplayOrder int     `json:"display_order"`
+	// InStock is false when the inventory can't make the item with this option
+	InStock bool `json:"in_stock"`
+}
+
+// SelectedOption is an option chosen for an item, with the name of its group
+type SelectedOption struct {
+	ID         int     `json:"id"`
+	GroupID    int     `json:"group_id"`
+	Group      string  `json:"group"`
+	Name       string  `json:"name"`
+	PriceDelta float64 `json:"price_delta"`
+}
+
+// PriceQuote is the price of a menu item with a selection of options
+type PriceQuote struct {
+	MenuItemID int              `json:"menu_item_id"`
+	Name       string           `json:"name"`
+	Category   string           `json:"category"`
+	BasePrice  float64          `json:"base_price"`
+	Options    []SelectedOption `json:"options"`
+	UnitPrice  float64          `json:"unit_price"`
+}
+
+var (
+	errMenuItemNotFound = errors.New("menu item not found")
+	errInvalidSelection = errors.New("invalid option selection")
+	errItemUnavailable  = errors.New("menu item unavailable")
+)
+
+// validateOptionGroups checks option groups before they are saved and fills in the
+// selection limits that can be worked out from the rest of the group
+func validateOptionGroups(groups []OptionGroup) error {
+	for i := range groups {
+		group := &groups[i]
+		group.Name = strings.TrimSpace(group.Name)
+		if group.Name == "" {
+			return errors.New("option groups must have a name")
+		}
+
+		if len(group.Options) == 0 {
+			return fmt.Errorf("option group %s has no options", group.Name)
+		}
+
+		names := make(map[string]bool, len(group.Options))
+		for j := range group.Options {
+			option := &group.Options[j]
+			option.Name = strings.TrimSpace(option.Name)
+			if option.Name == "" {
+				return fmt.Errorf("options in group %s must have a name", group.Name)
+			}
+			if names[strings.ToLower(option.Name)] {
+				return fmt.Errorf("option group %s has more than one %s option", group.Name, option.Name)
+			}
+			names[strings.ToLower(option.Name)] = true
+		}
+
+		// A required group needs at least one choice, and a minimum makes a group required
+		if group.Required && group.MinSelect == 0 {
+			group.MinSelect = 1
+		}
+		group.Required = group.MinSelect > 0
+
+		if group.MaxSelect == 0 {
+			group.MaxSelect = len(group.Options)
+		}
+
+		switch {
+		case group.MinSelect < 0:
+			return fmt.Errorf("min_select of option group %s can't be negative", group.Name)
+		case group.MaxSelect < group.MinSelect:
+			return fmt.Errorf("max_select of option group %s is less than min_select", group.Name)
+		case group.MinSelect > len(group.Options):
+			return fmt.Errorf("option group %s has fewer options than min_select", group.Name)
+		}
+	}
+
+	return nil
+}
+
+// saveOptionGroupsTx replaces a menu item's option groups as part of a transaction.
+// Groups and options sent with an id are updated in place, so their ids stay valid;
+// new ones are added and any left out are removed.
+func saveOptionGroupsTx(tx *sql.Tx, itemID int, groups []OptionGroup) error {
+	var err error
+
+	// Empty rather than nil, as a nil array would be NULL and delete nothing
+	groupIDs := []int64{}
+	optionIDs := []int64{}
+
+	for _, group := range groups {
+		if group.ID != 0 {
+			result, err := tx.Exec(`update menu_option_groups set name = $1, required = $2, min_select = $3, max_select = $4,
+				display_order = $5 where id = $6 and menu_item_id = $7`,
+				group.Name, group.Required, group.MinSelect, group.MaxSelect, group.DisplayOrder, group.ID, itemID)
+			if err != nil {
+				return err
+			}
+			if n, _ := result.RowsAffected(); n == 0 {
+				return fmt.Errorf("option group %d does not belong to this menu item", group.ID)
+			}
+		} else {
+			err = tx.QueryRow(`insert into menu_option_groups (menu_item_id, name, required, min_select, max_select, display_order)
+				values ($1, $2, $3, $4, $5, $6) returning id`,
+				itemID, group.Name, group.Required, group.MinSelect, group.MaxSelect, group.DisplayOrder).Scan(&group.ID)
+			if err != nil {
+				return err
+			}
+		}
+		groupIDs = append(groupIDs, int64(group.ID))
+
+		for _, option := range group.Options {
+			if option.ID != 0 {
+				result, err := tx.Exec(`update menu_options set group_id = $1, name = $2, price_delta = $3, display_order = $4
+					where id = $5 and group_id in (select id from menu_option_groups where menu_item_id = $6)`,
+					group.ID, option.Name, option.PriceDelta, option.DisplayOrder, option.ID, itemID)
+				if err != nil {
+					return err
+				}
+				if n, _ := result.RowsAffected(); n == 0 {
+					return fmt.Errorf("option %d does not belong to this menu item", option.ID)
+				}
+			} else {
+				err = tx.QueryRow(`insert into menu_options (group_id, name, price_delta, display_order)
+					values ($1, $2, $3, $4) returning id`,
+					group.ID, option.Name, option.PriceDelta, option.DisplayOrder).Scan(&option.ID)
+				if err != nil {
+					return err
+				}
+			}
+			optionIDs = append(optionIDs, int64(option.ID))
+		}
+	}
+
+	_, err = tx.Exec(`delete from menu_options where group_id in (select id from menu_option_groups where menu_item_id = $1)
+		and not (id = any($2))`, itemID, pq.Array(optionIDs))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`delete from menu_option_groups where menu_item_id = $1 and not (id = any($2))`,
+		itemID, pq.Array(groupIDs))
+	return err
+}
+
+// attachOptionGroups loads the option groups of a list of menu items
+func (app *Config) attachOptionGroups(items []MenuItem) error {
+	return loadOptionGroups(app.DB, items)
+}
+
+// loadOptionGroups loads the option groups of a list of menu items using a database or
+// transaction
+func loadOptionGroups(db dbtx, items []MenuItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	itemIDs := make([]int64, len(items))
+	byItem := make(map[int]*MenuItem, len(items))
+	for i := range items {
+		items[i].OptionGroups = []OptionGroup{}
+		itemIDs[i] = int64(items[i].ID)
+		byItem[items[i].ID] = &items[i]
+	}
+
+	rows, err := db.Query(`select g.menu_item_id, g.id, g.name, g.required, g.min_select, g.max_select, g.display_order,
+		o.id, o.name, o.price_delta, o.display_order, o.in_stock
+		from menu_option_groups g join menu_options o on o.group_id = g.id
+		where g.menu_item_id = any($1)
+		order by g.menu_item_id, g.display_order, g.id, o.display_order, o.id`, pq.Array(itemIDs))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var itemID int
+		var group OptionGroup
+		var option Option
+		err := rows.Scan(
+			&itemID,
+			&group.ID,
+			&group.Name,
+			&group.Required,
+			&group.MinSelect,
+			&group.MaxSelect,
+			&group.DisplayOrder,
+			&option.ID,
+			&option.Name,
+			&option.PriceDelta,
+			&option.DisplayOrder,
+			&option.InStock,
+		)
+		if err != nil {
+			return err
+		}
+
+		// Rows come grouped, so a new group starts whenever the group id changes
+		item := byItem[itemID]
+		last := len(item.OptionGroups) - 1
+		if last < 0 || item.OptionGroups[last].ID != group.ID {
+			item.OptionGroups = append(item.OptionGroups, group)
+			last++
+		}
+		item.OptionGroups[last].Options = append(item.OptionGroups[last].Options, option)
+	}
+
+	return rows.Err()
+}
+
+// priceSelection works out the price of a menu item with the chosen options at a given
+// time, checking the item is being served then and the choices against each option
+// group's limits
+func (app *Config) priceSelection(itemID int, optionIDs []int, at time.Time) (PriceQuote, error) {
+	item, err := app.getMenuItemByID(itemID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return PriceQuote{}, errMenuItemNotFound
+	}
+	if err != nil {
+		return PriceQuote{}, err
+	}
+
+	served, err := app.loadSchedule()
+	if err != nil {
+		return PriceQuote{}, err
+	}
+
+	return quoteSelection(item, served, optionIDs, at)
+}
+
+// quoteSelection prices a menu item with the chosen options at a given time, using the
+// menus and price overrides in a schedule
+func quoteSelection(item MenuItem, served *schedule, optionIDs []int, at time.Time) (PriceQuote, error) {
+	switch {
+	case item.ArchivedAt != nil:
+		return PriceQuote{}, fmt.Errorf("%w: %s is no longer on the menu", errItemUnavailable, item.Name)
+	case item.Availability == availabilityHidden:
+		return PriceQuote{}, fmt.Errorf("%w: %s is not on the menu", errItemUnavailable, item.Name)
+	case item.Availability == availabilitySoldOut:
+		return PriceQuote{}, fmt.Errorf("%w: %s is sold out", errItemUnavailable, item.Name)
+	}
+
+	if !served.servedAt(item.ID, at) {
+		return PriceQuote{}, fmt.Errorf("%w: %s is not being served at %s", errItemUnavailable, item.Name, at.Format(time.RFC3339))
+	}
+	item.Price = served.priceAt(item, at)
+
+	type choice struct {
+		group  *OptionGroup
+		option Option
+	}
+	choices := make(map[int]choice)
+	for i := range item.OptionGroups {
+		group := &item.OptionGroups[i]
+		for _, option := range group.Options {
+			choices[option.ID] = choice{group, option}
+		}
+	}
+
+	quote := PriceQuote{
+		MenuItemID: item.ID,
+		Name:       item.Name,
+		Category:   item.Category,
+		BasePrice:  item.Price,
+		Options:    []SelectedOption{},
+		UnitPrice:  item.Price,
+	}
+
+	selected := make(map[int]bool, len(optionIDs))
+	perGroup := make(map[int]int)
+	for _, id := range optionIDs {
+		chosen, ok := choices[id]
+		if !ok {
+			return PriceQuote{}, fmt.Errorf("%w: option %d is not available for %s", errInvalidSelection, id, item.Name)
+		}
+		if selected[id] {
+			return PriceQuote{}, fmt.Errorf("%w: option %s was chosen more than once", errInvalidSelection, chosen.option.Name)
+		}
+		if !chosen.option.InStock {
+			return PriceQuote{}, fmt.Errorf("%w: %s %s is sold out", errItemUnavailable, chosen.option.Name, item.Name)
+		}
+		selected[id] = true
+		perGroup[chosen.group.ID]++
+
+		quote.Options = append(quote.Options, SelectedOption{
+			ID:         id,
+			GroupID:    chosen.group.ID,
+			Group:      chosen.group.Name,
+			Name:       chosen.option.Name,
+			PriceDelta: chosen.option.PriceDelta,
+		})
+		quote.UnitPrice += chosen.option.PriceDelta
+	}
+
+	for _, group := range item.OptionGroups {
+		count := perGroup[group.ID]
+		if count < group.MinSelect {
+			return PriceQuote{}, fmt.Errorf("%w: choose at least %d %s", errInvalidSelection, group.MinSelect, group.Name)
+		}
+		if count > group.MaxSelect {
+			return PriceQuote{}, fmt.Errorf("%w: choose at most %d %s", errInvalidSelection, group.MaxSelect, group.Name)
+		}
+	}
+
+	// Round to cents, and never let discounts take the price below zero
+	quote.UnitPrice = math.Max(math.Round(quote.UnitPrice*100)/100, 0)
+
+	return quote, nil
+}
+
+// PriceMenuItem returns the price of a menu item with the given options. The order
+// service uses it to price order items.
+func (app *Config) PriceMenuItem(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		app.errorJSON(c, errors.New("invalid id parameter"), http.StatusBadRequest)
+		return
+	}
+
+	// At defaults to now
+	var requestPayload struct {
+		OptionIDs []int      `json:"option_ids"`
+		At        *time.Time `json:"at"`
+	}
+
+	err = app.readJSON(c, &requestPayload)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	at := time.Now()
+	if requestPayload.At != nil {
+		at = *requestPayload.At
+	}
+
+	quote, err := app.priceSelection(id, requestPayload.OptionIDs, at)
+	switch {
+	case errors.Is(err, errMenuItemNotFound):
+		app.errorJSON(c, err, http.StatusNotFound)
+		return
+	case errors.Is(err, errInvalidSelection), errors.Is(err, errItemUnavailable):
+		app.errorJSON(c, err, http.StatusUnprocessableEntity)
+		return
+	case err != nil:
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool       `json:"error"`
+		Message string     `json:"message"`
+		Data    PriceQuote `json:"data"`
+	}{
+		Error:   false,
+		Message: "Menu item priced",
+		Data:    quote,
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
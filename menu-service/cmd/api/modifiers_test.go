@@ -0,0 +1,260 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateOptionGroups(t *testing.T) {
+	options := func(names ...string) []Option {
+		list := []Option{}
+		for _, name := range names {
+			list = append(list, Option{Name: name})
+		}
+		return list
+	}
+
+	tests := []struct {
+		name     string
+		group    OptionGroup
+		wantErr  string
+		wantMin  int
+		wantMax  int
+		wantReqd bool
+	}{
+		{
+			name:    "optional group gets a max from its options",
+			group:   OptionGroup{Name: "Extras", Options: options("Cream", "Sprinkles")},
+			wantMin: 0, wantMax: 2, wantReqd: false,
+		},
+		{
+			name:    "required group needs one choice",
+			group:   OptionGroup{Name: "Size", Required: true, MaxSelect: 1, Options: options("Small", "Large")},
+			wantMin: 1, wantMax: 1, wantReqd: true,
+		},
+		{
+			name:    "a minimum makes a group required",
+			group:   OptionGroup{Name: "Sides", MinSelect: 2, Options: options("Fries", "Salad", "Slaw")},
+			wantMin: 2, wantMax: 3, wantReqd: true,
+		},
+		{
+			name:    "names are trimmed",
+			group:   OptionGroup{Name: "  Milk ", Options: options(" Oat ")},
+			wantMin: 0, wantMax: 1,
+		},
+		{
+			name:    "no name",
+			group:   OptionGroup{Name: " ", Options: options("Oat")},
+			wantErr: "must have a name",
+		},
+		{
+			name:    "no options",
+			group:   OptionGroup{Name: "Milk"},
+			wantErr: "has no options",
+		},
+		{
+			name:    "option without a name",
+			group:   OptionGroup{Name: "Milk", Options: options("Oat", "")},
+			wantErr: "must have a name",
+		},
+		{
+			name:    "duplicate option names",
+			group:   OptionGroup{Name: "Milk", Options: options("Oat", "oat")},
+			wantErr: "more than one",
+		},
+		{
+			name:    "negative minimum",
+			group:   OptionGroup{Name: "Milk", MinSelect: -1, Options: options("Oat")},
+			wantErr: "can't be negative",
+		},
+		{
+			name:    "max below min",
+			group:   OptionGroup{Name: "Sides", MinSelect: 2, MaxSelect: 1, Options: options("Fries", "Salad")},
+			wantErr: "less than min_select",
+		},
+		{
+			name:    "min above the number of options",
+			group:   OptionGroup{Name: "Sides", MinSelect: 3, MaxSelect: 3, Options: options("Fries", "Salad")},
+			wantErr: "fewer options than min_select",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			groups := []OptionGroup{tt.group}
+			err := validateOptionGroups(groups)
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("validateOptionGroups() = %v, want an error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validateOptionGroups() = %v", err)
+			}
+
+			group := groups[0]
+			if group.MinSelect != tt.wantMin || group.MaxSelect != tt.wantMax || group.Required != tt.wantReqd {
+				t.Errorf("got min %d, max %d, required %v; want %d, %d, %v",
+					group.MinSelect, group.MaxSelect, group.Required, tt.wantMin, tt.wantMax, tt.wantReqd)
+			}
+			if strings.TrimSpace(group.Name) != group.Name || strings.TrimSpace(group.Options[0].Name) != group.Options[0].Name {
+				t.Errorf("names were not trimmed: %q, %q", group.Name, group.Options[0].Name)
+			}
+		})
+	}
+}
+
+func TestQuoteSelection(t *testing.T) {
+	coffee := func() MenuItem {
+		return MenuItem{
+			ID:           1,
+			Name:         "Latte",
+			Category:     "Coffee",
+			Price:        3.20,
+			Availability: availabilityAvailable,
+			OptionGroups: []OptionGroup{
+				{ID: 10, Name: "Size", Required: true, MinSelect: 1, MaxSelect: 1, Options: []Option{
+					{ID: 100, Name: "Regular", InStock: true},
+					{ID: 101, Name: "Large", PriceDelta: 0.60, InStock: true},
+				}},
+				{ID: 11, Name: "Milk", MinSelect: 0, MaxSelect: 1, Options: []Option{
+					{ID: 110, Name: "Oat", PriceDelta: 0.40, InStock: true},
+					{ID: 111, Name: "Soy", PriceDelta: 0.30, InStock: false},
+				}},
+				{ID: 12, Name: "Offers", MinSelect: 0, MaxSelect: 2, Options: []Option{
+					{ID: 120, Name: "Own cup", PriceDelta: -0.25, InStock: true},
+					{ID: 121, Name: "Staff", PriceDelta: -5, InStock: true},
+				}},
+			},
+		}
+	}
+	noon := time.Date(2024, time.January, 1, 12, 0, 0, 0, time.UTC)
+	archived := "2024-01-01T00:00:00Z"
+
+	tests := []struct {
+		name      string
+		item      func(MenuItem) MenuItem
+		served    *schedule
+		optionIDs []int
+		wantPrice float64
+		wantErr   error
+	}{
+		{
+			name:      "required choice only",
+			optionIDs: []int{100},
+			wantPrice: 3.20,
+		},
+		{
+			name:      "options add to the price",
+			optionIDs: []int{101, 110},
+			wantPrice: 4.20,
+		},
+		{
+			name:      "negative option",
+			optionIDs: []int{100, 120},
+			wantPrice: 2.95,
+		},
+		{
+			name:      "price never goes below zero",
+			optionIDs: []int{100, 120, 121},
+			wantPrice: 0,
+		},
+		{
+			name:      "options are added to the scheduled price",
+			served:    &schedule{overrides: []PriceOverride{{MenuItemID: intRef(1), Price: floatRef(2.50), location: time.UTC}}},
+			optionIDs: []int{101},
+			wantPrice: 3.10,
+		},
+		{
+			name:    "missing required choice",
+			wantErr: errInvalidSelection,
+		},
+		{
+			name:      "too many choices in a group",
+			optionIDs: []int{100, 101},
+			wantErr:   errInvalidSelection,
+		},
+		{
+			name:      "same option twice",
+			optionIDs: []int{100, 120, 120},
+			wantErr:   errInvalidSelection,
+		},
+		{
+			name:      "option of another item",
+			optionIDs: []int{100, 999},
+			wantErr:   errInvalidSelection,
+		},
+		{
+			name:      "option out of stock",
+			optionIDs: []int{100, 111},
+			wantErr:   errItemUnavailable,
+		},
+		{
+			name:      "sold out",
+			item:      func(item MenuItem) MenuItem { item.Availability = availabilitySoldOut; return item },
+			optionIDs: []int{100},
+			wantErr:   errItemUnavailable,
+		},
+		{
+			name:      "hidden",
+			item:      func(item MenuItem) MenuItem { item.Availability = availabilityHidden; return item },
+			optionIDs: []int{100},
+			wantErr:   errItemUnavailable,
+		},
+		{
+			name:      "archived",
+			item:      func(item MenuItem) MenuItem { item.ArchivedAt = &archived; return item },
+			optionIDs: []int{100},
+			wantErr:   errItemUnavailable,
+		},
+		{
+			name: "menu not being served",
+			served: &schedule{menus: []Menu{{
+				ItemIDs:   []int{1},
+				Schedules: []TimeWindow{{StartTime: "17:00", EndTime: "22:00"}},
+				location:  time.UTC,
+			}}},
+			optionIDs: []int{100},
+			wantErr:   errItemUnavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := coffee()
+			if tt.item != nil {
+				item = tt.item(item)
+			}
+			served := tt.served
+			if served == nil {
+				served = &schedule{}
+			}
+
+			quote, err := quoteSelection(item, served, tt.optionIDs, noon)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("quoteSelection() = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("quoteSelection() = %v", err)
+			}
+
+			if quote.UnitPrice != tt.wantPrice {
+				t.Errorf("unit price = %v, want %v", quote.UnitPrice, tt.wantPrice)
+			}
+			if len(quote.Options) != len(tt.optionIDs) {
+				t.Errorf("got %d options, want %d", len(quote.Options), len(tt.optionIDs))
+			}
+		})
+	}
+}
+
+func intRef(v int) *int { return &v }
+
+func floatRef(v float64) *float64 { return &v }
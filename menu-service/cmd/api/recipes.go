@@ -0,0 +1,554 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RecipeIngredient is an amount of an inventory item used to make a menu item, or to
+// add one of its options
+type RecipeIngredient struct {
+	InventoryItemID int     `json:"inventory_item_id"`
+	InventoryItem   string  `json:"inventory_item,omitempty"`
+	Quantity        float64 `json:"quantity"`
+	Unit            string  `json:"unit"`
+}
+
+// OptionRecipe is the extra ingredients used when an option is chosen, such as the
+// oat milk for an oat milk latte
+type OptionRecipe struct {
+	OptionID    int                `json:"option_id"`
+	Ingredients []RecipeIngredient `json:"ingredients"`
+}
+
+// Recipe is the bill of materials for a menu item
+type Recipe struct {
+	MenuItemID  int                `json:"menu_item_id"`
+	Ingredients []RecipeIngredient `json:"ingredients"`
+	Options     []OptionRecipe     `json:"options"`
+}
+
+// CanMake is how many of a menu item the current inventory can produce. CanMake is nil
+// for items without a recipe, as nothing limits them.
+type CanMake struct {
+	MenuItemID int    `json:"menu_item_id"`
+	Name       string `json:"name"`
+	CanMake    *int   `json:"can_make"`
+	LimitedBy  string `json:"limited_by,omitempty"`
+}
+
+// unitDefinition places a unit in a dimension, with its size in the dimension's base
+// unit (grams, millilitres or single items)
+type unitDefinition struct {
+	dimension string
+	factor    float64
+}
+
+var units = map[string]unitDefinition{
+	"mg":    {"mass", 0.001},
+	"g":     {"mass", 1},
+	"kg":    {"mass", 1000},
+	"oz":    {"mass", 28.349523125},
+	"lb":    {"mass", 453.59237},
+	"ml":    {"volume", 1},
+	"cl":    {"volume", 10},
+	"l":     {"volume", 1000},
+	"tsp":   {"volume", 4.92892159375},
+	"tbsp":  {"volume", 14.78676478125},
+	"fl oz": {"volume", 29.5735295625},
+	"cup":   {"volume", 236.5882365},
+	"each":  {"count", 1},
+	"dozen": {"count", 12},
+}
+
+// unitAliases maps other spellings onto the names in units
+var unitAliases = map[string]string{
+	"gram": "g", "grams": "g", "kilogram": "kg", "kilograms": "kg", "milligram": "mg", "milligrams": "mg",
+	"ounce": "oz", "ounces": "oz", "pound": "lb", "pounds": "lb", "lbs": "lb",
+	"millilitre": "ml", "millilitres": "ml", "milliliter": "ml", "milliliters": "ml",
+	"litre": "l", "litres": "l", "liter": "l", "liters": "l",
+	"teaspoon": "tsp", "teaspoons": "tsp", "tablespoon": "tbsp", "tablespoons": "tbsp", "cups": "cup",
+	"floz": "fl oz", "fl. oz": "fl oz",
+	"unit": "each", "units": "each", "piece": "each", "pieces": "each", "pcs": "each", "pc": "each", "ea": "each",
+}
+
+func normalizeUnit(unit string) string {
+	unit = strings.ToLower(strings.Join(strings.Fields(unit), " "))
+	if alias, ok := unitAliases[unit]; ok {
+		return alias
+	}
+	return unit
+}
+
+// convertQuantity converts a quantity between units of the same dimension. Units this
+// service doesn't know, such as "slice", only convert to themselves.
+func convertQuantity(quantity float64, from, to string) (float64, error) {
+	from, to = normalizeUnit(from), normalizeUnit(to)
+	if from == to {
+		return quantity, nil
+	}
+
+	fromUnit, okFrom := units[from]
+	toUnit, okTo := units[to]
+	if !okFrom || !okTo || fromUnit.dimension != toUnit.dimension {
+		return 0, fmt.Errorf("can't convert %s to %s", from, to)
+	}
+
+	return quantity * fromUnit.factor / toUnit.factor, nil
+}
+
+// validateIngredients checks a list of ingredients against the inventory
+func validateIngredients(ingredients []RecipeIngredient, inventory map[int]InventoryItem) error {
+	seen := make(map[int]bool, len(ingredients))
+	for i := range ingredients {
+		ingredient := &ingredients[i]
+
+		stock, ok := inventory[ingredient.InventoryItemID]
+		if !ok {
+			return fmt.Errorf("inventory item %d not found", ingredient.InventoryItemID)
+		}
+		if seen[ingredient.InventoryItemID] {
+			return fmt.Errorf("%s is listed more than once", stock.ItemName)
+		}
+		seen[ingredient.InventoryItemID] = true
+
+		if ingredient.Quantity <= 0 {
+			return fmt.Errorf("quantity of %s must be greater than zero", stock.ItemName)
+		}
+
+		// Default to the unit the inventory is counted in
+		if strings.TrimSpace(ingredient.Unit) == "" {
+			ingredient.Unit = stock.Unit
+		}
+		if _, err := convertQuantity(ingredient.Quantity, ingredient.Unit, stock.Unit); err != nil {
+			return fmt.Errorf("%s is stocked in %s: %w", stock.ItemName, stock.Unit, err)
+		}
+		ingredient.Unit = normalizeUnit(ingredient.Unit)
+		ingredient.InventoryItem = stock.ItemName
+	}
+
+	return nil
+}
+
+// loadRecipes reads the recipes of the menu items selected by filter, by menu item id
+func (app *Config) loadRecipes(filter string, args ...any) (map[int]*Recipe, error) {
+	rows, err := app.DB.Query(`select menu_item_id, option_id, inventory_item_id, quantity, unit
+		from recipe_ingredients `+filter+` order by id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	recipes := make(map[int]*Recipe)
+	for rows.Next() {
+		var itemID int
+		var optionID sql.NullInt64
+		var ingredient RecipeIngredient
+		err := rows.Scan(&itemID, &optionID, &ingredient.InventoryItemID, &ingredient.Quantity, &ingredient.Unit)
+		if err != nil {
+			return nil, err
+		}
+
+		recipe, ok := recipes[itemID]
+		if !ok {
+			recipe = &Recipe{MenuItemID: itemID, Ingredients: []RecipeIngredient{}, Options: []OptionRecipe{}}
+			recipes[itemID] = recipe
+		}
+
+		if !optionID.Valid {
+			recipe.Ingredients = append(recipe.Ingredients, ingredient)
+			continue
+		}
+
+		found := false
+		for i := range recipe.Options {
+			if recipe.Options[i].OptionID == int(optionID.Int64) {
+				recipe.Options[i].Ingredients = append(recipe.Options[i].Ingredients, ingredient)
+				found = true
+				break
+			}
+		}
+		if !found {
+			recipe.Options = append(recipe.Options, OptionRecipe{
+				OptionID:    int(optionID.Int64),
+				Ingredients: []RecipeIngredient{ingredient},
+			})
+		}
+	}
+
+	return recipes, rows.Err()
+}
+
+// getRecipe returns a menu item's recipe, which is empty if none has been saved
+func (app *Config) getRecipe(itemID int) (*Recipe, error) {
+	recipes, err := app.loadRecipes(`where menu_item_id = $1`, itemID)
+	if err != nil {
+		return nil, err
+	}
+
+	if recipe, ok := recipes[itemID]; ok {
+		return recipe, nil
+	}
+
+	return &Recipe{MenuItemID: itemID, Ingredients: []RecipeIngredient{}, Options: []OptionRecipe{}}, nil
+}
+
+// saveRecipe replaces a menu item's recipe
+func (app *Config) saveRecipe(recipe Recipe) error {
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`delete from recipe_ingredients where menu_item_id = $1`, recipe.MenuItemID)
+	if err != nil {
+		return err
+	}
+
+	insert := func(optionID any, ingredients []RecipeIngredient) error {
+		for _, ingredient := range ingredients {
+			_, err := tx.Exec(`insert into recipe_ingredients (menu_item_id, option_id, inventory_item_id, quantity, unit)
+				values ($1, $2, $3, $4, $5)`,
+				recipe.MenuItemID, optionID, ingredient.InventoryItemID, ingredient.Quantity, ingredient.Unit)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err = insert(nil, recipe.Ingredients); err != nil {
+		return err
+	}
+	for _, option := range recipe.Options {
+		if err = insert(option.OptionID, option.Ingredients); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// canMake works out how many times the ingredients can be made from the inventory, and
+// which inventory item runs out first
+func canMake(ingredients []RecipeIngredient, inventory map[int]InventoryItem) (int, string) {
+	// The same inventory item can be used by the item and by its options
+	required := make(map[int]float64)
+	for _, ingredient := range ingredients {
+		stock, ok := inventory[ingredient.InventoryItemID]
+		if !ok {
+			return 0, fmt.Sprintf("inventory item %d", ingredient.InventoryItemID)
+		}
+
+		quantity, err := convertQuantity(ingredient.Quantity, ingredient.Unit, stock.Unit)
+		if err != nil {
+			return 0, stock.ItemName
+		}
+		required[ingredient.InventoryItemID] += quantity
+	}
+
+	most, limitedBy := math.MaxInt, ""
+	for inventoryItemID, quantity := range required {
+		stock := inventory[inventoryItemID]
+
+		// Allow for rounding errors from unit conversion
+		n := int(math.Floor(float64(stock.Quantity)/quantity + 1e-9))
+		if n < most || (n == most && stock.ItemName < limitedBy) {
+			most, limitedBy = n, stock.ItemName
+		}
+	}
+	if most < 0 {
+		most = 0
+	}
+
+	return most, limitedBy
+}
+
+// recipeIngredients returns the ingredients for an item made with the chosen options
+func (recipe *Recipe) recipeIngredients(optionIDs []int) []RecipeIngredient {
+	ingredients := append([]RecipeIngredient{}, recipe.Ingredients...)
+	for _, optionID := range optionIDs {
+		for _, option := range recipe.Options {
+			if option.OptionID == optionID {
+				ingredients = append(ingredients, option.Ingredients...)
+			}
+		}
+	}
+
+	return ingredients
+}
+
+// menuItemFromParam looks up the menu item in the id parameter, writing an error
+// response and returning false if there isn't one
+func (app *Config) menuItemFromParam(c *gin.Context) (MenuItem, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		app.errorJSON(c, errors.New("invalid id parameter"), http.StatusBadRequest)
+		return MenuItem{}, false
+	}
+
+	item, err := app.getMenuItemByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(c, errMenuItemNotFound, http.StatusNotFound)
+		return MenuItem{}, false
+	}
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return MenuItem{}, false
+	}
+
+	return item, true
+}
+
+func (app *Config) GetRecipe(c *gin.Context) {
+	item, ok := app.menuItemFromParam(c)
+	if !ok {
+		return
+	}
+
+	recipe, err := app.getRecipe(item.ID)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Ingredient names come from the inventory service; the recipe is still useful
+	// without them if it can't be reached
+	if inventory, err := app.getInventory(); err == nil {
+		name := func(ingredients []RecipeIngredient) {
+			for i := range ingredients {
+				ingredients[i].InventoryItem = inventory[ingredients[i].InventoryItemID].ItemName
+			}
+		}
+		name(recipe.Ingredients)
+		for _, option := range recipe.Options {
+			name(option.Ingredients)
+		}
+	}
+
+	payload := struct {
+		Error   bool    `json:"error"`
+		Message string  `json:"message"`
+		Data    *Recipe `json:"data"`
+	}{
+		Error:   false,
+		Message: "Recipe retrieved",
+		Data:    recipe,
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+// SaveRecipe creates or replaces a menu item's recipe
+func (app *Config) SaveRecipe(c *gin.Context) {
+	item, ok := app.menuItemFromParam(c)
+	if !ok {
+		return
+	}
+
+	var recipe Recipe
+	err := app.readJSON(c, &recipe)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+	recipe.MenuItemID = item.ID
+
+	inventory, err := app.getInventory()
+	if err != nil {
+		app.errorJSON(c, fmt.Errorf("unable to check inventory items: %w", err), http.StatusServiceUnavailable)
+		return
+	}
+
+	err = validateIngredients(recipe.Ingredients, inventory)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusUnprocessableEntity)
+		return
+	}
+
+	options := make(map[int]bool)
+	for _, group := range item.OptionGroups {
+		for _, option := range group.Options {
+			options[option.ID] = true
+		}
+	}
+
+	seen := make(map[int]bool, len(recipe.Options))
+	for _, option := range recipe.Options {
+		if !options[option.OptionID] || seen[option.OptionID] {
+			app.errorJSON(c, fmt.Errorf("option %d is not an option of %s, or is listed twice", option.OptionID, item.Name),
+				http.StatusUnprocessableEntity)
+			return
+		}
+		seen[option.OptionID] = true
+
+		err = validateIngredients(option.Ingredients, inventory)
+		if err != nil {
+			app.errorJSON(c, err, http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	if recipe.Ingredients == nil {
+		recipe.Ingredients = []RecipeIngredient{}
+	}
+	if recipe.Options == nil {
+		recipe.Options = []OptionRecipe{}
+	}
+
+	err = app.saveRecipe(recipe)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+	app.refreshAvailability()
+
+	payload := struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+		Data    Recipe `json:"data"`
+	}{
+		Error:   false,
+		Message: "Recipe saved",
+		Data:    recipe,
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+func (app *Config) DeleteRecipe(c *gin.Context) {
+	item, ok := app.menuItemFromParam(c)
+	if !ok {
+		return
+	}
+
+	_, err := app.DB.Exec(`delete from recipe_ingredients where menu_item_id = $1`, item.ID)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+	app.refreshAvailability()
+
+	payload := struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}{
+		Error:   false,
+		Message: "Recipe deleted",
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+// GetCanMake reports how many of every menu item the current inventory can make
+func (app *Config) GetCanMake(c *gin.Context) {
+	recipes, err := app.loadRecipes(``)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	inventory, err := app.getInventory()
+	if err != nil {
+		app.errorJSON(c, fmt.Errorf("unable to check inventory: %w", err), http.StatusServiceUnavailable)
+		return
+	}
+
+	rows, err := app.DB.Query(`select id, name from menu_items where archived_at is null order by name`)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	results := []CanMake{}
+	for rows.Next() {
+		var result CanMake
+		if err := rows.Scan(&result.MenuItemID, &result.Name); err != nil {
+			app.errorJSON(c, err, http.StatusInternalServerError)
+			return
+		}
+
+		if recipe, ok := recipes[result.MenuItemID]; ok && len(recipe.Ingredients) > 0 {
+			n, limitedBy := canMake(recipe.Ingredients, inventory)
+			result.CanMake, result.LimitedBy = &n, limitedBy
+		}
+
+		results = append(results, result)
+	}
+	if err = rows.Err(); err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool      `json:"error"`
+		Message string    `json:"message"`
+		Data    []CanMake `json:"data"`
+	}{
+		Error:   false,
+		Message: "Production capacity calculated",
+		Data:    results,
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+// GetItemCanMake reports how many of a menu item, made with the options given in
+// option_id query parameters, the current inventory can make
+func (app *Config) GetItemCanMake(c *gin.Context) {
+	item, ok := app.menuItemFromParam(c)
+	if !ok {
+		return
+	}
+
+	var optionIDs []int
+	for _, value := range c.QueryArray("option_id") {
+		optionID, err := strconv.Atoi(value)
+		if err != nil {
+			app.errorJSON(c, errors.New("invalid option_id parameter"), http.StatusBadRequest)
+			return
+		}
+		optionIDs = append(optionIDs, optionID)
+	}
+
+	recipe, err := app.getRecipe(item.ID)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	result := CanMake{MenuItemID: item.ID, Name: item.Name}
+
+	ingredients := recipe.recipeIngredients(optionIDs)
+	if len(ingredients) > 0 {
+		inventory, err := app.getInventory()
+		if err != nil {
+			app.errorJSON(c, fmt.Errorf("unable to check inventory: %w", err), http.StatusServiceUnavailable)
+			return
+		}
+
+		n, limitedBy := canMake(ingredients, inventory)
+		result.CanMake, result.LimitedBy = &n, limitedBy
+	}
+
+	payload := struct {
+		Error   bool    `json:"error"`
+		Message string  `json:"message"`
+		Data    CanMake `json:"data"`
+	}{
+		Error:   false,
+		Message: "Production capacity calculated",
+		Data:    result,
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+// testInventory is a small stock room, by inventory item id
+var testInventory = map[int]InventoryItem{
+	1: {ID: 1, ItemName: "Espresso beans", Quantity: 1000, Unit: "g"},
+	2: {ID: 2, ItemName: "Milk", Quantity: 2, Unit: "l"},
+	3: {ID: 3, ItemName: "Oat milk", Quantity: 500, Unit: "ml"},
+	4: {ID: 4, ItemName: "Eggs", Quantity: 24, Unit: "each"},
+	5: {ID: 5, ItemName: "Bread", Quantity: 10, Unit: "slice"},
+}
+
+func TestNormalizeUnit(t *testing.T) {
+	tests := []struct {
+		unit, want string
+	}{
+		{"g", "g"},
+		{"Grams", "g"},
+		{" KG ", "kg"},
+		{"fl  oz", "fl oz"},
+		{"FLOZ", "fl oz"},
+		{"Litres", "l"},
+		{"pcs", "each"},
+		{"slice", "slice"},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeUnit(tt.unit); got != tt.want {
+			t.Errorf("normalizeUnit(%q) = %q, want %q", tt.unit, got, tt.want)
+		}
+	}
+}
+
+func TestConvertQuantity(t *testing.T) {
+	tests := []struct {
+		name     string
+		quantity float64
+		from, to string
+		want     float64
+		wantErr  string
+	}{
+		{"same unit", 3, "g", "g", 3, ""},
+		{"aliases of the same unit", 3, "grams", "g", 3, ""},
+		{"kilograms to grams", 1.5, "kg", "g", 1500, ""},
+		{"grams to kilograms", 250, "g", "kg", 0.25, ""},
+		{"pounds to grams", 1, "lb", "g", 453.59237, ""},
+		{"millilitres to litres", 250, "ml", "l", 0.25, ""},
+		{"tablespoons to teaspoons", 1, "tbsp", "tsp", 3, ""},
+		{"cups to millilitres", 2, "cups", "ml", 473.176473, ""},
+		{"dozen to each", 2, "dozen", "each", 24, ""},
+		{"unknown unit to itself", 2, "slice", "Slice", 2, ""},
+		{"mass to volume", 1, "g", "ml", 0, "can't convert g to ml"},
+		{"count to mass", 1, "each", "g", 0, "can't convert each to g"},
+		{"unknown unit", 1, "slice", "each", 0, "can't convert slice to each"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := convertQuantity(tt.quantity, tt.from, tt.to)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("convertQuantity() = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("convertQuantity() = %v", err)
+			}
+			if math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("convertQuantity(%v %s to %s) = %v, want %v", tt.quantity, tt.from, tt.to, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateIngredients(t *testing.T) {
+	tests := []struct {
+		name        string
+		ingredients []RecipeIngredient
+		want        []RecipeIngredient
+		wantErr     string
+	}{
+		{
+			name: "fills in names and units",
+			ingredients: []RecipeIngredient{
+				{InventoryItemID: 1, Quantity: 18, Unit: "Grams"},
+				{InventoryItemID: 4, Quantity: 2},
+			},
+			want: []RecipeIngredient{
+				{InventoryItemID: 1, InventoryItem: "Espresso beans", Quantity: 18, Unit: "g"},
+				{InventoryItemID: 4, InventoryItem: "Eggs", Quantity: 2, Unit: "each"},
+			},
+		},
+		{
+			name:        "unknown inventory item",
+			ingredients: []RecipeIngredient{{InventoryItemID: 99, Quantity: 1}},
+			wantErr:     "inventory item 99 not found",
+		},
+		{
+			name:        "listed twice",
+			ingredients: []RecipeIngredient{{InventoryItemID: 1, Quantity: 18}, {InventoryItemID: 1, Quantity: 2}},
+			wantErr:     "Espresso beans is listed more than once",
+		},
+		{
+			name:        "no quantity",
+			ingredients: []RecipeIngredient{{InventoryItemID: 4, Quantity: 0}},
+			wantErr:     "quantity of Eggs must be greater than zero",
+		},
+		{
+			name:        "unit in another dimension",
+			ingredients: []RecipeIngredient{{InventoryItemID: 2, Quantity: 200, Unit: "g"}},
+			wantErr:     "Milk is stocked in l: can't convert g to l",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateIngredients(tt.ingredients, testInventory)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("validateIngredients() = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("validateIngredients() = %v", err)
+			}
+			if !reflect.DeepEqual(tt.ingredients, tt.want) {
+				t.Errorf("validated ingredients = %+v, want %+v", tt.ingredients, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecipeIngredients(t *testing.T) {
+	recipe := &Recipe{
+		Ingredients: []RecipeIngredient{{InventoryItemID: 1, Quantity: 18, Unit: "g"}},
+		Options: []OptionRecipe{
+			{OptionID: 10, Ingredients: []RecipeIngredient{{InventoryItemID: 3, Quantity: 200, Unit: "ml"}}},
+			{OptionID: 11, Ingredients: []RecipeIngredient{{InventoryItemID: 1, Quantity: 9, Unit: "g"}}},
+		},
+	}
+
+	got := recipe.recipeIngredients([]int{11, 10, 12})
+	want := []RecipeIngredient{
+		{InventoryItemID: 1, Quantity: 18, Unit: "g"},
+		{InventoryItemID: 1, Quantity: 9, Unit: "g"},
+		{InventoryItemID: 3, Quantity: 200, Unit: "ml"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("recipeIngredients() = %+v, want %+v", got, want)
+	}
+
+	// The recipe itself isn't changed
+	if len(recipe.Ingredients) != 1 {
+		t.Errorf("recipe ingredients = %+v, want the base ingredient only", recipe.Ingredients)
+	}
+}
+
+func TestCanMake(t *testing.T) {
+	inventory := map[int]InventoryItem{
+		1: {ID: 1, ItemName: "Espresso beans", Quantity: 1000, Unit: "g"},
+		2: {ID: 2, ItemName: "Milk", Quantity: 2, Unit: "l"},
+		3: {ID: 3, ItemName: "Oat milk", Quantity: 500, Unit: "ml"},
+		4: {ID: 4, ItemName: "Sugar", Quantity: 1, Unit: "tbsp"},
+		5: {ID: 5, ItemName: "Cups", Quantity: 10, Unit: "each"},
+		6: {ID: 6, ItemName: "Lids", Quantity: 10, Unit: "each"},
+		7: {ID: 7, ItemName: "Syrup", Quantity: 0, Unit: "ml"},
+		8: {ID: 8, ItemName: "Bread", Quantity: -2, Unit: "slice"},
+	}
+
+	tests := []struct {
+		name          string
+		ingredients   []RecipeIngredient
+		wantCanMake   int
+		wantLimitedBy string
+	}{
+		{"one ingredient", []RecipeIngredient{{InventoryItemID: 1, Quantity: 18, Unit: "g"}}, 55, "Espresso beans"},
+		{"the scarcest ingredient limits it", []RecipeIngredient{{InventoryItemID: 1, Quantity: 18, Unit: "g"}, {InventoryItemID: 3, Quantity: 200, Unit: "ml"}}, 2, "Oat milk"},
+		{"converts to the stock unit", []RecipeIngredient{{InventoryItemID: 2, Quantity: 150, Unit: "ml"}}, 13, "Milk"},
+		{"an ingredient used twice adds up", []RecipeIngredient{{InventoryItemID: 1, Quantity: 18, Unit: "g"}, {InventoryItemID: 1, Quantity: 9, Unit: "g"}}, 37, "Espresso beans"},
+		{"rounding from conversion", []RecipeIngredient{{InventoryItemID: 4, Quantity: 3, Unit: "tsp"}}, 1, "Sugar"},
+		{"ties go to the first name", []RecipeIngredient{{InventoryItemID: 6, Quantity: 1, Unit: "each"}, {InventoryItemID: 5, Quantity: 1, Unit: "each"}}, 10, "Cups"},
+		{"out of stock", []RecipeIngredient{{InventoryItemID: 1, Quantity: 18, Unit: "g"}, {InventoryItemID: 7, Quantity: 10, Unit: "ml"}}, 0, "Syrup"},
+		{"negative stock", []RecipeIngredient{{InventoryItemID: 8, Quantity: 2, Unit: "slice"}}, 0, "Bread"},
+		{"missing inventory item", []RecipeIngredient{{InventoryItemID: 99, Quantity: 1, Unit: "each"}}, 0, "inventory item 99"},
+		{"unit no longer converts", []RecipeIngredient{{InventoryItemID: 2, Quantity: 100, Unit: "g"}}, 0, "Milk"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, limitedBy := canMake(tt.ingredients, inventory)
+			if n != tt.wantCanMake || limitedBy != tt.wantLimitedBy {
+				t.Errorf("canMake() = %d, %q, want %d, %q", n, limitedBy, tt.wantCanMake, tt.wantLimitedBy)
+			}
+		})
+	}
+}
@@ -12,6 +12,69 @@ func (app *Config) setupRoutes() {
 	app.router.POST("/menu", app.CreateMenuItem)
 	app.router.PUT("/menu/:id", app.UpdateMenuItem)
 	app.router.DELETE("/menu/:id", app.DeleteMenuItem)
+	app.router.GET("/menu/archived", app.GetArchivedMenuItems)
+	app.router.GET("/menu/drafts", app.GetDrafts)
+	app.router.DELETE("/menu/:id/draft", app.DiscardDraft)
+	app.router.POST("/menu/publish", app.PublishMenu)
+	app.router.POST("/menu/rollback", app.RollBackMenu)
+	app.router.GET("/menu/publications", app.GetPublications)
+	app.router.DELETE("/menu/publications/:id", app.CancelPublication)
+	app.router.POST("/menu/import", app.ImportMenu)
+	app.router.GET("/menu/export", app.ExportMenu)
+	app.router.POST("/menu/:id/restore", app.RestoreMenuItem)
+	app.router.DELETE("/menu/:id/purge", app.PurgeMenuItem)
+	app.router.POST("/menu/sales", app.RecordSales)
+	app.router.POST("/menu/:id/price", app.PriceMenuItem)
+
+	app.router.GET("/menu/:id/recipe", app.GetRecipe)
+	app.router.PUT("/menu/:id/recipe", app.SaveRecipe)
+	app.router.DELETE("/menu/:id/recipe", app.DeleteRecipe)
+	app.router.GET("/menu/:id/can-make", app.GetItemCanMake)
+	app.router.GET("/menu/can-make", app.GetCanMake)
+
+	app.router.PUT("/menu/:id/availability", app.SetAvailability)
+	app.router.POST("/menu/inventory-changed", app.InventoryChanged)
+
+	app.router.GET("/menu/:id/versions", app.GetMenuItemVersions)
+	app.router.GET("/menu/:id/versions/diff", app.DiffMenuItemVersions)
+	app.router.GET("/menu/:id/versions/:version", app.GetMenuItemVersion)
+	app.router.POST("/menu/:id/versions/:version/restore", app.RestoreMenuItemVersion)
+	app.router.GET("/menu/:id/price-history", app.GetPriceHistory)
+
+	app.router.POST("/menu/:id/image", app.UploadMenuItemImage)
+	app.router.DELETE("/menu/:id/image", app.DeleteMenuItemImage)
+	app.router.GET("/images/*key", app.ServeImage)
+
+	app.router.GET("/menu/:id/translations", app.GetMenuItemTranslations)
+	app.router.PUT("/menu/:id/translations/:locale", app.SaveMenuItemTranslation)
+	app.router.DELETE("/menu/:id/translations/:locale", app.DeleteMenuItemTranslation)
+
+	app.router.GET("/categories", app.GetAllCategories)
+	app.router.GET("/categories/:id", app.GetCategory)
+	app.router.POST("/categories", app.CreateCategory)
+	app.router.PUT("/categories/:id", app.UpdateCategory)
+	app.router.DELETE("/categories/:id", app.DeleteCategory)
+	app.router.GET("/categories/:id/translations", app.GetCategoryTranslations)
+	app.router.PUT("/categories/:id/translations/:locale", app.SaveCategoryTranslation)
+	app.router.DELETE("/categories/:id/translations/:locale", app.DeleteCategoryTranslation)
+
+	app.router.GET("/menus", app.GetMenus)
+	app.router.GET("/menus/:id", app.GetMenu)
+	app.router.POST("/menus", app.SaveMenu)
+	app.router.PUT("/menus/:id", app.SaveMenu)
+	app.router.DELETE("/menus/:id", app.DeleteMenu)
+
+	app.router.GET("/combos", app.GetCombos)
+	app.router.GET("/combos/:id", app.GetCombo)
+	app.router.POST("/combos", app.SaveCombo)
+	app.router.PUT("/combos/:id", app.SaveCombo)
+	app.router.DELETE("/combos/:id", app.DeleteCombo)
+	app.router.POST("/combos/:id/price", app.PriceCombo)
+
+	app.router.GET("/price-overrides", app.GetPriceOverrides)
+	app.router.POST("/price-overrides", app.SavePriceOverride)
+	app.router.PUT("/price-overrides/:id", app.SavePriceOverride)
+	app.router.DELETE("/price-overrides/:id", app.DeletePriceOverride)
 	
 	// Health check endpoint
 	app.router.GET("/ping", func(c *gin.Context) {
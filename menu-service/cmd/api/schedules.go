@@ -0,0 +1,881 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// Menu is a collection of menu items served at set times, such as a breakfast menu.
+// Items on at least one active menu are only served while one of their menus is on;
+// items on no menu are served all the time.
+type Menu struct {
+	ID          int          `json:"id"`
+	Name        string       `json:"name"`
+	Description string       `json:"description"`
+	Timezone    string       `json:"timezone"`
+	Active      *bool        `json:"active,omitempty"`
+	Schedules   []TimeWindow `json:"schedules"`
+	ItemIDs     []int        `json:"item_ids"`
+	CreatedAt   string       `json:"created_at"`
+	UpdatedAt   string       `json:"updated_at"`
+	location    *time.Location
+}
+
+// TimeWindow is a daily window of time on some days of the week. Days are numbered from
+// 0 for Sunday, and no days means every day. A window ending before it starts runs past
+// midnight, and an empty window lasts all day.
+type TimeWindow struct {
+	Days      []int  `json:"days"`
+	StartTime string `json:"start_time"`
+	EndTime   string `json:"end_time"`
+}
+
+// PriceOverride changes the price of a menu item, or of every item in a category and
+// its subcategories, between two dates and optionally only in a daily time window, such
+// as a happy hour. It sets either a fixed Price or a PercentOff.
+type PriceOverride struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	MenuItemID *int       `json:"menu_item_id"`
+	CategoryID *int       `json:"category_id"`
+	Price      *float64   `json:"price"`
+	PercentOff *float64   `json:"percent_off"`
+	StartsAt   *time.Time `json:"starts_at"`
+	EndsAt     *time.Time `json:"ends_at"`
+	Window     TimeWindow `json:"window"`
+	Timezone   string     `json:"timezone"`
+	CreatedAt  string     `json:"created_at"`
+	location   *time.Location
+}
+
+// clockMinutes parses an HH:MM time into minutes since midnight
+func clockMinutes(value string) (int, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (w TimeWindow) validate() error {
+	for _, day := range w.Days {
+		if day < 0 || day > 6 {
+			return errors.New("days must be between 0 (Sunday) and 6 (Saturday)")
+		}
+	}
+
+	if (w.StartTime == "") != (w.EndTime == "") {
+		return errors.New("start_time and end_time must be given together")
+	}
+	if w.StartTime == "" {
+		return nil
+	}
+
+	if _, err := clockMinutes(w.StartTime); err != nil {
+		return err
+	}
+	_, err := clockMinutes(w.EndTime)
+	return err
+}
+
+func (w TimeWindow) onDay(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if time.Weekday(d) == day {
+			return true
+		}
+	}
+	return false
+}
+
+// contains reports whether the window is open at t, in the window's timezone
+func (w TimeWindow) contains(t time.Time, loc *time.Location) bool {
+	local := t.In(loc)
+	if w.StartTime == "" {
+		return w.onDay(local.Weekday())
+	}
+
+	start, _ := clockMinutes(w.StartTime)
+	end, _ := clockMinutes(w.EndTime)
+	now := local.Hour()*60 + local.Minute()
+
+	if start <= end {
+		return w.onDay(local.Weekday()) && now >= start && now < end
+	}
+
+	// The part after midnight belongs to the previous day's window
+	yesterday := (local.Weekday() + 6) % 7
+	return (w.onDay(local.Weekday()) && now >= start) || (w.onDay(yesterday) && now < end)
+}
+
+// loadLocation loads a timezone, defaulting to UTC
+func loadLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone: %s", name)
+	}
+	return loc, nil
+}
+
+// timeWindows reads the schedules column, a JSON array of windows
+func timeWindows(data []byte) ([]TimeWindow, error) {
+	windows := []TimeWindow{}
+	if len(data) == 0 {
+		return windows, nil
+	}
+	if err := json.Unmarshal(data, &windows); err != nil {
+		return nil, err
+	}
+	return windows, nil
+}
+
+// getMenus returns every menu with its schedules and items
+func (app *Config) getMenus() ([]Menu, error) {
+	rows, err := app.DB.Query(`select m.id, m.name, m.description, m.timezone, m.active, m.schedules, m.created_at, m.updated_at,
+		coalesce(array_agg(i.menu_item_id order by i.menu_item_id) filter (where i.menu_item_id is not null), '{}')
+		from menus m left join menu_collection_items i on i.menu_id = m.id
+		group by m.id order by m.name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	menus := []Menu{}
+	for rows.Next() {
+		var menu Menu
+		var active bool
+		var schedules []byte
+		var itemIDs pq.Int64Array
+		err := rows.Scan(
+			&menu.ID,
+			&menu.Name,
+			&menu.Description,
+			&menu.Timezone,
+			&active,
+			&schedules,
+			&menu.CreatedAt,
+			&menu.UpdatedAt,
+			&itemIDs,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		menu.Active = &active
+		menu.Schedules, err = timeWindows(schedules)
+		if err != nil {
+			return nil, err
+		}
+		menu.ItemIDs = make([]int, len(itemIDs))
+		for i, id := range itemIDs {
+			menu.ItemIDs[i] = int(id)
+		}
+
+		// A timezone that no longer loads is treated as UTC rather than failing the menu
+		if menu.location, err = loadLocation(menu.Timezone); err != nil {
+			menu.location = time.UTC
+		}
+
+		menus = append(menus, menu)
+	}
+
+	return menus, rows.Err()
+}
+
+// getMenu returns a menu by id
+func (app *Config) getMenu(id int) (Menu, error) {
+	menus, err := app.getMenus()
+	if err != nil {
+		return Menu{}, err
+	}
+
+	for _, menu := range menus {
+		if menu.ID == id {
+			return menu, nil
+		}
+	}
+
+	return Menu{}, errMenuNotFound
+}
+
+// saveMenu inserts or updates a menu and replaces its items
+func (app *Config) saveMenu(menu Menu) (int, error) {
+	schedules, err := json.Marshal(menu.Schedules)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now().Format(time.RFC3339)
+	active := menu.Active == nil || *menu.Active
+
+	if menu.ID == 0 {
+		err = tx.QueryRow(`insert into menus (name, description, timezone, active, schedules, created_at, updated_at)
+			values ($1, $2, $3, $4, $5, $6, $7) returning id`,
+			menu.Name, menu.Description, menu.Timezone, active, schedules, now, now).Scan(&menu.ID)
+	} else {
+		_, err = tx.Exec(`update menus set name = $1, description = $2, timezone = $3, active = $4, schedules = $5, updated_at = $6
+			where id = $7`,
+			menu.Name, menu.Description, menu.Timezone, active, schedules, now, menu.ID)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`delete from menu_collection_items where menu_id = $1`, menu.ID)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, itemID := range menu.ItemIDs {
+		_, err = tx.Exec(`insert into menu_collection_items (menu_id, menu_item_id) values ($1, $2) on conflict do nothing`,
+			menu.ID, itemID)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return menu.ID, tx.Commit()
+}
+
+// validateMenu checks a menu before it is saved
+func (app *Config) validateMenu(menu *Menu) error {
+	menu.Name = strings.TrimSpace(menu.Name)
+	if menu.Name == "" {
+		return errors.New("name is required")
+	}
+
+	if menu.Timezone == "" {
+		menu.Timezone = "UTC"
+	}
+	if _, err := loadLocation(menu.Timezone); err != nil {
+		return err
+	}
+
+	if menu.Schedules == nil {
+		menu.Schedules = []TimeWindow{}
+	}
+	for _, window := range menu.Schedules {
+		if err := window.validate(); err != nil {
+			return err
+		}
+	}
+
+	if len(menu.ItemIDs) > 0 {
+		ids := make([]int64, len(menu.ItemIDs))
+		for i, id := range menu.ItemIDs {
+			ids[i] = int64(id)
+		}
+
+		var found int
+		err := app.DB.QueryRow(`select count(*) from menu_items where id = any($1)`, pq.Array(ids)).Scan(&found)
+		if err != nil {
+			return err
+		}
+		if found != len(uniqueInts(menu.ItemIDs)) {
+			return errors.New("item_ids contains menu items that don't exist")
+		}
+	}
+
+	return nil
+}
+
+func uniqueInts(values []int) []int {
+	seen := make(map[int]bool, len(values))
+	unique := []int{}
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
+
+const priceOverrideColumns = `id, name, menu_item_id, category_id, price, percent_off, starts_at, ends_at, time_window, timezone, created_at`
+
+func scanPriceOverride(row rowScanner) (PriceOverride, error) {
+	var override PriceOverride
+	var menuItemID, categoryID sql.NullInt64
+	var price, percentOff sql.NullFloat64
+	var startsAt, endsAt sql.NullTime
+	var window []byte
+
+	err := row.Scan(
+		&override.ID,
+		&override.Name,
+		&menuItemID,
+		&categoryID,
+		&price,
+		&percentOff,
+		&startsAt,
+		&endsAt,
+		&window,
+		&override.Timezone,
+		&override.CreatedAt,
+	)
+	if err != nil {
+		return PriceOverride{}, err
+	}
+
+	if menuItemID.Valid {
+		id := int(menuItemID.Int64)
+		override.MenuItemID = &id
+	}
+	if categoryID.Valid {
+		id := int(categoryID.Int64)
+		override.CategoryID = &id
+	}
+	if price.Valid {
+		override.Price = &price.Float64
+	}
+	if percentOff.Valid {
+		override.PercentOff = &percentOff.Float64
+	}
+	if startsAt.Valid {
+		override.StartsAt = &startsAt.Time
+	}
+	if endsAt.Valid {
+		override.EndsAt = &endsAt.Time
+	}
+	override.Window.Days = []int{}
+	if len(window) > 0 {
+		if err := json.Unmarshal(window, &override.Window); err != nil {
+			return PriceOverride{}, err
+		}
+	}
+
+	if override.location, err = loadLocation(override.Timezone); err != nil {
+		override.location = time.UTC
+	}
+
+	return override, nil
+}
+
+// getPriceOverrides returns every price override, latest first
+func (app *Config) getPriceOverrides() ([]PriceOverride, error) {
+	rows, err := app.DB.Query(`select ` + priceOverrideColumns + ` from price_overrides order by id desc`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	overrides := []PriceOverride{}
+	for rows.Next() {
+		override, err := scanPriceOverride(rows)
+		if err != nil {
+			return nil, err
+		}
+		overrides = append(overrides, override)
+	}
+
+	return overrides, rows.Err()
+}
+
+// validatePriceOverride checks a price override before it is saved
+func (app *Config) validatePriceOverride(override *PriceOverride) error {
+	if (override.MenuItemID == nil) == (override.CategoryID == nil) {
+		return errors.New("set exactly one of menu_item_id and category_id")
+	}
+	if (override.Price == nil) == (override.PercentOff == nil) {
+		return errors.New("set exactly one of price and percent_off")
+	}
+	if override.Price != nil && *override.Price < 0 {
+		return errors.New("price can't be negative")
+	}
+	if override.PercentOff != nil && (*override.PercentOff <= 0 || *override.PercentOff > 100) {
+		return errors.New("percent_off must be greater than 0 and at most 100")
+	}
+	if override.StartsAt != nil && override.EndsAt != nil && !override.EndsAt.After(*override.StartsAt) {
+		return errors.New("ends_at must be after starts_at")
+	}
+	if err := override.Window.validate(); err != nil {
+		return err
+	}
+
+	if override.Timezone == "" {
+		override.Timezone = "UTC"
+	}
+	if _, err := loadLocation(override.Timezone); err != nil {
+		return err
+	}
+
+	if override.MenuItemID != nil {
+		if _, err := app.getMenuItemByID(*override.MenuItemID); err != nil {
+			return errMenuItemNotFound
+		}
+	}
+	if override.CategoryID != nil {
+		if _, err := app.getCategoryByID(*override.CategoryID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// savePriceOverride inserts or updates a price override and returns its id
+func (app *Config) savePriceOverride(override PriceOverride) (int, error) {
+	if override.Window.Days == nil {
+		override.Window.Days = []int{}
+	}
+	window, err := json.Marshal(override.Window)
+	if err != nil {
+		return 0, err
+	}
+
+	args := []any{override.Name, override.MenuItemID, override.CategoryID, override.Price, override.PercentOff,
+		override.StartsAt, override.EndsAt, window, override.Timezone}
+
+	if override.ID == 0 {
+		err = app.DB.QueryRow(`insert into price_overrides (name, menu_item_id, category_id, price, percent_off, starts_at, ends_at,
+			time_window, timezone) values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`, args...).Scan(&override.ID)
+		return override.ID, err
+	}
+
+	_, err = app.DB.Exec(`update price_overrides set name = $1, menu_item_id = $2, category_id = $3, price = $4, percent_off = $5,
+		starts_at = $6, ends_at = $7, time_window = $8, timezone = $9 where id = $10`, append(args, override.ID)...)
+	return override.ID, err
+}
+
+// activeAt reports whether the override applies at t
+func (o PriceOverride) activeAt(t time.Time) bool {
+	if o.StartsAt != nil && t.Before(*o.StartsAt) {
+		return false
+	}
+	if o.EndsAt != nil && !t.Before(*o.EndsAt) {
+		return false
+	}
+	return o.Window.contains(t, o.location)
+}
+
+// apply returns a price with the override applied
+func (o PriceOverride) apply(price float64) float64 {
+	if o.Price != nil {
+		return *o.Price
+	}
+	return math.Max(math.Round(price*(100-*o.PercentOff))/100, 0)
+}
+
+// schedule holds the menus, price overrides and category tree needed to work out what
+// is served, and at what price, at a given time
+type schedule struct {
+	menus     []Menu
+	overrides []PriceOverride
+	parents   map[int]int
+}
+
+// loadSchedule reads the menus and price overrides
+func (app *Config) loadSchedule() (*schedule, error) {
+	menus, err := app.getMenus()
+	if err != nil {
+		return nil, err
+	}
+
+	overrides, err := app.getPriceOverrides()
+	if err != nil {
+		return nil, err
+	}
+
+	categories, err := app.getAllCategories()
+	if err != nil {
+		return nil, err
+	}
+
+	parents := make(map[int]int, len(categories))
+	for _, category := range categories {
+		if category.ParentID != nil {
+			parents[category.ID] = *category.ParentID
+		}
+	}
+
+	return &schedule{menus: menus, overrides: overrides, parents: parents}, nil
+}
+
+// on reports whether a menu is being served at t
+func (menu Menu) on(t time.Time) bool {
+	if menu.Active != nil && !*menu.Active {
+		return false
+	}
+	if len(menu.Schedules) == 0 {
+		return true
+	}
+	for _, window := range menu.Schedules {
+		if window.contains(t, menu.location) {
+			return true
+		}
+	}
+	return false
+}
+
+// notServed returns the ids of items that are on active menus, none of which is being
+// served at t
+func (s *schedule) notServed(t time.Time) []int64 {
+	served := make(map[int]bool)
+	for _, menu := range s.menus {
+		if menu.Active != nil && !*menu.Active {
+			continue
+		}
+		on := menu.on(t)
+		for _, itemID := range menu.ItemIDs {
+			served[itemID] = served[itemID] || on
+		}
+	}
+
+	ids := []int64{}
+	for itemID, ok := range served {
+		if !ok {
+			ids = append(ids, int64(itemID))
+		}
+	}
+	return ids
+}
+
+// servedAt reports whether an item is being served at t
+func (s *schedule) servedAt(itemID int, t time.Time) bool {
+	for _, id := range s.notServed(t) {
+		if int(id) == itemID {
+			return false
+		}
+	}
+	return true
+}
+
+// inCategory reports whether a category is, or is under, another category
+func (s *schedule) inCategory(categoryID, ancestorID int) bool {
+	for depth := 0; categoryID != 0 && depth < 100; depth++ {
+		if categoryID == ancestorID {
+			return true
+		}
+		categoryID = s.parents[categoryID]
+	}
+	return false
+}
+
+// priceAt returns an item's price at t. Overrides for the item itself win over those for
+// its category; when several apply, the customer gets the lowest price.
+func (s *schedule) priceAt(item MenuItem, t time.Time) float64 {
+	var itemPrices, categoryPrices []float64
+	for _, override := range s.overrides {
+		if !override.activeAt(t) {
+			continue
+		}
+
+		switch {
+		case override.MenuItemID != nil && *override.MenuItemID == item.ID:
+			itemPrices = append(itemPrices, override.apply(item.Price))
+		case override.CategoryID != nil && s.inCategory(item.CategoryID, *override.CategoryID):
+			categoryPrices = append(categoryPrices, override.apply(item.Price))
+		}
+	}
+
+	prices := itemPrices
+	if len(prices) == 0 {
+		prices = categoryPrices
+	}
+
+	price := item.Price
+	for i, p := range prices {
+		if i == 0 || p < price {
+			price = p
+		}
+	}
+	return price
+}
+
+// applyPrices sets the prices of items to their prices at t, keeping the usual price in
+// RegularPrice when it differs
+func (s *schedule) applyPrices(items []MenuItem, t time.Time) {
+	for i := range items {
+		price := s.priceAt(items[i], t)
+		if price != items[i].Price {
+			regular := items[i].Price
+			items[i].RegularPrice = &regular
+			items[i].Price = price
+		}
+	}
+}
+
+var errMenuNotFound = errors.New("menu not found")
+
+// parseAt reads the at query parameter, an RFC 3339 timestamp or "now"
+func parseAt(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if value == "now" {
+		now := time.Now()
+		return &now, nil
+	}
+
+	at, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, errors.New("at must be an RFC 3339 timestamp, such as 2024-05-01T09:30:00Z")
+	}
+	return &at, nil
+}
+
+func (app *Config) GetMenus(c *gin.Context) {
+	menus, err := app.getMenus()
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+		Data    []Menu `json:"data"`
+	}{
+		Error:   false,
+		Message: "Menus retrieved",
+		Data:    menus,
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+func (app *Config) GetMenu(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		app.errorJSON(c, errors.New("invalid id parameter"), http.StatusBadRequest)
+		return
+	}
+
+	menu, err := app.getMenu(id)
+	if errors.Is(err, errMenuNotFound) {
+		app.errorJSON(c, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+		Data    Menu   `json:"data"`
+	}{
+		Error:   false,
+		Message: "Menu retrieved",
+		Data:    menu,
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+// SaveMenu creates a menu, or replaces one when called with an id parameter
+func (app *Config) SaveMenu(c *gin.Context) {
+	var menu Menu
+	err := app.readJSON(c, &menu)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	status, message := http.StatusCreated, "Menu created"
+	menu.ID = 0
+	if c.Param("id") != "" {
+		menu.ID, err = strconv.Atoi(c.Param("id"))
+		if err != nil {
+			app.errorJSON(c, errors.New("invalid id parameter"), http.StatusBadRequest)
+			return
+		}
+		if _, err := app.getMenu(menu.ID); err != nil {
+			app.errorJSON(c, err, http.StatusNotFound)
+			return
+		}
+		status, message = http.StatusOK, "Menu updated"
+	}
+
+	err = app.validateMenu(&menu)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	id, err := app.saveMenu(menu)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	saved, err := app.getMenu(id)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+		Data    Menu   `json:"data"`
+	}{
+		Error:   false,
+		Message: message,
+		Data:    saved,
+	}
+
+	app.writeJSON(c, status, payload)
+}
+
+func (app *Config) DeleteMenu(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		app.errorJSON(c, errors.New("invalid id parameter"), http.StatusBadRequest)
+		return
+	}
+
+	result, err := app.DB.Exec(`delete from menus where id = $1`, id)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		app.errorJSON(c, errMenuNotFound, http.StatusNotFound)
+		return
+	}
+
+	payload := struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}{
+		Error:   false,
+		Message: "Menu deleted",
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+func (app *Config) GetPriceOverrides(c *gin.Context) {
+	overrides, err := app.getPriceOverrides()
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool            `json:"error"`
+		Message string          `json:"message"`
+		Data    []PriceOverride `json:"data"`
+	}{
+		Error:   false,
+		Message: "Price overrides retrieved",
+		Data:    overrides,
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+// SavePriceOverride creates a price override, or replaces one when called with an id
+// parameter
+func (app *Config) SavePriceOverride(c *gin.Context) {
+	var override PriceOverride
+	err := app.readJSON(c, &override)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	status, message := http.StatusCreated, "Price override created"
+	override.ID = 0
+	if c.Param("id") != "" {
+		override.ID, err = strconv.Atoi(c.Param("id"))
+		if err != nil {
+			app.errorJSON(c, errors.New("invalid id parameter"), http.StatusBadRequest)
+			return
+		}
+
+		var exists bool
+		err = app.DB.QueryRow(`select exists (select 1 from price_overrides where id = $1)`, override.ID).Scan(&exists)
+		if err != nil {
+			app.errorJSON(c, err, http.StatusInternalServerError)
+			return
+		}
+		if !exists {
+			app.errorJSON(c, errors.New("price override not found"), http.StatusNotFound)
+			return
+		}
+		status, message = http.StatusOK, "Price override updated"
+	}
+
+	err = app.validatePriceOverride(&override)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	id, err := app.savePriceOverride(override)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	saved, err := scanPriceOverride(app.DB.QueryRow(`select `+priceOverrideColumns+` from price_overrides where id = $1`, id))
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool          `json:"error"`
+		Message string        `json:"message"`
+		Data    PriceOverride `json:"data"`
+	}{
+		Error:   false,
+		Message: message,
+		Data:    saved,
+	}
+
+	app.writeJSON(c, status, payload)
+}
+
+func (app *Config) DeletePriceOverride(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		app.errorJSON(c, errors.New("invalid id parameter"), http.StatusBadRequest)
+		return
+	}
+
+	result, err := app.DB.Exec(`delete from price_overrides where id = $1`, id)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		app.errorJSON(c, errors.New("price override not found"), http.StatusNotFound)
+		return
+	}
+
+	payload := struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}{
+		Error:   false,
+		Message: "Price override deleted",
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
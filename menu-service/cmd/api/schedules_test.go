@@ -0,0 +1,182 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// monday is a Monday at midnight UTC
+var monday = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// at returns the time on a day after monday at a given hour and minute, in UTC
+func at(day, hour, minute int) time.Time {
+	return monday.AddDate(0, 0, day).Add(time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute)
+}
+
+func TestTimeWindowContains(t *testing.T) {
+	weekdays := []int{1, 2, 3, 4, 5}
+	plusTwo := time.FixedZone("UTC+2", 2*60*60)
+
+	tests := []struct {
+		name   string
+		window TimeWindow
+		t      time.Time
+		loc    *time.Location
+		want   bool
+	}{
+		{"all day every day", TimeWindow{}, at(0, 3, 0), time.UTC, true},
+		{"all day on the day", TimeWindow{Days: []int{1}}, at(0, 23, 59), time.UTC, true},
+		{"all day on another day", TimeWindow{Days: []int{0, 6}}, at(0, 12, 0), time.UTC, false},
+		{"at the start", TimeWindow{StartTime: "11:00", EndTime: "15:00"}, at(0, 11, 0), time.UTC, true},
+		{"inside", TimeWindow{StartTime: "11:00", EndTime: "15:00"}, at(0, 14, 59), time.UTC, true},
+		{"at the end", TimeWindow{StartTime: "11:00", EndTime: "15:00"}, at(0, 15, 0), time.UTC, false},
+		{"before the start", TimeWindow{StartTime: "11:00", EndTime: "15:00"}, at(0, 10, 59), time.UTC, false},
+		{"weekday window on a weekday", TimeWindow{Days: weekdays, StartTime: "11:00", EndTime: "15:00"}, at(4, 12, 0), time.UTC, true},
+		{"weekday window at the weekend", TimeWindow{Days: weekdays, StartTime: "11:00", EndTime: "15:00"}, at(5, 12, 0), time.UTC, false},
+		{"overnight before midnight", TimeWindow{StartTime: "22:00", EndTime: "02:00"}, at(0, 23, 0), time.UTC, true},
+		{"overnight after midnight", TimeWindow{StartTime: "22:00", EndTime: "02:00"}, at(1, 1, 59), time.UTC, true},
+		{"overnight at the end", TimeWindow{StartTime: "22:00", EndTime: "02:00"}, at(1, 2, 0), time.UTC, false},
+		{"overnight in the gap", TimeWindow{StartTime: "22:00", EndTime: "02:00"}, at(0, 12, 0), time.UTC, false},
+		// Friday night's window runs into Saturday morning, but Saturday's doesn't start
+		{"overnight from the last day", TimeWindow{Days: []int{5}, StartTime: "22:00", EndTime: "02:00"}, at(5, 1, 0), time.UTC, true},
+		{"overnight on a day after the last", TimeWindow{Days: []int{5}, StartTime: "22:00", EndTime: "02:00"}, at(5, 23, 0), time.UTC, false},
+		{"overnight from the day before the first", TimeWindow{Days: []int{5}, StartTime: "22:00", EndTime: "02:00"}, at(4, 1, 0), time.UTC, false},
+		{"in the window's timezone", TimeWindow{StartTime: "11:00", EndTime: "15:00"}, at(0, 9, 30), plusTwo, true},
+		{"outside in the window's timezone", TimeWindow{StartTime: "11:00", EndTime: "15:00"}, at(0, 13, 30), plusTwo, false},
+		{"day in the window's timezone", TimeWindow{Days: []int{2}}, at(0, 23, 0), plusTwo, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.window.contains(tt.t, tt.loc); got != tt.want {
+				t.Errorf("contains(%v) = %v, want %v", tt.t, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPriceAt(t *testing.T) {
+	intPtr := func(v int) *int { return &v }
+	floatPtr := func(v float64) *float64 { return &v }
+	timePtr := func(v time.Time) *time.Time { return &v }
+
+	const (
+		drinks = 1
+		beer   = 2
+		food   = 3
+	)
+	item := MenuItem{ID: 7, Price: 5, CategoryID: beer}
+
+	happyHour := TimeWindow{StartTime: "17:00", EndTime: "19:00"}
+	noon := at(0, 12, 0)
+
+	tests := []struct {
+		name      string
+		overrides []PriceOverride
+		t         time.Time
+		want      float64
+	}{
+		{
+			name: "no overrides",
+			t:    noon,
+			want: 5,
+		},
+		{
+			name:      "fixed price for the item",
+			overrides: []PriceOverride{{MenuItemID: intPtr(7), Price: floatPtr(4)}},
+			t:         noon,
+			want:      4,
+		},
+		{
+			name:      "percent off for the item",
+			overrides: []PriceOverride{{MenuItemID: intPtr(7), PercentOff: floatPtr(15)}},
+			t:         noon,
+			want:      4.25,
+		},
+		{
+			name:      "override for another item",
+			overrides: []PriceOverride{{MenuItemID: intPtr(8), Price: floatPtr(1)}},
+			t:         noon,
+			want:      5,
+		},
+		{
+			name:      "override for the item's category",
+			overrides: []PriceOverride{{CategoryID: intPtr(beer), PercentOff: floatPtr(50)}},
+			t:         noon,
+			want:      2.5,
+		},
+		{
+			name:      "override for a parent category",
+			overrides: []PriceOverride{{CategoryID: intPtr(drinks), PercentOff: floatPtr(10)}},
+			t:         noon,
+			want:      4.5,
+		},
+		{
+			name:      "override for another category",
+			overrides: []PriceOverride{{CategoryID: intPtr(food), PercentOff: floatPtr(10)}},
+			t:         noon,
+			want:      5,
+		},
+		{
+			name: "item override wins over a cheaper category override",
+			overrides: []PriceOverride{
+				{CategoryID: intPtr(drinks), Price: floatPtr(1)},
+				{MenuItemID: intPtr(7), Price: floatPtr(4)},
+			},
+			t:    noon,
+			want: 4,
+		},
+		{
+			name: "lowest of several item overrides",
+			overrides: []PriceOverride{
+				{MenuItemID: intPtr(7), Price: floatPtr(4)},
+				{MenuItemID: intPtr(7), PercentOff: floatPtr(30)},
+			},
+			t:    noon,
+			want: 3.5,
+		},
+		{
+			name:      "inside the time window",
+			overrides: []PriceOverride{{MenuItemID: intPtr(7), Price: floatPtr(3), Window: happyHour}},
+			t:         at(0, 17, 30),
+			want:      3,
+		},
+		{
+			name:      "outside the time window",
+			overrides: []PriceOverride{{MenuItemID: intPtr(7), Price: floatPtr(3), Window: happyHour}},
+			t:         noon,
+			want:      5,
+		},
+		{
+			name:      "before the override starts",
+			overrides: []PriceOverride{{MenuItemID: intPtr(7), Price: floatPtr(3), StartsAt: timePtr(at(1, 0, 0))}},
+			t:         noon,
+			want:      5,
+		},
+		{
+			name:      "when the override ends",
+			overrides: []PriceOverride{{MenuItemID: intPtr(7), Price: floatPtr(3), EndsAt: timePtr(noon)}},
+			t:         noon,
+			want:      5,
+		},
+		{
+			name:      "between the dates",
+			overrides: []PriceOverride{{MenuItemID: intPtr(7), Price: floatPtr(3), StartsAt: timePtr(monday), EndsAt: timePtr(at(1, 0, 0))}},
+			t:         noon,
+			want:      3,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := range tt.overrides {
+				tt.overrides[i].location = time.UTC
+			}
+			s := &schedule{overrides: tt.overrides, parents: map[int]int{beer: drinks}}
+
+			if got := s.priceAt(item, tt.t); got != tt.want {
+				t.Errorf("priceAt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+)
+
+// defaultLocale is the language menu items and categories are written in. Other
+// locales are added as translations, and anything left untranslated falls back to it.
+const defaultLocale = "en"
+
+var errTranslationNotFound = errors.New("translation not found")
+
+// Translation is the name and description of a menu item or category in one locale
+type Translation struct {
+	Locale      string `json:"locale"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	UpdatedAt   string `json:"updated_at"`
+}
+
+// translationKind is what a translation belongs to, and where its translations are kept
+type translationKind struct {
+	table    string
+	idColumn string
+	owners   string
+	notFound error
+}
+
+var (
+	menuItemTranslations = translationKind{"menu_item_translations", "menu_item_id", "menu_items", errMenuItemNotFound}
+	categoryTranslations = translationKind{"category_translations", "category_id", "menu_categories", errCategoryNotFound}
+)
+
+// normalizeLocale tidies a locale such as es_mx into es-MX. Only a language and an
+// optional region are accepted.
+func normalizeLocale(locale string) (string, bool) {
+	parts := strings.FieldsFunc(strings.TrimSpace(locale), func(r rune) bool { return r == '-' || r == '_' })
+	if len(parts) == 0 || len(parts) > 2 {
+		return "", false
+	}
+
+	language := strings.ToLower(parts[0])
+	if len(language) < 2 || len(language) > 3 || !isLetters(language) {
+		return "", false
+	}
+	if len(parts) == 1 {
+		return language, true
+	}
+
+	region := strings.ToUpper(parts[1])
+	if len(region) != 2 || !isLetters(region) {
+		return "", false
+	}
+
+	return language + "-" + region, true
+}
+
+func isLetters(s string) bool {
+	for _, r := range s {
+		if (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') {
+			return false
+		}
+	}
+	return true
+}
+
+// requestLocales lists the locales a request asks for, most wanted first, from ?lang=
+// or else the Accept-Language header. A regional locale is followed by its language,
+// so es-MX falls back to es. The list stops at the default language, since nothing
+// needs translating from there on.
+func requestLocales(c *gin.Context) []string {
+	var wanted []string
+	if lang := c.Query("lang"); lang != "" {
+		wanted = []string{lang}
+	} else {
+		wanted = parseAcceptLanguage(c.GetHeader("Accept-Language"))
+	}
+
+	var locales []string
+	seen := map[string]bool{}
+	for _, locale := range wanted {
+		locale, ok := normalizeLocale(locale)
+		if !ok {
+			continue
+		}
+
+		language, _, _ := strings.Cut(locale, "-")
+		if language == defaultLocale {
+			return locales
+		}
+		for _, l := range []string{locale, language} {
+			if !seen[l] {
+				seen[l] = true
+				locales = append(locales, l)
+			}
+		}
+	}
+
+	return locales
+}
+
+// parseAcceptLanguage returns the languages in an Accept-Language header ordered by
+// their quality, leaving out wildcards and languages marked q=0
+func parseAcceptLanguage(header string) []string {
+	type language struct {
+		tag     string
+		quality float64
+	}
+
+	var languages []language
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.TrimSpace(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+
+		quality := 1.0
+		for _, param := range strings.Split(params, ";") {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+				if err != nil {
+					q = 0
+				}
+				quality = q
+			}
+		}
+		if quality <= 0 {
+			continue
+		}
+
+		languages = append(languages, language{tag, quality})
+	}
+
+	sort.SliceStable(languages, func(i, j int) bool { return languages[i].quality > languages[j].quality })
+
+	tags := make([]string, 0, len(languages))
+	for _, l := range languages {
+		tags = append(tags, l.tag)
+	}
+	return tags
+}
+
+// getTranslations returns every translation of a menu item or category
+func (app *Config) getTranslations(kind translationKind, id int) ([]Translation, error) {
+	rows, err := app.DB.Query(fmt.Sprintf(`select locale, name, description, updated_at from %s
+		where %s = $1 order by locale`, kind.table, kind.idColumn), id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	translations := []Translation{}
+	for rows.Next() {
+		var t Translation
+		err := rows.Scan(&t.Locale, &t.Name, &t.Description, &t.UpdatedAt)
+		if err != nil {
+			return nil, err
+		}
+		translations = append(translations, t)
+	}
+
+	return translations, rows.Err()
+}
+
+// saveTranslation adds or replaces the translation of a menu item or category
+func (app *Config) saveTranslation(kind translationKind, id int, t Translation) error {
+	_, err := app.DB.Exec(fmt.Sprintf(`insert into %s (%s, locale, name, description)
+		values ($1, $2, $3, $4)
+		on conflict (%s, locale) do update set name = excluded.name, description = excluded.description,
+		updated_at = now()`, kind.table, kind.idColumn, kind.idColumn), id, t.Locale, t.Name, t.Description)
+	return err
+}
+
+// deleteTranslation removes the translation of a menu item or category into a locale
+func (app *Config) deleteTranslation(kind translationKind, id int, locale string) error {
+	result, err := app.DB.Exec(fmt.Sprintf(`delete from %s where %s = $1 and locale = $2`, kind.table, kind.idColumn),
+		id, locale)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return errTranslationNotFound
+	}
+
+	return nil
+}
+
+// bestTranslations returns, for each id, its translation into the first of locales it
+// has been translated into. Ids without any of those translations are left out.
+func (app *Config) bestTranslations(kind translationKind, ids []int, locales []string) (map[int]Translation, error) {
+	best := map[int]Translation{}
+	if len(ids) == 0 || len(locales) == 0 {
+		return best, nil
+	}
+
+	rows, err := app.DB.Query(fmt.Sprintf(`select %s, locale, name, description from %s
+		where %s = any($1) and locale = any($2::text[])
+		order by array_position($2::text[], locale::text)`, kind.idColumn, kind.table, kind.idColumn),
+		pq.Array(ids), pq.Array(locales))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int
+		var t Translation
+		err := rows.Scan(&id, &t.Locale, &t.Name, &t.Description)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := best[id]; !ok {
+			best[id] = t
+		}
+	}
+
+	return best, rows.Err()
+}
+
+// localizeMenuItems translates the names and descriptions of menu items, and the names
+// of their categories, into the first of locales that has a translation. Each item's
+// Locale is set to the locale its name ended up in.
+func (app *Config) localizeMenuItems(items []MenuItem, locales []string) error {
+	itemIDs := make([]int, 0, len(items))
+	var categoryIDs []int
+	for _, item := range items {
+		itemIDs = append(itemIDs, item.ID)
+		if item.CategoryID != 0 {
+			categoryIDs = append(categoryIDs, item.CategoryID)
+		}
+	}
+
+	itemTranslations, err := app.bestTranslations(menuItemTranslations, itemIDs, locales)
+	if err != nil {
+		return err
+	}
+	categoryNames, err := app.bestTranslations(categoryTranslations, uniqueInts(categoryIDs), locales)
+	if err != nil {
+		return err
+	}
+
+	for i := range items {
+		item := &items[i]
+		item.Locale = defaultLocale
+		if t, ok := itemTranslations[item.ID]; ok {
+			item.Locale = t.Locale
+			item.Name = t.Name
+			if t.Description != "" {
+				item.Description = t.Description
+			}
+		}
+		if t, ok := categoryNames[item.CategoryID]; ok {
+			item.Category = t.Name
+		}
+	}
+
+	return nil
+}
+
+// localizeCategories translates category names and descriptions into the first of
+// locales that has a translation
+func (app *Config) localizeCategories(categories []Category, locales []string) error {
+	ids := make([]int, 0, len(categories))
+	for _, category := range categories {
+		ids = append(ids, category.ID)
+	}
+
+	translations, err := app.bestTranslations(categoryTranslations, ids, locales)
+	if err != nil {
+		return err
+	}
+
+	for i := range categories {
+		if t, ok := translations[categories[i].ID]; ok {
+			categories[i].Name = t.Name
+			if t.Description != "" {
+				categories[i].Description = t.Description
+			}
+		}
+	}
+
+	return nil
+}
+
+// translationOwnerFromParam reads the id of the menu item or category whose
+// translations are being managed, and checks that it exists
+func (app *Config) translationOwnerFromParam(c *gin.Context, kind translationKind) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		app.errorJSON(c, errors.New("invalid id parameter"), http.StatusBadRequest)
+		return 0, false
+	}
+
+	var exists bool
+	err = app.DB.QueryRow(fmt.Sprintf(`select exists (select 1 from %s where id = $1)`, kind.owners), id).Scan(&exists)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return 0, false
+	}
+	if !exists {
+		app.errorJSON(c, kind.notFound, http.StatusNotFound)
+		return 0, false
+	}
+
+	return id, true
+}
+
+// localeFromParam reads the locale of the translation being changed. The default
+// language has no translations, since it is the item or category itself.
+func (app *Config) localeFromParam(c *gin.Context) (string, bool) {
+	locale, ok := normalizeLocale(c.Param("locale"))
+	if !ok {
+		app.errorJSON(c, errors.New("invalid locale"), http.StatusBadRequest)
+		return "", false
+	}
+	if language, _, _ := strings.Cut(locale, "-"); language == defaultLocale {
+		app.errorJSON(c, fmt.Errorf("%s is the default locale; update the item itself instead", defaultLocale),
+			http.StatusBadRequest)
+		return "", false
+	}
+
+	return locale, true
+}
+
+func (app *Config) listTranslations(c *gin.Context, kind translationKind) {
+	id, ok := app.translationOwnerFromParam(c, kind)
+	if !ok {
+		return
+	}
+
+	translations, err := app.getTranslations(kind, id)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool          `json:"error"`
+		Message string        `json:"message"`
+		Data    []Translation `json:"data"`
+	}{
+		Error:   false,
+		Message: "Translations retrieved",
+		Data:    translations,
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+func (app *Config) putTranslation(c *gin.Context, kind translationKind) {
+	id, ok := app.translationOwnerFromParam(c, kind)
+	if !ok {
+		return
+	}
+	locale, ok := app.localeFromParam(c)
+	if !ok {
+		return
+	}
+
+	var translation Translation
+	err := app.readJSON(c, &translation)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	translation.Locale = locale
+	translation.Name = strings.TrimSpace(translation.Name)
+	translation.Description = strings.TrimSpace(translation.Description)
+	if translation.Name == "" {
+		app.errorJSON(c, errors.New("translation name is required"), http.StatusBadRequest)
+		return
+	}
+
+	err = app.saveTranslation(kind, id, translation)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool        `json:"error"`
+		Message string      `json:"message"`
+		Data    Translation `json:"data"`
+	}{
+		Error:   false,
+		Message: "Translation saved",
+		Data:    translation,
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+func (app *Config) removeTranslation(c *gin.Context, kind translationKind) {
+	id, ok := app.translationOwnerFromParam(c, kind)
+	if !ok {
+		return
+	}
+	locale, ok := app.localeFromParam(c)
+	if !ok {
+		return
+	}
+
+	err := app.deleteTranslation(kind, id, locale)
+	if errors.Is(err, errTranslationNotFound) {
+		app.errorJSON(c, err, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}{
+		Error:   false,
+		Message: "Translation deleted",
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+func (app *Config) GetMenuItemTranslations(c *gin.Context) {
+	app.listTranslations(c, menuItemTranslations)
+}
+
+func (app *Config) SaveMenuItemTranslation(c *gin.Context) {
+	app.putTranslation(c, menuItemTranslations)
+}
+
+func (app *Config) DeleteMenuItemTranslation(c *gin.Context) {
+	app.removeTranslation(c, menuItemTranslations)
+}
+
+func (app *Config) GetCategoryTranslations(c *gin.Context) {
+	app.listTranslations(c, categoryTranslations)
+}
+
+func (app *Config) SaveCategoryTranslation(c *gin.Context) {
+	app.putTranslation(c, categoryTranslations)
+}
+
+func (app *Config) DeleteCategoryTranslation(c *gin.Context) {
+	app.removeTranslation(c, categoryTranslations)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNormalizeLocale(t *testing.T) {
+	tests := []struct {
+		locale string
+		want   string
+		wantOK bool
+	}{
+		{"es", "es", true},
+		{" FR ", "fr", true},
+		{"es_mx", "es-MX", true},
+		{"pt-br", "pt-BR", true},
+		{"fil", "fil", true},
+		{"", "", false},
+		{"e", "", false},
+		{"engl", "", false},
+		{"e5", "", false},
+		{"es-419", "", false},
+		{"zh-Hant-TW", "", false},
+		{"es-", "es", true},
+	}
+
+	for _, tt := range tests {
+		got, ok := normalizeLocale(tt.locale)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("normalizeLocale(%q) = %q, %v, want %q, %v", tt.locale, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestIsLetters(t *testing.T) {
+	for s, want := range map[string]bool{"es": true, "MX": true, "": true, "e5": false, "é": false, "e-s": false} {
+		if got := isLetters(s); got != want {
+			t.Errorf("isLetters(%q) = %v, want %v", s, got, want)
+		}
+	}
+}
+
+func TestParseAcceptLanguage(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []string
+	}{
+		{"", []string{}},
+		{"es", []string{"es"}},
+		{"fr;q=0.5, es-MX, es;q=0.8", []string{"es-MX", "es", "fr"}},
+		{"de;q=0.7, fr;q=0.7, it", []string{"it", "de", "fr"}},
+		{"*, es;q=0.9", []string{"es"}},
+		{"es;q=0, fr", []string{"fr"}},
+		{"es;q=high, fr;q=0.2", []string{"fr"}},
+		{" , es ; q=0.4 ;level=1", []string{"es"}},
+	}
+
+	for _, tt := range tests {
+		if got := parseAcceptLanguage(tt.header); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseAcceptLanguage(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestRequestLocales(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name           string
+		target         string
+		acceptLanguage string
+		want           []string
+	}{
+		{"nothing asked for", "/menu", "", nil},
+		{"regional locale falls back to its language", "/menu", "es-MX", []string{"es-MX", "es"}},
+		{"ordered by quality without repeats", "/menu", "fr;q=0.5, es-MX, es;q=0.8", []string{"es-MX", "es", "fr"}},
+		{"stops at the default language", "/menu", "es, en-GB, fr", []string{"es"}},
+		{"default language first", "/menu", "en, es", nil},
+		{"invalid locales are skipped", "/menu", "klingon, x1, de", []string{"de"}},
+		{"lang wins over the header", "/menu?lang=pt_br", "es", []string{"pt-BR", "pt"}},
+		{"lang in the default language", "/menu?lang=en", "es", nil},
+		{"invalid lang", "/menu?lang=nope!", "es", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("GET", tt.target, nil)
+			if tt.acceptLanguage != "" {
+				c.Request.Header.Set("Accept-Language", tt.acceptLanguage)
+			}
+
+			if got := requestLocales(c); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("requestLocales() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
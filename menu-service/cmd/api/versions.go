@@ -0,0 +1,592 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Changes recorded in a menu item's version history
+const (
+	changeInitial      = "initial"
+	changeCreated      = "created"
+	changeUpdated      = "updated"
+	changeAvailability = "availability"
+	changeRestored     = "restored"
+	changeArchived     = "archived"
+	changeUnarchived   = "unarchived"
+)
+
+// MenuItemSnapshot is the part of a menu item kept in its version history: everything
+// staff can change, but nothing worked out from stock, sales or recipes
+type MenuItemSnapshot struct {
+	Name                string        `json:"name"`
+	Description         string        `json:"description"`
+	Price               float64       `json:"price"`
+	CategoryID          int           `json:"category_id"`
+	Category            string        `json:"category"`
+	AvailabilitySetting string        `json:"availability_setting"`
+	DietaryTags         []string      `json:"dietary_tags"`
+	Allergens           []string      `json:"allergens"`
+	Nutrition           *Nutrition    `json:"nutrition"`
+	OptionGroups        []OptionGroup `json:"option_groups"`
+}
+
+// MenuItemVersion is a menu item as it was after a change. Changes lists the fields that
+// differ from the previous version.
+type MenuItemVersion struct {
+	Version      int                    `json:"version"`
+	Change       string                 `json:"change"`
+	Actor        string                 `json:"actor"`
+	RestoredFrom *int                   `json:"restored_from,omitempty"`
+	Item         MenuItemSnapshot       `json:"item"`
+	Changes      map[string]FieldChange `json:"changes,omitempty"`
+	CreatedAt    string                 `json:"created_at"`
+}
+
+// FieldChange is the old and new value of a field that changed between two versions
+type FieldChange struct {
+	Old any `json:"old"`
+	New any `json:"new"`
+}
+
+// PricePeriod is a stretch of time during which a menu item had the same price. Until is
+// empty for the current price.
+type PricePeriod struct {
+	Price   float64 `json:"price"`
+	From    string  `json:"from"`
+	Until   string  `json:"until,omitempty"`
+	Version int     `json:"version"`
+}
+
+var errVersionNotFound = errors.New("version not found")
+
+// requestActor is who made a request, as passed on by the broker in the X-User header
+func requestActor(c *gin.Context) string {
+	if actor := strings.TrimSpace(c.GetHeader("X-User")); actor != "" {
+		return actor
+	}
+	return "unknown"
+}
+
+func snapshotOf(item MenuItem) MenuItemSnapshot {
+	return MenuItemSnapshot{
+		Name:                item.Name,
+		Description:         item.Description,
+		Price:               item.Price,
+		CategoryID:          item.CategoryID,
+		Category:            item.Category,
+		AvailabilitySetting: item.AvailabilitySetting,
+		DietaryTags:         item.DietaryTags,
+		Allergens:           item.Allergens,
+		Nutrition:           item.Nutrition,
+		OptionGroups:        item.OptionGroups,
+	}
+}
+
+// recordVersion adds the menu item as it is now to its version history. Changes record
+// their version in the same transaction as the change, so the history can't miss a
+// change or show one that was rolled back.
+func recordVersion(tx *sql.Tx, itemID int, change, actor string, restoredFrom *int) error {
+	item, err := getMenuItemRow(tx, itemID)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(snapshotOf(item))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`insert into menu_item_versions (menu_item_id, version, change, actor, restored_from, data)
+		select $1, coalesce(max(version), 0) + 1, $2, $3, $4, $5 from menu_item_versions where menu_item_id = $1`,
+		itemID, change, actor, restoredFrom, string(data))
+	return err
+}
+
+// ensureVersioned records an item's current state as its first version if it has no
+// history yet, so the first change to an item made before versioning still shows what
+// it changed from. It is called in the change's transaction, before the change.
+func ensureVersioned(tx *sql.Tx, itemID int) error {
+	var exists bool
+	err := tx.QueryRow(`select exists (select 1 from menu_item_versions where menu_item_id = $1)`, itemID).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+
+	return recordVersion(tx, itemID, changeInitial, "system", nil)
+}
+
+// versionedChange makes a change to a menu item with a single statement and records
+// the result as a new version, all in one transaction. With refuseIfDrafted the change
+// is refused while the item has a draft waiting to be published.
+func (app *Config) versionedChange(itemID int, change, actor string, refuseIfDrafted bool, stmt string, args ...any) error {
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the item, so concurrent changes are versioned in the order they are made
+	err = lockMenuItem(tx, itemID)
+	if err != nil {
+		return err
+	}
+
+	if refuseIfDrafted {
+		err = refuseDrafted(tx, itemID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = ensureVersioned(tx, itemID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(stmt, args...)
+	if err != nil {
+		return err
+	}
+
+	err = recordVersion(tx, itemID, change, actor, nil)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// getVersions returns a menu item's versions, oldest first, each with the changes from
+// the version before it
+func (app *Config) getVersions(itemID int) ([]MenuItemVersion, error) {
+	rows, err := app.DB.Query(`select version, change, actor, restored_from, data, created_at
+		from menu_item_versions where menu_item_id = $1 order by version`, itemID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	versions := []MenuItemVersion{}
+	for rows.Next() {
+		var version MenuItemVersion
+		var restoredFrom sql.NullInt64
+		var data []byte
+		err := rows.Scan(&version.Version, &version.Change, &version.Actor, &restoredFrom, &data, &version.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		if restoredFrom.Valid {
+			from := int(restoredFrom.Int64)
+			version.RestoredFrom = &from
+		}
+		if err := json.Unmarshal(data, &version.Item); err != nil {
+			return nil, err
+		}
+
+		if len(versions) > 0 {
+			version.Changes, err = diffSnapshots(versions[len(versions)-1].Item, version.Item)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		versions = append(versions, version)
+	}
+
+	return versions, rows.Err()
+}
+
+// findVersion returns one version from a list
+func findVersion(versions []MenuItemVersion, number int) (MenuItemVersion, error) {
+	for _, version := range versions {
+		if version.Version == number {
+			return version, nil
+		}
+	}
+	return MenuItemVersion{}, errVersionNotFound
+}
+
+// diffSnapshots compares two snapshots field by field, using their JSON names
+func diffSnapshots(old, new MenuItemSnapshot) (map[string]FieldChange, error) {
+	fields := func(snapshot MenuItemSnapshot) (map[string]any, error) {
+		data, err := json.Marshal(snapshot)
+		if err != nil {
+			return nil, err
+		}
+		var values map[string]any
+		err = json.Unmarshal(data, &values)
+		return values, err
+	}
+
+	oldFields, err := fields(old)
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := fields(new)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make(map[string]FieldChange)
+	for name, value := range newFields {
+		if !reflect.DeepEqual(oldFields[name], value) {
+			changes[name] = FieldChange{Old: oldFields[name], New: value}
+		}
+	}
+
+	return changes, nil
+}
+
+// pricePeriods works out the history of an item's price from its versions
+func pricePeriods(versions []MenuItemVersion) []PricePeriod {
+	periods := []PricePeriod{}
+	for _, version := range versions {
+		if n := len(periods); n > 0 {
+			if periods[n-1].Price == version.Item.Price {
+				continue
+			}
+			periods[n-1].Until = version.CreatedAt
+		}
+
+		periods = append(periods, PricePeriod{
+			Price:   version.Item.Price,
+			From:    version.CreatedAt,
+			Version: version.Version,
+		})
+	}
+
+	// Newest first, as the current price is usually what's wanted
+	sort.Slice(periods, func(i, j int) bool { return periods[i].Version > periods[j].Version })
+	return periods
+}
+
+// restoreSnapshot puts a menu item back as it was in one of its versions, recording
+// the restore as a new version. Option groups and options removed since are added back
+// with new ids. Items with a pending draft can't be restored, as publishing the draft
+// would undo the restore.
+func (app *Config) restoreSnapshot(itemID int, version MenuItemVersion, actor string) error {
+	snapshot := version.Item
+
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = lockMenuItem(tx, itemID)
+	if err != nil {
+		return err
+	}
+
+	err = refuseDrafted(tx, itemID)
+	if err != nil {
+		return err
+	}
+
+	item, err := getMenuItemRow(tx, itemID)
+	if err != nil {
+		return err
+	}
+
+	err = app.applySnapshot(tx, item, snapshot)
+	if err != nil {
+		return err
+	}
+
+	if snapshot.AvailabilitySetting != "" {
+		_, err = tx.Exec(`update menu_items set availability = $1 where id = $2`, snapshot.AvailabilitySetting, item.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = recordVersion(tx, item.ID, changeRestored, actor, &version.Version)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// applySnapshot puts back everything in a snapshot except the availability setting,
+// as part of a transaction
+func (app *Config) applySnapshot(tx *sql.Tx, item MenuItem, snapshot MenuItemSnapshot) error {
+	existingGroups := make(map[int]bool)
+	existingOptions := make(map[int]bool)
+	for _, group := range item.OptionGroups {
+		existingGroups[group.ID] = true
+		for _, option := range group.Options {
+			existingOptions[option.ID] = true
+		}
+	}
+
+	groups := make([]OptionGroup, len(snapshot.OptionGroups))
+	for i, group := range snapshot.OptionGroups {
+		if !existingGroups[group.ID] {
+			group.ID = 0
+		}
+		group.Options = append([]Option{}, group.Options...)
+		for j := range group.Options {
+			if !existingOptions[group.Options[j].ID] {
+				group.Options[j].ID = 0
+			}
+		}
+		groups[i] = group
+	}
+
+	restored := item
+	restored.Name = snapshot.Name
+	restored.Description = snapshot.Description
+	restored.Price = snapshot.Price
+	restored.CategoryID = snapshot.CategoryID
+	restored.Category = snapshot.Category
+	restored.DietaryTags = snapshot.DietaryTags
+	restored.Allergens = snapshot.Allergens
+	restored.Nutrition = snapshot.Nutrition
+
+	// Fall back to the category's name if the category itself has since been deleted
+	if err := app.resolveItemCategory(&restored); err != nil {
+		restored.CategoryID = 0
+		if err := app.resolveItemCategory(&restored); err != nil {
+			return err
+		}
+	}
+
+	err := updateMenuItemRow(tx, restored)
+	if err != nil {
+		return err
+	}
+
+	return saveOptionGroupsTx(tx, item.ID, groups)
+}
+
+// versionParam reads a version number from the path or query
+func versionParam(value, name string) (int, error) {
+	version, err := strconv.Atoi(value)
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("invalid %s parameter", name)
+	}
+	return version, nil
+}
+
+// GetMenuItemVersions lists a menu item's version history
+func (app *Config) GetMenuItemVersions(c *gin.Context) {
+	item, ok := app.menuItemFromParam(c)
+	if !ok {
+		return
+	}
+
+	versions, err := app.getVersions(item.ID)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool              `json:"error"`
+		Message string            `json:"message"`
+		Data    []MenuItemVersion `json:"data"`
+	}{
+		Error:   false,
+		Message: "Menu item versions retrieved",
+		Data:    versions,
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+func (app *Config) GetMenuItemVersion(c *gin.Context) {
+	item, ok := app.menuItemFromParam(c)
+	if !ok {
+		return
+	}
+
+	number, err := versionParam(c.Param("version"), "version")
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	versions, err := app.getVersions(item.ID)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	version, err := findVersion(versions, number)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusNotFound)
+		return
+	}
+
+	payload := struct {
+		Error   bool            `json:"error"`
+		Message string          `json:"message"`
+		Data    MenuItemVersion `json:"data"`
+	}{
+		Error:   false,
+		Message: "Menu item version retrieved",
+		Data:    version,
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+// DiffMenuItemVersions compares two versions of a menu item, given as from and to. To
+// defaults to the latest version.
+func (app *Config) DiffMenuItemVersions(c *gin.Context) {
+	item, ok := app.menuItemFromParam(c)
+	if !ok {
+		return
+	}
+
+	versions, err := app.getVersions(item.ID)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+	if len(versions) == 0 {
+		app.errorJSON(c, errVersionNotFound, http.StatusNotFound)
+		return
+	}
+
+	fromNumber, err := versionParam(c.Query("from"), "from")
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	toNumber := versions[len(versions)-1].Version
+	if value := c.Query("to"); value != "" {
+		toNumber, err = versionParam(value, "to")
+		if err != nil {
+			app.errorJSON(c, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	from, err := findVersion(versions, fromNumber)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusNotFound)
+		return
+	}
+	to, err := findVersion(versions, toNumber)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusNotFound)
+		return
+	}
+
+	changes, err := diffSnapshots(from.Item, to.Item)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+		Data    any    `json:"data"`
+	}{
+		Error:   false,
+		Message: "Menu item versions compared",
+		Data: gin.H{
+			"from":    from.Version,
+			"to":      to.Version,
+			"changes": changes,
+		},
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+// GetPriceHistory lists the prices a menu item has had, and when
+func (app *Config) GetPriceHistory(c *gin.Context) {
+	item, ok := app.menuItemFromParam(c)
+	if !ok {
+		return
+	}
+
+	versions, err := app.getVersions(item.ID)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool          `json:"error"`
+		Message string        `json:"message"`
+		Data    []PricePeriod `json:"data"`
+	}{
+		Error:   false,
+		Message: "Menu item price history retrieved",
+		Data:    pricePeriods(versions),
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+// RestoreMenuItemVersion puts a menu item back as it was in an earlier version. The
+// restore is recorded as a new version, so it can be undone in turn.
+func (app *Config) RestoreMenuItemVersion(c *gin.Context) {
+	item, ok := app.menuItemFromParam(c)
+	if !ok {
+		return
+	}
+
+	number, err := versionParam(c.Param("version"), "version")
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	versions, err := app.getVersions(item.ID)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	version, err := findVersion(versions, number)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusNotFound)
+		return
+	}
+
+	err = app.restoreSnapshot(item.ID, version, requestActor(c))
+	if errors.Is(err, errItemHasDraft) {
+		app.errorJSON(c, err, http.StatusConflict)
+		return
+	}
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	restoredItem, err := app.getMenuItemByID(item.ID)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool     `json:"error"`
+		Message string   `json:"message"`
+		Data    MenuItem `json:"data"`
+	}{
+		Error:   false,
+		Message: fmt.Sprintf("Menu item restored to version %d", version.Version),
+		Data:    restoredItem,
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSnapshotOf(t *testing.T) {
+	item := MenuItem{
+		ID:                  7,
+		Name:                "Flat white",
+		Price:               3.2,
+		CategoryID:          2,
+		Category:            "Coffee",
+		AvailabilitySetting: availabilityAvailable,
+		InStock:             true,
+		Allergens:           []string{"milk"},
+		RecipeAllergens:     []string{"milk", "soya"},
+		Popularity:          120,
+	}
+
+	want := MenuItemSnapshot{
+		Name:                "Flat white",
+		Price:               3.2,
+		CategoryID:          2,
+		Category:            "Coffee",
+		AvailabilitySetting: availabilityAvailable,
+		Allergens:           []string{"milk"},
+	}
+	if got := snapshotOf(item); !reflect.DeepEqual(got, want) {
+		t.Errorf("snapshotOf() = %+v, want %+v", got, want)
+	}
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	old := MenuItemSnapshot{Name: "Flat white", Price: 3.2, Category: "Coffee", Allergens: []string{"milk"}}
+
+	tests := []struct {
+		name string
+		new  func(MenuItemSnapshot) MenuItemSnapshot
+		want map[string]FieldChange
+	}{
+		{
+			name: "no changes",
+			new:  func(s MenuItemSnapshot) MenuItemSnapshot { return s },
+			want: map[string]FieldChange{},
+		},
+		{
+			name: "price and name",
+			new: func(s MenuItemSnapshot) MenuItemSnapshot {
+				s.Name, s.Price = "Flat White", 3.4
+				return s
+			},
+			want: map[string]FieldChange{
+				"name":  {Old: "Flat white", New: "Flat White"},
+				"price": {Old: 3.2, New: 3.4},
+			},
+		},
+		{
+			name: "lists and nutrition",
+			new: func(s MenuItemSnapshot) MenuItemSnapshot {
+				s.Allergens = []string{"milk", "soya"}
+				s.Nutrition = &Nutrition{Energy: 110}
+				return s
+			},
+			want: map[string]FieldChange{
+				"allergens": {Old: []any{"milk"}, New: []any{"milk", "soya"}},
+				"nutrition": {Old: nil, New: map[string]any{
+					"energy_kcal": 110.0, "fat_g": 0.0, "saturated_fat_g": 0.0, "carbohydrate_g": 0.0,
+					"sugars_g": 0.0, "fibre_g": 0.0, "protein_g": 0.0, "salt_g": 0.0,
+				}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := diffSnapshots(old, tt.new(old))
+			if err != nil {
+				t.Fatalf("diffSnapshots() = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffSnapshots() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindVersion(t *testing.T) {
+	versions := []MenuItemVersion{{Version: 1}, {Version: 2, Change: changeUpdated}, {Version: 3}}
+
+	version, err := findVersion(versions, 2)
+	if err != nil || version.Change != changeUpdated {
+		t.Errorf("findVersion(2) = %+v, %v", version, err)
+	}
+
+	if _, err := findVersion(versions, 4); err != errVersionNotFound {
+		t.Errorf("findVersion(4) = %v, want %v", err, errVersionNotFound)
+	}
+}
+
+func TestPricePeriods(t *testing.T) {
+	version := func(number int, price float64, createdAt string) MenuItemVersion {
+		return MenuItemVersion{Version: number, Item: MenuItemSnapshot{Price: price}, CreatedAt: createdAt}
+	}
+
+	tests := []struct {
+		name     string
+		versions []MenuItemVersion
+		want     []PricePeriod
+	}{
+		{
+			name:     "no versions",
+			versions: nil,
+			want:     []PricePeriod{},
+		},
+		{
+			name:     "one price",
+			versions: []MenuItemVersion{version(1, 3.2, "2024-01-01"), version(2, 3.2, "2024-02-01")},
+			want:     []PricePeriod{{Price: 3.2, From: "2024-01-01", Version: 1}},
+		},
+		{
+			name: "price changes, newest first",
+			versions: []MenuItemVersion{
+				version(1, 3.2, "2024-01-01"),
+				version(2, 3.2, "2024-02-01"),
+				version(3, 3.4, "2024-03-01"),
+				version(4, 3.2, "2024-04-01"),
+			},
+			want: []PricePeriod{
+				{Price: 3.2, From: "2024-04-01", Version: 4},
+				{Price: 3.4, From: "2024-03-01", Until: "2024-04-01", Version: 3},
+				{Price: 3.2, From: "2024-01-01", Until: "2024-03-01", Version: 1},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pricePeriods(tt.versions); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pricePeriods() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVersionParam(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{"1", 1, false},
+		{"12", 12, false},
+		{"0", 0, true},
+		{"-1", 0, true},
+		{"latest", 0, true},
+		{"", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := versionParam(tt.value, "from")
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("versionParam(%q) = %d, %v, want %d", tt.value, got, err, tt.want)
+		}
+		if err != nil && err.Error() != "invalid from parameter" {
+			t.Errorf("versionParam(%q) error = %q", tt.value, err)
+		}
+	}
+}
+
+func TestRequestActor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	for header, want := range map[string]string{"ada@example.com": "ada@example.com", "  ": "unknown", "": "unknown"} {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("PUT", "/menu-items/1", nil)
+		c.Request.Header.Set("X-User", header)
+
+		if got := requestActor(c); got != want {
+			t.Errorf("requestActor() with X-User %q = %q, want %q", header, got, want)
+		}
+	}
+}
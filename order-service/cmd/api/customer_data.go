@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ExportCustomerData returns all of a customer's orders, with their items, and their
+// whole loyalty history
+func (app *Config) ExportCustomerData(c *gin.Context) {
+	customerID, err := strconv.Atoi(c.Param("customer_id"))
+	if err != nil {
+		app.errorJSON(c, errors.New("invalid customer_id parameter"), http.StatusBadRequest)
+		return
+	}
+
+	orders, err := app.getOrdersByCustomer(customerID)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+	if orders == nil {
+		orders = []Order{}
+	}
+
+	ledger, err := app.getLedger(customerID, 0)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+		Data    any    `json:"data,omitempty"`
+	}{
+		Error:   false,
+		Message: "Customer data exported",
+		Data: gin.H{
+			"orders":         orders,
+			"loyalty_ledger": ledger,
+		},
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+// EraseCustomerData removes a customer's loyalty history. Orders hold no personal data
+// beyond the customer id, which the authentication service pseudonymizes, so they are
+// kept intact for the accounts.
+func (app *Config) EraseCustomerData(c *gin.Context) {
+	customerID, err := strconv.Atoi(c.Param("customer_id"))
+	if err != nil {
+		app.errorJSON(c, errors.New("invalid customer_id parameter"), http.StatusBadRequest)
+		return
+	}
+
+	result, err := app.DB.Exec(`delete from loyalty_ledger where customer_id = $1`, customerID)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+	deleted, _ := result.RowsAffected()
+
+	var retained int
+	err = app.DB.QueryRow(`select count(*) from orders where customer_id = $1`, customerID).Scan(&retained)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+		Data    any    `json:"data,omitempty"`
+	}{
+		Error:   false,
+		Message: "Customer data erased",
+		Data: gin.H{
+			"loyalty_entries_deleted": deleted,
+			"orders_retained":         retained,
+		},
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
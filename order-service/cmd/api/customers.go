@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const authServiceURL = "http://0.0.0.0:8001"
+
+var (
+	errCustomerNotFound = errors.New("customer not found")
+	errCustomerInactive = errors.New("customer account is not active")
+)
+
+// validateCustomer checks with the authentication service that customerID refers to
+// an existing, active customer
+func (app *Config) validateCustomer(customerID int) error {
+	client := &http.Client{Timeout: 5 * time.Second}
+	response, err := client.Get(fmt.Sprintf("%s/customers/%d", authServiceURL, customerID))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return errCustomerNotFound
+	}
+	if response.StatusCode != http.StatusOK {
+		return errors.New("error calling auth service")
+	}
+
+	var result struct {
+		Data struct {
+			ID     int `json:"id"`
+			Active int `json:"active"`
+		} `json:"data"`
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&result)
+	if err != nil {
+		return err
+	}
+
+	if result.Data.Active != 1 {
+		return errCustomerInactive
+	}
+
+	return nil
+}
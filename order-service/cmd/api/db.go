@@ -1,8 +1,10 @@
 package main
 
 import (
+	"database/sql"
 	"errors"
 	"fmt"
+	"log"
 	"time"
 )
 
@@ -11,7 +13,7 @@ func (app *Config) getAllOrders() ([]Order, error) {
 	var orders []Order
 
 	// Get all orders
-	query := `select id, customer_id, status, total, created_at, updated_at from orders order by created_at desc`
+	query := `select id, customer_id, status, total, discount, points_redeemed, created_at, updated_at from orders order by created_at desc`
 
 	rows, err := app.DB.Query(query)
 	if err != nil {
@@ -26,6 +28,8 @@ func (app *Config) getAllOrders() ([]Order, error) {
 			&order.CustomerID,
 			&order.Status,
 			&order.Total,
+			&order.Discount,
+			&order.PointsRedeemed,
 			&order.CreatedAt,
 			&order.UpdatedAt,
 		)
@@ -51,7 +55,7 @@ func (app *Config) getOrderByID(id int) (Order, error) {
 	var order Order
 
 	// Get the order
-	query := `select id, customer_id, status, total, created_at, updated_at from orders where id = $1`
+	query := `select id, customer_id, status, total, discount, points_redeemed, created_at, updated_at from orders where id = $1`
 
 	row := app.DB.QueryRow(query, id)
 	err := row.Scan(
@@ -59,6 +63,8 @@ func (app *Config) getOrderByID(id int) (Order, error) {
 		&order.CustomerID,
 		&order.Status,
 		&order.Total,
+		&order.Discount,
+		&order.PointsRedeemed,
 		&order.CreatedAt,
 		&order.UpdatedAt,
 	)
@@ -83,7 +89,7 @@ func (app *Config) getOrdersByCustomer(customerID int) ([]Order, error) {
 	var orders []Order
 
 	// Get orders for this customer
-	query := `select id, customer_id, status, total, created_at, updated_at
+	query := `select id, customer_id, status, total, discount, points_redeemed, created_at, updated_at
                 from orders
                 where customer_id = $1
                 order by created_at desc`
@@ -101,6 +107,8 @@ func (app *Config) getOrdersByCustomer(customerID int) ([]Order, error) {
 			&order.CustomerID,
 			&order.Status,
 			&order.Total,
+			&order.Discount,
+			&order.PointsRedeemed,
 			&order.CreatedAt,
 			&order.UpdatedAt,
 		)
@@ -125,9 +133,11 @@ func (app *Config) getOrdersByCustomer(customerID int) ([]Order, error) {
 func (app *Config) getOrderItems(orderID int) ([]OrderItem, error) {
 	var items []OrderItem
 
-	query := `select id, order_id, menu_item_id, quantity, price
+	query := `select id, order_id, menu_item_id, quantity, price, combo_id, coalesce(combo_line, 0),
+                coalesce(combo_name, ''), coalesce(combo_slot, '')
                 from order_items
-                where order_id = $1`
+                where order_id = $1
+                order by id`
 
 	rows, err := app.DB.Query(query, orderID)
 	if err != nil {
@@ -137,21 +147,61 @@ func (app *Config) getOrderItems(orderID int) ([]OrderItem, error) {
 
 	for rows.Next() {
 		var item OrderItem
+		var comboID sql.NullInt64
 		err := rows.Scan(
 			&item.ID,
 			&item.OrderID,
 			&item.MenuItemID,
 			&item.Quantity,
 			&item.Price,
+			&comboID,
+			&item.ComboLine,
+			&item.ComboName,
+			&item.ComboSlot,
 		)
 		if err != nil {
 			return nil, err
 		}
 
+		if comboID.Valid {
+			id := int(comboID.Int64)
+			item.ComboID = &id
+		}
+
+		item.Options = []OrderItemOption{}
 		items = append(items, item)
 	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Get the options chosen for the items
+	optionRows, err := app.DB.Query(`select o.order_item_id, o.option_id, o.group_name, o.name, o.price_delta
+                from order_item_options o
+                join order_items i on i.id = o.order_item_id
+                where i.order_id = $1
+                order by o.id`, orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer optionRows.Close()
+
+	for optionRows.Next() {
+		var orderItemID int
+		var option OrderItemOption
+		err := optionRows.Scan(&orderItemID, &option.OptionID, &option.Group, &option.Name, &option.PriceDelta)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range items {
+			if items[i].ID == orderItemID {
+				items[i].Options = append(items[i].Options, option)
+			}
+		}
+	}
 
-	return items, nil
+	return items, optionRows.Err()
 }
 
 // insertOrder creates a new order with all associated items
@@ -176,16 +226,25 @@ func (app *Config) insertOrder(order Order) (int, error) {
 		total += item.Price * float64(item.Quantity)
 	}
 
+	// Loyalty points redeemed at checkout are taken off the total
+	pointsRedeemed, discount := 0, 0.0
+	if order.RedeemPoints > 0 {
+		pointsRedeemed, discount = redemptionDiscount(order.RedeemPoints, total)
+		total -= discount
+	}
+
 	// Insert the order
 	var newOrderID int
-	stmt := `insert into orders (customer_id, status, total, created_at, updated_at)
-                values ($1, $2, $3, $4, $5) returning id`
+	stmt := `insert into orders (customer_id, status, total, discount, points_redeemed, created_at, updated_at)
+                values ($1, $2, $3, $4, $5, $6, $7) returning id`
 
 	err = tx.QueryRow(
 		stmt,
 		order.CustomerID,
 		"pending", // Default status
 		total,
+		discount,
+		pointsRedeemed,
 		now,
 		now,
 	).Scan(&newOrderID)
@@ -196,20 +255,41 @@ func (app *Config) insertOrder(order Order) (int, error) {
 
 	// Insert order items
 	for _, item := range order.Items {
-		stmt := `insert into order_items (order_id, menu_item_id, quantity, price)
-                        values ($1, $2, $3, $4)`
+		stmt := `insert into order_items (order_id, menu_item_id, quantity, price, combo_id, combo_line, combo_name, combo_slot)
+                        values ($1, $2, $3, $4, $5, nullif($6, 0), nullif($7, ''), nullif($8, '')) returning id`
 
-		_, err := tx.Exec(
+		var orderItemID int
+		err = tx.QueryRow(
 			stmt,
 			newOrderID,
 			item.MenuItemID,
 			item.Quantity,
 			item.Price,
-		)
+			item.ComboID,
+			item.ComboLine,
+			item.ComboName,
+			item.ComboSlot,
+		).Scan(&orderItemID)
 
 		if err != nil {
 			return 0, err
 		}
+
+		for _, option := range item.Options {
+			_, err = tx.Exec(`insert into order_item_options (order_item_id, option_id, group_name, name, price_delta)
+                        values ($1, $2, $3, $4, $5)`,
+				orderItemID, option.OptionID, option.Group, option.Name, option.PriceDelta)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	if pointsRedeemed > 0 {
+		err = redeemPoints(tx, order.CustomerID, newOrderID, pointsRedeemed)
+		if err != nil {
+			return 0, err
+		}
 	}
 
 	// Commit transaction
@@ -224,25 +304,75 @@ func (app *Config) insertOrder(order Order) (int, error) {
 	return newOrderID, nil
 }
 
-// updateOrderStatus updates the status of an order
+// orderStatusTransitions lists the statuses an order can move to from each status.
+// Cancelled and refunded orders are final: their loyalty points have been reversed
+// and can't be reversed again.
+var orderStatusTransitions = map[string][]string{
+	"pending":   {"preparing", "ready", "completed", "cancelled"},
+	"preparing": {"ready", "completed", "cancelled"},
+	"ready":     {"completed", "cancelled"},
+	"completed": {"refunded"},
+	"cancelled": {},
+	"refunded":  {},
+}
+
+var errInvalidStatusTransition = errors.New("invalid status transition")
+
+// checkStatusTransition returns an error unless an order can move from one status to another
+func checkStatusTransition(from, to string) error {
+	if _, ok := orderStatusTransitions[to]; !ok {
+		return fmt.Errorf("invalid status: %s", to)
+	}
+
+	for _, next := range orderStatusTransitions[from] {
+		if next == to {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: a %s order can't be marked %s", errInvalidStatusTransition, from, to)
+}
+
+// updateOrderStatus updates the status of an order. Completing an order earns the
+// customer loyalty points; refunding or cancelling it reverses its points.
 func (app *Config) updateOrderStatus(orderID int, status string) error {
 	// Check if the order exists
-	_, err := app.getOrderByID(orderID)
+	order, err := app.getOrderByID(orderID)
 	if err != nil {
 		return errors.New("order not found")
 	}
 
-	// Validate status
-	validStatuses := map[string]bool{
-		"pending":   true,
-		"preparing": true,
-		"ready":     true,
-		"completed": true,
-		"cancelled": true,
+	if err := checkStatusTransition(order.Status, status); err != nil {
+		return err
+	}
+
+	// Work out the points before opening the transaction, as it calls the menu service
+	var points int
+	if status == "completed" {
+		points, err = app.pointsForOrder(order)
+		if err != nil {
+			return err
+		}
+	}
+
+	tx, err := app.DB.Begin()
+	if err != nil {
+		return err
 	}
+	defer tx.Rollback()
 
-	if !validStatuses[status] {
-		return fmt.Errorf("invalid status: %s", status)
+	// Check the transition again under a row lock, so two concurrent updates can't both
+	// move the order on from the status read above
+	var current string
+	err = tx.QueryRow(`select status from orders where id = $1 for update`, orderID).Scan(&current)
+	if err != nil {
+		return err
+	}
+	if current != order.Status {
+		if err := checkStatusTransition(current, status); err != nil {
+			return err
+		}
+		order.Status = current
 	}
 
 	// Set updated timestamp
@@ -253,7 +383,7 @@ func (app *Config) updateOrderStatus(orderID int, status string) error {
                 updated_at = $2
                 where id = $3`
 
-	_, err = app.DB.Exec(
+	_, err = tx.Exec(
 		stmt,
 		status,
 		now,
@@ -264,12 +394,52 @@ func (app *Config) updateOrderStatus(orderID int, status string) error {
 		return err
 	}
 
+	order.Status = status
+
+	switch {
+	case status == "completed":
+		err = earnOrderPoints(tx, order, points)
+	case status == "refunded" || status == "cancelled":
+		err = reverseOrderPoints(tx, order)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+
+	// Popularity is only a ranking, so the order isn't failed if the menu service can't
+	// be told about the sale
+	switch {
+	case status == "completed":
+		err = app.reportSales(order, 1)
+	case status == "refunded":
+		err = app.reportSales(order, -1)
+	}
+	if err != nil {
+		log.Printf("Error reporting sales of order %d to the menu service: %v", orderID, err)
+	}
+
 	// Log the status change
 	app.logOrderStatusChange(orderID, status)
 
 	return nil
 }
 
+// countMenuItemReferences returns the number of orders that include a menu item
+func (app *Config) countMenuItemReferences(menuItemID int) (int, error) {
+	var count int
+	err := app.DB.QueryRow(`select count(distinct order_id) from order_items where menu_item_id = $1`, menuItemID).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
 // logNewOrder logs when a new order is created
 func (app *Config) logNewOrder(orderID, customerID int, total float64) {
 	// In a real application, this would send a request to the logger service
@@ -40,6 +40,73 @@ func initDB(db *sql.DB) error {
 		return err
 	}
 
+	// Create table for the menu options chosen for order items
+	orderItemOptionsTableQuery := `
+	CREATE TABLE IF NOT EXISTS order_item_options (
+		id SERIAL PRIMARY KEY,
+		order_item_id INTEGER NOT NULL REFERENCES order_items(id) ON DELETE CASCADE,
+		option_id INTEGER NOT NULL,
+		group_name VARCHAR(100) NOT NULL,
+		name VARCHAR(100) NOT NULL,
+		price_delta DECIMAL(10, 2) NOT NULL DEFAULT 0.00
+	);
+
+	CREATE INDEX IF NOT EXISTS order_item_options_order_item_id_idx ON order_item_options (order_item_id);`
+
+	_, err = db.Exec(orderItemOptionsTableQuery)
+	if err != nil {
+		return err
+	}
+
+	// Combos are stored as their items, grouped by combo line
+	orderItemComboColumnsQuery := `
+	ALTER TABLE order_items ADD COLUMN IF NOT EXISTS combo_id INTEGER;
+	ALTER TABLE order_items ADD COLUMN IF NOT EXISTS combo_line INTEGER;
+	ALTER TABLE order_items ADD COLUMN IF NOT EXISTS combo_name VARCHAR(255);
+	ALTER TABLE order_items ADD COLUMN IF NOT EXISTS combo_slot VARCHAR(100);`
+
+	_, err = db.Exec(orderItemComboColumnsQuery)
+	if err != nil {
+		return err
+	}
+
+	// Add loyalty redemption columns to orders
+	orderLoyaltyColumnsQuery := `
+	ALTER TABLE orders ADD COLUMN IF NOT EXISTS discount DECIMAL(10, 2) NOT NULL DEFAULT 0.00;
+	ALTER TABLE orders ADD COLUMN IF NOT EXISTS points_redeemed INTEGER NOT NULL DEFAULT 0;`
+
+	_, err = db.Exec(orderLoyaltyColumnsQuery)
+	if err != nil {
+		return err
+	}
+
+	// Create loyalty points ledger and earn rates tables
+	loyaltyTablesQuery := `
+	CREATE TABLE IF NOT EXISTS loyalty_ledger (
+		id SERIAL PRIMARY KEY,
+		customer_id INTEGER NOT NULL,
+		order_id INTEGER REFERENCES orders(id) ON DELETE SET NULL,
+		entry_type VARCHAR(20) NOT NULL,
+		points INTEGER NOT NULL,
+		remaining INTEGER NOT NULL DEFAULT 0,
+		description TEXT NOT NULL DEFAULT '',
+		expires_at TIMESTAMP WITH TIME ZONE,
+		created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE INDEX IF NOT EXISTS loyalty_ledger_customer_id_idx ON loyalty_ledger (customer_id);
+	CREATE INDEX IF NOT EXISTS loyalty_ledger_order_id_idx ON loyalty_ledger (order_id);
+
+	CREATE TABLE IF NOT EXISTS loyalty_category_earn_rates (
+		category_id INTEGER PRIMARY KEY,
+		points_per_unit DECIMAL(10, 4) NOT NULL
+	);`
+
+	_, err = db.Exec(loyaltyTablesQuery)
+	if err != nil {
+		return err
+	}
+
 	log.Println("Order service database tables initialized")
 	return nil
 }
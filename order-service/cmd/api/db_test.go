@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckStatusTransition(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     error
+	}{
+		{"pending", "preparing", nil},
+		{"pending", "completed", nil},
+		{"preparing", "ready", nil},
+		{"ready", "completed", nil},
+		{"ready", "cancelled", nil},
+		{"completed", "refunded", nil},
+		{"preparing", "pending", errInvalidStatusTransition},
+		{"completed", "cancelled", errInvalidStatusTransition},
+		{"completed", "completed", errInvalidStatusTransition},
+		{"pending", "refunded", errInvalidStatusTransition},
+		{"cancelled", "pending", errInvalidStatusTransition},
+		{"cancelled", "completed", errInvalidStatusTransition},
+		{"refunded", "completed", errInvalidStatusTransition},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from+" to "+tt.to, func(t *testing.T) {
+			err := checkStatusTransition(tt.from, tt.to)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("checkStatusTransition(%q, %q) = %v, want %v", tt.from, tt.to, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckStatusTransitionUnknownStatus(t *testing.T) {
+	err := checkStatusTransition("pending", "lost")
+	if err == nil || errors.Is(err, errInvalidStatusTransition) {
+		t.Errorf("checkStatusTransition(pending, lost) = %v, want an invalid status error", err)
+	}
+}
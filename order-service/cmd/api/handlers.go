@@ -2,6 +2,7 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 
@@ -80,6 +81,37 @@ func (app *Config) GetOrdersByCustomer(c *gin.Context) {
 	app.writeJSON(c, http.StatusOK, payload)
 }
 
+// GetMenuItemReferences reports how many orders include a menu item. The menu service
+// checks it before purging an item for good.
+func (app *Config) GetMenuItemReferences(c *gin.Context) {
+	menuItemID, err := strconv.Atoi(c.Param("menu_item_id"))
+	if err != nil {
+		app.errorJSON(c, errors.New("invalid menu_item_id parameter"), http.StatusBadRequest)
+		return
+	}
+
+	count, err := app.countMenuItemReferences(menuItemID)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+		Data    gin.H  `json:"data"`
+	}{
+		Error:   false,
+		Message: "Menu item references retrieved",
+		Data: gin.H{
+			"menu_item_id": menuItemID,
+			"order_count":  count,
+		},
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
 func (app *Config) CreateOrder(c *gin.Context) {
 	var order Order
 
@@ -95,9 +127,51 @@ func (app *Config) CreateOrder(c *gin.Context) {
 		return
 	}
 
+	// Make sure the order is for a real, active customer
+	err = app.validateCustomer(order.CustomerID)
+	if err != nil {
+		if errors.Is(err, errCustomerNotFound) || errors.Is(err, errCustomerInactive) {
+			app.errorJSON(c, err, http.StatusUnprocessableEntity)
+			return
+		}
+		app.errorJSON(c, fmt.Errorf("unable to verify customer: %w", err), http.StatusServiceUnavailable)
+		return
+	}
+
+	if order.RedeemPoints < 0 {
+		app.errorJSON(c, errors.New("redeem_points can't be negative"), http.StatusBadRequest)
+		return
+	}
+
+	for _, item := range order.Items {
+		if item.Quantity < 1 {
+			app.errorJSON(c, errors.New("item quantities must be at least 1"), http.StatusBadRequest)
+			return
+		}
+		if (item.ComboID == nil) == (item.MenuItemID == 0) {
+			app.errorJSON(c, errors.New("each item needs either a menu_item_id or a combo_id"), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Price the items, with their options, from the menu
+	err = app.priceOrderItems(&order)
+	if err != nil {
+		if errors.Is(err, errMenuItemNotFound) || errors.Is(err, errNotOrderable) {
+			app.errorJSON(c, err, http.StatusUnprocessableEntity)
+			return
+		}
+		app.errorJSON(c, fmt.Errorf("unable to price order: %w", err), http.StatusServiceUnavailable)
+		return
+	}
+
 	// Create the order
 	newID, err := app.insertOrder(order)
 	if err != nil {
+		if errors.Is(err, errInsufficientPoints) {
+			app.errorJSON(c, err, http.StatusUnprocessableEntity)
+			return
+		}
 		app.errorJSON(c, err, http.StatusInternalServerError)
 		return
 	}
@@ -141,6 +215,10 @@ func (app *Config) UpdateOrderStatus(c *gin.Context) {
 
 	// Update the order status
 	err = app.updateOrderStatus(id, statusUpdate.Status)
+	if errors.Is(err, errInvalidStatusTransition) {
+		app.errorJSON(c, err, http.StatusConflict)
+		return
+	}
 	if err != nil {
 		app.errorJSON(c, err, http.StatusInternalServerError)
 		return
@@ -0,0 +1,809 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Types of loyalty ledger entries
+const (
+	ledgerEarn     = "earn"
+	ledgerRedeem   = "redeem"
+	ledgerExpire   = "expire"
+	ledgerAdjust   = "adjust"
+	ledgerReversal = "reversal"
+)
+
+// loyaltyLockNamespace is the first key of the advisory lock taken while changing a
+// customer's points; the second key is the customer id
+const loyaltyLockNamespace = 35
+
+var errInsufficientPoints = errors.New("not enough loyalty points")
+
+// LedgerEntry is a change to a customer's points balance. Positive entries (earned,
+// adjusted up, or refunded redemptions) are lots that expire; Remaining is how much of
+// a lot hasn't been spent or expired yet. On a negative entry that the customer's lots
+// couldn't cover, Remaining is minus the points still owed, which come out of the
+// next lots credited.
+type LedgerEntry struct {
+	ID          int        `json:"id"`
+	CustomerID  int        `json:"customer_id"`
+	OrderID     *int       `json:"order_id,omitempty"`
+	EntryType   string     `json:"entry_type"`
+	Points      int        `json:"points"`
+	Remaining   int        `json:"remaining,omitempty"`
+	Description string     `json:"description"`
+	ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// Loyalty rules used when they aren't set in the environment
+const (
+	// defaultPointValue is what one point is worth, in currency units
+	defaultPointValue = 0.01
+	// defaultPointsPerUnit is the points earned per currency unit in categories
+	// without their own earn rate
+	defaultPointsPerUnit = 1
+	// defaultPointsExpiryDays is how many days points last before expiring
+	defaultPointsExpiryDays = 365
+)
+
+const (
+	// pointsExpiryInterval is how often expired points are swept
+	pointsExpiryInterval = time.Hour
+	// defaultHistoryLimit and maxHistoryLimit bound how many ledger entries a points
+	// history request returns
+	defaultHistoryLimit = 50
+	maxHistoryLimit     = 500
+)
+
+// EarnRate is the number of points earned per currency unit spent on a menu category.
+// Rates are kept by category ID, so renaming a category keeps its rate.
+type EarnRate struct {
+	CategoryID    int     `json:"category_id"`
+	PointsPerUnit float64 `json:"points_per_unit"`
+}
+
+// envFloat reads a float from the environment, falling back when unset or invalid
+func envFloat(key string, fallback float64) float64 {
+	value, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil {
+		return fallback
+	}
+
+	return value
+}
+
+// pointValue is the discount, in currency units, that one point is worth
+// (LOYALTY_POINT_VALUE)
+func pointValue() float64 {
+	return envFloat("LOYALTY_POINT_VALUE", defaultPointValue)
+}
+
+// defaultEarnRate applies to categories without their own rate
+// (LOYALTY_DEFAULT_EARN_RATE)
+func defaultEarnRate() float64 {
+	return envFloat("LOYALTY_DEFAULT_EARN_RATE", defaultPointsPerUnit)
+}
+
+// pointsLifetime is how long points last before expiring (LOYALTY_POINTS_EXPIRY_DAYS)
+func pointsLifetime() time.Duration {
+	return time.Duration(envFloat("LOYALTY_POINTS_EXPIRY_DAYS", defaultPointsExpiryDays)) * 24 * time.Hour
+}
+
+// roundCents rounds an amount of money to whole cents
+func roundCents(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// lockCustomerPoints serializes changes to one customer's points for the rest of tx
+func lockCustomerPoints(tx *sql.Tx, customerID int) error {
+	_, err := tx.Exec(`select pg_advisory_xact_lock($1, $2)`, loyaltyLockNamespace, customerID)
+	return err
+}
+
+// pointsBalance returns a customer's current balance. Lots past their expiry date that
+// haven't been swept yet don't count, since they can no longer be spent.
+func pointsBalance(q interface {
+	QueryRow(string, ...any) *sql.Row
+}, customerID int) (int, error) {
+	var balance int
+	err := q.QueryRow(`select coalesce(sum(points), 0) - coalesce(sum(remaining) filter (where remaining > 0 and expires_at <= $2), 0)
+		from loyalty_ledger where customer_id = $1`, customerID, time.Now()).Scan(&balance)
+	return balance, err
+}
+
+// creditPoints adds a lot of points that expires after the configured lifetime. Points
+// the customer still owes from earlier debits are paid off first, so only the rest of
+// the lot can be spent or expire.
+func creditPoints(tx *sql.Tx, customerID int, orderID *int, entryType string, points int, description string) error {
+	// Debts are kept as negative remaining points on the debits that caused them
+	entries, err := ledgerAmounts(tx, `select id, -remaining from loyalty_ledger
+		where customer_id = $1 and remaining < 0
+		order by id`, customerID)
+	if err != nil {
+		return err
+	}
+
+	paid, left := drawDown(entries, points)
+	for i, entry := range entries {
+		if paid[i] == 0 {
+			continue
+		}
+		_, err := tx.Exec(`update loyalty_ledger set remaining = remaining + $1 where id = $2`, paid[i], entry.id)
+		if err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	stmt := `insert into loyalty_ledger (customer_id, order_id, entry_type, points, remaining, description, expires_at, created_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	_, err = tx.Exec(stmt, customerID, orderID, entryType, points, left, description, now.Add(pointsLifetime()), now)
+	return err
+}
+
+// debitPoints records points leaving the balance and spends them from the lots that
+// expire first
+func debitPoints(tx *sql.Tx, customerID int, orderID *int, entryType string, points int, description string) error {
+	return insertDebit(tx, customerID, orderID, entryType, points, points, description)
+}
+
+// insertDebit records a debit of points and spends spend of them from the customer's
+// lots. If the lots don't cover it (a reversal of points that were already spent) the
+// shortfall is recorded on the debit and paid off by the next lots credited.
+func insertDebit(tx *sql.Tx, customerID int, orderID *int, entryType string, points, spend int, description string) error {
+	var id int
+	stmt := `insert into loyalty_ledger (customer_id, order_id, entry_type, points, remaining, description, created_at)
+		values ($1, $2, $3, $4, 0, $5, $6) returning id`
+
+	err := tx.QueryRow(stmt, customerID, orderID, entryType, -points, description, time.Now()).Scan(&id)
+	if err != nil {
+		return err
+	}
+
+	shortfall, err := spendLots(tx, customerID, spend)
+	if err != nil || shortfall == 0 {
+		return err
+	}
+
+	_, err = tx.Exec(`update loyalty_ledger set remaining = $1 where id = $2`, -shortfall, id)
+	return err
+}
+
+// spendLots takes points from a customer's unexpired lots, soonest to expire first, and
+// returns the points the lots couldn't cover
+func spendLots(tx *sql.Tx, customerID int, points int) (int, error) {
+	lots, err := ledgerAmounts(tx, `select id, remaining from loyalty_ledger
+		where customer_id = $1 and remaining > 0 and expires_at > $2
+		order by expires_at, id`, customerID, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	spent, shortfall := drawDown(lots, points)
+	for i, lot := range lots {
+		if spent[i] == 0 {
+			continue
+		}
+		_, err := tx.Exec(`update loyalty_ledger set remaining = remaining - $1 where id = $2`, spent[i], lot.id)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return shortfall, nil
+}
+
+// ledgerAmount is a ledger entry and a number of points on it: the points left in a
+// lot, or the points still owed on a debit
+type ledgerAmount struct {
+	id     int
+	points int
+}
+
+// ledgerAmounts reads the id and points amount selected by query
+func ledgerAmounts(tx *sql.Tx, query string, args ...any) ([]ledgerAmount, error) {
+	rows, err := tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var amounts []ledgerAmount
+	for rows.Next() {
+		var a ledgerAmount
+		if err := rows.Scan(&a.id, &a.points); err != nil {
+			return nil, err
+		}
+		amounts = append(amounts, a)
+	}
+
+	return amounts, rows.Err()
+}
+
+// drawDown takes points from amounts in order, as much from each as it holds, and
+// returns how many were taken from each and how many couldn't be taken
+func drawDown(amounts []ledgerAmount, points int) ([]int, int) {
+	taken := make([]int, len(amounts))
+	for i, a := range amounts {
+		if points == 0 {
+			break
+		}
+
+		take := a.points
+		if take > points {
+			take = points
+		}
+		taken[i] = take
+		points -= take
+	}
+
+	return taken, points
+}
+
+// redeemPoints spends a customer's points on an order, failing if the balance is too low
+func redeemPoints(tx *sql.Tx, customerID, orderID, points int) error {
+	if err := lockCustomerPoints(tx, customerID); err != nil {
+		return err
+	}
+
+	balance, err := pointsBalance(tx, customerID)
+	if err != nil {
+		return err
+	}
+	if balance < points {
+		return fmt.Errorf("%w: balance is %d", errInsufficientPoints, balance)
+	}
+
+	return debitPoints(tx, customerID, &orderID, ledgerRedeem, points, fmt.Sprintf("Redeemed on order %d", orderID))
+}
+
+// redemptionDiscount works out the discount for redeeming points against a subtotal.
+// The discount never exceeds the subtotal; only the points needed for it are used.
+func redemptionDiscount(points int, subtotal float64) (int, float64) {
+	value := pointValue()
+	discount := roundCents(float64(points) * value)
+
+	if discount > subtotal {
+		discount = subtotal
+		// Rounding first stops float error such as 0.07 / 0.01 = 7.000000000000001
+		// costing an extra point
+		points = int(math.Ceil(math.Round(subtotal/value*1e6) / 1e6))
+	}
+
+	return points, discount
+}
+
+// getEarnRates returns the configured earn rates by category ID
+func (app *Config) getEarnRates() (map[int]float64, error) {
+	rows, err := app.DB.Query(`select category_id, points_per_unit from loyalty_category_earn_rates order by category_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rates := make(map[int]float64)
+	for rows.Next() {
+		var category int
+		var rate float64
+		if err := rows.Scan(&category, &rate); err != nil {
+			return nil, err
+		}
+		rates[category] = rate
+	}
+
+	return rates, rows.Err()
+}
+
+// pointsForOrder works out how many points an order earns, looking up the category of
+// each item with the menu service. Items whose category can't be looked up earn at the
+// default rate.
+func (app *Config) pointsForOrder(order Order) (int, error) {
+	rates, err := app.getEarnRates()
+	if err != nil {
+		return 0, err
+	}
+
+	categories := make(map[int]int)
+	for _, item := range order.Items {
+		if _, ok := categories[item.MenuItemID]; ok {
+			continue
+		}
+
+		menuItem, err := app.getMenuItem(item.MenuItemID)
+		if err != nil {
+			log.Printf("Error looking up menu item %d for loyalty points, using default rate: %v", item.MenuItemID, err)
+			continue
+		}
+		categories[item.MenuItemID] = menuItem.CategoryID
+	}
+
+	return earnedPoints(order, categories, rates, defaultEarnRate()), nil
+}
+
+// earnedPoints works out the points an order earns from the category of each menu item
+// and the earn rate of each category. Each item earns at its category's rate, or the
+// default rate, scaled down by any discount so that redeemed points don't earn more
+// points.
+func earnedPoints(order Order, categories map[int]int, rates map[int]float64, defaultRate float64) int {
+	var subtotal, points float64
+	for _, item := range order.Items {
+		rate := defaultRate
+		if category, ok := categories[item.MenuItemID]; ok {
+			if categoryRate, ok := rates[category]; ok {
+				rate = categoryRate
+			}
+		}
+
+		amount := item.Price * float64(item.Quantity)
+		subtotal += amount
+		points += amount * rate
+	}
+
+	if subtotal > 0 && order.Discount > 0 {
+		points *= (subtotal - order.Discount) / subtotal
+	}
+
+	return int(math.Floor(points))
+}
+
+// earnOrderPoints credits the points for a completed order, once
+func earnOrderPoints(tx *sql.Tx, order Order, points int) error {
+	if points <= 0 {
+		return nil
+	}
+
+	if err := lockCustomerPoints(tx, order.CustomerID); err != nil {
+		return err
+	}
+
+	var exists bool
+	err := tx.QueryRow(`select exists(select 1 from loyalty_ledger where order_id = $1 and entry_type = $2)`, order.ID, ledgerEarn).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+
+	return creditPoints(tx, order.CustomerID, &order.ID, ledgerEarn, points, fmt.Sprintf("Earned on order %d", order.ID))
+}
+
+// reverseOrderPoints undoes the loyalty effects of a refunded or cancelled order: points
+// earned on it are taken back and points redeemed on it are returned. It only runs once
+// per order.
+func reverseOrderPoints(tx *sql.Tx, order Order) error {
+	if err := lockCustomerPoints(tx, order.CustomerID); err != nil {
+		return err
+	}
+
+	var reversed bool
+	err := tx.QueryRow(`select exists(select 1 from loyalty_ledger where order_id = $1 and entry_type = $2)`, order.ID, ledgerReversal).Scan(&reversed)
+	if err != nil || reversed {
+		return err
+	}
+
+	var earned, redeemed int
+	err = tx.QueryRow(`select
+			coalesce(sum(points) filter (where entry_type = $2), 0),
+			coalesce(-sum(points) filter (where entry_type = $3), 0)
+		from loyalty_ledger where order_id = $1`, order.ID, ledgerEarn, ledgerRedeem).Scan(&earned, &redeemed)
+	if err != nil {
+		return err
+	}
+
+	if earned > 0 {
+		// Take back whatever is left of the order's own lot first, then the rest
+		// from the customer's other points
+		var unspent int
+		err = tx.QueryRow(`select coalesce(sum(remaining), 0) from loyalty_ledger where order_id = $1 and entry_type = $2`, order.ID, ledgerEarn).Scan(&unspent)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`update loyalty_ledger set remaining = 0 where order_id = $1 and entry_type = $2`, order.ID, ledgerEarn)
+		if err != nil {
+			return err
+		}
+
+		description := fmt.Sprintf("Reversed points earned on order %d (%s)", order.ID, order.Status)
+		err = insertDebit(tx, order.CustomerID, &order.ID, ledgerReversal, earned, earned-unspent, description)
+		if err != nil {
+			return err
+		}
+	}
+
+	if redeemed > 0 {
+		description := fmt.Sprintf("Returned points redeemed on order %d (%s)", order.ID, order.Status)
+		err = creditPoints(tx, order.CustomerID, &order.ID, ledgerReversal, redeemed, description)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// expirePoints expires the unspent part of every lot past its expiry date and returns
+// the number of points expired
+func (app *Config) expirePoints() (int, error) {
+	rows, err := app.DB.Query(`select id, customer_id, remaining from loyalty_ledger where remaining > 0 and expires_at <= $1`, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	type lot struct{ id, customerID, remaining int }
+	var lots []lot
+	for rows.Next() {
+		var l lot
+		if err := rows.Scan(&l.id, &l.customerID, &l.remaining); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		lots = append(lots, l)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, l := range lots {
+		err := func() error {
+			tx, err := app.DB.Begin()
+			if err != nil {
+				return err
+			}
+			defer tx.Rollback()
+
+			if err := lockCustomerPoints(tx, l.customerID); err != nil {
+				return err
+			}
+
+			// Re-read under the lock in case the lot was spent in the meantime
+			var remaining int
+			err = tx.QueryRow(`select remaining from loyalty_ledger where id = $1`, l.id).Scan(&remaining)
+			if err != nil || remaining == 0 {
+				return err
+			}
+
+			_, err = tx.Exec(`update loyalty_ledger set remaining = 0 where id = $1`, l.id)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.Exec(`insert into loyalty_ledger (customer_id, entry_type, points, remaining, description, created_at)
+				values ($1, $2, $3, 0, $4, $5)`, l.customerID, ledgerExpire, -remaining, "Points expired", time.Now())
+			if err != nil {
+				return err
+			}
+
+			expired += remaining
+			return tx.Commit()
+		}()
+		if err != nil {
+			return expired, err
+		}
+	}
+
+	return expired, nil
+}
+
+// schedulePointsExpiry expires points every interval
+func (app *Config) schedulePointsExpiry(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		expired, err := app.expirePoints()
+		if err != nil {
+			log.Printf("Error expiring loyalty points: %v", err)
+			continue
+		}
+		if expired > 0 {
+			log.Printf("Expired %d loyalty points", expired)
+		}
+	}
+}
+
+// getLedger returns a customer's most recent ledger entries. A limit of 0 returns
+// every entry.
+func (app *Config) getLedger(customerID, limit int) ([]LedgerEntry, error) {
+	query := `select id, customer_id, order_id, entry_type, points, remaining, description, expires_at, created_at
+		from loyalty_ledger where customer_id = $1 order by created_at desc, id desc limit nullif($2, 0)`
+
+	rows, err := app.DB.Query(query, customerID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []LedgerEntry{}
+	for rows.Next() {
+		var entry LedgerEntry
+		var orderID sql.NullInt64
+		var expiresAt sql.NullTime
+
+		err := rows.Scan(
+			&entry.ID,
+			&entry.CustomerID,
+			&orderID,
+			&entry.EntryType,
+			&entry.Points,
+			&entry.Remaining,
+			&entry.Description,
+			&expiresAt,
+			&entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		if orderID.Valid {
+			id := int(orderID.Int64)
+			entry.OrderID = &id
+		}
+		if expiresAt.Valid {
+			entry.ExpiresAt = &expiresAt.Time
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
+
+func (app *Config) GetPointsBalance(c *gin.Context) {
+	customerID, err := strconv.Atoi(c.Param("customer_id"))
+	if err != nil {
+		app.errorJSON(c, errors.New("invalid customer_id parameter"), http.StatusBadRequest)
+		return
+	}
+
+	balance, err := pointsBalance(app.DB, customerID)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	// Points due to expire within the next 30 days
+	var expiringSoon int
+	err = app.DB.QueryRow(`select coalesce(sum(remaining), 0) from loyalty_ledger
+		where customer_id = $1 and remaining > 0 and expires_at <= $2`, customerID, time.Now().Add(30*24*time.Hour)).Scan(&expiringSoon)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+		Data    any    `json:"data,omitempty"`
+	}{
+		Error:   false,
+		Message: "Points balance retrieved",
+		Data: gin.H{
+			"customer_id":   customerID,
+			"balance":       balance,
+			"value":         roundCents(float64(balance) * pointValue()),
+			"expiring_soon": expiringSoon,
+		},
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+func (app *Config) GetPointsHistory(c *gin.Context) {
+	customerID, err := strconv.Atoi(c.Param("customer_id"))
+	if err != nil {
+		app.errorJSON(c, errors.New("invalid customer_id parameter"), http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultHistoryLimit
+	if value := c.Query("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxHistoryLimit {
+			app.errorJSON(c, fmt.Errorf("limit must be between 1 and %d", maxHistoryLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	entries, err := app.getLedger(customerID, limit)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool          `json:"error"`
+		Message string        `json:"message"`
+		Data    []LedgerEntry `json:"data"`
+	}{
+		Error:   false,
+		Message: "Points history retrieved",
+		Data:    entries,
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+// AdjustPoints lets staff add (positive) or remove (negative) points by hand
+func (app *Config) AdjustPoints(c *gin.Context) {
+	customerID, err := strconv.Atoi(c.Param("customer_id"))
+	if err != nil {
+		app.errorJSON(c, errors.New("invalid customer_id parameter"), http.StatusBadRequest)
+		return
+	}
+
+	var adjustment struct {
+		Points      int    `json:"points"`
+		Description string `json:"description"`
+	}
+
+	err = app.readJSON(c, &adjustment)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	if adjustment.Points == 0 || adjustment.Description == "" {
+		app.errorJSON(c, errors.New("points must be non-zero and a description is required"), http.StatusBadRequest)
+		return
+	}
+
+	tx, err := app.DB.Begin()
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	err = lockCustomerPoints(tx, customerID)
+	if err == nil {
+		if adjustment.Points > 0 {
+			err = creditPoints(tx, customerID, nil, ledgerAdjust, adjustment.Points, adjustment.Description)
+		} else {
+			err = debitPoints(tx, customerID, nil, ledgerAdjust, -adjustment.Points, adjustment.Description)
+		}
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	balance, err := pointsBalance(app.DB, customerID)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+		Data    any    `json:"data,omitempty"`
+	}{
+		Error:   false,
+		Message: "Points adjusted",
+		Data:    gin.H{"customer_id": customerID, "balance": balance},
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+func (app *Config) GetEarnRates(c *gin.Context) {
+	rates, err := app.getEarnRates()
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	earnRates := []EarnRate{}
+	for category, rate := range rates {
+		earnRates = append(earnRates, EarnRate{CategoryID: category, PointsPerUnit: rate})
+	}
+	sort.Slice(earnRates, func(i, j int) bool { return earnRates[i].CategoryID < earnRates[j].CategoryID })
+
+	payload := struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+		Data    any    `json:"data"`
+	}{
+		Error:   false,
+		Message: "Earn rates retrieved",
+		Data: gin.H{
+			"default_points_per_unit": defaultEarnRate(),
+			"categories":              earnRates,
+		},
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+// SetEarnRate sets the earn rate for a menu category
+func (app *Config) SetEarnRate(c *gin.Context) {
+	categoryID, err := strconv.Atoi(c.Param("category_id"))
+	if err != nil {
+		app.errorJSON(c, errors.New("invalid category_id parameter"), http.StatusBadRequest)
+		return
+	}
+
+	var rate EarnRate
+	err = app.readJSON(c, &rate)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusBadRequest)
+		return
+	}
+
+	rate.CategoryID = categoryID
+	if rate.PointsPerUnit < 0 {
+		app.errorJSON(c, errors.New("points_per_unit can't be negative"), http.StatusBadRequest)
+		return
+	}
+
+	exists, err := app.categoryExists(categoryID)
+	if err != nil {
+		app.errorJSON(c, fmt.Errorf("unable to verify category: %w", err), http.StatusServiceUnavailable)
+		return
+	}
+	if !exists {
+		app.errorJSON(c, errors.New("category not found"), http.StatusNotFound)
+		return
+	}
+
+	_, err = app.DB.Exec(`insert into loyalty_category_earn_rates (category_id, points_per_unit) values ($1, $2)
+		on conflict (category_id) do update set points_per_unit = excluded.points_per_unit`, rate.CategoryID, rate.PointsPerUnit)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool     `json:"error"`
+		Message string   `json:"message"`
+		Data    EarnRate `json:"data"`
+	}{
+		Error:   false,
+		Message: "Earn rate updated",
+		Data:    rate,
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
+
+// DeleteEarnRate removes a category's earn rate so it uses the default again
+func (app *Config) DeleteEarnRate(c *gin.Context) {
+	categoryID, err := strconv.Atoi(c.Param("category_id"))
+	if err != nil {
+		app.errorJSON(c, errors.New("invalid category_id parameter"), http.StatusBadRequest)
+		return
+	}
+
+	_, err = app.DB.Exec(`delete from loyalty_category_earn_rates where category_id = $1`, categoryID)
+	if err != nil {
+		app.errorJSON(c, err, http.StatusInternalServerError)
+		return
+	}
+
+	payload := struct {
+		Error   bool   `json:"error"`
+		Message string `json:"message"`
+	}{
+		Error:   false,
+		Message: "Earn rate removed",
+	}
+
+	app.writeJSON(c, http.StatusOK, payload)
+}
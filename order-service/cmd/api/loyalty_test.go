@@ -0,0 +1,258 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRedemptionDiscount(t *testing.T) {
+	t.Setenv("LOYALTY_POINT_VALUE", "0.01")
+
+	tests := []struct {
+		name         string
+		points       int
+		subtotal     float64
+		wantPoints   int
+		wantDiscount float64
+	}{
+		{"less than the subtotal", 500, 20, 500, 5},
+		{"exactly the subtotal", 2000, 20, 2000, 20},
+		{"more than the subtotal", 3000, 20, 2000, 20},
+		{"rounded to cents", 1, 20, 1, 0.01},
+		{"no points", 0, 20, 0, 0},
+		{"subtotal that divides badly", 100, 0.07, 7, 0.07},
+		{"subtotal between points", 100, 0.075, 8, 0.075},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			points, discount := redemptionDiscount(tt.points, tt.subtotal)
+			if points != tt.wantPoints || discount != tt.wantDiscount {
+				t.Errorf("redemptionDiscount(%d, %v) = %d, %v; want %d, %v",
+					tt.points, tt.subtotal, points, discount, tt.wantPoints, tt.wantDiscount)
+			}
+		})
+	}
+}
+
+func TestRedemptionDiscountPointValue(t *testing.T) {
+	t.Setenv("LOYALTY_POINT_VALUE", "0.05")
+
+	points, discount := redemptionDiscount(100, 3)
+	if points != 60 || discount != 3 {
+		t.Errorf("redemptionDiscount(100, 3) = %d, %v; want 60, 3", points, discount)
+	}
+}
+
+func TestEarnedPoints(t *testing.T) {
+	const (
+		drinks   = 1
+		desserts = 2
+	)
+	categories := map[int]int{10: drinks, 11: desserts, 12: 99}
+	rates := map[int]float64{drinks: 2, desserts: 0}
+
+	tests := []struct {
+		name     string
+		items    []OrderItem
+		discount float64
+		want     int
+	}{
+		{
+			name:  "category rate",
+			items: []OrderItem{{MenuItemID: 10, Quantity: 2, Price: 3.50}},
+			want:  14,
+		},
+		{
+			name:  "category that earns nothing",
+			items: []OrderItem{{MenuItemID: 11, Quantity: 1, Price: 6}},
+			want:  0,
+		},
+		{
+			name:  "category without a rate earns the default",
+			items: []OrderItem{{MenuItemID: 12, Quantity: 1, Price: 4}},
+			want:  4,
+		},
+		{
+			name:  "unknown category earns the default",
+			items: []OrderItem{{MenuItemID: 13, Quantity: 3, Price: 2}},
+			want:  6,
+		},
+		{
+			name:  "mixed items",
+			items: []OrderItem{{MenuItemID: 10, Quantity: 1, Price: 5}, {MenuItemID: 11, Quantity: 1, Price: 5}, {MenuItemID: 13, Quantity: 1, Price: 5}},
+			want:  15,
+		},
+		{
+			name:     "discount scales points down",
+			items:    []OrderItem{{MenuItemID: 13, Quantity: 1, Price: 10}},
+			discount: 2.5,
+			want:     7,
+		},
+		{
+			name:     "fully discounted",
+			items:    []OrderItem{{MenuItemID: 10, Quantity: 1, Price: 10}},
+			discount: 10,
+			want:     0,
+		},
+		{
+			name:  "fractions round down",
+			items: []OrderItem{{MenuItemID: 13, Quantity: 1, Price: 9.99}},
+			want:  9,
+		},
+		{
+			name: "no items",
+			want: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order := Order{Items: tt.items, Discount: tt.discount}
+			if got := earnedPoints(order, categories, rates, 1); got != tt.want {
+				t.Errorf("earnedPoints() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDrawDown(t *testing.T) {
+	amounts := func(points ...int) []ledgerAmount {
+		list := []ledgerAmount{}
+		for i, p := range points {
+			list = append(list, ledgerAmount{id: i + 1, points: p})
+		}
+		return list
+	}
+
+	tests := []struct {
+		name      string
+		amounts   []ledgerAmount
+		points    int
+		wantTaken []int
+		wantLeft  int
+	}{
+		{"from the first", amounts(50, 30), 20, []int{20, 0}, 0},
+		{"all of the first", amounts(50, 30), 50, []int{50, 0}, 0},
+		{"across several", amounts(50, 30, 10), 70, []int{50, 20, 0}, 0},
+		{"everything", amounts(50, 30), 80, []int{50, 30}, 0},
+		{"not enough", amounts(50, 30), 100, []int{50, 30}, 20},
+		{"nothing to take from", nil, 40, []int{}, 40},
+		{"nothing to take", amounts(50), 0, []int{0}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			taken, left := drawDown(tt.amounts, tt.points)
+			if !reflect.DeepEqual(taken, tt.wantTaken) || left != tt.wantLeft {
+				t.Errorf("drawDown(%v, %d) = %v, %d; want %v, %d", tt.amounts, tt.points, taken, left, tt.wantTaken, tt.wantLeft)
+			}
+		})
+	}
+}
+
+// testLedger keeps a customer's ledger entries in memory and changes them the way
+// creditPoints, insertDebit and expirePoints change the loyalty_ledger table
+type testLedger struct {
+	points    []int
+	remaining []int
+}
+
+func (l *testLedger) amounts(keep func(remaining int) bool, sign int) []ledgerAmount {
+	var amounts []ledgerAmount
+	for i, remaining := range l.remaining {
+		if keep(remaining) {
+			amounts = append(amounts, ledgerAmount{id: i, points: sign * remaining})
+		}
+	}
+	return amounts
+}
+
+func (l *testLedger) credit(points int) {
+	debts := l.amounts(func(r int) bool { return r < 0 }, -1)
+	paid, left := drawDown(debts, points)
+	for i, debt := range debts {
+		l.remaining[debt.id] += paid[i]
+	}
+
+	l.points = append(l.points, points)
+	l.remaining = append(l.remaining, left)
+}
+
+func (l *testLedger) debit(points, spend int) {
+	lots := l.amounts(func(r int) bool { return r > 0 }, 1)
+	spent, shortfall := drawDown(lots, spend)
+	for i, lot := range lots {
+		l.remaining[lot.id] -= spent[i]
+	}
+
+	l.points = append(l.points, -points)
+	l.remaining = append(l.remaining, -shortfall)
+}
+
+// expireAll expires every lot, as if all of them had passed their expiry date
+func (l *testLedger) expireAll() int {
+	expired := 0
+	for _, lot := range l.amounts(func(r int) bool { return r > 0 }, 1) {
+		l.remaining[lot.id] = 0
+		l.points = append(l.points, -lot.points)
+		l.remaining = append(l.remaining, 0)
+		expired += lot.points
+	}
+	return expired
+}
+
+func (l *testLedger) balance() int {
+	balance := 0
+	for _, points := range l.points {
+		balance += points
+	}
+	return balance
+}
+
+func TestReversalAfterSpendingThenExpiry(t *testing.T) {
+	tests := []struct {
+		name        string
+		later       []int
+		wantExpired int
+		wantBalance int
+	}{
+		{"later points pay off the reversal", []int{60, 80}, 40, 0},
+		{"later points exactly pay it off", []int{100}, 0, 0},
+		{"later points don't cover it", []int{30}, 0, -70},
+		{"nothing earned later", nil, 0, -100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &testLedger{}
+
+			// 100 points are earned on an order and spent on another, then the
+			// first order is refunded. None of its lot is left, so the whole
+			// reversal comes out of the customer's other lots, and there are none.
+			l.credit(100)
+			l.debit(100, 100)
+			l.debit(100, 100)
+
+			for _, points := range tt.later {
+				l.credit(points)
+			}
+
+			if expired := l.expireAll(); expired != tt.wantExpired {
+				t.Errorf("expired %d points, want %d", expired, tt.wantExpired)
+			}
+			if balance := l.balance(); balance != tt.wantBalance {
+				t.Errorf("balance is %d, want %d", balance, tt.wantBalance)
+			}
+
+			// Whatever is still owed is what keeps the balance below zero
+			owed := 0
+			for _, debt := range l.amounts(func(r int) bool { return r < 0 }, -1) {
+				owed += debt.points
+			}
+			if owed != -tt.wantBalance {
+				t.Errorf("%d points still owed, want %d", owed, -tt.wantBalance)
+			}
+		})
+	}
+}
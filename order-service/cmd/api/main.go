@@ -29,8 +29,13 @@ type Order struct {
 	Items      []OrderItem `json:"items"`
 	Status     string      `json:"status"`
 	Total      float64     `json:"total"`
-	CreatedAt  time.Time   `json:"created_at"`
-	UpdatedAt  time.Time   `json:"updated_at"`
+	// Discount is the amount taken off the total by redeeming PointsRedeemed loyalty
+	// points. RedeemPoints is only used when creating an order.
+	Discount       float64   `json:"discount"`
+	PointsRedeemed int       `json:"points_redeemed"`
+	RedeemPoints   int       `json:"redeem_points,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
 }
 
 type OrderItem struct {
@@ -39,6 +44,33 @@ type OrderItem struct {
 	MenuItemID int     `json:"menu_item_id"`
 	Quantity   int     `json:"quantity"`
 	Price      float64 `json:"price"`
+	// OptionIDs are the menu options chosen when ordering, such as a size. Options holds
+	// what was chosen, as priced when the order was placed.
+	OptionIDs []int             `json:"option_ids,omitempty"`
+	Options   []OrderItemOption `json:"options"`
+	// ComboID orders a combo instead of a single item, filled with Selections. The
+	// combo is stored as its items, which share a ComboLine and the combo's details.
+	ComboID    *int             `json:"combo_id,omitempty"`
+	Selections []ComboSelection `json:"selections,omitempty"`
+	ComboLine  int              `json:"combo_line,omitempty"`
+	ComboName  string           `json:"combo_name,omitempty"`
+	ComboSlot  string           `json:"combo_slot,omitempty"`
+}
+
+// ComboSelection is the menu item, and its options, chosen for one slot of a combo
+type ComboSelection struct {
+	SlotID     int   `json:"slot_id"`
+	MenuItemID int   `json:"menu_item_id"`
+	OptionIDs  []int `json:"option_ids,omitempty"`
+}
+
+// OrderItemOption is a menu option chosen for an order item. Its name and price are
+// copied from the menu so later menu changes don't alter past orders.
+type OrderItemOption struct {
+	OptionID   int     `json:"option_id"`
+	Group      string  `json:"group"`
+	Name       string  `json:"name"`
+	PriceDelta float64 `json:"price_delta"`
 }
 
 func main() {
@@ -55,6 +87,9 @@ func main() {
 		DB: conn,
 	}
 
+	// Expire old loyalty points in the background
+	go app.schedulePointsExpiry(pointsExpiryInterval)
+
 	// Set up Gin router with middleware
 	router := gin.New()
 	router.Use(gin.Recovery())
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const menuServiceURL = "http://0.0.0.0:8002"
+
+// MenuItem is the part of a menu-service item that orders need
+type MenuItem struct {
+	ID         int     `json:"id"`
+	Name       string  `json:"name"`
+	Price      float64 `json:"price"`
+	Category   string  `json:"category"`
+	CategoryID int     `json:"category_id"`
+}
+
+var (
+	errMenuItemNotFound = errors.New("menu item not found")
+	errNotOrderable     = errors.New("menu item can't be ordered")
+)
+
+// getMenuItem fetches a menu item from the menu service
+func (app *Config) getMenuItem(id int) (MenuItem, error) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	response, err := client.Get(fmt.Sprintf("%s/menu/%d", menuServiceURL, id))
+	if err != nil {
+		return MenuItem{}, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return MenuItem{}, errMenuItemNotFound
+	}
+	if response.StatusCode != http.StatusOK {
+		return MenuItem{}, errors.New("error calling menu service")
+	}
+
+	var result struct {
+		Data MenuItem `json:"data"`
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&result)
+	if err != nil {
+		return MenuItem{}, err
+	}
+
+	return result.Data, nil
+}
+
+// categoryExists checks with the menu service that a category exists
+func (app *Config) categoryExists(id int) (bool, error) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	response, err := client.Get(fmt.Sprintf("%s/categories/%d", menuServiceURL, id))
+	if err != nil {
+		return false, err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, errors.New("error calling menu service")
+	}
+}
+
+// reportSales tells the menu service how many of each item an order sold, which it
+// uses to rank items by popularity. sign is 1 for a completed order and -1 for a refund.
+func (app *Config) reportSales(order Order, sign int) error {
+	type sale struct {
+		MenuItemID int `json:"menu_item_id"`
+		Quantity   int `json:"quantity"`
+	}
+
+	sales := make([]sale, 0, len(order.Items))
+	for _, item := range order.Items {
+		sales = append(sales, sale{MenuItemID: item.MenuItemID, Quantity: sign * item.Quantity})
+	}
+
+	jsonData, _ := json.Marshal(map[string]any{"sales": sales})
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	response, err := client.Post(menuServiceURL+"/menu/sales", "application/json", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return errors.New("error calling menu service")
+	}
+
+	return nil
+}
+
+// priceMenuItem asks the menu service for the price of an item with the chosen options
+// at a given time, so scheduled menus and price overrides apply. It returns the unit
+// price and the options as they should be stored on the order.
+func (app *Config) priceMenuItem(id int, optionIDs []int, at time.Time) (float64, []OrderItemOption, error) {
+	if optionIDs == nil {
+		optionIDs = []int{}
+	}
+	jsonData, _ := json.Marshal(map[string]any{"option_ids": optionIDs, "at": at.Format(time.RFC3339)})
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	response, err := client.Post(fmt.Sprintf("%s/menu/%d/price", menuServiceURL, id), "application/json", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return 0, nil, err
+	}
+	defer response.Body.Close()
+
+	var result struct {
+		Message string         `json:"message"`
+		Data    menuPriceQuote `json:"data"`
+	}
+
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return 0, nil, fmt.Errorf("%w: %d", errMenuItemNotFound, id)
+	case http.StatusUnprocessableEntity:
+		_ = json.NewDecoder(response.Body).Decode(&result)
+		return 0, nil, fmt.Errorf("%w: %s", errNotOrderable, result.Message)
+	default:
+		return 0, nil, errors.New("error calling menu service")
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&result)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return result.Data.UnitPrice, result.Data.orderOptions(), nil
+}
+
+// priceCombo asks the menu service for the price of a combo with the chosen items, and
+// returns the combo split into its items. Each item carries its share of the bundle
+// price, so the items add up to the price of the combo.
+func (app *Config) priceCombo(id int, selections []ComboSelection, at time.Time) ([]OrderItem, error) {
+	jsonData, _ := json.Marshal(map[string]any{"selections": selections, "at": at.Format(time.RFC3339)})
+
+	client := &http.Client{Timeout: 5 * time.Second}
+	response, err := client.Post(fmt.Sprintf("%s/combos/%d/price", menuServiceURL, id), "application/json", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	var result struct {
+		Message string `json:"message"`
+		Data    struct {
+			Name       string `json:"name"`
+			Components []struct {
+				Slot string `json:"slot"`
+				menuPriceQuote
+				AllocatedPrice float64 `json:"allocated_price"`
+			} `json:"components"`
+		} `json:"data"`
+	}
+
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("%w: combo %d", errMenuItemNotFound, id)
+	case http.StatusUnprocessableEntity:
+		_ = json.NewDecoder(response.Body).Decode(&result)
+		return nil, fmt.Errorf("%w: %s", errNotOrderable, result.Message)
+	default:
+		return nil, errors.New("error calling menu service")
+	}
+
+	err = json.NewDecoder(response.Body).Decode(&result)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]OrderItem, 0, len(result.Data.Components))
+	for _, component := range result.Data.Components {
+		items = append(items, OrderItem{
+			MenuItemID: component.MenuItemID,
+			Price:      component.AllocatedPrice,
+			Options:    component.orderOptions(),
+			ComboID:    &id,
+			ComboName:  result.Data.Name,
+			ComboSlot:  component.Slot,
+		})
+	}
+
+	return items, nil
+}
+
+// menuPriceQuote is the part of a menu-service price quote that orders need
+type menuPriceQuote struct {
+	MenuItemID int `json:"menu_item_id"`
+	Options    []struct {
+		ID         int     `json:"id"`
+		Group      string  `json:"group"`
+		Name       string  `json:"name"`
+		PriceDelta float64 `json:"price_delta"`
+	} `json:"options"`
+	UnitPrice float64 `json:"unit_price"`
+}
+
+// orderOptions turns the options in a quote into options stored on an order item
+func (q menuPriceQuote) orderOptions() []OrderItemOption {
+	options := make([]OrderItemOption, 0, len(q.Options))
+	for _, option := range q.Options {
+		options = append(options, OrderItemOption{
+			OptionID:   option.ID,
+			Group:      option.Group,
+			Name:       option.Name,
+			PriceDelta: option.PriceDelta,
+		})
+	}
+	return options
+}
+
+// priceOrderItems sets the price of each order item from the menu, including the price
+// of its chosen options, rather than trusting the price sent with the order. Every item
+// is priced at the same moment, so an order can't straddle the end of a happy hour.
+// Combos are replaced by the items they are made of, each numbered with the same combo
+// line and charged its share of the bundle price.
+func (app *Config) priceOrderItems(order *Order) error {
+	at := time.Now()
+	items := make([]OrderItem, 0, len(order.Items))
+	comboLine := 0
+
+	for _, item := range order.Items {
+		if item.ComboID != nil {
+			components, err := app.priceCombo(*item.ComboID, item.Selections, at)
+			if err != nil {
+				return err
+			}
+
+			comboLine++
+			for _, component := range components {
+				component.Quantity = item.Quantity
+				component.ComboLine = comboLine
+				items = append(items, component)
+			}
+			continue
+		}
+
+		price, options, err := app.priceMenuItem(item.MenuItemID, item.OptionIDs, at)
+		if err != nil {
+			return err
+		}
+
+		item.Price = price
+		item.Options = options
+		items = append(items, item)
+	}
+
+	order.Items = items
+	return nil
+}
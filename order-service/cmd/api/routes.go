@@ -10,8 +10,19 @@ func (app *Config) setupRoutes() {
 	app.router.GET("/orders", app.GetAllOrders)
 	app.router.GET("/orders/:id", app.GetOrder)
 	app.router.GET("/orders/customer/:customer_id", app.GetOrdersByCustomer)
+	app.router.GET("/orders/customer/:customer_id/data-export", app.ExportCustomerData)
+	app.router.POST("/orders/customer/:customer_id/erase", app.EraseCustomerData)
 	app.router.POST("/orders", app.CreateOrder)
 	app.router.PATCH("/orders/:id/status", app.UpdateOrderStatus)
+	app.router.GET("/orders/menu-items/:menu_item_id/references", app.GetMenuItemReferences)
+
+	// Loyalty points
+	app.router.GET("/loyalty/customers/:customer_id/balance", app.GetPointsBalance)
+	app.router.GET("/loyalty/customers/:customer_id/history", app.GetPointsHistory)
+	app.router.POST("/loyalty/customers/:customer_id/adjustments", app.AdjustPoints)
+	app.router.GET("/loyalty/earn-rates", app.GetEarnRates)
+	app.router.PUT("/loyalty/earn-rates/:category_id", app.SetEarnRate)
+	app.router.DELETE("/loyalty/earn-rates/:category_id", app.DeleteEarnRate)
 	
 	// Health check endpoint
 	app.router.GET("/ping", func(c *gin.Context) {